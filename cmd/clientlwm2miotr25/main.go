@@ -6,6 +6,7 @@ package main
 
 import (
 	"log"
+	"strings"
 
 	"launchpad.net/ce-web/alpaca/lwm2m"
 	"launchpad.net/ce-web/alpaca/pivot"
@@ -28,10 +29,49 @@ func main() {
 	}
 
 	log.Printf("Starting LWM2M client '%s'\n", c.Name)
-	log.Printf("Connect to the LwM2M server %s:%s\n", c.ServerHost, c.ServerPort)
 
-	// Initialize the connection to the LWM2M server
-	out := lwm2m.CreateServer(c.ServerHost, c.ServerPort, c.LocalPort, c.Name, c.BootstrapRequired)
+	// Set the source of the device position
+	lwm2m.ConfigureLocation(c)
+
+	// Initialize the client, then add the LWM2M servers to register with
+	out := lwm2m.CreateServer(c.LocalPort, c.Name, c.AddressFamily)
+	if out != 0 {
+		log.Fatalln("Error creating the LWM2M client")
+	}
+
+	// The servers provisioned by the last bootstrap are used when they are stored
+	bootstrap, hasBootstrap := c.BootstrapServerConfig()
+	restored := false
+	if hasBootstrap {
+		var err error
+		if restored, err = lwm2m.RestoreBootstrap(); err != nil {
+			log.Printf("Error restoring the bootstrap: %v\n", err)
+		}
+	}
+
+	if !restored && hasBootstrap {
+		log.Printf("Bootstrap from the LwM2M bootstrap server at %s\n", bootstrap.URI)
+		logSecurity(bootstrap)
+
+		if err := lwm2m.AddServer(bootstrap, true); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// The factory-installed servers are registered with first, and the client
+	// falls back to the bootstrap server when they reject the registration
+	for _, s := range c.ServerList() {
+		if restored || c.BootstrapRequired {
+			break
+		}
+
+		log.Printf("Connect to the LwM2M server %d at %s\n", s.ShortID, s.URI)
+		logSecurity(s)
+
+		if err := lwm2m.AddServer(s, false); err != nil {
+			log.Println(err)
+		}
+	}
 
 	defer lwm2m.CloseServer()
 
@@ -44,6 +84,8 @@ func main() {
 		// Send the queued updates to the lwm2m server
 		lwm2m.SendData()
 
+		// Wait for the server requests. In queue mode the client sleeps
+		// until the next registration update or resource change instead.
 		out = lwm2m.WaitForTimeout()
 		if out != 0 {
 			continue
@@ -59,3 +101,18 @@ func main() {
 	}
 
 }
+
+func logSecurity(s lwm2m.ServerConfig) {
+	switch s.SecurityMode() {
+	case lwm2m.SecurityModePSK:
+		log.Printf("Using DTLS with the pre-shared key identity '%s'\n", s.PSKIdentity)
+	case lwm2m.SecurityModeRPK:
+		log.Println("Using DTLS with a raw public key")
+	case lwm2m.SecurityModeCertificate:
+		if strings.HasPrefix(s.URI, "coaps+tcp://") {
+			log.Println("Using TLS with an X.509 certificate")
+		} else {
+			log.Println("Using DTLS with an X.509 certificate")
+		}
+	}
+}
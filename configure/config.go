@@ -7,19 +7,38 @@ package configure
 import (
 	"fmt"
 	"log"
+	"strconv"
 
 	"launchpad.net/ce-web/alpaca/lwm2m"
 )
 
 // ConfigCommand defines the options for the configure command-line utility
 type ConfigCommand struct {
-	ServerHost     string `short:"s" long:"server" description:"Hostname of the LWM2M Server" default:"localhost"`
-	ServerPort     string `short:"p" long:"port" description:"Port of the LWM2M Server" default:"5683"`
-	LocalPort      string `short:"l" long:"localport" description:"Local port for the LWM2M client" default:"56830"`
-	Name           string `short:"n" long:"name" description:"Name of the LWM2M client" default:"lwm2mclient"`
-	Bootstrap      string `short:"b" long:"bootstrap" description:"Whether bootstrap is required" default:"false" choice:"false" choice:"true"`
-	SerialVaultURL string `short:"u" long:"url" description:"URL to the serial-vault" default:"https://serial-vault-partners.canonical.com/v1/"`
-	SerialVaultAPI string `short:"a" long:"apikey" description:"API key for the serial-vault"`
+	ServerHost           string `short:"s" long:"server" description:"Hostname of the LWM2M Server" default:"localhost"`
+	ServerPort           string `short:"p" long:"port" description:"Port of the LWM2M Server" default:"5683"`
+	LocalPort            string `short:"l" long:"localport" description:"Local port for the LWM2M client" default:"56830"`
+	Name                 string `short:"n" long:"name" description:"Name of the LWM2M client" default:"lwm2mclient"`
+	Bootstrap            string `short:"b" long:"bootstrap" description:"Whether bootstrap is required" default:"false" choice:"false" choice:"true"`
+	SerialVaultURL       string `short:"u" long:"url" description:"URL to the serial-vault" default:"https://serial-vault-partners.canonical.com/v1/"`
+	SerialVaultAPI       string `short:"a" long:"apikey" description:"API key for the serial-vault"`
+	GPSD                 string `short:"g" long:"gpsd" description:"Address of gpsd for the device position e.g. localhost:2947"`
+	Latitude             string `long:"latitude" description:"Static latitude of the device, used when there is no GPS fix"`
+	Longitude            string `long:"longitude" description:"Static longitude of the device, used when there is no GPS fix"`
+	Altitude             string `long:"altitude" description:"Static altitude of the device in metres"`
+	PSKIdentity          string `short:"i" long:"psk-identity" description:"Pre-shared key identity to secure the connection with DTLS"`
+	PSKKey               string `short:"k" long:"psk-key" description:"Pre-shared key to secure the connection with DTLS, as hex digits"`
+	Security             string `long:"security" description:"Security mode of the connection, kept from the stored parameters when not given" choice:"psk" choice:"rpk" choice:"x509"`
+	Lifetime             int    `long:"lifetime" description:"Registration lifetime in seconds, which queue mode needs to be well above the 93 seconds that the client stays awake" default:"30"`
+	Binding              string `long:"binding" description:"Transport binding of the LWM2M Server e.g. UQ for queue mode, U or T by default"`
+	AddressFamily        string `long:"address-family" description:"Address family of the client socket, dual-stack falls back to IPv4 without IPv6" default:"ipv4" choice:"ipv4" choice:"ipv6" choice:"dual"`
+	Transport            string `long:"transport" description:"Transport of the connection to the servers, CoAP over UDP or TCP" default:"udp" choice:"udp" choice:"tcp"`
+	BootstrapServer      string `long:"bootstrap-server" description:"Hostname of the LWM2M Bootstrap Server, when it differs from the LWM2M Server"`
+	BootstrapPort        string `long:"bootstrap-port" description:"Port of the LWM2M Bootstrap Server" default:"5685"`
+	BootstrapSecurity    string `long:"bootstrap-security" description:"Security mode of the bootstrap connection" choice:"psk" choice:"rpk" choice:"x509"`
+	BootstrapPSKIdentity string `long:"bootstrap-psk-identity" description:"Pre-shared key identity for the LWM2M Bootstrap Server"`
+	BootstrapPSKKey      string `long:"bootstrap-psk-key" description:"Pre-shared key for the LWM2M Bootstrap Server, as hex digits"`
+	BootstrapCredentials string `long:"bootstrap-credentials" description:"Name of the imported credentials for the LWM2M Bootstrap Server"`
+	BootstrapHoldOff     int    `long:"bootstrap-hold-off" description:"Seconds to wait before the client initiates the bootstrap" default:"10"`
 }
 
 // Execute the adding a new user
@@ -33,6 +52,61 @@ func (cmd ConfigCommand) Execute(args []string) error {
 		BootstrapRequired: false,
 		SerialVaultURL:    cmd.SerialVaultURL,
 		SerialVaultAPI:    cmd.SerialVaultAPI,
+		GPSD:              cmd.GPSD,
+		Latitude:          cmd.Latitude,
+		Longitude:         cmd.Longitude,
+		Altitude:          cmd.Altitude,
+		PSKIdentity:       cmd.PSKIdentity,
+		PSKKey:            cmd.PSKKey,
+		Security:          cmd.Security,
+		Lifetime:          cmd.Lifetime,
+		Binding:           cmd.Binding,
+		Transport:         cmd.Transport,
+		AddressFamily:     cmd.AddressFamily,
+	}
+
+	// The security mode is also set when the credentials are imported, and the
+	// servers are managed by the server command
+	stored := lwm2m.ReadParameters()
+	if len(c.Security) == 0 {
+		c.Security = stored.Security
+	}
+	c.Servers = stored.Servers
+
+	if err := lwm2m.ValidatePSK(cmd.PSKIdentity, cmd.PSKKey); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if err := lwm2m.ValidateServers(c.ServerList()); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if len(cmd.BootstrapServer) > 0 {
+		c.BootstrapServer = lwm2m.ServerConfig{
+			Security:    cmd.BootstrapSecurity,
+			PSKIdentity: cmd.BootstrapPSKIdentity,
+			PSKKey:      cmd.BootstrapPSKKey,
+			Credentials: cmd.BootstrapCredentials,
+			HoldOff:     cmd.BootstrapHoldOff,
+		}
+		c.BootstrapServer.URI = lwm2m.ServerURI(cmd.BootstrapServer, cmd.BootstrapPort, c.BootstrapServer.SecurityMode(), cmd.Transport)
+
+		if err := c.BootstrapServer.ValidateBootstrap(); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+
+	// The static position is only used when both coordinates are given
+	for _, v := range []string{cmd.Latitude, cmd.Longitude, cmd.Altitude} {
+		if len(v) == 0 {
+			continue
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			fmt.Printf("Invalid coordinate: %s\n", v)
+			return err
+		}
 	}
 
 	log.Println("---", cmd.Bootstrap)
@@ -6,7 +6,9 @@ package configure
 
 // Command defines the options for the configure command-line utility
 type Command struct {
-	Config ConfigCommand `command:"config" alias:"c" description:"Configure the client connections"`
+	Config      ConfigCommand      `command:"config" alias:"c" description:"Configure the client connections"`
+	Credentials CredentialsCommand `command:"credentials" description:"Import and inspect the certificates and keys of the client"`
+	Server      ServerCommand      `command:"server" description:"Manage the LWM2M servers that the client registers with"`
 }
 
 // Configure is the implementation of the command configuration for the configure command-line
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package configure
+
+import (
+	"errors"
+	"fmt"
+
+	"launchpad.net/ce-web/alpaca/lwm2m"
+)
+
+// CredentialsCommand defines the subcommands that manage the stored credentials
+type CredentialsCommand struct {
+	Import ImportCommand `command:"import" description:"Import the credentials and select their security mode"`
+	Show   ShowCommand   `command:"show" description:"Show the stored credentials, without the private key"`
+}
+
+// ImportCommand defines the options to import the credentials
+type ImportCommand struct {
+	Mode      string `short:"m" long:"mode" description:"Security mode of the credentials" required:"true" choice:"x509" choice:"rpk"`
+	Cert      string `short:"c" long:"cert" description:"PEM file of the client certificate, for the x509 mode"`
+	Key       string `short:"k" long:"key" description:"PEM file of the client private key" required:"true"`
+	CA        string `long:"ca" description:"PEM file of the CA certificate of the server, for the x509 mode"`
+	ServerKey string `long:"server-key" description:"PEM file of the server public key or certificate, for the rpk mode"`
+	Set       string `long:"set" description:"Name of the credentials of a server, which only selects the security mode when not given"`
+}
+
+// ShowCommand defines the options to show the credentials
+type ShowCommand struct {
+	Set string `long:"set" description:"Name of the credentials of a server"`
+}
+
+// Execute imports the credentials and stores the security mode in the parameters
+func (cmd ImportCommand) Execute(args []string) error {
+	var err error
+
+	// The mode of the default server is checked before anything is stored, as
+	// e.g. CoAP over TCP only supports the x509 mode
+	c := lwm2m.ReadParameters()
+	if len(cmd.Set) == 0 {
+		c.Security = cmd.Mode
+		if err = c.ValidateServers(); err != nil {
+			fmt.Printf("Error selecting the %s security mode: %v\n", cmd.Mode, err)
+			return err
+		}
+	}
+
+	switch cmd.Mode {
+	case lwm2m.SecurityCertificate:
+		if len(cmd.Cert) == 0 || len(cmd.CA) == 0 {
+			err = errors.New("the x509 mode needs the --cert and --ca files")
+		} else {
+			err = lwm2m.ImportCertificate(cmd.Set, cmd.Cert, cmd.Key, cmd.CA)
+		}
+	case lwm2m.SecurityRPK:
+		if len(cmd.ServerKey) == 0 {
+			err = errors.New("the rpk mode needs the --server-key file")
+		} else {
+			err = lwm2m.ImportRawPublicKey(cmd.Set, cmd.Key, cmd.ServerKey)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error importing the credentials: %v\n", err)
+		return err
+	}
+
+	// A named set is selected in the configuration of its server
+	if len(cmd.Set) > 0 {
+		fmt.Printf("Imported the %s credentials for the %s security mode\n", cmd.Set, cmd.Mode)
+		return nil
+	}
+
+	if err = lwm2m.StoreParameters(c); err != nil {
+		fmt.Printf("Error storing the security mode: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("Imported the credentials for the %s security mode\n", cmd.Mode)
+	return nil
+}
+
+// Execute prints a summary of the stored credentials
+func (cmd ShowCommand) Execute(args []string) error {
+	lines := lwm2m.DescribeCredentials(cmd.Set)
+	if len(lines) == 0 {
+		fmt.Println("No credentials are stored")
+		return nil
+	}
+
+	if len(cmd.Set) == 0 {
+		fmt.Printf("Security mode: %s\n", securityName(lwm2m.ReadParameters().SecurityMode()))
+	}
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	return nil
+}
+
+func securityName(mode int) string {
+	switch mode {
+	case lwm2m.SecurityModePSK:
+		return lwm2m.SecurityPSK
+	case lwm2m.SecurityModeRPK:
+		return lwm2m.SecurityRPK
+	case lwm2m.SecurityModeCertificate:
+		return lwm2m.SecurityCertificate
+	default:
+		return "none"
+	}
+}
@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package configure
+
+import (
+	"fmt"
+
+	"launchpad.net/ce-web/alpaca/lwm2m"
+)
+
+// ServerCommand defines the subcommands that manage the list of servers
+type ServerCommand struct {
+	Add    ServerAddCommand    `command:"add" description:"Add a server, or replace the server with the same short ID"`
+	Remove ServerRemoveCommand `command:"remove" description:"Remove a server"`
+	List   ServerListCommand   `command:"list" description:"List the servers"`
+}
+
+// ServerAddCommand defines the options of a server
+type ServerAddCommand struct {
+	URI         string `long:"uri" description:"URI of the LWM2M server e.g. coaps://dm.example.com:5684" required:"true"`
+	ShortID     int    `long:"short-id" description:"Short server ID, between 1 and 65534" required:"true"`
+	Lifetime    int    `long:"lifetime" description:"Registration lifetime in seconds" default:"30"`
+	Binding     string `long:"binding" description:"Transport binding of the server, UQ for queue mode" default:"U"`
+	Security    string `long:"security" description:"Security mode of the connection, a pre-shared key is used when given" choice:"psk" choice:"rpk" choice:"x509"`
+	PSKIdentity string `short:"i" long:"psk-identity" description:"Pre-shared key identity"`
+	PSKKey      string `short:"k" long:"psk-key" description:"Pre-shared key, as hex digits"`
+	Credentials string `long:"credentials" description:"Name of the imported credentials, for the rpk and x509 modes"`
+}
+
+// ServerRemoveCommand defines the options to remove a server
+type ServerRemoveCommand struct {
+	ShortID int `long:"short-id" description:"Short server ID" required:"true"`
+}
+
+// ServerListCommand defines the options to list the servers
+type ServerListCommand struct{}
+
+// Execute adds the server to the stored parameters
+func (cmd ServerAddCommand) Execute(args []string) error {
+	s := lwm2m.ServerConfig{
+		URI:         cmd.URI,
+		ShortID:     cmd.ShortID,
+		Lifetime:    cmd.Lifetime,
+		Binding:     cmd.Binding,
+		Security:    cmd.Security,
+		PSKIdentity: cmd.PSKIdentity,
+		PSKKey:      cmd.PSKKey,
+		Credentials: cmd.Credentials,
+	}
+
+	c := lwm2m.ReadParameters()
+
+	servers := []lwm2m.ServerConfig{}
+	for _, old := range c.Servers {
+		if old.ShortID != s.ShortID {
+			servers = append(servers, old)
+		}
+	}
+	servers = append(servers, s)
+
+	if err := lwm2m.ValidateServers(servers); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	c.Servers = servers
+	if err := lwm2m.StoreParameters(c); err != nil {
+		fmt.Printf("Error storing the servers: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// Execute removes the server from the stored parameters
+func (cmd ServerRemoveCommand) Execute(args []string) error {
+	c := lwm2m.ReadParameters()
+
+	servers := []lwm2m.ServerConfig{}
+	for _, s := range c.Servers {
+		if s.ShortID != cmd.ShortID {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == len(c.Servers) {
+		err := fmt.Errorf("no server has the short ID %d", cmd.ShortID)
+		fmt.Println(err)
+		return err
+	}
+
+	c.Servers = servers
+	if err := lwm2m.StoreParameters(c); err != nil {
+		fmt.Printf("Error storing the servers: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// Execute prints the servers that the client registers with
+func (cmd ServerListCommand) Execute(args []string) error {
+	for _, s := range lwm2m.ReadParameters().ServerList() {
+		fmt.Printf("%d\t%s\tlifetime %ds\tbinding %s\tsecurity %s\n", s.ShortID, s.URI, s.Lifetime, s.Binding, securityName(s.SecurityMode()))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"unsafe"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+const bootstrapFilename = "bootstrap.json"
+
+// maxRegistrationFailures is the number of failed registrations with each of
+// the servers before the client bootstraps again
+const maxRegistrationFailures = 3
+
+// BootstrapInstance is a security or server instance provisioned by the bootstrap server
+type BootstrapInstance struct {
+	ObjectID   int `json:"object"`
+	InstanceID int `json:"instance"`
+
+	// Data holds the resources of the instance in the LwM2M JSON format
+	Data []byte `json:"data"`
+}
+
+// pendingInstances collects the instances until the set is complete
+var pendingInstances []BootstrapInstance
+
+// RestoreBootstrap adds the security and server instances provisioned by a
+// previous bootstrap, so the client registers without bootstrapping again. It
+// returns false when no instances are stored.
+func RestoreBootstrap() (bool, error) {
+	dat, err := ioutil.ReadFile(bootstrapPath())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	instances := []BootstrapInstance{}
+	if err = json.Unmarshal(dat, &instances); err != nil {
+		return false, err
+	}
+
+	// The server instances refer to the security instances
+	sort.SliceStable(instances, func(i, j int) bool { return instances[i].ObjectID < instances[j].ObjectID })
+
+	for _, i := range instances {
+		if err = restoreInstance(i); err != nil {
+			return false, err
+		}
+	}
+
+	log.Printf("Restored %d instances from the last bootstrap\n", len(instances))
+	return len(instances) > 0, nil
+}
+
+//export StoreBootstrapInstance
+func StoreBootstrapInstance(objectID int, instanceID int, buffer *C.char, length C.int) {
+	pendingInstances = append(pendingInstances, BootstrapInstance{
+		ObjectID:   objectID,
+		InstanceID: instanceID,
+		Data:       C.GoBytes(unsafe.Pointer(buffer), length),
+	})
+}
+
+//export CommitBootstrapInstances
+func CommitBootstrapInstances(complete int) {
+	instances := pendingInstances
+	pendingInstances = nil
+
+	if complete == 0 {
+		log.Println("Error reading the bootstrap instances, they are not stored")
+		return
+	}
+
+	b, err := json.Marshal(instances)
+	if err != nil {
+		log.Printf("Error marshalling the bootstrap instances: %v", err)
+		return
+	}
+
+	// The security instances hold the secret keys
+	if err = objects.WriteFileAtomic(bootstrapPath(), b, 0600); err != nil {
+		log.Printf("Error storing the bootstrap instances: %v", err)
+		return
+	}
+	log.Printf("Stored %d instances from the bootstrap\n", len(instances))
+
+	resetRegistrationFailures()
+}
+
+//export BootstrapNeeded
+func BootstrapNeeded() C.int {
+	registrations.Lock()
+	defer registrations.Unlock()
+
+	for _, r := range registrations.servers {
+		if r.Failures < maxRegistrationFailures {
+			return 0
+		}
+	}
+
+	log.Println("Registration keeps failing, bootstrapping again")
+	for _, r := range registrations.servers {
+		r.Failures = 0
+	}
+	return 1
+}
+
+func resetRegistrationFailures() {
+	registrations.Lock()
+	defer registrations.Unlock()
+
+	for _, r := range registrations.servers {
+		r.Failures = 0
+	}
+}
+
+func bootstrapPath() string {
+	return filepath.Join(os.Getenv(paramsEnvVar), bootstrapFilename)
+}
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"strings"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+//export ConnectivityRead
+func ConnectivityRead(rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(connectivityValue(rid), valueType, length)
+}
+
+// connectivityValue returns the value of a single-instance resource of the
+// network connection. The bearer of a modem is empty when it is unknown.
+func connectivityValue(rid int) Value {
+	o := objects.GetConnectivityInstance()
+	switch rid {
+	case 0:
+		if o.Bearer == objects.BearerUnknown {
+			return Value{Type: IntegerType}
+		}
+		return IntValue(int64(o.Bearer))
+	case 2:
+		return IntValue(int64(o.SignalStrength))
+	case 3:
+		return IntValue(int64(o.LinkQuality))
+	default:
+		return StringValue("")
+	}
+}
+
+//export ConnectivityCount
+func ConnectivityCount(rid int) C.int {
+	return C.int(len(connectivityValues(rid)))
+}
+
+//export ConnectivityReadInstance
+func ConnectivityReadInstance(rid int, index int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	values := connectivityValues(rid)
+	if index < 0 || index >= len(values) {
+		return cValue(StringValue(""), valueType, length)
+	}
+	return cValue(values[index], valueType, length)
+}
+
+// connectivityValues returns the values of a multiple-instance resource
+func connectivityValues(rid int) []Value {
+	o := objects.GetConnectivityInstance()
+	values := []Value{}
+	switch rid {
+	case 1:
+		for _, b := range o.AvailableBearers {
+			values = append(values, IntValue(int64(b)))
+		}
+	case 4:
+		for _, a := range o.IPAddresses {
+			values = append(values, StringValue(a))
+		}
+	case 5:
+		for _, a := range o.RouterAddresses {
+			values = append(values, StringValue(a))
+		}
+	}
+	return values
+}
+
+// ConnectivityRefreshData refreshes the state of the network connection
+func ConnectivityRefreshData() map[string]Value {
+	o := objects.GetConnectivityInstance()
+
+	data := map[string]Value{
+		"/4/0/2": IntValue(int64(o.SignalStrength)),
+		"/4/0/3": IntValue(int64(o.LinkQuality)),
+		"/4/0/4": StringValue(strings.Join(o.IPAddresses, ",")),
+		"/4/0/5": StringValue(strings.Join(o.RouterAddresses, ",")),
+	}
+	if o.Bearer != objects.BearerUnknown {
+		data["/4/0/0"] = IntValue(int64(o.Bearer))
+	}
+
+	return data
+}
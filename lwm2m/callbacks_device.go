@@ -12,7 +12,9 @@ package lwm2m
 */
 import "C"
 import (
-	"unsafe"
+	"log"
+	"strconv"
+	"time"
 
 	"launchpad.net/ce-web/alpaca/objects"
 )
@@ -23,46 +25,79 @@ func GetDeviceInformation() {
 }
 
 //export DeviceObjectRead
-func DeviceObjectRead(rid int) *C.char {
-
-	var resp *C.char
-	defer C.free(unsafe.Pointer(resp))
+func DeviceObjectRead(rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(deviceValue(rid), valueType, length)
+}
 
+// deviceValue returns the value of a resource of the device
+func deviceValue(rid int) Value {
 	o := objects.GetDeviceInstance()
 
 	switch rid {
 	case 0:
-		resp = C.CString(o.Info.Brand)
+		return StringValue(o.Info.Brand)
 	case 1:
-		resp = C.CString(o.Info.Model)
+		return StringValue(o.Info.Model)
 	case 2:
-		resp = C.CString(o.Info.Serial)
+		return StringValue(o.Info.Serial)
 	case 3:
-		resp = C.CString(o.Info.FirmwareVersion)
+		return StringValue(o.Info.FirmwareVersion)
 	case 13:
-		resp = C.CString(o.Info.CurrentTime)
+		return TimeValue(deviceTime(o.Info.CurrentTime))
 	case 14:
-		resp = C.CString(o.Info.UTCOffset)
+		return StringValue(o.Info.UTCOffset)
 	case 15:
-		resp = C.CString(o.Info.Timezone)
+		return StringValue(o.Info.Timezone)
 	case 19:
-		resp = C.CString(o.Info.SoftwareVersion)
+		return StringValue(o.Info.SoftwareVersion)
 	default:
-		resp = C.CString("")
+		return StringValue("")
+	}
+}
+
+// deviceTime parses the current time of the device, in seconds since the epoch
+func deviceTime(s string) time.Time {
+	t, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Unix(t, 0)
+}
+
+//export DeviceReboot
+func DeviceReboot() C.int {
+	o := objects.GetDeviceInstance()
+
+	err := o.Reboot()
+	if err != nil {
+		log.Printf("Error rebooting the device: %v", err)
+		return C.int(-1)
 	}
+	return C.int(0)
+}
 
-	return resp
+//export DeviceFactoryReset
+func DeviceFactoryReset() C.int {
+	o := objects.GetDeviceInstance()
+
+	err := o.RequestFactoryReset()
+	if err != nil {
+		log.Printf("Error requesting the factory reset: %v", err)
+		return C.int(-1)
+	}
+	return C.int(0)
 }
 
 // DeviceRefreshData refreshes the data for resources whose values change often
-func DeviceRefreshData() map[string]string {
+func DeviceRefreshData() map[string]Value {
 	o := objects.GetDeviceInstance()
 
-	data := map[string]string{
-		"/3/0/3":  o.Info.FirmwareVersion,
-		"/3/0/13": o.Info.CurrentTime,
-		"/3/0/14": o.Info.UTCOffset,
-		"/3/0/19": o.Info.SoftwareVersion,
+	data := map[string]Value{
+		"/3/0/3":  StringValue(o.Info.FirmwareVersion),
+		"/3/0/13": TimeValue(deviceTime(o.Info.CurrentTime)),
+		"/3/0/14": StringValue(o.Info.UTCOffset),
+		"/3/0/19": StringValue(o.Info.SoftwareVersion),
 	}
 
 	return data
@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"log"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+//export FirmwareRead
+func FirmwareRead(rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(firmwareValue(rid), valueType, length)
+}
+
+// firmwareValue returns the value of a resource of the firmware update
+func firmwareValue(rid int) Value {
+	s := objects.GetFirmwareInstance().Status()
+	switch rid {
+	case 1:
+		return StringValue(s.URI)
+	case 3:
+		return IntValue(int64(s.State))
+	case 5:
+		return IntValue(int64(s.Result))
+	case 6:
+		return StringValue(s.Name)
+	case 7:
+		return StringValue(s.Version)
+	default:
+		return StringValue("")
+	}
+}
+
+//export FirmwareWrite
+func FirmwareWrite(rid int, value *C.char) C.int {
+	o := objects.GetFirmwareInstance()
+
+	switch rid {
+	case 1:
+		if err := o.SetPackageURI(C.GoString(value)); err != nil {
+			log.Printf("Error setting the firmware package URI: %v", err)
+			return C.int(-1)
+		}
+		return C.int(0)
+	default:
+		return C.int(-1)
+	}
+}
+
+//export FirmwareExecute
+func FirmwareExecute(rid int) C.int {
+	o := objects.GetFirmwareInstance()
+
+	switch rid {
+	case 2:
+		if err := o.Update(); err != nil {
+			log.Printf("Error updating the firmware: %v", err)
+			return C.int(-1)
+		}
+		return C.int(0)
+	default:
+		return C.int(-1)
+	}
+}
+
+// FirmwareRefreshData refreshes the state of the firmware update
+func FirmwareRefreshData() map[string]Value {
+	o := objects.GetFirmwareInstance()
+	o.Refresh()
+	s := o.Status()
+
+	data := map[string]Value{
+		"/5/0/3": IntValue(int64(s.State)),
+		"/5/0/5": IntValue(int64(s.Result)),
+		"/5/0/6": StringValue(s.Name),
+		"/5/0/7": StringValue(s.Version),
+	}
+
+	return data
+}
@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+// ConfigureLocation sets the source of the device position from the configuration parameters
+func ConfigureLocation(c ConfigParameters) {
+	var static objects.LocationProvider
+	if len(c.Latitude) > 0 && len(c.Longitude) > 0 {
+		lat, errLat := strconv.ParseFloat(c.Latitude, 64)
+		lon, errLon := strconv.ParseFloat(c.Longitude, 64)
+		alt, _ := strconv.ParseFloat(c.Altitude, 64)
+		if errLat != nil || errLon != nil {
+			log.Printf("Error parsing the static position: %s, %s", c.Latitude, c.Longitude)
+		} else {
+			static = objects.NewStaticLocation(lat, lon, alt)
+		}
+	}
+
+	if len(c.GPSD) > 0 {
+		objects.UseLocationProvider(objects.NewGPSDLocation(c.GPSD, static))
+	} else if static != nil {
+		objects.UseLocationProvider(static)
+	}
+}
+
+//export LocationRead
+func LocationRead(rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(locationValue(rid), valueType, length)
+}
+
+// locationValue returns the value of a resource of the position
+func locationValue(rid int) Value {
+	p := objects.GetLocationInstance().Position()
+
+	switch rid {
+	case 0:
+		return FloatValue(p.Latitude)
+	case 1:
+		return FloatValue(p.Longitude)
+	case 2:
+		return FloatValue(p.Altitude)
+	case 3:
+		return FloatValue(p.Radius)
+	case 4:
+		return OpaqueValue(p.Velocity())
+	case 5:
+		return TimeValue(time.Unix(p.Timestamp, 0))
+	case 6:
+		return FloatValue(p.Speed)
+	default:
+		return StringValue("")
+	}
+}
+
+// LocationRefreshData returns the position when the device has moved
+func LocationRefreshData() map[string]Value {
+	p, changed := objects.GetLocationInstance().Changed()
+	if !changed {
+		return map[string]Value{}
+	}
+
+	data := map[string]Value{
+		"/6/0/0": FloatValue(p.Latitude),
+		"/6/0/1": FloatValue(p.Longitude),
+		"/6/0/2": FloatValue(p.Altitude),
+		"/6/0/3": FloatValue(p.Radius),
+		"/6/0/4": OpaqueValue(p.Velocity()),
+		"/6/0/5": TimeValue(time.Unix(p.Timestamp, 0)),
+		"/6/0/6": FloatValue(p.Speed),
+	}
+
+	return data
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+
+//export GetOperationCount
+func GetOperationCount() C.int {
+	return C.int(len(objects.CachedOperationsInstance().IDs()))
+}
+
+//export GetOperationInstanceID
+func GetOperationInstanceID(index int) C.int {
+	ids := objects.CachedOperationsInstance().IDs()
+	if index < 0 || index >= len(ids) {
+		return C.int(-1)
+	}
+	return C.int(ids[index])
+}
+
+//export OperationRead
+func OperationRead(instanceID int, rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	op, ok := objects.GetOperationsInstance().Operation(instanceID)
+	if !ok {
+		log.Println("Attempt to retrieve an unlisted snap operation")
+		return cValue(StringValue(""), valueType, length)
+	}
+
+	return cValue(operationResource(op, rid), valueType, length)
+}
+
+// operationResource returns the value of a resource of the snap operation
+func operationResource(op objects.Operation, rid int) Value {
+	switch rid {
+	case 0:
+		return StringValue(op.Action)
+	case 1:
+		return StringValue(op.Snap)
+	case 2:
+		return IntValue(int64(op.State))
+	case 3:
+		return IntValue(int64(op.Progress))
+	case 4:
+		return StringValue(op.Change)
+	case 5:
+		return StringValue(op.Error)
+	case 6:
+		return TimeValue(op.Started)
+	case 7:
+		return TimeValue(op.Updated)
+	case 8:
+		// Zero until the operation has finished
+		if op.Finished.IsZero() {
+			return TimeValue(time.Unix(0, 0))
+		}
+		return TimeValue(op.Finished)
+	default:
+		return StringValue("")
+	}
+}
+
+// startOperation runs the snap action in the background, tracked by an
+// operation instance
+func startOperation(action, snap string, run func() (string, error)) error {
+	id, err := objects.GetOperationsInstance().Start(action, snap, run)
+	if err != nil {
+		return err
+	}
+	log.Printf("The %s of %s is tracked by /30003/%d", action, snap, id)
+	return nil
+}
+
+// operationsUpdated holds when each operation was updated at the last refresh
+var operationsUpdated = map[int]time.Time{}
+
+// OperationsRefreshData refreshes the resources of the operations that have
+// changed since the last refresh, so that the observers see their completion
+func OperationsRefreshData() map[string]Value {
+	o := objects.GetOperationsInstance()
+
+	data := map[string]Value{}
+	updated := map[int]time.Time{}
+	for _, id := range o.IDs() {
+		op, ok := o.Operation(id)
+		if !ok {
+			continue
+		}
+		updated[id] = op.Updated
+		if op.Updated.Equal(operationsUpdated[id]) {
+			continue
+		}
+		for rid := 0; rid <= 8; rid++ {
+			data[fmt.Sprintf("/30003/%d/%d", id, rid)] = operationResource(op, rid)
+		}
+	}
+	operationsUpdated = updated
+
+	return data
+}
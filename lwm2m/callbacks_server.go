@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registration states of a server, as defined by the lwm2m library
+const (
+	StatusDeregistered = iota
+	StatusRegHoldOff
+	StatusRegPending
+	StatusRegistered
+	StatusRegFailed
+	StatusRegUpdatePending
+	StatusRegUpdateNeeded
+	StatusRegFullUpdateNeeded
+	StatusDeregPending
+)
+
+var statusNames = map[int]string{
+	StatusDeregistered:        "deregistered",
+	StatusRegHoldOff:          "registration hold off",
+	StatusRegPending:          "registration pending",
+	StatusRegistered:          "registered",
+	StatusRegFailed:           "registration failed",
+	StatusRegUpdatePending:    "registration update pending",
+	StatusRegUpdateNeeded:     "registration update needed",
+	StatusRegFullUpdateNeeded: "registration full update needed",
+	StatusDeregPending:        "deregistration pending",
+}
+
+// Registration holds the registration state with a server
+type Registration struct {
+	ShortID int
+	Status  int
+	Since   time.Time
+
+	// Failures counts the registration attempts that failed since the last success
+	Failures int
+}
+
+// Registered checks whether the client is registered with the server
+func (r Registration) Registered() bool {
+	switch r.Status {
+	case StatusRegistered, StatusRegUpdatePending, StatusRegUpdateNeeded, StatusRegFullUpdateNeeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// StatusName returns the readable name of the registration state
+func (r Registration) StatusName() string {
+	if name, ok := statusNames[r.Status]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+var registrations = struct {
+	sync.Mutex
+	servers map[int]*Registration
+}{servers: map[int]*Registration{}}
+
+// Registrations returns the registration state with each server, by short server ID
+func Registrations() []Registration {
+	registrations.Lock()
+	defer registrations.Unlock()
+
+	list := []Registration{}
+	for _, r := range registrations.servers {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ShortID < list[j].ShortID })
+	return list
+}
+
+//export ServerRegistration
+func ServerRegistration(shortID int, status int) {
+	registrations.Lock()
+	defer registrations.Unlock()
+
+	r, ok := registrations.servers[shortID]
+	if !ok {
+		r = &Registration{ShortID: shortID, Status: -1}
+		registrations.servers[shortID] = r
+	}
+	if r.Status == status {
+		return
+	}
+
+	r.Status = status
+	r.Since = time.Now()
+	switch {
+	case status == StatusRegFailed:
+		r.Failures++
+	case r.Registered():
+		r.Failures = 0
+	}
+
+	log.Printf("Server %d: %s\n", shortID, r.StatusName())
+}
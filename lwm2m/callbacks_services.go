@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/snapcore/snapd/client"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+
+// serviceActions maps the execute resources of the snap services to their actions
+var serviceActions = map[int]string{
+	10: "start",
+	11: "stop",
+	12: "restart",
+	13: "enable",
+	14: "disable",
+}
+
+//export GetServiceCount
+func GetServiceCount() C.int {
+	return C.int(len(objects.CachedServicesInstance().IDs()))
+}
+
+//export GetServiceInstanceID
+func GetServiceInstanceID(index int) C.int {
+	ids := objects.CachedServicesInstance().IDs()
+	if index < 0 || index >= len(ids) {
+		return C.int(-1)
+	}
+	return C.int(ids[index])
+}
+
+//export ServiceRead
+func ServiceRead(instanceID int, rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	app, ok := objects.GetServicesInstance().Service(instanceID)
+	if !ok {
+		log.Println("Attempt to retrieve an unlisted snap service")
+		return cValue(StringValue(""), valueType, length)
+	}
+
+	return cValue(serviceResource(app, rid), valueType, length)
+}
+
+// serviceResource returns the value of a resource of the snap service
+func serviceResource(app client.AppInfo, rid int) Value {
+	switch rid {
+	case 0:
+		return StringValue(app.Snap)
+	case 1:
+		return StringValue(app.Name)
+	case 2:
+		return StringValue(app.Daemon)
+	case 3:
+		return BoolValue(app.Active)
+	case 4:
+		return BoolValue(app.Enabled)
+	default:
+		return StringValue("")
+	}
+}
+
+//export ServiceExecute
+func ServiceExecute(instanceID int, rid int) C.int {
+	action, ok := serviceActions[rid]
+	if !ok {
+		return C.int(-1)
+	}
+
+	o := objects.GetServicesInstance()
+	app, ok := o.Service(instanceID)
+	if !ok {
+		log.Println("Attempt to retrieve an unlisted snap service")
+		return C.int(-1)
+	}
+	name := objects.ServiceName(app)
+
+	var run func() (string, error)
+	switch action {
+	case "start":
+		run = func() (string, error) { return o.Start(name) }
+	case "stop":
+		run = func() (string, error) { return o.Stop(name) }
+	case "restart":
+		run = func() (string, error) { return o.Restart(name) }
+	case "enable":
+		run = func() (string, error) { return o.Enable(name) }
+	case "disable":
+		run = func() (string, error) { return o.Disable(name) }
+	}
+
+	if err := startOperation(action, name, run); err != nil {
+		log.Printf("Error requesting the %s of %s: %v", action, name, err)
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+// ServicesRefreshData refreshes the state of the snap services, so that the
+// observers see a service stop or start
+func ServicesRefreshData() map[string]Value {
+	o := objects.GetServicesInstance()
+
+	data := map[string]Value{}
+	for _, id := range o.IDs() {
+		app, ok := o.Service(id)
+		if !ok {
+			continue
+		}
+		data[fmt.Sprintf("/30004/%d/3", id)] = serviceResource(app, 3)
+		data[fmt.Sprintf("/30004/%d/4", id)] = serviceResource(app, 4)
+	}
+
+	return data
+}
@@ -5,11 +5,11 @@
 package lwm2m
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
+	"sort"
 	"time"
-	"unsafe"
 
 	"github.com/snapcore/snapd/client"
 
@@ -26,126 +26,229 @@ import "C"
 
 //export GetSnapCount
 func GetSnapCount() C.int {
-	l := objects.GetSnapsInstance()
+	l := objects.CachedSnapsInstance()
 	number := len(l.Snaps)
 
 	return C.int(number)
 }
 
+//export GetSnapInstanceID
+func GetSnapInstanceID(index int) C.int {
+	ids := objects.CachedSnapsInstance().IDs()
+	if index < 0 || index >= len(ids) {
+		return C.int(-1)
+	}
+	return C.int(ids[index])
+}
+
 //export SnapInstanceRead
-func SnapInstanceRead(instanceID int, rid int) *C.char {
+func SnapInstanceRead(instanceID int, rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(snapValue(instanceID, rid), valueType, length)
+}
+
+// snapValue returns the value of a resource of the snap instance
+func snapValue(instanceID int, rid int) Value {
+	o := objects.GetSnapsInstance()
+	snap, ok := o.Snap(instanceID)
+	if !ok {
+		log.Println("Attempt to retrieve an unlisted snap")
+		return StringValue("")
+	}
 
-	var resp *C.char
-	defer C.free(unsafe.Pointer(resp))
+	c := objects.GetSnapConfigsInstance()
+	switch rid {
+	case 16:
+		conf, err := c.Conf(o, snap.Name)
+		if err != nil {
+			log.Printf("Error retrieving the config of %s: %v", snap.Name, err)
+		}
+		return StringValue(conf)
+	case 17:
+		return StringValue(c.Key(snap.Name))
+	case 18:
+		value, err := c.Value(o, snap.Name)
+		if err != nil {
+			log.Printf("Error retrieving the config value of %s: %v", snap.Name, err)
+		}
+		return StringValue(value)
+	case 20:
+		return IntValue(int64(c.Result(snap.Name)))
+	default:
+		return snapResource(snap, rid)
+	}
+}
 
+//export SnapInstanceWrite
+func SnapInstanceWrite(instanceID int, rid int, value *C.char, length C.int) C.int {
 	o := objects.GetSnapsInstance()
+	snap, ok := o.Snap(instanceID)
+	if !ok {
+		log.Println("Attempt to configure an unlisted snap")
+		return C.int(-1)
+	}
 
-	if instanceID > len(o.Snaps) {
-		log.Println("Attempt to retrieve an unlisted snap")
-		return resp
+	c := objects.GetSnapConfigsInstance()
+	switch rid {
+	case 17:
+		if err := c.SetKey(snap.Name, C.GoStringN(value, length)); err != nil {
+			log.Printf("Error selecting the config key of %s: %v", snap.Name, err)
+			return C.int(-1)
+		}
+		return C.int(0)
+	case 19:
+		if err := c.Patch(o, snap.Name, []byte(C.GoStringN(value, length))); err != nil {
+			log.Printf("Error setting the config of %s: %v", snap.Name, err)
+			return C.int(-1)
+		}
+		return C.int(0)
+	default:
+		return C.int(-1)
 	}
+}
 
+// snapResource returns the value of a resource of the snap
+func snapResource(s client.Snap, rid int) Value {
 	switch rid {
 	case 0:
-		resp = C.CString(o.Snaps[instanceID].Name)
+		return StringValue(s.Name)
 	case 1:
-		resp = C.CString(o.Snaps[instanceID].Summary)
+		return StringValue(s.Summary)
 	case 2:
-		resp = C.CString(o.Snaps[instanceID].Confinement)
+		return StringValue(s.Confinement)
 	case 3:
-		resp = C.CString(o.Snaps[instanceID].Developer)
+		return StringValue(s.Developer)
 	case 4:
-		resp = C.CString(o.Snaps[instanceID].InstallDate.UTC().Format(time.RFC3339))
+		return TimeValue(s.InstallDate)
 	case 5:
-		value := strconv.FormatInt(o.Snaps[instanceID].InstalledSize, 10)
-		resp = C.CString(value)
+		return IntValue(s.InstalledSize)
 	case 6:
-		resp = C.CString(o.Snaps[instanceID].Status)
+		return StringValue(s.Status)
 	case 7:
-		resp = C.CString(o.Snaps[instanceID].Version)
+		return StringValue(s.Version)
 	case 8:
-		resp = C.CString(o.Snaps[instanceID].Revision.String())
+		return StringValue(s.Revision.String())
 	case 9:
-		if o.Snaps[instanceID].DevMode {
-			resp = C.CString("true")
-		}
-		resp = C.CString("false")
+		return BoolValue(s.DevMode)
 	default:
-		resp = C.CString("")
+		return StringValue("")
 	}
+}
 
-	return resp
+// snapInstanceActions maps the execute resources of the snap instances to the
+// actions of the Snap Control object
+var snapInstanceActions = map[int]int{
+	11: 12, // Refresh
+	12: 11, // Remove
+	13: 13, // Revert
+	14: 14, // Enable
+	15: 15, // Disable
 }
 
 //export SnapInstanceExecute
-func SnapInstanceExecute(instanceID int, rid int) *C.char {
-
-	var resp *C.char
-	defer C.free(unsafe.Pointer(resp))
-
+func SnapInstanceExecute(instanceID int, rid int, args *C.char, length C.int) C.int {
 	o := objects.GetSnapsInstance()
 
-	if instanceID > len(o.Snaps) {
+	snap, ok := o.Snap(instanceID)
+	if !ok {
 		log.Println("Attempt to retrieve an unlisted snap")
-		resp = C.CString("")
-		return resp
+		return C.int(-1)
 	}
 
-	snapName := o.Snaps[instanceID].Name
-
-	switch rid {
-	case 11:
-		resp = C.CString(o.Refresh(snapName))
-	case 12:
-		resp = C.CString(o.Remove(snapName))
-	case 13:
-		resp = C.CString(o.Revert(snapName))
-	case 14:
-		resp = C.CString(o.Enable(snapName))
-	case 15:
-		resp = C.CString(o.Disable(snapName))
-	default:
-		resp = C.CString("Unknown action requested")
+	a, err := parseSnapArgs(C.GoStringN(args, length))
+	if err == nil && len(a.Name) > 0 && a.Name != snap.Name {
+		err = fmt.Errorf("the snap is %s, not %s", snap.Name, a.Name)
 	}
+	if err != nil {
+		log.Printf("Invalid arguments for the action on %s: %v", snap.Name, err)
+		return C.int(-1)
+	}
+	a.Name = snap.Name
 
-	return resp
+	if err := snapAction(o, snapInstanceActions[rid], a); err != nil {
+		log.Printf("Error requesting the action on %s: %v", snap.Name, err)
+		return C.int(-1)
+	}
+	return C.int(0)
 }
 
 //export SnapDelimited
 func SnapDelimited(instanceID int) *C.char {
-
-	var resp *C.char
-	defer C.free(unsafe.Pointer(resp))
-
-	o := objects.GetSnapsInstance()
-
-	if instanceID > len(o.Snaps) {
+	// The C caller frees the returned string
+	snap, ok := objects.GetSnapsInstance().Snap(instanceID)
+	if !ok {
 		log.Println("Attempt to retrieve an unlisted snap")
-		return resp
+		return C.CString("")
 	}
 
 	// Return a pipe-delimited representation of the snap
-	s := encodeSnap(o.Snaps[instanceID])
-	resp = C.CString(s)
+	return C.CString(encodeSnap(snap))
+}
+
+//export SnapControlConfig
+func SnapControlConfig() *C.char {
+	// The C caller frees the returned string
+	conf := objects.GetSnapConfigsInstance().Configuration(objects.GetSnapsInstance())
 
-	return resp
+	b, err := json.Marshal(conf)
+	if err != nil {
+		log.Println("Error encoding the snap config:", err)
+		return C.CString("{}")
+	}
+	return C.CString(string(b))
+}
+
+//export SnapControlSetConfig
+func SnapControlSetConfig(value *C.char, length C.int) C.int {
+	if err := setSnapConfig([]byte(C.GoStringN(value, length))); err != nil {
+		log.Println("Error setting the snap config:", err)
+		return C.int(-1)
+	}
+	return C.int(0)
 }
 
-//export SnapConfig
-func SnapConfig(instanceID int) {
+// setSnapConfig applies the JSON document of config patches, keyed by snap
+// name, to the snaps. Each snap is configured by an operation.
+func setSnapConfig(doc []byte) error {
+	patches := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(doc, &patches); err != nil {
+		return err
+	}
+
 	o := objects.GetSnapsInstance()
-	if instanceID > len(o.Snaps) {
-		log.Println("Attempt to retrieve an unlisted snap")
-		return
+	for name := range patches {
+		if !o.Installed(name) {
+			return fmt.Errorf("snap %s is not installed", name)
+		}
 	}
 
-	snap := o.Snaps[instanceID]
-	c, err := o.Conf(snap.Name)
-	if err != nil {
-		log.Println("Error retrieving the snap config:", err)
+	for name, patch := range patches {
+		if err := objects.GetSnapConfigsInstance().Apply(o, name, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//export GetSnapErrorCount
+func GetSnapErrorCount() C.int {
+	return C.int(len(objects.GetErrorsInstance().Errors()))
+}
+
+//export SnapErrorRead
+func SnapErrorRead(index int) *C.char {
+	// The C caller frees the returned string
+	errors := objects.GetErrorsInstance().Errors()
+	if index < 0 || index >= len(errors) {
+		return C.CString("")
 	}
 
-	log.Println("---", c)
+	return C.CString(encodeSnapError(errors[index]))
+}
+
+// encodeSnapError creates a pipe-delimited description of the snap error
+func encodeSnapError(e objects.SnapError) string {
+	return e.Time.UTC().Format(time.RFC3339) + "|" + e.Action + "|" + e.Snap + "|" + e.Kind + "|" + e.Message
 }
 
 // encodeSnap creates a pipe-delimited description of the snap. This is a hacked solution
@@ -156,58 +259,124 @@ func encodeSnap(s client.Snap) string {
 }
 
 //export SnapExecute
-func SnapExecute(action int, name *C.char) *C.char {
+func SnapExecute(action int, args *C.char, length C.int) C.int {
+	o := objects.GetSnapsInstance()
 
-	var resp *C.char
-	defer C.free(unsafe.Pointer(resp))
+	a, err := parseSnapArgs(C.GoStringN(args, length))
+	if err == nil && len(a.Name) == 0 {
+		err = fmt.Errorf("no snap name")
+	}
+	if err != nil {
+		log.Println("Invalid arguments for the snap action:", err)
+		return C.int(-1)
+	}
 
-	o := objects.GetSnapsInstance()
-	snapName := C.GoString(name)
+	if err := snapAction(o, action, a); err != nil {
+		log.Printf("Error requesting the action on %s: %v", a.Name, err)
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+// snapAction starts the action of the Snap Control object on the snap. Only
+// the install and the refresh take options.
+func snapAction(o *objects.SnapList, action int, a snapArgs) error {
+	name, options := a.Name, a.options()
+	if options != nil && action != 10 && action != 12 {
+		return fmt.Errorf("the action takes no options")
+	}
 
+	// The snapd change runs in the background, tracked by an operation instance
 	switch action {
 	case 10:
-		resp = C.CString(o.Install(snapName))
+		return startOperation("install", name, func() (string, error) { return o.Install(name, options) })
 	case 11:
-		resp = C.CString(o.Uninstall(snapName))
+		return startOperation("uninstall", name, func() (string, error) { return o.Uninstall(name) })
 	case 12:
-		resp = C.CString(o.Refresh(snapName))
+		return startOperation("refresh", name, func() (string, error) { return o.Refresh(name, options) })
 	case 13:
-		resp = C.CString(o.Revert(snapName))
+		return startOperation("revert", name, func() (string, error) { return o.Revert(name) })
 	case 14:
-		resp = C.CString(o.Enable(snapName))
+		return startOperation("enable", name, func() (string, error) { return o.Enable(name) })
 	case 15:
-		resp = C.CString(o.Disable(snapName))
+		return startOperation("disable", name, func() (string, error) { return o.Disable(name) })
+	default:
+		return fmt.Errorf("unknown action %d", action)
 	}
-
-	return resp
 }
 
 // SnapRefreshData refreshes the data for resources whose values change often
-func SnapRefreshData() map[string]string {
+func SnapRefreshData() map[string]Value {
 	o := objects.GetSnapsInstance()
 
-	data := map[string]string{
-		"/30000/0/0": string(len(o.Snaps)),
-		"/30000/0/1": "",
-		"/30000/0/2": "",
-	}
-
-	for i := range o.Snaps {
-		data[fmt.Sprintf("/30001/%d/0", i)] = o.Snaps[i].Name
-		data[fmt.Sprintf("/30001/%d/1", i)] = o.Snaps[i].Summary
-		data[fmt.Sprintf("/30001/%d/2", i)] = o.Snaps[i].Confinement
-		data[fmt.Sprintf("/30001/%d/3", i)] = o.Snaps[i].Developer
-		data[fmt.Sprintf("/30001/%d/4", i)] = o.Snaps[i].InstallDate.UTC().Format(time.RFC3339)
-		data[fmt.Sprintf("/30001/%d/5", i)] = string(o.Snaps[i].InstalledSize)
-		data[fmt.Sprintf("/30001/%d/6", i)] = o.Snaps[i].Status
-		data[fmt.Sprintf("/30001/%d/7", i)] = o.Snaps[i].Version
-		data[fmt.Sprintf("/30001/%d/8", i)] = o.Snaps[i].Revision.String()
-		if o.Snaps[i].DevMode {
-			data[fmt.Sprintf("/30001/%d/9", i)] = "true"
-		} else {
-			data[fmt.Sprintf("/30001/%d/9", i)] = "false"
+	data := map[string]Value{
+		"/30000/0/0": IntValue(int64(len(o.Snaps))),
+		"/30000/0/1": StringValue(""),
+		"/30000/0/3": StringValue(""),
+	}
+
+	for _, id := range o.IDs() {
+		snap, ok := o.Snap(id)
+		if !ok {
+			continue
+		}
+		for rid := 0; rid <= 9; rid++ {
+			data[fmt.Sprintf("/30001/%d/%d", id, rid)] = snapResource(snap, rid)
 		}
+		data[fmt.Sprintf("/30001/%d/20", id)] = IntValue(int64(objects.GetSnapConfigsInstance().Result(snap.Name)))
 	}
 
+	sendSnapChanges(o)
+
 	return data
 }
+
+// snapStates holds the revision and status of each snap instance at the last
+// refresh
+var snapStates map[int]string
+
+// sendSnapChanges pushes the instances of the snaps that were installed,
+// refreshed, changed status or removed since the last refresh, with the snap
+// summary
+func sendSnapChanges(o *objects.SnapList) {
+	states := map[int]string{}
+	for _, id := range o.IDs() {
+		if s, ok := o.Snap(id); ok {
+			states[id] = s.Revision.String() + "|" + s.Status
+		}
+	}
+
+	first := snapStates == nil
+	paths := changedSnapPaths(snapStates, states)
+	snapStates = states
+	if first || len(paths) == 0 {
+		return
+	}
+
+	if err := Send(append(paths, "/30000/0")...); err != nil {
+		log.Println("Error sending the snap changes:", err)
+	}
+}
+
+// changedSnapPaths returns the paths of the snap instances that differ between
+// the states. A removed instance is sent without a value.
+func changedSnapPaths(previous, current map[int]string) []string {
+	ids := []int{}
+	for id, state := range current {
+		if previous[id] != state {
+			ids = append(ids, id)
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	paths := []string{}
+	for _, id := range ids {
+		paths = append(paths, fmt.Sprintf("/30001/%d", id))
+	}
+	return paths
+}
@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"log"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+//export GetSoftwareCount
+func GetSoftwareCount() C.int {
+	l := objects.CachedSoftwareInstance()
+	return C.int(len(l.IDs()))
+}
+
+//export GetSoftwareInstanceID
+func GetSoftwareInstanceID(index int) C.int {
+	ids := objects.CachedSoftwareInstance().IDs()
+	if index < 0 || index >= len(ids) {
+		return C.int(-1)
+	}
+	return C.int(ids[index])
+}
+
+//export SoftwareRead
+func SoftwareRead(instanceID int, rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(softwareValue(instanceID, rid), valueType, length)
+}
+
+// softwareValue returns the value of a resource of the software package
+func softwareValue(instanceID int, rid int) Value {
+	p, ok := objects.GetSoftwareInstance().Package(instanceID)
+	if !ok {
+		log.Println("Attempt to retrieve an unlisted software package")
+		return StringValue("")
+	}
+
+	switch rid {
+	case 0:
+		return StringValue(p.Name)
+	case 1:
+		return StringValue(p.Version)
+	case 7:
+		return IntValue(int64(p.UpdateState))
+	case 9:
+		return IntValue(int64(p.UpdateResult))
+	case 12:
+		return BoolValue(p.Active)
+	default:
+		return StringValue("")
+	}
+}
+
+//export SoftwareCreate
+func SoftwareCreate(instanceID int, name *C.char) C.int {
+	err := objects.GetSoftwareInstance().Create(instanceID, C.GoString(name))
+	if err != nil {
+		log.Printf("Error creating the software package: %v", err)
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+//export SoftwareExecute
+func SoftwareExecute(instanceID int, rid int) C.int {
+	o := objects.GetSoftwareInstance()
+
+	var err error
+	switch rid {
+	case 4:
+		err = o.Install(instanceID)
+	case 6:
+		err = o.Uninstall(instanceID)
+	case 10:
+		err = o.Activate(instanceID)
+	case 11:
+		err = o.Deactivate(instanceID)
+	default:
+		return C.int(-1)
+	}
+
+	if err != nil {
+		log.Printf("Error executing /9/%d/%d: %v", instanceID, rid, err)
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+//export SoftwareDelete
+func SoftwareDelete(instanceID int) C.int {
+	if err := objects.GetSoftwareInstance().Uninstall(instanceID); err != nil {
+		log.Printf("Error deleting the software package: %v", err)
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+// SoftwareRefreshData refreshes the state of the software packages
+func SoftwareRefreshData() map[string]Value {
+	o := objects.GetSoftwareInstance()
+
+	data := map[string]Value{}
+	for _, id := range o.IDs() {
+		p, ok := o.Package(id)
+		if !ok {
+			continue
+		}
+		data[fmt.Sprintf("/9/%d/1", id)] = StringValue(p.Version)
+		data[fmt.Sprintf("/9/%d/7", id)] = IntValue(int64(p.UpdateState))
+		data[fmt.Sprintf("/9/%d/9", id)] = IntValue(int64(p.UpdateResult))
+		data[fmt.Sprintf("/9/%d/12", id)] = BoolValue(p.Active)
+	}
+
+	return data
+}
@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"log"
+	"strconv"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+//export StatisticsRead
+func StatisticsRead(rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(statisticsValue(rid), valueType, length)
+}
+
+// statisticsValue returns the value of a resource of the connectivity statistics
+func statisticsValue(rid int) Value {
+	o := objects.GetStatisticsInstance()
+	switch rid {
+	case 2:
+		return IntValue(int64(o.TxKB()))
+	case 3:
+		return IntValue(int64(o.RxKB()))
+	case 4:
+		return IntValue(int64(o.MaxSize()))
+	case 5:
+		return IntValue(int64(o.AverageSize()))
+	case 8:
+		return IntValue(int64(o.Status().Period))
+	default:
+		return StringValue("")
+	}
+}
+
+//export StatisticsWrite
+func StatisticsWrite(rid int, value *C.char) C.int {
+	o := objects.GetStatisticsInstance()
+
+	switch rid {
+	case 8:
+		period, err := strconv.Atoi(C.GoString(value))
+		if err == nil {
+			err = o.SetPeriod(period)
+		}
+		if err != nil {
+			log.Printf("Error setting the collection period: %v", err)
+			return C.int(-1)
+		}
+		return C.int(0)
+	default:
+		return C.int(-1)
+	}
+}
+
+//export StatisticsExecute
+func StatisticsExecute(rid int) C.int {
+	o := objects.GetStatisticsInstance()
+
+	switch rid {
+	case 6:
+		o.Start()
+		return C.int(0)
+	case 7:
+		o.Stop()
+		return C.int(0)
+	default:
+		return C.int(-1)
+	}
+}
+
+// StatisticsRefreshData refreshes the traffic counters
+func StatisticsRefreshData() map[string]Value {
+	o := objects.GetStatisticsInstance()
+
+	data := map[string]Value{
+		"/7/0/2": IntValue(int64(o.TxKB())),
+		"/7/0/3": IntValue(int64(o.RxKB())),
+		"/7/0/4": IntValue(int64(o.MaxSize())),
+		"/7/0/5": IntValue(int64(o.AverageSize())),
+	}
+
+	return data
+}
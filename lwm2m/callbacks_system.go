@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"log"
+	"strings"
+
+	"launchpad.net/ce-web/alpaca/objects"
+)
+
+// systemModes maps the execute resources of the recovery system object to snapd system modes
+var systemModes = map[int]string{
+	10: objects.ModeRun,
+	11: objects.ModeRecover,
+	12: objects.ModeInstall,
+	13: objects.ModeFactoryReset,
+}
+
+//export GetSystemCount
+func GetSystemCount() C.int {
+	l := objects.GetSystemsInstance()
+	return C.int(len(l.Systems))
+}
+
+//export SystemInstanceRead
+func SystemInstanceRead(instanceID int, rid int, valueType *C.int, length *C.int) *C.char {
+	// The C caller frees the returned buffer
+	return cValue(systemValue(instanceID, rid), valueType, length)
+}
+
+// systemValue returns the value of a resource of the recovery system instance
+func systemValue(instanceID int, rid int) Value {
+	o := objects.GetSystemsInstance()
+	if instanceID < 0 || instanceID >= len(o.Systems) {
+		log.Println("Attempt to retrieve an unlisted recovery system")
+		return StringValue("")
+	}
+
+	s := o.Systems[instanceID]
+	switch rid {
+	case 0:
+		return StringValue(s.Label)
+	case 1:
+		return StringValue(s.Model.Model)
+	case 2:
+		return StringValue(s.Model.BrandID)
+	case 3:
+		return BoolValue(s.Current)
+	case 4:
+		modes := []string{}
+		for _, a := range s.Actions {
+			modes = append(modes, a.Mode)
+		}
+		return StringValue(strings.Join(modes, ","))
+	default:
+		return StringValue("")
+	}
+}
+
+//export SystemExecute
+func SystemExecute(instanceID int, rid int) C.int {
+	mode, ok := systemModes[rid]
+	if !ok {
+		return C.int(-1)
+	}
+
+	o := objects.GetSystemsInstance()
+	if err := o.Supports(instanceID, mode); err != nil {
+		log.Println(err)
+		return C.int(-1)
+	}
+
+	// The action runs once the client has deregistered
+	objects.GetDeviceInstance().RequestSystemAction(o.Systems[instanceID].Label, mode)
+	return C.int(0)
+}
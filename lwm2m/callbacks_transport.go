@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+/*
+#cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+#cgo CFLAGS: -I${SRCDIR}/wakaama/core
+#define _GNU_SOURCE
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"log"
+	"unsafe"
+)
+
+//export TransportConnect
+func TransportConnect(uri *C.char, mode int, publicID *C.char, publicIDLen C.int, serverKey *C.char, serverKeyLen C.int, secretKey *C.char, secretKeyLen C.int) C.int {
+	c := Credentials{
+		Mode:                mode,
+		PublicKeyOrIdentity: C.GoBytes(unsafe.Pointer(publicID), publicIDLen),
+		ServerPublicKey:     C.GoBytes(unsafe.Pointer(serverKey), serverKeyLen),
+		SecretKey:           C.GoBytes(unsafe.Pointer(secretKey), secretKeyLen),
+	}
+
+	id, err := connectTransport(C.GoString(uri), c)
+
+	// The TLS configuration holds the parsed key
+	for i := range c.SecretKey {
+		c.SecretKey[i] = 0
+	}
+
+	if err != nil {
+		log.Printf("Error connecting to %s: %v\n", C.GoString(uri), err)
+		return -1
+	}
+	return C.int(id)
+}
+
+//export TransportSend
+func TransportSend(transportID int, buffer *C.char, length C.int) C.int {
+	t := findTransport(transportID)
+	if t == nil {
+		return -1
+	}
+
+	if err := t.send(C.GoBytes(unsafe.Pointer(buffer), length)); err != nil {
+		log.Printf("Error sending to %s: %v\n", t.uri, err)
+		return -1
+	}
+	return 0
+}
+
+//export TransportReceive
+func TransportReceive(transportID *C.int, length *C.int) *C.char {
+	// The C caller frees the returned packet. It is NUL-terminated, so that an
+	// empty packet is not NULL.
+	p, ok := popTransportPacket()
+	if !ok {
+		return nil
+	}
+
+	*transportID = C.int(p.id)
+	*length = C.int(len(p.data))
+	return (*C.char)(C.CBytes(append(p.data, 0)))
+}
+
+//export TransportClose
+func TransportClose(transportID int) {
+	closeTransport(transportID)
+}
+
+//export TransportWakeFd
+func TransportWakeFd() C.int {
+	return C.int(transportWakeFd())
+}
@@ -5,11 +5,16 @@
 package lwm2m
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strings"
 )
 
 const (
@@ -22,17 +27,252 @@ const (
 	defaultSerialVaultAPI = ""
 	paramsEnvVar          = "SNAP_DATA"
 	paramsFilename        = "params"
+	defaultServerID       = 123
+	defaultLifetime       = 30
+	defaultBinding        = "U"
+	defaultHoldOff        = 10
+)
+
+// Address families of the client socket. Dual-stack falls back to IPv4 when
+// the host has no IPv6.
+const (
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+	AddressFamilyDual = "dual"
+)
+
+// Transports of the connection to the servers
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
 )
 
 // ConfigParameters holds the parameters to configure the client service
 type ConfigParameters struct {
-	ServerHost        string `json:"serverhost"`
-	ServerPort        string `json:"serverport"`
-	LocalPort         string `json:"localport"`
-	Name              string `json:"name"`
-	BootstrapRequired bool   `json:"bootstrap"`
-	SerialVaultURL    string `json:"url"`
-	SerialVaultAPI    string `json:"api-key"`
+	ServerHost        string         `json:"serverhost"`
+	ServerPort        string         `json:"serverport"`
+	LocalPort         string         `json:"localport"`
+	Name              string         `json:"name"`
+	BootstrapRequired bool           `json:"bootstrap"`
+	SerialVaultURL    string         `json:"url"`
+	SerialVaultAPI    string         `json:"api-key"`
+	GPSD              string         `json:"gpsd"`
+	Latitude          string         `json:"latitude"`
+	Longitude         string         `json:"longitude"`
+	Altitude          string         `json:"altitude"`
+	PSKIdentity       string         `json:"psk-identity"`
+	PSKKey            string         `json:"psk-key"`
+	Security          string         `json:"security"`
+	Lifetime          int            `json:"lifetime"`
+	Binding           string         `json:"binding"`
+	Transport         string         `json:"transport"`
+	AddressFamily     string         `json:"address-family"`
+	Servers           []ServerConfig `json:"servers"`
+	BootstrapServer   ServerConfig   `json:"bootstrap-server"`
+}
+
+// ServerConfig holds the connection parameters of a LwM2M server. The client
+// registers with each of the configured servers.
+type ServerConfig struct {
+	URI         string `json:"uri"`
+	ShortID     int    `json:"short-id"`
+	Lifetime    int    `json:"lifetime"`
+	Binding     string `json:"binding"`
+	Security    string `json:"security"`
+	PSKIdentity string `json:"psk-identity"`
+	PSKKey      string `json:"psk-key"`
+	Credentials string `json:"credentials"`
+
+	// HoldOff is the delay in seconds before the client initiates the
+	// bootstrap, or registers with the server
+	HoldOff int `json:"hold-off"`
+}
+
+// Secure checks whether the connection to the server uses DTLS
+func (c ConfigParameters) Secure() bool {
+	return c.SecurityMode() != SecurityModeNoSec
+}
+
+// SecurityMode returns the security mode of the connection to the server. A
+// pre-shared key is used when it is configured and no other mode is selected.
+func (c ConfigParameters) SecurityMode() int {
+	return securityMode(c.Security, c.PSKIdentity, c.PSKKey)
+}
+
+// ServerList returns the servers to register with. The server host and port
+// define the only server when no list is configured.
+func (c ConfigParameters) ServerList() []ServerConfig {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+
+	lifetime := c.Lifetime
+	if lifetime == 0 {
+		lifetime = defaultLifetime
+	}
+	binding := c.Binding
+	if len(binding) == 0 {
+		binding = defaultBinding
+		if c.Transport == TransportTCP {
+			binding = "T"
+		}
+	}
+
+	return []ServerConfig{{
+		URI:         ServerURI(c.ServerHost, c.ServerPort, c.SecurityMode(), c.Transport),
+		ShortID:     defaultServerID,
+		Lifetime:    lifetime,
+		Binding:     binding,
+		Security:    c.Security,
+		PSKIdentity: c.PSKIdentity,
+		PSKKey:      c.PSKKey,
+	}}
+}
+
+// BootstrapServerConfig returns the bootstrap server, if any. The server host
+// and port define the bootstrap server when bootstrap is required and no
+// separate bootstrap server is configured.
+func (c ConfigParameters) BootstrapServerConfig() (ServerConfig, bool) {
+	if len(c.BootstrapServer.URI) > 0 {
+		return c.BootstrapServer, true
+	}
+	if !c.BootstrapRequired {
+		return ServerConfig{}, false
+	}
+
+	return ServerConfig{
+		URI:         ServerURI(c.ServerHost, c.ServerPort, c.SecurityMode(), c.Transport),
+		Security:    c.Security,
+		PSKIdentity: c.PSKIdentity,
+		PSKKey:      c.PSKKey,
+		HoldOff:     defaultHoldOff,
+	}, true
+}
+
+// ServerURI builds the URI of a server from its host and port, with an IPv6
+// address in brackets. The coaps scheme is used unless the security mode is
+// NoSec, and CoAP over TCP uses the +tcp schemes.
+func ServerURI(host, port string, securityMode int, transport string) string {
+	scheme := "coap"
+	if securityMode != SecurityModeNoSec {
+		scheme = "coaps"
+	}
+	if transport == TransportTCP {
+		scheme += "+tcp"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))
+}
+
+// SecurityMode returns the security mode of the connection to the server
+func (s ServerConfig) SecurityMode() int {
+	return securityMode(s.Security, s.PSKIdentity, s.PSKKey)
+}
+
+// Validate checks the connection parameters of the server
+func (s ServerConfig) Validate() error {
+	if err := s.ValidateBootstrap(); err != nil {
+		return err
+	}
+
+	// Short server ID 0 and 65535 are reserved
+	if s.ShortID < 1 || s.ShortID > 65534 {
+		return fmt.Errorf("the short server ID must be between 1 and 65534: %d", s.ShortID)
+	}
+	if s.Lifetime <= 0 {
+		return fmt.Errorf("the lifetime must be a positive number of seconds: %d", s.Lifetime)
+	}
+	if len(s.Binding) == 0 || len(s.Binding) > 3 || strings.Trim(s.Binding, "UQST") != "" {
+		return fmt.Errorf("invalid binding: %s", s.Binding)
+	}
+	if u, _ := url.Parse(s.URI); strings.HasSuffix(u.Scheme, "+tcp") && !strings.Contains(s.Binding, "T") {
+		return fmt.Errorf("the binding must include T for CoAP over TCP: %s", s.Binding)
+	}
+
+	return nil
+}
+
+// ValidateBootstrap checks the parameters that a bootstrap server shares with
+// the other servers. It has no short server ID, lifetime or binding.
+func (s ServerConfig) ValidateBootstrap() error {
+	u, err := url.Parse(s.URI)
+	if err != nil || len(u.Host) == 0 {
+		return fmt.Errorf("invalid server URI: %s", s.URI)
+	}
+	switch u.Scheme {
+	case "coap", "coaps", "coap+tcp", "coaps+tcp":
+	default:
+		return fmt.Errorf("the server URI must use the coap, coaps, coap+tcp or coaps+tcp scheme: %s", s.URI)
+	}
+	if strings.HasPrefix(u.Scheme, "coaps") != (s.SecurityMode() != SecurityModeNoSec) {
+		return fmt.Errorf("the coaps scheme must be used for a secure connection: %s", s.URI)
+	}
+
+	// CoAP over TLS is only authenticated with certificates, while DTLS also
+	// uses a pre-shared key or a raw public key
+	if u.Scheme == "coaps+tcp" && s.SecurityMode() != SecurityModeCertificate {
+		return fmt.Errorf("the coaps+tcp scheme needs the x509 security mode: %s", s.URI)
+	}
+	if s.HoldOff < 0 {
+		return fmt.Errorf("the hold off time must not be negative: %d", s.HoldOff)
+	}
+
+	return ValidatePSK(s.PSKIdentity, s.PSKKey)
+}
+
+// ValidateServers checks the servers, which must have distinct short server IDs
+func ValidateServers(servers []ServerConfig) error {
+	ids := map[int]bool{}
+	for _, s := range servers {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		if ids[s.ShortID] {
+			return fmt.Errorf("the short server ID %d is used by more than one server", s.ShortID)
+		}
+		ids[s.ShortID] = true
+	}
+	return nil
+}
+
+// ValidateServers checks the servers and the bootstrap server that the client
+// connects to with the parameters
+func (c ConfigParameters) ValidateServers() error {
+	if err := ValidateServers(c.ServerList()); err != nil {
+		return err
+	}
+	if s, ok := c.BootstrapServerConfig(); ok {
+		return s.ValidateBootstrap()
+	}
+	return nil
+}
+
+func securityMode(name, pskIdentity, pskKey string) int {
+	switch name {
+	case SecurityRPK:
+		return SecurityModeRPK
+	case SecurityCertificate:
+		return SecurityModeCertificate
+	}
+
+	if len(pskIdentity) > 0 && len(pskKey) > 0 {
+		return SecurityModePSK
+	}
+	return SecurityModeNoSec
+}
+
+// ValidatePSK checks the pre-shared key identity and hex-encoded key. Both or
+// neither must be given. The key is never included in the error.
+func ValidatePSK(identity, key string) error {
+	if len(identity) == 0 && len(key) == 0 {
+		return nil
+	}
+	if len(identity) == 0 || len(key) == 0 {
+		return errors.New("both the pre-shared key identity and key are required")
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return errors.New("the pre-shared key must be an even number of hex digits")
+	}
+	return nil
 }
 
 // StoreParameters stores the configuration parameters on the filesystem
@@ -59,8 +299,8 @@ func StoreParameters(c ConfigParameters) error {
 		c.SerialVaultAPI = defaultSerialVaultAPI
 	}
 
-	// Create the output file
-	f, err := os.Create(path)
+	// Create the output file, restricting access as it may hold the pre-shared key
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -81,9 +321,8 @@ func StoreParameters(c ConfigParameters) error {
 	}
 	f.Sync()
 
-	// Restrict access to the file
-	err = os.Chmod(path, 0600)
-	return nil
+	// Restrict access to a file that was created before
+	return os.Chmod(path, 0600)
 }
 
 // ReadParameters fetches the store config parameters
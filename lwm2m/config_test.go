@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"testing"
+)
+
+func TestServerConfigValidate(t *testing.T) {
+	valid := ServerConfig{URI: "coap://localhost:5683", ShortID: 1, Lifetime: 30, Binding: "U"}
+
+	tests := []struct {
+		name   string
+		change func(*ServerConfig)
+		valid  bool
+	}{
+		{"no security", func(s *ServerConfig) {}, true},
+		{"pre-shared key", func(s *ServerConfig) {
+			s.URI = "coaps://localhost:5684"
+			s.PSKIdentity, s.PSKKey = "device", "0a0b0c"
+		}, true},
+		{"raw public key", func(s *ServerConfig) {
+			s.URI = "coaps://localhost:5684"
+			s.Security = SecurityRPK
+		}, true},
+		{"certificate over TLS", func(s *ServerConfig) {
+			s.URI = "coaps+tcp://localhost:5684"
+			s.Security = SecurityCertificate
+			s.Binding = "T"
+		}, true},
+		{"certificate over DTLS", func(s *ServerConfig) {
+			s.URI = "coaps://localhost:5684"
+			s.Security = SecurityCertificate
+		}, true},
+		{"pre-shared key over TLS", func(s *ServerConfig) {
+			s.URI = "coaps+tcp://localhost:5684"
+			s.PSKIdentity, s.PSKKey = "device", "0a0b0c"
+			s.Binding = "T"
+		}, false},
+		{"secure mode without coaps", func(s *ServerConfig) { s.Security = SecurityRPK }, false},
+		{"coaps without a secure mode", func(s *ServerConfig) { s.URI = "coaps://localhost:5684" }, false},
+		{"invalid pre-shared key", func(s *ServerConfig) {
+			s.URI = "coaps://localhost:5684"
+			s.PSKIdentity, s.PSKKey = "device", "xyz"
+		}, false},
+		{"invalid scheme", func(s *ServerConfig) { s.URI = "http://localhost" }, false},
+		{"reserved short ID", func(s *ServerConfig) { s.ShortID = 65535 }, false},
+		{"no lifetime", func(s *ServerConfig) { s.Lifetime = 0 }, false},
+		{"TCP without the T binding", func(s *ServerConfig) { s.URI = "coap+tcp://localhost:5683" }, false},
+		{"negative hold off", func(s *ServerConfig) { s.HoldOff = -1 }, false},
+	}
+
+	for _, tt := range tests {
+		s := valid
+		tt.change(&s)
+		if err := s.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateServersSharedShortID(t *testing.T) {
+	servers := []ServerConfig{
+		{URI: "coap://one:5683", ShortID: 1, Lifetime: 30, Binding: "U"},
+		{URI: "coap://two:5683", ShortID: 1, Lifetime: 30, Binding: "U"},
+	}
+	if err := ValidateServers(servers); err == nil {
+		t.Error("servers with the same short server ID were accepted")
+	}
+
+	servers[1].ShortID = 2
+	if err := ValidateServers(servers); err != nil {
+		t.Errorf("ValidateServers: %v", err)
+	}
+}
+
+func TestConfigParametersValidateServers(t *testing.T) {
+	tests := []struct {
+		name      string
+		security  string
+		transport string
+		bootstrap bool
+		valid     bool
+	}{
+		{"certificate over DTLS", SecurityCertificate, TransportUDP, false, true},
+		{"certificate over TLS", SecurityCertificate, TransportTCP, false, true},
+		{"raw public key over DTLS", SecurityRPK, TransportUDP, false, true},
+		{"raw public key over TLS", SecurityRPK, TransportTCP, false, false},
+		{"bootstrap with a raw public key over DTLS", SecurityRPK, TransportUDP, true, true},
+		{"bootstrap with a raw public key over TLS", SecurityRPK, TransportTCP, true, false},
+	}
+
+	for _, tt := range tests {
+		c := ConfigParameters{
+			ServerHost:        "localhost",
+			ServerPort:        "5684",
+			Security:          tt.security,
+			Transport:         tt.transport,
+			BootstrapRequired: tt.bootstrap,
+		}
+		if err := c.ValidateServers(); (err == nil) != tt.valid {
+			t.Errorf("%s: ValidateServers() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+
+	// the servers of the list keep their own security mode
+	c := ConfigParameters{
+		Security: SecurityRPK,
+		Servers:  []ServerConfig{{URI: "coaps+tcp://one:5684", ShortID: 1, Lifetime: 30, Binding: "T", Security: SecurityCertificate}},
+	}
+	if err := c.ValidateServers(); err != nil {
+		t.Errorf("ValidateServers: %v", err)
+	}
+}
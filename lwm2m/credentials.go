@@ -0,0 +1,369 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Security modes, as defined by the LwM2M Security object
+const (
+	SecurityModePSK         = 0
+	SecurityModeRPK         = 1
+	SecurityModeCertificate = 2
+	SecurityModeNoSec       = 3
+)
+
+// Names of the security modes in the configuration parameters
+const (
+	SecurityPSK         = "psk"
+	SecurityRPK         = "rpk"
+	SecurityCertificate = "x509"
+)
+
+const (
+	credentialsDir = "credentials"
+	clientCertFile = "client.crt"
+	clientKeyFile  = "client.key"
+	serverCAFile   = "server-ca.crt"
+	serverKeyFile  = "server.pub"
+)
+
+// Credentials holds the keying material of the Security object for a security mode
+type Credentials struct {
+	Mode                int
+	PublicKeyOrIdentity []byte
+	ServerPublicKey     []byte
+	SecretKey           []byte
+}
+
+// ImportCertificate stores the client certificate and private key, and the CA
+// certificate of the server, for the X.509 certificate mode. The credentials
+// of a server are kept in a named set, and the default set has no name.
+func ImportCertificate(set, certPath, keyPath, caPath string) error {
+	if err := validateSet(set); err != nil {
+		return err
+	}
+
+	cert, err := readCertificate(certPath)
+	if err != nil {
+		return err
+	}
+	key, err := readPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+	ca, err := readCertificate(caPath)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(cert.PublicKey, key.Public()) {
+		return errors.New("the private key does not match the client certificate")
+	}
+	if !ca.IsCA {
+		return fmt.Errorf("%s is not a CA certificate", caPath)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = storeCredential(set, clientCertFile, "CERTIFICATE", cert.Raw, 0644); err != nil {
+		return err
+	}
+	if err = storeCredential(set, serverCAFile, "CERTIFICATE", ca.Raw, 0644); err != nil {
+		return err
+	}
+	return storeCredential(set, clientKeyFile, "PRIVATE KEY", keyDER, 0600)
+}
+
+// ImportRawPublicKey stores the client private key and the public key of the
+// server for the raw public key mode. The DTLS library only supports P-256 keys.
+func ImportRawPublicKey(set, keyPath, serverKeyPath string) error {
+	if err := validateSet(set); err != nil {
+		return err
+	}
+
+	key, err := readPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok || !isP256(key.Public()) {
+		return errors.New("the raw public key mode needs an ECDSA P-256 private key")
+	}
+
+	serverKey, err := readPublicKey(serverKeyPath)
+	if err != nil {
+		return err
+	}
+	if !isP256(serverKey) {
+		return errors.New("the raw public key mode needs an ECDSA P-256 server key")
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	serverDER, err := x509.MarshalPKIXPublicKey(serverKey)
+	if err != nil {
+		return err
+	}
+
+	if err = storeCredential(set, serverKeyFile, "PUBLIC KEY", serverDER, 0644); err != nil {
+		return err
+	}
+	return storeCredential(set, clientKeyFile, "PRIVATE KEY", keyDER, 0600)
+}
+
+// LoadCredentials reads the stored credentials in the form used by the Security
+// object. Certificates and public keys are DER-encoded. The private key is a
+// PKCS#8 key in certificate mode, and a SEC1 EC key in raw public key mode.
+func LoadCredentials(set string, mode int) (Credentials, error) {
+	c := Credentials{Mode: mode}
+
+	if err := validateSet(set); err != nil {
+		return c, err
+	}
+
+	key, err := readPrivateKey(credentialPath(set, clientKeyFile))
+	if err != nil {
+		return c, err
+	}
+
+	switch mode {
+	case SecurityModeCertificate:
+		cert, err := readCertificate(credentialPath(set, clientCertFile))
+		if err != nil {
+			return c, err
+		}
+		ca, err := readCertificate(credentialPath(set, serverCAFile))
+		if err != nil {
+			return c, err
+		}
+		if c.SecretKey, err = x509.MarshalPKCS8PrivateKey(key); err != nil {
+			return c, err
+		}
+		c.PublicKeyOrIdentity = cert.Raw
+		c.ServerPublicKey = ca.Raw
+
+	case SecurityModeRPK:
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return c, errors.New("the raw public key mode needs an ECDSA private key")
+		}
+		serverKey, err := readPublicKey(credentialPath(set, serverKeyFile))
+		if err != nil {
+			return c, err
+		}
+		if c.SecretKey, err = x509.MarshalECPrivateKey(ecKey); err != nil {
+			return c, err
+		}
+		if c.PublicKeyOrIdentity, err = x509.MarshalPKIXPublicKey(&ecKey.PublicKey); err != nil {
+			return c, err
+		}
+		if c.ServerPublicKey, err = x509.MarshalPKIXPublicKey(serverKey); err != nil {
+			return c, err
+		}
+
+	default:
+		return c, fmt.Errorf("security mode %d does not use stored credentials", mode)
+	}
+
+	return c, nil
+}
+
+// DescribeCredentials summarizes the stored credentials of the set. The private
+// key is only identified by the fingerprint of its public key.
+func DescribeCredentials(set string) []string {
+	lines := []string{}
+
+	if validateSet(set) != nil {
+		return lines
+	}
+
+	for _, name := range []string{clientCertFile, serverCAFile} {
+		cert, err := readCertificate(credentialPath(set, name))
+		if err != nil {
+			continue
+		}
+
+		status := "valid"
+		now := time.Now()
+		if now.Before(cert.NotBefore) {
+			status = "not yet valid"
+		} else if now.After(cert.NotAfter) {
+			status = "expired"
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("%s:", name),
+			fmt.Sprintf("  Subject:     %s", cert.Subject),
+			fmt.Sprintf("  Issuer:      %s", cert.Issuer),
+			fmt.Sprintf("  Validity:    %s to %s (%s)", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339), status),
+			fmt.Sprintf("  Fingerprint: %s", fingerprint(cert.Raw)),
+		)
+	}
+
+	if key, err := readPrivateKey(credentialPath(set, clientKeyFile)); err == nil {
+		lines = append(lines, fmt.Sprintf("%s:", clientKeyFile), describeKey(key.Public()))
+	}
+	if key, err := readPublicKey(credentialPath(set, serverKeyFile)); err == nil {
+		lines = append(lines, fmt.Sprintf("%s:", serverKeyFile), describeKey(key))
+	}
+
+	return lines
+}
+
+func credentialPath(set, name string) string {
+	return filepath.Join(os.Getenv(paramsEnvVar), credentialsDir, set, name)
+}
+
+// validateSet checks that the name of the set cannot escape the credentials directory
+func validateSet(set string) error {
+	if strings.ContainsAny(set, `/\`) || set == "." || set == ".." {
+		return fmt.Errorf("invalid name of the credentials: %s", set)
+	}
+	return nil
+}
+
+// storeCredential writes the PEM-encoded credential, so it is never left partially written
+func storeCredential(set, name, blockType string, der []byte, perm os.FileMode) error {
+	dir := filepath.Dir(credentialPath(set, name))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, name+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), credentialPath(set, name))
+}
+
+// readPEM returns the first PEM block of the file
+func readPEM(path string) (*pem.Block, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM-encoded", path)
+	}
+	return block, nil
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not hold a certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key in %s", path)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s does not hold a private key", path)
+	}
+}
+
+// readPublicKey reads a public key, or the public key of a certificate
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("%s does not hold a public key", path)
+	}
+}
+
+func isP256(key crypto.PublicKey) bool {
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	return ok && ecKey.Curve == elliptic.P256()
+}
+
+func describeKey(key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "  Unsupported key"
+	}
+
+	kind := "Unknown"
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		kind = "ECDSA " + k.Curve.Params().Name
+	case *rsa.PublicKey:
+		kind = fmt.Sprintf("RSA %d", k.N.BitLen())
+	}
+	return fmt.Sprintf("  %s public key, fingerprint %s", kind, fingerprint(der))
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,255 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/snapcore/snapd/snap"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+// Arguments of the snap Executes, as LwM2M 1.1 execute arguments e.g. 0='hello',1='beta',3
+const (
+	argName             = 0
+	argChannel          = 1
+	argRevision         = 2
+	argClassic          = 3
+	argDevMode          = 4
+	argJailMode         = 5
+	argIgnoreValidation = 6
+	argCohort           = 7
+)
+
+// executeArgsStart matches the start of a list of execute arguments, which a
+// snap name cannot start with as it has a letter
+var executeArgsStart = regexp.MustCompile(`^[0-9](=|,|$)`)
+
+// snapRisks are the risk levels of the snap channels
+var snapRisks = map[string]bool{"stable": true, "candidate": true, "beta": true, "edge": true}
+
+// snapArgs are the snap name and the options of a snap Execute. The Execute
+// argument is the name, the execute arguments or a JSON object.
+type snapArgs struct {
+	Name             string `json:"name"`
+	Channel          string `json:"channel"`
+	Revision         string `json:"revision"`
+	Classic          bool   `json:"classic"`
+	DevMode          bool   `json:"devmode"`
+	JailMode         bool   `json:"jailmode"`
+	IgnoreValidation bool   `json:"ignore-validation"`
+	CohortKey        string `json:"cohort-key"`
+}
+
+// parseSnapArgs parses and validates the argument of a snap Execute
+func parseSnapArgs(payload string) (snapArgs, error) {
+	payload = strings.TrimSpace(payload)
+
+	var a snapArgs
+	var err error
+	switch {
+	case len(payload) == 0:
+	case strings.HasPrefix(payload, "{"):
+		d := json.NewDecoder(bytes.NewReader([]byte(payload)))
+		d.DisallowUnknownFields()
+		err = d.Decode(&a)
+
+		// The object must be the whole argument
+		var extra json.RawMessage
+		if err == nil && d.Decode(&extra) != io.EOF {
+			err = errors.New("unexpected data after the JSON arguments")
+		}
+	case executeArgsStart.MatchString(payload):
+		a, err = snapArgsFromExecuteArgs(payload)
+	default:
+		// The snap name alone
+		a.Name = payload
+	}
+	if err != nil {
+		return a, err
+	}
+
+	return a, a.validate()
+}
+
+// snapArgsFromExecuteArgs maps the execute arguments onto the snap arguments
+func snapArgsFromExecuteArgs(payload string) (snapArgs, error) {
+	var a snapArgs
+
+	args, err := parseExecuteArgs(payload)
+	if err != nil {
+		return a, err
+	}
+
+	for n, v := range args {
+		switch n {
+		case argName:
+			a.Name, err = argValue(n, v)
+		case argChannel:
+			a.Channel, err = argValue(n, v)
+		case argRevision:
+			a.Revision, err = argValue(n, v)
+		case argCohort:
+			a.CohortKey, err = argValue(n, v)
+		case argClassic:
+			a.Classic, err = argFlag(n, v)
+		case argDevMode:
+			a.DevMode, err = argFlag(n, v)
+		case argJailMode:
+			a.JailMode, err = argFlag(n, v)
+		case argIgnoreValidation:
+			a.IgnoreValidation, err = argFlag(n, v)
+		default:
+			err = fmt.Errorf("unknown execute argument: %d", n)
+		}
+		if err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+// argValue returns the value of an execute argument that needs one
+func argValue(n int, v *string) (string, error) {
+	if v == nil || len(*v) == 0 {
+		return "", fmt.Errorf("execute argument %d needs a value", n)
+	}
+	return *v, nil
+}
+
+// argFlag returns the value of an execute argument that is a flag, which is
+// set by the argument without a value
+func argFlag(n int, v *string) (bool, error) {
+	if v == nil {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(*v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value of execute argument %d: %q", n, *v)
+	}
+	return b, nil
+}
+
+// parseExecuteArgs parses the LwM2M 1.1 execute arguments, a comma-separated
+// list of digits each with an optional quoted value e.g. 0='stable',3. The
+// value is nil for an argument without a value.
+func parseExecuteArgs(s string) (map[int]*string, error) {
+	args := map[int]*string{}
+
+	for i := 0; i < len(s); {
+		if s[i] < '0' || s[i] > '9' {
+			return nil, fmt.Errorf("invalid execute arguments: %q", s)
+		}
+		n := int(s[i] - '0')
+		if _, ok := args[n]; ok {
+			return nil, fmt.Errorf("execute argument %d is repeated", n)
+		}
+		i++
+
+		var value *string
+		if i < len(s) && s[i] == '=' {
+			if i+1 >= len(s) || s[i+1] != '\'' {
+				return nil, fmt.Errorf("invalid execute arguments: %q", s)
+			}
+			end := strings.IndexByte(s[i+2:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid execute arguments: %q", s)
+			}
+			v := s[i+2 : i+2+end]
+			value = &v
+			i += end + 3
+		}
+		args[n] = value
+
+		if i < len(s) {
+			if s[i] != ',' || i == len(s)-1 {
+				return nil, fmt.Errorf("invalid execute arguments: %q", s)
+			}
+			i++
+		}
+	}
+
+	return args, nil
+}
+
+// validate checks the snap name and options, as snapd would reject them
+func (a snapArgs) validate() error {
+	if len(a.Name) > 0 {
+		if err := snap.ValidateName(a.Name); err != nil {
+			return err
+		}
+	}
+
+	if len(a.Channel) > 0 {
+		if err := validateChannel(a.Channel); err != nil {
+			return err
+		}
+	}
+
+	if len(a.Revision) > 0 {
+		r, err := snap.ParseRevision(a.Revision)
+		if err != nil {
+			return err
+		}
+		if !r.Store() {
+			return fmt.Errorf("invalid store revision: %q", a.Revision)
+		}
+	}
+
+	if a.DevMode && a.JailMode {
+		return fmt.Errorf("cannot use devmode and jailmode together")
+	}
+	if len(a.CohortKey) > 0 && len(a.Revision) > 0 {
+		return fmt.Errorf("cannot use a cohort key and a revision together")
+	}
+	return nil
+}
+
+// validateChannel checks the channel is a risk, track or track/risk[/branch]
+func validateChannel(channel string) error {
+	parts := strings.Split(channel, "/")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid channel: %q", channel)
+	}
+	for _, p := range parts {
+		if len(p) == 0 || strings.ContainsAny(p, " \t\n'\"") {
+			return fmt.Errorf("invalid channel: %q", channel)
+		}
+	}
+
+	// The risk is the first part of risk/branch, and the second otherwise
+	if len(parts) == 3 && !snapRisks[parts[1]] {
+		return fmt.Errorf("invalid channel risk: %q", channel)
+	}
+	if len(parts) == 2 && !snapRisks[parts[0]] && !snapRisks[parts[1]] {
+		return fmt.Errorf("invalid channel risk: %q", channel)
+	}
+	return nil
+}
+
+// options returns the snapd options of the install or refresh, or nil when
+// there is none
+func (a snapArgs) options() *snapdapi.SnapOptions {
+	opts := &snapdapi.SnapOptions{CohortKey: a.CohortKey}
+	opts.Channel = a.Channel
+	opts.Revision = a.Revision
+	opts.Classic = a.Classic
+	opts.DevMode = a.DevMode
+	opts.JailMode = a.JailMode
+	opts.IgnoreValidation = a.IgnoreValidation
+
+	if *opts == (snapdapi.SnapOptions{}) {
+		return nil
+	}
+	return opts
+}
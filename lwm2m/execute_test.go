@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"reflect"
+	"testing"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+func TestParseSnapArgs(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    snapArgs
+		valid   bool
+	}{
+		{"", snapArgs{}, true},
+		{"hello", snapArgs{Name: "hello"}, true},
+		{"  hello\n", snapArgs{Name: "hello"}, true},
+		{"0='hello',1='beta',3", snapArgs{Name: "hello", Channel: "beta", Classic: true}, true},
+		{"0='hello',2='42',6", snapArgs{Name: "hello", Revision: "42", IgnoreValidation: true}, true},
+		{"0='hello',4='false',5='true'", snapArgs{Name: "hello", JailMode: true}, true},
+		{"0='hello',7='cohort'", snapArgs{Name: "hello", CohortKey: "cohort"}, true},
+		{`{"name":"hello","channel":"latest/stable","devmode":true}`, snapArgs{Name: "hello", Channel: "latest/stable", DevMode: true}, true},
+		{`{"name":"hello","cohort-key":"cohort"} `, snapArgs{Name: "hello", CohortKey: "cohort"}, true},
+
+		// JSON
+		{`{"name":"hello"} garbage`, snapArgs{}, false},
+		{`{"name":"hello"}{"name":"world"}`, snapArgs{}, false},
+		{`{"name":"hello"}}`, snapArgs{}, false},
+		{`{"name":"hello"}]`, snapArgs{}, false},
+		{`{"name":"hello"`, snapArgs{}, false},
+		{`{"name":"hello","unknown":true}`, snapArgs{}, false},
+		{`{"name":"hello","classic":"yes"}`, snapArgs{}, false},
+
+		// Execute arguments
+		{"0", snapArgs{}, false},
+		{"0='hello',8", snapArgs{}, false},
+		{"0='hello',3='maybe'", snapArgs{}, false},
+		{"0='hello',1=''", snapArgs{}, false},
+		{"0='hello',0='world'", snapArgs{}, false},
+
+		// Validation
+		{"Hello", snapArgs{}, false},
+		{"0='hello',1='stable/beta/fix/x'", snapArgs{}, false},
+		{"0='hello',2='x1'", snapArgs{}, false},
+		{"0='hello',2='-3'", snapArgs{}, false},
+		{"0='hello',4,5", snapArgs{}, false},
+		{`{"name":"hello","devmode":true,"jailmode":true}`, snapArgs{}, false},
+		{"0='hello',2='42',7='cohort'", snapArgs{}, false},
+		{`{"name":"hello","revision":"42","cohort-key":"cohort"}`, snapArgs{}, false},
+	}
+
+	for _, tt := range tests {
+		a, err := parseSnapArgs(tt.payload)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseSnapArgs(%q) error = %v, want valid %v", tt.payload, err, tt.valid)
+			continue
+		}
+		if tt.valid && a != tt.want {
+			t.Errorf("parseSnapArgs(%q) = %+v, want %+v", tt.payload, a, tt.want)
+		}
+	}
+}
+
+func TestParseExecuteArgs(t *testing.T) {
+	tests := []struct {
+		args  string
+		want  map[int]string // "-" for an argument without a value
+		valid bool
+	}{
+		{"", map[int]string{}, true},
+		{"3", map[int]string{3: "-"}, true},
+		{"0='hello',3", map[int]string{0: "hello", 3: "-"}, true},
+		{"1=''", map[int]string{1: ""}, true},
+		{"1='a,b',2='='", map[int]string{1: "a,b", 2: "="}, true},
+		{"0,0", nil, false},
+		{"12", nil, false},
+		{"0=hello", nil, false},
+		{"0='hello", nil, false},
+		{"0='hello'x", nil, false},
+		{"0='hello',", nil, false},
+		{",0", nil, false},
+		{"0;1", nil, false},
+		{"x", nil, false},
+	}
+
+	for _, tt := range tests {
+		args, err := parseExecuteArgs(tt.args)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseExecuteArgs(%q) error = %v, want valid %v", tt.args, err, tt.valid)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+
+		got := map[int]string{}
+		for n, v := range args {
+			got[n] = "-"
+			if v != nil {
+				got[n] = *v
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseExecuteArgs(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestValidateChannel(t *testing.T) {
+	tests := []struct {
+		channel string
+		valid   bool
+	}{
+		{"stable", true},
+		{"latest", true},
+		{"latest/stable", true},
+		{"beta/hotfix-1", true},
+		{"2.0/edge/hotfix-1", true},
+		{"latest/unknown", false},
+		{"2.0/unknown/hotfix-1", false},
+		{"2.0/edge/hotfix/1", false},
+		{"latest/", false},
+		{"/stable", false},
+		{"latest stable", false},
+		{"'stable'", false},
+	}
+
+	for _, tt := range tests {
+		if err := validateChannel(tt.channel); (err == nil) != tt.valid {
+			t.Errorf("validateChannel(%q) = %v, want valid %v", tt.channel, err, tt.valid)
+		}
+	}
+}
+
+func TestSnapArgsOptions(t *testing.T) {
+	if opts := (snapArgs{Name: "hello"}).options(); opts != nil {
+		t.Errorf("options = %+v, want none", opts)
+	}
+
+	a := snapArgs{Name: "hello", Channel: "beta", Classic: true, CohortKey: "cohort"}
+	want := &snapdapi.SnapOptions{CohortKey: "cohort"}
+	want.Channel = "beta"
+	want.Classic = true
+	if opts := a.options(); !reflect.DeepEqual(opts, want) {
+		t.Errorf("options = %+v, want %+v", opts, want)
+	}
+}
@@ -0,0 +1,247 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// Calls the Go callbacks the way the LwM2M objects do, reading every byte of
+// the returned buffers and freeing them, so that AddressSanitizer reports a
+// buffer that Go has already freed or that is shorter than its length.
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "../src/gocallbacks.h"
+
+// Highest resource ID tried on each object
+#define MAX_RESOURCE_ID 24
+
+// An instance ID that is not listed
+#define UNLISTED_INSTANCE 1000
+
+// The instance ID of the software package created by the harness
+#define SOFTWARE_INSTANCE 500
+
+// Sum of the bytes read, so that the reads are not optimized out
+static __thread unsigned long checksum = 0;
+
+// Read a NUL-terminated string returned by Go and free it
+static void prv_take_string(char * value)
+{
+    size_t i;
+
+    if (value == NULL) return;
+    for (i = 0 ; value[i] != 0 ; i++)
+    {
+        checksum += (unsigned char)value[i];
+    }
+    free(value);
+}
+
+// Read a typed value returned by Go and free it
+static int prv_take_value(char * value, int type, int length)
+{
+    int i;
+
+    if (length < 0 || (value == NULL && length != 0))
+    {
+        fprintf(stderr, "Invalid value: type %d, length %d\n", type, length);
+        return -1;
+    }
+    for (i = 0 ; i < length ; i++)
+    {
+        checksum += (unsigned char)value[i];
+    }
+    free(value);
+    return 0;
+}
+
+static int prv_reads(void)
+{
+    char * value;
+    int type;
+    int length;
+    int count;
+    int i;
+    int rid;
+
+    GetDeviceInformation();
+    for (rid = 0 ; rid <= MAX_RESOURCE_ID ; rid++)
+    {
+        value = DeviceObjectRead(rid, &type, &length);
+        if (0 != prv_take_value(value, type, length)) return -1;
+
+        value = LocationRead(rid, &type, &length);
+        if (0 != prv_take_value(value, type, length)) return -1;
+
+        value = FirmwareRead(rid, &type, &length);
+        if (0 != prv_take_value(value, type, length)) return -1;
+
+        value = ConnectivityRead(rid, &type, &length);
+        if (0 != prv_take_value(value, type, length)) return -1;
+
+        value = StatisticsRead(rid, &type, &length);
+        if (0 != prv_take_value(value, type, length)) return -1;
+
+        count = ConnectivityCount(rid);
+        for (i = 0 ; i <= count ; i++)
+        {
+            value = ConnectivityReadInstance(rid, i, &type, &length);
+            if (0 != prv_take_value(value, type, length)) return -1;
+        }
+    }
+
+    count = GetSnapCount();
+    for (i = 0 ; i < count ; i++)
+    {
+        for (rid = 0 ; rid <= MAX_RESOURCE_ID ; rid++)
+        {
+            value = SnapInstanceRead(GetSnapInstanceID(i), rid, &type, &length);
+            if (0 != prv_take_value(value, type, length)) return -1;
+        }
+        prv_take_string(SnapDelimited(GetSnapInstanceID(i)));
+    }
+    value = SnapInstanceRead(UNLISTED_INSTANCE, 0, &type, &length);
+    if (0 != prv_take_value(value, type, length)) return -1;
+    prv_take_string(SnapDelimited(UNLISTED_INSTANCE));
+
+    // The snap configs and errors are JSON and pipe-delimited documents
+    prv_take_string(SnapControlConfig());
+    count = GetSnapErrorCount();
+    for (i = 0 ; i <= count ; i++)
+    {
+        prv_take_string(SnapErrorRead(i));
+    }
+
+    count = GetSystemCount();
+    for (i = 0 ; i < count ; i++)
+    {
+        for (rid = 0 ; rid <= MAX_RESOURCE_ID ; rid++)
+        {
+            value = SystemInstanceRead(i, rid, &type, &length);
+            if (0 != prv_take_value(value, type, length)) return -1;
+        }
+    }
+    value = SystemInstanceRead(UNLISTED_INSTANCE, 0, &type, &length);
+    if (0 != prv_take_value(value, type, length)) return -1;
+
+    count = GetSoftwareCount();
+    for (i = 0 ; i < count ; i++)
+    {
+        for (rid = 0 ; rid <= MAX_RESOURCE_ID ; rid++)
+        {
+            value = SoftwareRead(GetSoftwareInstanceID(i), rid, &type, &length);
+            if (0 != prv_take_value(value, type, length)) return -1;
+        }
+    }
+    value = SoftwareRead(UNLISTED_INSTANCE, 0, &type, &length);
+    if (0 != prv_take_value(value, type, length)) return -1;
+
+    count = GetOperationCount();
+    for (i = 0 ; i < count ; i++)
+    {
+        for (rid = 0 ; rid <= MAX_RESOURCE_ID ; rid++)
+        {
+            value = OperationRead(GetOperationInstanceID(i), rid, &type, &length);
+            if (0 != prv_take_value(value, type, length)) return -1;
+        }
+    }
+    value = OperationRead(UNLISTED_INSTANCE, 0, &type, &length);
+    if (0 != prv_take_value(value, type, length)) return -1;
+
+    count = GetServiceCount();
+    for (i = 0 ; i < count ; i++)
+    {
+        for (rid = 0 ; rid <= MAX_RESOURCE_ID ; rid++)
+        {
+            value = ServiceRead(GetServiceInstanceID(i), rid, &type, &length);
+            if (0 != prv_take_value(value, type, length)) return -1;
+        }
+    }
+    value = ServiceRead(UNLISTED_INSTANCE, 0, &type, &length);
+    return prv_take_value(value, type, length);
+}
+
+// The actions run against the fake snapd and the temporary data directory of
+// main.go, or are unknown or target nothing
+static void prv_actions(void)
+{
+    char name[] = "harness";
+    char snap[] = "hello-world";
+    char uri[] = "coap+tcp://localhost:0";
+    char packet[] = "harness";
+    char period[] = "60";
+    char invalid[] = "ftp://localhost/pc.snap";
+    char empty[] = "";
+    char * value;
+    int transportId;
+    int length;
+
+    SnapInstanceExecute(UNLISTED_INSTANCE, 11, NULL, 0);
+    SnapInstanceExecute(GetSnapInstanceID(0), 14, NULL, 0);
+    ServiceExecute(UNLISTED_INSTANCE, 12);
+    ServiceExecute(GetServiceInstanceID(0), 12);
+    SnapInstanceWrite(UNLISTED_INSTANCE, 19, name, sizeof(name) - 1);
+    SnapExecute(0, name, sizeof(name) - 1);
+    SnapExecute(14, snap, sizeof(snap) - 1);
+    SnapControlSetConfig(name, sizeof(name) - 1);
+
+    // The recovery system actions run at the reboot
+    DeviceFactoryReset();
+    SystemExecute(0, 10);
+    SystemExecute(UNLISTED_INSTANCE, 10);
+    DeviceReboot();
+
+    FirmwareWrite(1, invalid);
+    FirmwareWrite(1, empty);
+    FirmwareWrite(0, empty);
+    FirmwareExecute(2);
+    FirmwareExecute(0);
+
+    // The fake snapd does not list the installed snap, so the package is
+    // forgotten once its install is done
+    SoftwareCreate(SOFTWARE_INSTANCE, name);
+    SoftwareExecute(SOFTWARE_INSTANCE, 4);
+    SoftwareExecute(SOFTWARE_INSTANCE, 6);
+    SoftwareDelete(SOFTWARE_INSTANCE);
+    SoftwareCreate(UNLISTED_INSTANCE, empty);
+    SoftwareExecute(GetSoftwareInstanceID(0), 11);
+    SoftwareExecute(GetSoftwareInstanceID(0), 10);
+    SoftwareExecute(GetSoftwareInstanceID(0), 0);
+
+    StatisticsWrite(8, period);
+    StatisticsWrite(8, name);
+    StatisticsWrite(0, period);
+    StatisticsExecute(6);
+    StatisticsExecute(7);
+    StatisticsExecute(0);
+
+    ServerRegistration(1, 0);
+    BootstrapNeeded();
+    StoreBootstrapInstance(1, 0, packet, sizeof(packet));
+    CommitBootstrapInstances(0);
+    StoreBootstrapInstance(1, 0, packet, sizeof(packet));
+    CommitBootstrapInstances(1);
+
+    transportId = TransportConnect(uri, 3, NULL, 0, NULL, 0, NULL, 0);
+    if (transportId > 0) TransportClose(transportId);
+    TransportSend(-1, packet, sizeof(packet));
+    value = TransportReceive(&transportId, &length);
+    if (value != NULL) prv_take_value(value, 0, length + 1);
+    TransportWakeFd();
+
+    SendResult(-1, 0, 0);
+}
+
+int run_callbacks(int iterations)
+{
+    int i;
+
+    for (i = 0 ; i < iterations ; i++)
+    {
+        if (0 != prv_reads()) return -1;
+        prv_actions();
+    }
+
+    fprintf(stdout, "%d iterations, checksum %lu\n", iterations, checksum);
+    fflush(stdout);
+    return 0;
+}
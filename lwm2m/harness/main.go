@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// The harness calls the Go callbacks of the LwM2M client from C, as the LwM2M
+// objects do, to check the ownership of the memory that crosses the cgo
+// boundary. Run it with run.sh, under AddressSanitizer and the race detector.
+// The objects use a fake snapd and a temporary data directory, so that the
+// actions change nothing on the device.
+package main
+
+// extern int run_callbacks(int iterations);
+import "C"
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/snap"
+
+	// The callbacks are exported by the lwm2m package
+	_ "launchpad.net/ce-web/alpaca/lwm2m"
+	"launchpad.net/ce-web/alpaca/objects"
+	"launchpad.net/ce-web/alpaca/snapdapi"
+	"launchpad.net/ce-web/alpaca/snapdapi/snapdtest"
+)
+
+// newFakeSnapd creates a snapd with snaps, services, recovery systems and a
+// change in progress, so that every callback has instances to read
+func newFakeSnapd() *snapdtest.FakeSnapdClient {
+	f := snapdtest.NewFakeSnapdClient()
+	f.Version = client.ServerVersion{Version: "2.45", Series: "16", OSID: "ubuntu-core"}
+	f.Snaps = []*client.Snap{
+		{Name: "core18", Type: "base", Version: "20200724", Revision: snap.R(1885), Status: "active"},
+		{Name: "pc", Type: "gadget", Version: "18-2", Revision: snap.R(36), Status: "active"},
+		{Name: "hello-world", Type: "app", Version: "6.4", Revision: snap.R(29), Status: "active", Summary: "The 'hello-world' of snaps"},
+		{Name: "mosquitto", Type: "app", Version: "1.6.12", Revision: snap.R(313), Status: "active", DevMode: true},
+	}
+	f.AppList = []*client.AppInfo{
+		{Snap: "hello-world", Name: "hello-world"},
+		{Snap: "mosquitto", Name: "mosquitto", Daemon: "simple", Enabled: true, Active: true},
+	}
+	f.Config["mosquitto"] = map[string]interface{}{"port": 1883}
+	f.SystemList = []snapdapi.System{{
+		Label:   "20200724",
+		Current: true,
+		Model:   snapdapi.SystemModel{Model: "ubuntu-core-20-amd64", BrandID: "canonical"},
+		Actions: []snapdapi.SystemAction{
+			{Title: "Recover", Mode: objects.ModeRecover},
+			{Title: "Run normally", Mode: objects.ModeRun},
+		},
+	}}
+	f.Changes["harness"] = &client.Change{ID: "harness", Kind: "refresh-snap", Summary: "Refresh mosquitto", Status: "Doing"}
+	return f
+}
+
+func main() {
+	iterations := flag.Int("iterations", 10, "number of times each callback is called")
+	parallel := flag.Int("parallel", 1, "number of threads calling the callbacks")
+	flag.Parse()
+
+	dir, err := ioutil.TempDir("", "harness")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SNAP_DATA", dir)
+	objects.UseSnapdClient(newFakeSnapd())
+
+	// The refresh of mosquitto is still running
+	_, err = objects.GetOperationsInstance().Start("refresh", "mosquitto", func() (string, error) { return "harness", nil })
+	if err != nil {
+		os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+
+	failed := false
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if C.run_callbacks(C.int(*iterations)) != 0 {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed {
+		os.RemoveAll(dir)
+		log.Fatal("Callbacks returned invalid values")
+	}
+}
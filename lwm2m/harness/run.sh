@@ -0,0 +1,37 @@
+#!/bin/sh
+# Run the callbacks of the LwM2M client under AddressSanitizer, then under the
+# race detector. The two cannot be combined in one binary. liblwm2mclient.a and
+# gocallbacks.h are built first, see build.sh.
+set -e
+
+cd "$(dirname "$0")"
+
+ITERATIONS=${ITERATIONS:-10}
+
+# Every callback exported by Go is declared in gocallbacks.h and called by the
+# harness
+missing=0
+for name in $(sed -n 's#^//export ##p' ../*.go); do
+    if ! grep -q "[ *]$name(" ../src/gocallbacks.h; then
+        echo "$name is not declared in src/gocallbacks.h"
+        missing=1
+    fi
+    if ! grep -q "\b$name(" harness.c; then
+        echo "$name is not called by harness.c"
+        missing=1
+    fi
+done
+[ "$missing" -eq 0 ]
+
+echo "AddressSanitizer"
+CGO_CFLAGS="-g -fsanitize=address -fno-omit-frame-pointer" \
+CGO_LDFLAGS="-fsanitize=address" \
+ASAN_OPTIONS="detect_leaks=0:abort_on_error=1" \
+    go run . -iterations "$ITERATIONS"
+
+# The client loop calls the callbacks from one thread, so the races are between
+# the callbacks and the goroutines of the client. The unaligned reads of the
+# vendored sha3 fail the pointer checks of -race.
+echo "Race detector"
+GORACE="halt_on_error=1" \
+    go run -race -gcflags=all=-d=checkptr=0 . -iterations "$ITERATIONS"
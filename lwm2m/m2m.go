@@ -5,32 +5,121 @@
 package lwm2m
 
 // #cgo LDFLAGS: -L${SRCDIR} -llwm2mclient
+// #cgo dtls LDFLAGS: -lssl -lcrypto
 // #define _GNU_SOURCE
 // #include "src/lwm2mclient.h"
 // #include <stdlib.h>
+// #include <string.h>
 import "C"
-import "unsafe"
-
-// CreateServer wraps C library createServer
-func CreateServer(serverHost, serverPort, localPort, name string, bootstrapRequired bool) int {
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// CreateServer wraps C library createServer. The servers to register with are
+// added with AddServer before the event loop starts.
+func CreateServer(localPort, name, addressFamily string) int {
+
+	family := C.ADDRESS_FAMILY_IPV4
+	network := "tcp4"
+	switch addressFamily {
+	case AddressFamilyIPv6:
+		family = C.ADDRESS_FAMILY_IPV6
+		network = "tcp6"
+	case AddressFamilyDual:
+		family = C.ADDRESS_FAMILY_DUAL
+		network = "tcp"
+	}
+	useTransportNetwork(network)
 
-	chost := C.CString(serverHost)
-	cport := C.CString(serverPort)
 	clocal := C.CString(localPort)
 	cname := C.CString(name)
-	cbootstrap := C.int(0)
-	if bootstrapRequired {
-		cbootstrap = C.int(1)
-	}
 
-	out := C.createServer(chost, cport, clocal, cname, cbootstrap)
-	C.free(unsafe.Pointer(chost))
-	C.free(unsafe.Pointer(cport))
+	out := C.createServer(clocal, cname, C.int(family))
 	C.free(unsafe.Pointer(clocal))
 	C.free(unsafe.Pointer(cname))
 	return int(out)
 }
 
+// AddServer wraps C library addServer. The connection is secured with DTLS, or
+// TLS for CoAP over TCP, unless the security mode is NoSec, using the pre-shared
+// key of the server or its stored credentials. A bootstrap server only gets a
+// security instance.
+func AddServer(s ServerConfig, bootstrap bool) error {
+	if err := s.ValidateBootstrap(); err != nil {
+		return fmt.Errorf("server %d: %v", s.ShortID, err)
+	}
+	mode := s.SecurityMode()
+
+	c := Credentials{Mode: mode}
+	switch mode {
+	case SecurityModePSK:
+		key, err := hex.DecodeString(s.PSKKey)
+		if err != nil {
+			return fmt.Errorf("server %d: the pre-shared key must be hex digits", s.ShortID)
+		}
+		c.PublicKeyOrIdentity = []byte(s.PSKIdentity)
+		c.SecretKey = key
+	case SecurityModeRPK, SecurityModeCertificate:
+		var err error
+		if c, err = LoadCredentials(s.Credentials, mode); err != nil {
+			return fmt.Errorf("server %d: %v", s.ShortID, err)
+		}
+	}
+
+	curi := C.CString(s.URI)
+	cbinding := C.CString(s.Binding)
+	cbootstrap := C.int(0)
+	if bootstrap {
+		cbootstrap = C.int(1)
+	}
+	cpublic := cBuffer(c.PublicKeyOrIdentity)
+	cserver := cBuffer(c.ServerPublicKey)
+	csecret := cBuffer(c.SecretKey)
+
+	out := C.addServer(curi, C.int(s.ShortID), C.int(s.Lifetime), cbinding, cbootstrap, C.int(s.HoldOff), C.int(mode),
+		cpublic, C.int(len(c.PublicKeyOrIdentity)), cserver, C.int(len(c.ServerPublicKey)),
+		csecret, C.int(len(c.SecretKey)))
+	C.free(unsafe.Pointer(curi))
+	C.free(unsafe.Pointer(cbinding))
+	C.free(unsafe.Pointer(cpublic))
+	C.free(unsafe.Pointer(cserver))
+
+	// Do not leave the secret key in freed memory
+	if csecret != nil {
+		C.memset(unsafe.Pointer(csecret), 0, C.size_t(len(c.SecretKey)))
+	}
+	C.free(unsafe.Pointer(csecret))
+	for i := range c.SecretKey {
+		c.SecretKey[i] = 0
+	}
+
+	if out != 0 {
+		return fmt.Errorf("server %d: failed to add the server %s", s.ShortID, s.URI)
+	}
+	return nil
+}
+
+// restoreInstance wraps C library restoreInstance
+func restoreInstance(i BootstrapInstance) error {
+	cdata := cBuffer(i.Data)
+
+	out := C.restoreInstance(C.int(i.ObjectID), C.int(i.InstanceID), cdata, C.int(len(i.Data)))
+
+	// The security instances hold the secret keys
+	if cdata != nil {
+		C.memset(unsafe.Pointer(cdata), 0, C.size_t(len(i.Data)))
+	}
+	C.free(unsafe.Pointer(cdata))
+
+	if out != 0 {
+		return fmt.Errorf("failed to restore the bootstrap instance /%d/%d", i.ObjectID, i.InstanceID)
+	}
+	return nil
+}
+
 // CloseServer closes the connection to the server
 func CloseServer() int {
 	out := C.closeServer()
@@ -43,8 +132,11 @@ func WaitForTimeout() int {
 	return int(out)
 }
 
-// SendData sends data to the server from the object registry
+// SendData sends data to the server from the object registry, with the paths
+// queued by Send
 func SendData() int {
+	flushSends()
+
 	out := C.sendData()
 	return int(out)
 }
@@ -67,6 +159,43 @@ func RefreshData() {
 		handleValueChanged(k, v)
 	}
 
+	changedFirmware := FirmwareRefreshData()
+	for k, v := range changedFirmware {
+		handleValueChanged(k, v)
+	}
+
+	changedSoftware := SoftwareRefreshData()
+	for k, v := range changedSoftware {
+		handleValueChanged(k, v)
+	}
+
+	changedConnectivity := ConnectivityRefreshData()
+	for k, v := range changedConnectivity {
+		handleValueChanged(k, v)
+	}
+
+	changedStatistics := StatisticsRefreshData()
+	for k, v := range changedStatistics {
+		handleValueChanged(k, v)
+	}
+
+	changedLocation := LocationRefreshData()
+	for k, v := range changedLocation {
+		handleValueChanged(k, v)
+	}
+
+	changedOperations := OperationsRefreshData()
+	for k, v := range changedOperations {
+		handleValueChanged(k, v)
+	}
+
+	changedServices := ServicesRefreshData()
+	for k, v := range changedServices {
+		handleValueChanged(k, v)
+	}
+
+	// The instances are updated once, from the lists refreshed above
+	RefreshObjects()
 }
 
 // RefreshObjects refreshes the full object list. Used after snap install/uninstall
@@ -74,13 +203,31 @@ func RefreshObjects() {
 	C.refreshObjects()
 }
 
+// sendPaths wraps C library sendPaths. It returns the number of servers the
+// paths were sent to.
+func sendPaths(paths []string, id int) int {
+	cpaths := C.CString(strings.Join(paths, "\n"))
+
+	out := C.sendPaths(cpaths, C.int(id))
+	C.free(unsafe.Pointer(cpaths))
+	return int(out)
+}
+
 // handleValueChanged calls the lwm2m function to update the object registry
-func handleValueChanged(uri, value string) {
+func handleValueChanged(uri string, v Value) {
 	curi := C.CString(uri)
-	cvalue := C.CString(value)
+	cvalue := cBuffer(v.data)
 
-	C.handleValueRefresh(curi, C.int(len(uri)), cvalue, C.int(len(value)))
+	C.handleValueRefresh(curi, C.int(len(uri)), C.int(v.Type), cvalue, C.int(len(v.data)))
 
 	C.free(unsafe.Pointer(curi))
 	C.free(unsafe.Pointer(cvalue))
 }
+
+// cBuffer copies the bytes to C memory, which the caller frees. An empty buffer is NULL.
+func cBuffer(b []byte) *C.char {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.char)(C.CBytes(b))
+}
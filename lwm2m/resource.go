@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+// #include "src/lwm2mclient.h"
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ValueType is the LwM2M data type of a resource value
+type ValueType int
+
+// Types of the resource values, matching VALUE_TYPE_* in lwm2mclient.h
+const (
+	StringType  ValueType = C.VALUE_TYPE_STRING
+	IntegerType ValueType = C.VALUE_TYPE_INTEGER
+	FloatType   ValueType = C.VALUE_TYPE_FLOAT
+	BooleanType ValueType = C.VALUE_TYPE_BOOLEAN
+	TimeType    ValueType = C.VALUE_TYPE_TIME
+	OpaqueType  ValueType = C.VALUE_TYPE_OPAQUE
+	ObjLinkType ValueType = C.VALUE_TYPE_OBJLINK
+)
+
+// Value is the typed value of a resource, used by the reads and the refreshes
+type Value struct {
+	Type ValueType
+	data []byte
+}
+
+// StringValue returns the value of a string resource
+func StringValue(s string) Value {
+	return Value{Type: StringType, data: []byte(s)}
+}
+
+// IntValue returns the value of an integer resource
+func IntValue(i int64) Value {
+	return Value{Type: IntegerType, data: []byte(strconv.FormatInt(i, 10))}
+}
+
+// FloatValue returns the value of a float resource
+func FloatValue(f float64) Value {
+	return Value{Type: FloatType, data: []byte(strconv.FormatFloat(f, 'g', -1, 64))}
+}
+
+// BoolValue returns the value of a boolean resource
+func BoolValue(b bool) Value {
+	data := "0"
+	if b {
+		data = "1"
+	}
+	return Value{Type: BooleanType, data: []byte(data)}
+}
+
+// TimeValue returns the value of a time resource, which has a precision of a second
+func TimeValue(t time.Time) Value {
+	return Value{Type: TimeType, data: []byte(strconv.FormatInt(t.Unix(), 10))}
+}
+
+// OpaqueValue returns the value of an opaque resource
+func OpaqueValue(b []byte) Value {
+	data := make([]byte, len(b))
+	copy(data, b)
+	return Value{Type: OpaqueType, data: data}
+}
+
+// ObjLinkValue returns the value of a resource that links to an object instance
+func ObjLinkValue(objectID, instanceID uint16) Value {
+	return Value{Type: ObjLinkType, data: []byte(fmt.Sprintf("%d:%d", objectID, instanceID))}
+}
+
+// cValue copies the value to C memory, which the caller frees, for a read
+// callback. An empty value is NULL.
+func cValue(v Value, valueType *C.int, length *C.int) *C.char {
+	*valueType = C.int(v.Type)
+	*length = C.int(len(v.data))
+	return cBuffer(v.data)
+}
@@ -0,0 +1,168 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sendRetryInterval is the wait before sending again paths that were not delivered
+	sendRetryInterval = 30 * time.Second
+	// sendTimeout is the wait for the responses of the servers to a Send
+	sendTimeout = 5 * time.Minute
+)
+
+// sendBatch is a set of paths sent together, waiting for the responses of the servers
+type sendBatch struct {
+	paths     []string
+	sent      time.Time
+	servers   int
+	responses int
+	delivered bool
+}
+
+// sends queues the paths until a server acknowledges them
+var sends = struct {
+	sync.Mutex
+	pending []string
+	batches map[int]*sendBatch
+	nextID  int
+	retryAt time.Time
+}{batches: map[int]*sendBatch{}}
+
+// Send reports the resources at the paths e.g. /30001/2 to the LwM2M servers
+// with the Send operation. The paths are queued, and sent again while the
+// client is offline or until a server acknowledges them.
+func Send(paths ...string) error {
+	for _, p := range paths {
+		if err := validatePath(p); err != nil {
+			return err
+		}
+	}
+
+	sends.Lock()
+	defer sends.Unlock()
+
+	queuePaths(paths)
+	return nil
+}
+
+// validatePath checks the path is an object, instance, resource or resource instance
+func validatePath(path string) error {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || len(parts) > 5 || parts[0] != "" {
+		return fmt.Errorf("invalid LwM2M path: %s", path)
+	}
+
+	for _, p := range parts[1:] {
+		id, err := strconv.Atoi(p)
+		if err != nil || id < 0 || id > 65534 {
+			return fmt.Errorf("invalid LwM2M path: %s", path)
+		}
+	}
+	return nil
+}
+
+// queuePaths adds the paths that are not pending yet. The caller holds the lock.
+func queuePaths(paths []string) {
+	for _, p := range paths {
+		queued := false
+		for _, q := range sends.pending {
+			if p == q {
+				queued = true
+				break
+			}
+		}
+		if !queued {
+			sends.pending = append(sends.pending, p)
+		}
+	}
+}
+
+// flushSends sends the pending paths to the registered servers
+func flushSends() {
+	sends.Lock()
+
+	now := time.Now()
+
+	// The responses are lost when the connection is closed
+	for id, b := range sends.batches {
+		if now.Sub(b.sent) > sendTimeout {
+			log.Printf("No response to the Send of %s\n", strings.Join(b.paths, ", "))
+			delete(sends.batches, id)
+			queuePaths(b.paths)
+		}
+	}
+
+	if len(sends.pending) == 0 || now.Before(sends.retryAt) {
+		sends.Unlock()
+		return
+	}
+
+	id := sends.nextID
+	sends.nextID++
+	b := &sendBatch{paths: sends.pending, sent: now, servers: -1}
+	sends.batches[id] = b
+	sends.pending = nil
+
+	// The responses may be handled before sendPaths returns
+	sends.Unlock()
+	servers := sendPaths(b.paths, id)
+	sends.Lock()
+	defer sends.Unlock()
+
+	switch {
+	case servers < 0:
+		log.Printf("Error sending %s: the resources cannot be read\n", strings.Join(b.paths, ", "))
+		delete(sends.batches, id)
+	case servers == 0:
+		// Not registered with a server yet
+		delete(sends.batches, id)
+		queuePaths(b.paths)
+		sends.retryAt = now.Add(sendRetryInterval)
+	default:
+		b.servers = servers
+		completeSend(id, b)
+	}
+}
+
+// sendResult handles the response of a server to a Send
+func sendResult(id, shortServerID, status int) {
+	sends.Lock()
+	defer sends.Unlock()
+
+	b, ok := sends.batches[id]
+	if !ok {
+		return
+	}
+
+	b.responses++
+	if status>>5 == 2 {
+		b.delivered = true
+	} else {
+		log.Printf("Send to server %d failed: %d.%02d\n", shortServerID, status>>5, status&0x1f)
+	}
+	completeSend(id, b)
+}
+
+// completeSend queues the paths again when no server acknowledged them. The
+// caller holds the lock.
+func completeSend(id int, b *sendBatch) {
+	if b.servers < 0 || b.responses < b.servers {
+		return
+	}
+
+	delete(sends.batches, id)
+	if !b.delivered {
+		queuePaths(b.paths)
+		sends.retryAt = time.Now().Add(sendRetryInterval)
+	}
+}
@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedSnapPaths(t *testing.T) {
+	previous := map[int]string{0: "10|active", 1: "3|active", 4: "7|active"}
+	current := map[int]string{0: "10|active", 1: "4|active", 5: "1|active"}
+
+	// The refreshed, installed and removed instances are sent
+	want := []string{"/30001/1", "/30001/4", "/30001/5"}
+	if paths := changedSnapPaths(previous, current); !reflect.DeepEqual(paths, want) {
+		t.Errorf("changed paths = %v, want %v", paths, want)
+	}
+
+	if paths := changedSnapPaths(current, current); len(paths) != 0 {
+		t.Errorf("changed paths = %v, want none", paths)
+	}
+}
+
+func TestSendQueuesThePaths(t *testing.T) {
+	sends.Lock()
+	sends.pending = nil
+	sends.Unlock()
+
+	if err := Send("/30001/2", "/30000/0"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := Send("/30000/0", "/30001/4"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	for _, p := range []string{"30001/2", "/30001/x", "/65535", "/1/2/3/4/5"} {
+		if err := Send(p); err == nil {
+			t.Errorf("the invalid path %s was queued", p)
+		}
+	}
+
+	sends.Lock()
+	defer sends.Unlock()
+	want := []string{"/30001/2", "/30000/0", "/30001/4"}
+	if !reflect.DeepEqual(sends.pending, want) {
+		t.Errorf("pending paths = %v, want %v", sends.pending, want)
+	}
+	sends.pending = nil
+}
@@ -0,0 +1,394 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// DTLS sessions of the certificate security mode, with OpenSSL. The records
+// go through a BIO that sends the datagrams with the callback of the session
+// and reads the datagram being handled, so the session is driven by the
+// sends and the packets of the connection like a tinydtls session.
+
+#include "dtls_x509.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <arpa/inet.h>
+
+#include <openssl/bio.h>
+#include <openssl/err.h>
+#include <openssl/ssl.h>
+#include <openssl/x509v3.h>
+
+// The ciphers of the certificate mode in the LwM2M specification, then the
+// ones of the TLS connections
+#define PRV_CIPHERS "ECDHE-ECDSA-AES128-CCM8:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:HIGH:!aNULL:!PSK:!SRP"
+
+// The largest DTLS record
+#define PRV_MAX_RECORD 16384
+
+// The UDP and IPv6 headers, not available for the records in the MTU
+#define PRV_MTU_OVERHEAD 48
+
+struct _dtls_x509_session_t
+{
+    SSL_CTX *                    ctx;
+    SSL *                        ssl;
+    char *                       host;
+    dtls_x509_send_callback_t    sendCallback;
+    dtls_x509_receive_callback_t receiveCallback;
+    void *                       userData;
+    uint8_t *                    packet;       // datagram being handled, read by the BIO
+    size_t                       packetLength;
+    uint8_t *                    plain;        // buffer of the decrypted records
+};
+
+static BIO_METHOD * prv_method = NULL;
+
+static void prv_log_error(const char * message)
+{
+    fprintf(stderr, "DTLS X.509: %s\r\n", message);
+    ERR_print_errors_fp(stderr);
+}
+
+static int prv_bio_write(BIO * bio, const char * buffer, int length)
+{
+    dtls_x509_session_t * sessionP = (dtls_x509_session_t *)BIO_get_data(bio);
+
+    BIO_clear_retry_flags(bio);
+    if (sessionP->sendCallback(sessionP->userData, (uint8_t *)buffer, length) < 0) return -1;
+    return length;
+}
+
+static int prv_bio_read(BIO * bio, char * buffer, int length)
+{
+    dtls_x509_session_t * sessionP = (dtls_x509_session_t *)BIO_get_data(bio);
+    size_t readLength;
+
+    BIO_clear_retry_flags(bio);
+    if (sessionP->packet == NULL)
+    {
+        // the next datagram comes with the next packet of the connection
+        BIO_set_retry_read(bio);
+        return -1;
+    }
+
+    // a datagram is read at once, its end is dropped if the buffer is too small
+    readLength = sessionP->packetLength;
+    if (readLength > (size_t)length) readLength = length;
+    memcpy(buffer, sessionP->packet, readLength);
+    sessionP->packet = NULL;
+    sessionP->packetLength = 0;
+
+    return readLength;
+}
+
+static long prv_bio_ctrl(BIO * bio, int cmd, long num, void * ptr)
+{
+    switch (cmd)
+    {
+    case BIO_CTRL_FLUSH:
+        return 1;
+    case BIO_CTRL_DGRAM_QUERY_MTU:
+    case BIO_CTRL_DGRAM_GET_FALLBACK_MTU:
+        return DTLS_X509_MTU;
+    case BIO_CTRL_DGRAM_GET_MTU_OVERHEAD:
+        return PRV_MTU_OVERHEAD;
+    default:
+        return 0;
+    }
+}
+
+static int prv_bio_create(BIO * bio)
+{
+    BIO_set_init(bio, 1);
+    return 1;
+}
+
+static BIO * prv_new_bio(dtls_x509_session_t * sessionP)
+{
+    BIO * bio;
+
+    if (prv_method == NULL)
+    {
+        prv_method = BIO_meth_new(BIO_get_new_index() | BIO_TYPE_SOURCE_SINK, "lwm2m datagram");
+        if (prv_method == NULL) return NULL;
+        BIO_meth_set_write(prv_method, prv_bio_write);
+        BIO_meth_set_read(prv_method, prv_bio_read);
+        BIO_meth_set_ctrl(prv_method, prv_bio_ctrl);
+        BIO_meth_set_create(prv_method, prv_bio_create);
+    }
+
+    bio = BIO_new(prv_method);
+    if (bio == NULL) return NULL;
+    BIO_set_data(bio, sessionP);
+
+    return bio;
+}
+
+static bool prv_is_address(const char * host)
+{
+    struct in6_addr addr;
+
+    return inet_pton(AF_INET, host, &addr) == 1 || inet_pton(AF_INET6, host, &addr) == 1;
+}
+
+static SSL * prv_new_ssl(dtls_x509_session_t * sessionP)
+{
+    SSL * ssl;
+    BIO * bio;
+
+    ssl = SSL_new(sessionP->ctx);
+    if (ssl == NULL) return NULL;
+
+    bio = prv_new_bio(sessionP);
+    if (bio == NULL)
+    {
+        SSL_free(ssl);
+        return NULL;
+    }
+    SSL_set_bio(ssl, bio, bio);
+
+    // the MTU cannot be queried without a socket
+    SSL_set_options(ssl, SSL_OP_NO_QUERY_MTU);
+    DTLS_set_link_mtu(ssl, DTLS_X509_MTU);
+
+    // the certificate of the server is checked against the host of its URI,
+    // as for the TLS connections
+    if (prv_is_address(sessionP->host))
+    {
+        if (!X509_VERIFY_PARAM_set1_ip_asc(SSL_get0_param(ssl), sessionP->host))
+        {
+            SSL_free(ssl);
+            return NULL;
+        }
+    }
+    else
+    {
+        if (!SSL_set1_host(ssl, sessionP->host)
+         || !SSL_set_tlsext_host_name(ssl, sessionP->host))
+        {
+            SSL_free(ssl);
+            return NULL;
+        }
+    }
+
+    SSL_set_connect_state(ssl);
+
+    return ssl;
+}
+
+static void prv_close(dtls_x509_session_t * sessionP)
+{
+    SSL_free(sessionP->ssl);
+    sessionP->ssl = NULL;
+}
+
+// Continue the handshake. Returns 0 while it is in progress or when it
+// completed, and -1 when it failed.
+static int prv_handshake(dtls_x509_session_t * sessionP)
+{
+    int result;
+
+    result = SSL_do_handshake(sessionP->ssl);
+    if (result == 1) return 0;
+
+    switch (SSL_get_error(sessionP->ssl, result))
+    {
+    case SSL_ERROR_WANT_READ:
+    case SSL_ERROR_WANT_WRITE:
+        return 0;
+    default:
+        prv_log_error("the handshake failed");
+        prv_close(sessionP);
+        return -1;
+    }
+}
+
+static SSL_CTX * prv_new_ctx(const dtls_x509_credentials_t * credentialsP)
+{
+    SSL_CTX * ctx;
+    const unsigned char * der;
+    EVP_PKEY * keyP;
+    X509 * serverCertP;
+    int result;
+
+    ctx = SSL_CTX_new(DTLS_client_method());
+    if (ctx == NULL) return NULL;
+
+    if (!SSL_CTX_set_min_proto_version(ctx, DTLS1_2_VERSION)
+     || !SSL_CTX_set_cipher_list(ctx, PRV_CIPHERS))
+    {
+        goto error;
+    }
+
+    if (!SSL_CTX_use_certificate_ASN1(ctx, credentialsP->certificateLength, credentialsP->certificate))
+    {
+        goto error;
+    }
+
+    der = credentialsP->privateKey;
+    keyP = d2i_AutoPrivateKey(NULL, &der, credentialsP->privateKeyLength);
+    if (keyP == NULL) goto error;
+    result = SSL_CTX_use_PrivateKey(ctx, keyP);
+    EVP_PKEY_free(keyP);
+    if (!result || !SSL_CTX_check_private_key(ctx)) goto error;
+
+    der = credentialsP->serverCertificate;
+    serverCertP = d2i_X509(NULL, &der, credentialsP->serverCertificateLength);
+    if (serverCertP == NULL) goto error;
+    result = X509_STORE_add_cert(SSL_CTX_get_cert_store(ctx), serverCertP);
+    X509_free(serverCertP);
+    if (!result) goto error;
+
+    SSL_CTX_set_verify(ctx, SSL_VERIFY_PEER, NULL);
+
+    return ctx;
+
+error:
+    SSL_CTX_free(ctx);
+    return NULL;
+}
+
+dtls_x509_session_t * dtls_x509_new(const dtls_x509_credentials_t * credentialsP,
+                                    dtls_x509_send_callback_t sendCallback,
+                                    dtls_x509_receive_callback_t receiveCallback,
+                                    void * userData)
+{
+    dtls_x509_session_t * sessionP;
+
+    sessionP = (dtls_x509_session_t *)calloc(1, sizeof(dtls_x509_session_t));
+    if (sessionP == NULL) return NULL;
+
+    sessionP->sendCallback = sendCallback;
+    sessionP->receiveCallback = receiveCallback;
+    sessionP->userData = userData;
+    sessionP->host = strdup(credentialsP->host);
+    sessionP->plain = (uint8_t *)malloc(PRV_MAX_RECORD);
+    if (sessionP->host == NULL || sessionP->plain == NULL)
+    {
+        dtls_x509_free(sessionP);
+        return NULL;
+    }
+
+    sessionP->ctx = prv_new_ctx(credentialsP);
+    if (sessionP->ctx == NULL)
+    {
+        prv_log_error("the credentials cannot be loaded");
+        dtls_x509_free(sessionP);
+        return NULL;
+    }
+
+    return sessionP;
+}
+
+void dtls_x509_free(dtls_x509_session_t * sessionP)
+{
+    if (sessionP == NULL) return;
+
+    SSL_free(sessionP->ssl);
+    SSL_CTX_free(sessionP->ctx);
+    free(sessionP->host);
+    free(sessionP->plain);
+    free(sessionP);
+}
+
+int dtls_x509_connect(dtls_x509_session_t * sessionP)
+{
+    if (sessionP->ssl != NULL) prv_close(sessionP);
+
+    sessionP->ssl = prv_new_ssl(sessionP);
+    if (sessionP->ssl == NULL)
+    {
+        prv_log_error("the session cannot be created");
+        return -1;
+    }
+
+    return prv_handshake(sessionP);
+}
+
+int dtls_x509_write(dtls_x509_session_t * sessionP, uint8_t * buffer, size_t length)
+{
+    int result;
+
+    if (sessionP->ssl == NULL)
+    {
+        if (dtls_x509_connect(sessionP) != 0) return -1;
+    }
+    else if (!SSL_is_init_finished(sessionP->ssl))
+    {
+        // resend the last flight of the handshake if its timer expired
+        if (DTLSv1_handle_timeout(sessionP->ssl) < 0)
+        {
+            prv_log_error("the handshake timed out");
+            prv_close(sessionP);
+            return -1;
+        }
+    }
+
+    if (!SSL_is_init_finished(sessionP->ssl)) return length;
+
+    result = SSL_write(sessionP->ssl, buffer, length);
+    if (result <= 0)
+    {
+        prv_log_error("the data cannot be sent");
+        prv_close(sessionP);
+        return -1;
+    }
+
+    return result;
+}
+
+int dtls_x509_handle_packet(dtls_x509_session_t * sessionP, uint8_t * buffer, size_t length)
+{
+    int result = 0;
+
+    // a datagram of a session that was dropped
+    if (sessionP->ssl == NULL) return -1;
+
+    sessionP->packet = buffer;
+    sessionP->packetLength = length;
+
+    if (!SSL_is_init_finished(sessionP->ssl))
+    {
+        result = prv_handshake(sessionP);
+    }
+
+    while (result == 0 && sessionP->ssl != NULL && SSL_is_init_finished(sessionP->ssl))
+    {
+        int readLength;
+
+        readLength = SSL_read(sessionP->ssl, sessionP->plain, PRV_MAX_RECORD);
+        if (readLength > 0)
+        {
+            sessionP->receiveCallback(sessionP->userData, sessionP->plain, readLength);
+            continue;
+        }
+
+        switch (SSL_get_error(sessionP->ssl, readLength))
+        {
+        case SSL_ERROR_WANT_READ:
+        case SSL_ERROR_WANT_WRITE:
+            break;
+        case SSL_ERROR_ZERO_RETURN:
+            fprintf(stderr, "DTLS X.509: the session was closed by the server\r\n");
+            prv_close(sessionP);
+            result = -1;
+            break;
+        default:
+            prv_log_error("the data cannot be read");
+            prv_close(sessionP);
+            result = -1;
+            break;
+        }
+        break;
+    }
+
+    sessionP->packet = NULL;
+    sessionP->packetLength = 0;
+
+    return result;
+}
+
+bool dtls_x509_is_connected(dtls_x509_session_t * sessionP)
+{
+    return sessionP->ssl != NULL && SSL_is_init_finished(sessionP->ssl);
+}
@@ -0,0 +1,63 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#ifndef DTLS_X509_H_
+#define DTLS_X509_H_
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+// The largest datagram sent in a session, the IPv6 minimum MTU
+#define DTLS_X509_MTU 1280
+
+// A DTLS 1.2 session with a server, authenticated with X.509 certificates.
+// tinydtls only supports the pre-shared keys and the raw public keys, so the
+// sessions of the certificate security mode are handled by OpenSSL. The
+// datagrams are exchanged through the callbacks, so the session does not own
+// a socket.
+typedef struct _dtls_x509_session_t dtls_x509_session_t;
+
+// Sends a datagram to the server. Returns the number of bytes sent, or -1.
+typedef int (*dtls_x509_send_callback_t)(void * userData, uint8_t * buffer, size_t length);
+
+// Receives the data decrypted from a datagram of the server
+typedef void (*dtls_x509_receive_callback_t)(void * userData, uint8_t * buffer, size_t length);
+
+// The credentials of the session, as stored in the security object: the DER
+// certificate and PKCS#8 private key of the client, and the DER certificate
+// the chain of the server is verified against. The certificate of the server
+// must also be issued for the host.
+typedef struct
+{
+    const uint8_t * certificate;
+    size_t          certificateLength;
+    const uint8_t * privateKey;
+    size_t          privateKeyLength;
+    const uint8_t * serverCertificate;
+    size_t          serverCertificateLength;
+    const char *    host;
+} dtls_x509_credentials_t;
+
+// Create a session with the credentials. Returns NULL if they cannot be
+// loaded. The handshake starts with the first write.
+dtls_x509_session_t * dtls_x509_new(const dtls_x509_credentials_t * credentialsP, dtls_x509_send_callback_t sendCallback, dtls_x509_receive_callback_t receiveCallback, void * userData);
+void dtls_x509_free(dtls_x509_session_t * sessionP);
+
+// Start a new handshake, dropping the current session. Returns 0, or -1 on error.
+int dtls_x509_connect(dtls_x509_session_t * sessionP);
+
+// Encrypt and send the data. The data written during the handshake is dropped,
+// as CoAP retransmits it. Returns the number of bytes written, or -1 on error.
+int dtls_x509_write(dtls_x509_session_t * sessionP, uint8_t * buffer, size_t length);
+
+// Handle a datagram of the server: the handshake messages are answered and the
+// application data is passed to the receive callback. Returns 0, or -1 when
+// the session failed or was closed by the server, and a new handshake starts
+// with the next write.
+int dtls_x509_handle_packet(dtls_x509_session_t * sessionP, uint8_t * buffer, size_t length);
+
+// Whether the handshake completed
+bool dtls_x509_is_connected(dtls_x509_session_t * sessionP);
+
+#endif
@@ -68,19 +68,105 @@ extern "C" {
 
 extern void GetDeviceInformation();
 
-extern char* DeviceObjectRead(GoInt p0);
+extern char* DeviceObjectRead(GoInt p0, int* p1, int* p2);
+
+extern int DeviceReboot();
+
+extern int DeviceFactoryReset();
 
 extern int GetSnapCount();
 
-extern char* SnapInstanceRead(GoInt p0, GoInt p1);
+extern int GetSnapInstanceID(GoInt p0);
+
+extern char* SnapInstanceRead(GoInt p0, GoInt p1, int* p2, int* p3);
 
-extern char* SnapInstanceExecute(GoInt p0, GoInt p1);
+extern int SnapInstanceWrite(GoInt p0, GoInt p1, char* p2, int p3);
+
+extern int SnapInstanceExecute(GoInt p0, GoInt p1, char* p2, int p3);
 
 extern char* SnapDelimited(GoInt p0);
 
-extern void SnapConfig(GoInt p0);
+extern char* SnapControlConfig();
+
+extern int SnapControlSetConfig(char* p0, int p1);
+
+extern int GetSnapErrorCount();
+
+extern char* SnapErrorRead(GoInt p0);
+
+extern int SnapExecute(GoInt p0, char* p1, int p2);
+
+extern int GetSystemCount();
+
+extern char* SystemInstanceRead(GoInt p0, GoInt p1, int* p2, int* p3);
+
+extern int SystemExecute(GoInt p0, GoInt p1);
+
+extern char* FirmwareRead(GoInt p0, int* p1, int* p2);
+
+extern int FirmwareWrite(GoInt p0, char* p1);
+
+extern int FirmwareExecute(GoInt p0);
+
+extern int GetSoftwareCount();
+
+extern int GetSoftwareInstanceID(GoInt p0);
+
+extern char* SoftwareRead(GoInt p0, GoInt p1, int* p2, int* p3);
+
+extern int SoftwareCreate(GoInt p0, char* p1);
+
+extern int SoftwareExecute(GoInt p0, GoInt p1);
+
+extern int SoftwareDelete(GoInt p0);
+
+extern char* ConnectivityRead(GoInt p0, int* p1, int* p2);
+
+extern int ConnectivityCount(GoInt p0);
+
+extern char* ConnectivityReadInstance(GoInt p0, GoInt p1, int* p2, int* p3);
+
+extern char* StatisticsRead(GoInt p0, int* p1, int* p2);
+
+extern int StatisticsWrite(GoInt p0, char* p1);
+
+extern int StatisticsExecute(GoInt p0);
+
+extern char* LocationRead(GoInt p0, int* p1, int* p2);
+
+extern void ServerRegistration(GoInt p0, GoInt p1);
+
+extern void StoreBootstrapInstance(GoInt p0, GoInt p1, char* p2, int p3);
+
+extern void CommitBootstrapInstances(GoInt p0);
+
+extern int BootstrapNeeded();
+
+extern int TransportConnect(char* p0, GoInt p1, char* p2, int p3, char* p4, int p5, char* p6, int p7);
+
+extern int TransportSend(GoInt p0, char* p1, int p2);
+
+extern char* TransportReceive(int* p0, int* p1);
+
+extern void TransportClose(GoInt p0);
+
+extern int TransportWakeFd();
+
+extern void SendResult(GoInt p0, GoInt p1, GoInt p2);
+
+extern int GetOperationCount();
+
+extern int GetOperationInstanceID(GoInt p0);
+
+extern char* OperationRead(GoInt p0, GoInt p1, int* p2, int* p3);
+
+extern int GetServiceCount();
+
+extern int GetServiceInstanceID(GoInt p0);
+
+extern char* ServiceRead(GoInt p0, GoInt p1, int* p2, int* p3);
 
-extern char* SnapExecute(GoInt p0, char* p1);
+extern int ServiceExecute(GoInt p0, GoInt p1);
 
 #ifdef __cplusplus
 }
@@ -81,22 +81,28 @@
 #include <sys/stat.h>
 #include <errno.h>
 #include <signal.h>
+#include <stdint.h>
 
 #include "lwm2mclient.h"
+#include "send.h"
 #include "gocallbacks.h"
 
 extern lwm2m_object_t * get_object_device(void);
 extern void display_device_object(lwm2m_object_t * objectP);
 extern void free_object_device(lwm2m_object_t * objectP);
 
-extern lwm2m_object_t * get_security_object(int serverId, const char* serverUri,
-     char * bsPskId, char * psk, uint16_t pskLen, bool isBootstrap);
+extern lwm2m_object_t * get_security_object(void);
+extern int add_security_instance(lwm2m_object_t * objectP, int serverId, const char* serverUri, uint8_t securityMode,
+     char * publicId, uint16_t publicIdLen, char * serverKey, uint16_t serverKeyLen,
+     char * secretKey, uint16_t secretKeyLen, bool isBootstrap, uint32_t holdOffTime);
 extern void clean_security_object(lwm2m_object_t * objectP);
 extern void display_security_object(lwm2m_object_t * objectP);
 extern void copy_security_object(lwm2m_object_t * objectDest, lwm2m_object_t * objectSrc);
 
 extern char * get_server_uri(lwm2m_object_t * objectP, uint16_t secObjInstID);
-extern lwm2m_object_t * get_server_object(int serverId, const char* binding, int lifetime, bool storing);
+extern int connect_transport(lwm2m_object_t * objectP, uint16_t secObjInstID);
+extern lwm2m_object_t * get_server_object(void);
+extern int add_server_instance(lwm2m_object_t * objectP, int serverId, const char* binding, int lifetime, bool storing);
 extern void clean_server_object(lwm2m_object_t * object);
 extern void display_server_object(lwm2m_object_t * objectP);
 extern void copy_server_object(lwm2m_object_t * objectDest, lwm2m_object_t * objectSrc);
@@ -108,14 +114,64 @@ extern void free_snap_control_object(lwm2m_object_t * object);
 extern lwm2m_object_t * get_snap_object(void);
 extern void display_snap_object(lwm2m_object_t * object);
 extern void free_snap_object(lwm2m_object_t * object);
+extern bool update_snap_instances(lwm2m_object_t * object);
+
+extern lwm2m_object_t * get_object_firmware(void);
+extern void display_firmware_object(lwm2m_object_t * object);
+extern void free_object_firmware(lwm2m_object_t * objectP);
+
+extern lwm2m_object_t * get_system_object(void);
+extern void display_system_object(lwm2m_object_t * object);
+extern void free_system_object(lwm2m_object_t * object);
+
+extern lwm2m_object_t * get_operations_object(void);
+extern void display_operations_object(lwm2m_object_t * object);
+extern void free_operations_object(lwm2m_object_t * object);
+extern bool update_operation_instances(lwm2m_object_t * object);
+
+extern lwm2m_object_t * get_services_object(void);
+extern void display_services_object(lwm2m_object_t * object);
+extern void free_services_object(lwm2m_object_t * object);
+extern bool update_service_instances(lwm2m_object_t * object);
+
+extern lwm2m_object_t * get_software_object(void);
+extern void display_software_object(lwm2m_object_t * object);
+extern void free_software_object(lwm2m_object_t * object);
+
+extern lwm2m_object_t * get_object_conn_m(void);
+extern void display_connectivity_object(lwm2m_object_t * object);
+extern void free_object_conn_m(lwm2m_object_t * objectP);
+
+extern lwm2m_object_t * get_object_conn_s(void);
+extern void display_statistics_object(lwm2m_object_t * object);
+extern void free_object_conn_s(lwm2m_object_t * objectP);
+
+extern lwm2m_object_t * get_object_location(void);
+extern void display_location_object(lwm2m_object_t * object);
+extern void free_object_location(lwm2m_object_t * objectP);
 
 extern void init_value_change(lwm2m_context_t * lwm2m);
 extern void sendFullObjectList();
 
 #define MAX_PACKET_SIZE 1024
+#define MAX_URI_LENGTH  128
+
+// Seconds to wait for the deregistration to complete before rebooting
+#define REBOOT_DELAY 5
+
+// Seconds to wait for the device to go down before registering again
+#define REBOOT_TIMEOUT 300
+
+// Seconds between the checks of the Go resources for changes
+#define REFRESH_INTERVAL 10
+
+// Seconds that a client in queue mode listens for the server requests after it
+// has sent a message to the server, the CoAP MAX_TRANSMIT_WAIT
+#define QUEUE_AWAKE_TIME 93
 
 int g_reboot = 0;
-int lifetime = 30;
+time_t reboot_time = 0;
+int g_bootstrap_requested = 0;
 char * clientName;
 int totalSnaps = 0;
 
@@ -138,6 +194,58 @@ typedef struct
 } client_data_t;
 
 
+// CoAP over TCP and TLS is driven by Go, outside of the UDP socket
+static bool prv_is_transport_uri(const char * uri)
+{
+    return 0 == strncmp(uri, "coap+tcp://", strlen("coap+tcp://"))
+        || 0 == strncmp(uri, "coaps+tcp://", strlen("coaps+tcp://"));
+}
+
+static void * prv_connect_transport(client_data_t * dataP,
+                                    uint16_t secObjInstID)
+{
+#ifdef WITH_TINYDTLS
+    dtls_connection_t * connP;
+#else
+    connection_t * connP;
+#endif
+    int transportId;
+
+    transportId = connect_transport(dataP->securityObjP, secObjInstID);
+    if (transportId <= 0)
+    {
+        fprintf(stderr, "Transport connection failed.\r\n");
+        return NULL;
+    }
+
+    connP = lwm2m_malloc(sizeof(*connP));
+    if (connP == NULL)
+    {
+        TransportClose(transportId);
+        return NULL;
+    }
+    memset(connP, 0, sizeof(*connP));
+    connP->sock = -1;
+    connP->transportId = transportId;
+#ifdef WITH_TINYDTLS
+    connP->securityObj = dataP->securityObjP;
+    connP->securityInstId = secObjInstID;
+    connP->lwm2mH = dataP->lwm2mH;
+#endif
+
+    connP->next = dataP->connList;
+    dataP->connList = connP;
+    return (void *)connP;
+}
+
+// Send the data of a connection that Go drives
+int transport_send(int transportId,
+                   uint8_t * buffer,
+                   size_t length)
+{
+    return TransportSend(transportId, (char *)buffer, length);
+}
+
 #ifdef WITH_TINYDTLS
 void * lwm2m_connect_server(uint16_t secObjInstID,
                             void * userData)
@@ -151,6 +259,15 @@ void * lwm2m_connect_server(uint16_t secObjInstID,
   instance = LWM2M_LIST_FIND(dataP->securityObjP->instanceList, secObjInstID);
   if (instance == NULL) return NULL;
 
+  char * uri = get_server_uri(securityObj, secObjInstID);
+  if (uri == NULL) return NULL;
+  if (prv_is_transport_uri(uri))
+  {
+      fprintf(stdout, "Connecting to %s\r\n", uri);
+      lwm2m_free(uri);
+      return prv_connect_transport(dataP, secObjInstID);
+  }
+  lwm2m_free(uri);
 
   newConnP = connection_create(dataP->connList, dataP->sock, securityObj, instance->id, dataP->lwm2mH, dataP->addressFamily);
   if (newConnP == NULL)
@@ -180,6 +297,12 @@ void * lwm2m_connect_server(uint16_t secObjInstID,
 
     fprintf(stdout, "Connecting to %s\r\n", uri);
 
+    if (prv_is_transport_uri(uri))
+    {
+        newConnP = prv_connect_transport(dataP, secObjInstID);
+        goto exit;
+    }
+
     // parse uri in the form "coaps://[host]:[port]"
     if (0 == strncmp(uri, "coaps://", strlen("coaps://"))) {
         host = uri+strlen("coaps://");
@@ -238,6 +361,12 @@ void lwm2m_close_connection(void * sessionH,
     targetP = (connection_t *)sessionH;
 #endif
 
+    // Go closes the connections that it drives
+    if (targetP->transportId > 0)
+    {
+        TransportClose(targetP->transportId);
+    }
+
     if (targetP == app_data->connList)
     {
         app_data->connList = targetP->next;
@@ -381,7 +510,7 @@ void print_state(lwm2m_context_t * lwm2mH)
     }
 }
 
-#define OBJ_COUNT 5
+#define OBJ_COUNT 13
 
 client_data_t data;
 lwm2m_context_t * lwm2mH = NULL;
@@ -389,17 +518,20 @@ lwm2m_object_t * objArray[OBJ_COUNT];
 
 #ifdef LWM2M_BOOTSTRAP
 lwm2m_client_state_t previousState = STATE_INITIAL;
-#endif
 
-char * pskId = NULL;
-char * psk = NULL;
-uint16_t pskLen = -1;
-char * pskBuffer = NULL;
+// Set while a bootstrap that must not be cancelled is starting
+bool bootstrapTriggered = false;
+#endif
 
 // Timing for the send/receive connections to the server
 struct timeval tv;
 fd_set readfds;
 
+// Queue mode: the client sleeps until awakeUntil has passed, and wakes when an
+// observed resource changes
+time_t awakeUntil = 0;
+bool wakeRequested = false;
+
 
 #ifdef LWM2M_BOOTSTRAP
 
@@ -440,12 +572,36 @@ static void prv_display_objects(char * buffer,
             case LWM2M_DEVICE_OBJECT_ID:
                 display_device_object(object);
                 break;
+            case LWM2M_CONN_MONITOR_OBJECT_ID:
+                display_connectivity_object(object);
+                break;
+            case LWM2M_LOCATION_OBJECT_ID:
+                display_location_object(object);
+                break;
+            case LWM2M_CONN_STATS_OBJECT_ID:
+                display_statistics_object(object);
+                break;
+            case LWM2M_FIRMWARE_UPDATE_OBJECT_ID:
+                display_firmware_object(object);
+                break;
+            case LWM2M_SOFTWARE_MANAGEMENT_OBJECT_ID:
+                display_software_object(object);
+                break;
             case LWM2M_SNAP_CONTROL_OBJECT_ID:
                 display_snap_control_object(object);
                 break;
             case LWM2M_SNAP_OBJECT_ID:
                 display_snap_object(object);
                 break;
+            case LWM2M_RECOVERY_SYSTEM_OBJECT_ID:
+                display_system_object(object);
+                break;
+            case LWM2M_SNAP_OPERATIONS_OBJECT_ID:
+                display_operations_object(object);
+                break;
+            case LWM2M_SNAP_SERVICES_OBJECT_ID:
+                display_services_object(object);
+                break;
             }
         }
     }
@@ -549,6 +705,54 @@ static void close_backup_object()
     }
 }
 
+// Hand the provisioned security and server instances to Go, which stores them
+static void prv_store_objects(lwm2m_context_t * context)
+{
+    uint16_t objectIds[] = {LWM2M_SECURITY_OBJECT_ID, LWM2M_SERVER_OBJECT_ID};
+    int complete = 1;
+    int i;
+
+    for (i = 0; i < BACKUP_OBJECT_COUNT; i++) {
+        lwm2m_object_t * objectP = (lwm2m_object_t *)LWM2M_LIST_FIND(context->objectList, objectIds[i]);
+        lwm2m_list_t * instanceP;
+
+        if (NULL == objectP) continue;
+
+        for (instanceP = objectP->instanceList; instanceP != NULL; instanceP = instanceP->next) {
+            lwm2m_media_type_t format = LWM2M_CONTENT_JSON;
+            lwm2m_data_t * dataP = NULL;
+            uint8_t * buffer = NULL;
+            lwm2m_uri_t uri;
+            int size = 0;
+            int length = -1;
+
+            LWM2M_URI_RESET(&uri);
+            uri.objectId = objectP->objID;
+            uri.instanceId = instanceP->id;
+
+            if (COAP_205_CONTENT == objectP->readFunc(context, instanceP->id, &size, &dataP, objectP)) {
+                length = lwm2m_data_serialize(&uri, size, dataP, &format, &buffer);
+            }
+            lwm2m_data_free(size, dataP);
+
+            if (length <= 0) {
+                complete = 0;
+                continue;
+            }
+
+            // Go callback to keep the instance until the set is complete
+            StoreBootstrapInstance(objectP->objID, instanceP->id, (char *)buffer, length);
+
+            // The security instances hold the secret keys
+            memset(buffer, 0, length);
+            lwm2m_free(buffer);
+        }
+    }
+
+    // Go callback to write the instances to the filesystem
+    CommitBootstrapInstances(complete);
+}
+
 static void update_bootstrap_info(lwm2m_client_state_t * previousBootstrapState,
         lwm2m_context_t * context)
 {
@@ -559,6 +763,15 @@ static void update_bootstrap_info(lwm2m_client_state_t * previousBootstrapState,
 
     if (*previousBootstrapState != context->state)
     {
+        // The bootstrap is finished when the client goes on to register
+        if (*previousBootstrapState == STATE_BOOTSTRAPPING && context->state != STATE_BOOTSTRAP_REQUIRED)
+        {
+#ifdef WITH_LOGS
+            fprintf(stdout, "[BOOTSTRAP] store security and server objects\r\n");
+#endif
+            prv_store_objects(context);
+        }
+
         *previousBootstrapState = context->state;
         switch(context->state)
         {
@@ -578,24 +791,114 @@ static void update_bootstrap_info(lwm2m_client_state_t * previousBootstrapState,
 
 
 
-int createServer(
-        char server[50], char port[5], char localPort[5], char name[20],
-        int bootstrapRequested)
+// Returns true when no deregistration is waiting for the reply of its server
+static bool prv_deregistered(lwm2m_context_t * context)
+{
+    lwm2m_server_t * serverP;
+
+    for (serverP = context->serverList ; serverP != NULL ; serverP = serverP->next)
+    {
+        if (serverP->status == STATE_DEREG_PENDING) return false;
+    }
+
+    return true;
+}
+
+// The client may only sleep when it is registered with every server in queue mode
+static bool prv_can_sleep(lwm2m_context_t * context)
+{
+    lwm2m_server_t * serverP;
+
+    if (context->state != STATE_READY) return false;
+
+    for (serverP = context->serverList ; serverP != NULL ; serverP = serverP->next)
+    {
+        if ((serverP->binding & BINDING_Q) == 0 || serverP->status != STATE_REGISTERED)
+        {
+            return false;
+        }
+    }
+
+    return context->serverList != NULL;
+}
+
+static bool prv_is_observed(lwm2m_context_t * context, lwm2m_uri_t * uriP)
+{
+    lwm2m_observed_t * observedP;
+
+    for (observedP = context->observedList ; observedP != NULL ; observedP = observedP->next)
+    {
+        if (observedP->uri.objectId != uriP->objectId) continue;
+        if (LWM2M_URI_IS_SET_INSTANCE(&observedP->uri) && observedP->uri.instanceId != uriP->instanceId) continue;
+        if (LWM2M_URI_IS_SET_RESOURCE(&observedP->uri) && observedP->uri.resourceId != uriP->resourceId) continue;
+        return true;
+    }
+
+    return false;
+}
+
+// Bind an IPv6 socket, which also handles IPv4 through mapped addresses unless
+// it is IPv6 only
+static int prv_create_ipv6_socket(const char * portStr,
+                                  bool v6only)
+{
+    struct sockaddr_in6 addr;
+    int opt = v6only ? 1 : 0;
+    int s;
+
+    s = socket(AF_INET6, SOCK_DGRAM, 0);
+    if (s < 0) return -1;
+
+    if (-1 == setsockopt(s, IPPROTO_IPV6, IPV6_V6ONLY, &opt, sizeof(opt)))
+    {
+        close(s);
+        return -1;
+    }
+
+    memset(&addr, 0, sizeof(addr));
+    addr.sin6_family = AF_INET6;
+    addr.sin6_addr = in6addr_any;
+    addr.sin6_port = htons(atoi(portStr));
+    if (-1 == bind(s, (struct sockaddr *)&addr, sizeof(addr)))
+    {
+        close(s);
+        return -1;
+    }
+
+    return s;
+}
+
+int createServer(char localPort[5], char name[20], int addressFamily)
 {
     int result;
-    int opt;
 
     clientName = name;
 
     memset(&data, 0, sizeof(client_data_t));
 
-    data.addressFamily = AF_INET;   // Default to IPv4
-
-    /*
-     *This call an internal function that create an IPv6 socket on the port 5683.
-     */
     fprintf(stderr, "Trying to bind LWM2M Client to port %s\r\n", localPort);
-    data.sock = create_socket(localPort, data.addressFamily);
+    switch (addressFamily)
+    {
+    case ADDRESS_FAMILY_IPV6:
+        data.addressFamily = AF_INET6;
+        data.sock = prv_create_ipv6_socket(localPort, true);
+        break;
+    case ADDRESS_FAMILY_DUAL:
+        data.addressFamily = AF_INET6;
+        data.sock = prv_create_ipv6_socket(localPort, false);
+        if (data.sock < 0)
+        {
+            // Fall back to IPv4 when the host has no IPv6
+            fprintf(stderr, "IPv6 is not available, binding to IPv4\r\n");
+            data.addressFamily = AF_INET;
+            data.sock = create_socket(localPort, data.addressFamily);
+        }
+        break;
+    default:
+        data.addressFamily = AF_INET;
+        data.sock = create_socket(localPort, data.addressFamily);
+        break;
+    }
     if (data.sock < 0)
     {
         fprintf(stderr, "Failed to open socket: %d %s\r\n", errno, strerror(errno));
@@ -606,51 +909,8 @@ int createServer(
      * Now the main function fill an array with each object, this list will be later passed to liblwm2m.
      * Those functions are located in their respective object file.
      */
-#ifdef WITH_TINYDTLS
-    if (psk != NULL)
-    {
-        pskLen = strlen(psk) / 2;
-        pskBuffer = malloc(pskLen);
-
-        if (NULL == pskBuffer)
-        {
-            fprintf(stderr, "Failed to create PSK binary buffer\r\n");
-            return -1;
-        }
-        // Hex string to binary
-        char *h = psk;
-        char *b = pskBuffer;
-        char xlate[] = "0123456789ABCDEF";
-
-        for ( ; *h; h += 2, ++b)
-        {
-            char *l = strchr(xlate, toupper(*h));
-            char *r = strchr(xlate, toupper(*(h+1)));
-
-            if (!r || !l)
-            {
-                fprintf(stderr, "Failed to parse Pre-Shared-Key HEXSTRING\r\n");
-                return -1;
-            }
-
-            *b = ((l - xlate) << 4) + (r - xlate);
-        }
-    }
-#endif
-
-     char serverUri[50];
-     int serverId = 123;
-     sprintf (serverUri, "coap://%s:%s", server, port);
-
-#ifdef LWM2M_BOOTSTRAP
-    bool bootstrap = false;
-    if (bootstrapRequested == 1) {
-        bootstrap = true;
-    }
-    objArray[0] = get_security_object(serverId, serverUri, pskId, pskBuffer, pskLen, bootstrap);
-#else
-    objArray[0] = get_security_object(serverId, serverUri, pskId, pskBuffer, pskLen, false);
-#endif
+    // The security and server instances are added by addServer
+    objArray[0] = get_security_object();
     if (NULL == objArray[0])
     {
         fprintf(stderr, "Failed to create security object\r\n");
@@ -658,7 +918,7 @@ int createServer(
     }
     data.securityObjP = objArray[0];
 
-    objArray[1] = get_server_object(serverId, "U", lifetime, false);
+    objArray[1] = get_server_object();
     if (NULL == objArray[1])
     {
         fprintf(stderr, "Failed to create server object\r\n");
@@ -686,6 +946,62 @@ int createServer(
         return -1;
     }
 
+    objArray[5] = get_system_object();
+    if (NULL == objArray[5])
+    {
+        fprintf(stderr, "Failed to create Recovery system object\r\n");
+        return -1;
+    }
+
+    objArray[6] = get_object_firmware();
+    if (NULL == objArray[6])
+    {
+        fprintf(stderr, "Failed to create Firmware object\r\n");
+        return -1;
+    }
+
+    objArray[7] = get_software_object();
+    if (NULL == objArray[7])
+    {
+        fprintf(stderr, "Failed to create Software management object\r\n");
+        return -1;
+    }
+
+    objArray[8] = get_object_conn_m();
+    if (NULL == objArray[8])
+    {
+        fprintf(stderr, "Failed to create Connectivity monitoring object\r\n");
+        return -1;
+    }
+
+    objArray[9] = get_object_conn_s();
+    if (NULL == objArray[9])
+    {
+        fprintf(stderr, "Failed to create Connectivity statistics object\r\n");
+        return -1;
+    }
+
+    objArray[10] = get_object_location();
+    if (NULL == objArray[10])
+    {
+        fprintf(stderr, "Failed to create Location object\r\n");
+        return -1;
+    }
+
+    objArray[11] = get_operations_object();
+    if (NULL == objArray[11])
+    {
+        fprintf(stderr, "Failed to create Snap operations object\r\n");
+        return -1;
+    }
+
+    objArray[12] = get_services_object();
+    if (NULL == objArray[12])
+    {
+        fprintf(stderr, "Failed to create Snap services object\r\n");
+        return -1;
+    }
+
     /*
      * The liblwm2m library is now initialized with the functions that will be in
      * charge of communication
@@ -722,55 +1038,213 @@ int createServer(
 
 }
 
-void handle_value_changed(lwm2m_context_t * lwm2mH,
-                          lwm2m_uri_t * uri,
-                          const char * value,
-                          size_t valueLength)
+// Add a server to connect to, before the client starts registering
+int addServer(
+        char * serverUri, int serverId, int lifetime, char * binding, int bootstrap, int holdOffTime,
+        int securityMode, char * publicId, int publicIdLen, char * serverKey, int serverKeyLen,
+        char * secretKey, int secretKeyLen)
 {
-    lwm2m_object_t * object = (lwm2m_object_t *)LWM2M_LIST_FIND(lwm2mH->objectList, uri->objectId);
+    int instanceId;
 
-    if (NULL != object)
+#ifndef WITH_TINYDTLS
+    if (securityMode != LWM2M_SECURITY_MODE_NONE)
     {
-        if (object->writeFunc != NULL)
-        {
-            lwm2m_data_t * dataP;
-            int result;
+        fprintf(stderr, "Server %d needs a secure connection, but the client is built without DTLS\r\n", serverId);
+        return -1;
+    }
+#endif
 
-            dataP = lwm2m_data_new(1);
-            if (dataP == NULL)
-            {
-                fprintf(stderr, "Internal allocation failure !\n");
-                return;
-            }
-            dataP->id = uri->resourceId;
-            lwm2m_data_encode_nstring(value, valueLength, dataP);
+    // The security object keeps its own copy of the credentials, which are freed by the Go caller
+    instanceId = add_security_instance(objArray[0], serverId, serverUri, securityMode, publicId, publicIdLen,
+                                       serverKey, serverKeyLen, secretKey, secretKeyLen, bootstrap == 1, holdOffTime);
+    if (instanceId < 0)
+    {
+        fprintf(stderr, "Failed to add security instance for server %d\r\n", serverId);
+        return -1;
+    }
 
-            // JBP change
-            // result = object->writeFunc(uri->instanceId, 1, dataP, object);
-            result = object->writeFunc(lwm2mH, uri->instanceId, 1, dataP, object, LWM2M_WRITE_PARTIAL_UPDATE);
+    // A bootstrap server has no server instance
+    if (bootstrap == 1)
+    {
+        return 0;
+    }
 
-            if (COAP_204_CHANGED != result)
-            {
-                //fprintf(stderr, "Failed to change value!\n");
-            }
-            else
-            {
-                // Indicate that the value has changed
-                lwm2m_resource_value_changed(lwm2mH, uri);
-            }
-            lwm2m_data_free(1, dataP);
-            return;
-        }
-        else
-        {
-            fprintf(stderr, "write not supported for specified resource!\n");
-        }
-        return;
+    instanceId = add_server_instance(objArray[1], serverId, binding, lifetime, false);
+    if (instanceId < 0)
+    {
+        fprintf(stderr, "Failed to add server instance for server %d\r\n", serverId);
+        return -1;
     }
-    else
+
+    return 0;
+}
+
+// Restore a security or server instance that was provisioned by the bootstrap server
+int restoreInstance(int objectId, int instanceId, char * buffer, int length)
+{
+    lwm2m_object_t * objectP;
+    lwm2m_data_t * dataP = NULL;
+    lwm2m_uri_t uri;
+    uint8_t result;
+    int size;
+
+    if (objectId != LWM2M_SECURITY_OBJECT_ID && objectId != LWM2M_SERVER_OBJECT_ID)
+    {
+        return -1;
+    }
+
+    objectP = (lwm2m_object_t *)LWM2M_LIST_FIND(lwm2mH->objectList, objectId);
+    if (NULL == objectP || NULL == objectP->createFunc)
+    {
+        return -1;
+    }
+
+    LWM2M_URI_RESET(&uri);
+    uri.objectId = objectId;
+    uri.instanceId = instanceId;
+
+    size = lwm2m_data_parse(&uri, (uint8_t *)buffer, length, LWM2M_CONTENT_JSON, &dataP);
+    if (size <= 0)
+    {
+        fprintf(stderr, "Failed to parse instance /%d/%d\r\n", objectId, instanceId);
+        return -1;
+    }
+
+    result = objectP->createFunc(lwm2mH, instanceId, size, dataP, objectP);
+    lwm2m_data_free(size, dataP);
+
+    if (COAP_201_CREATED != result)
+    {
+        fprintf(stderr, "Failed to restore instance /%d/%d: 0x%X\r\n", objectId, instanceId, result);
+        return -1;
+    }
+    return 0;
+}
+
+// Decode a value passed by Go into the data of a resource, see VALUE_TYPE_*
+int encode_value(int valueType,
+                 const char * value,
+                 int valueLength,
+                 lwm2m_data_t * dataP)
+{
+    char text[64];
+    char * end;
+    long long integer;
+    double number;
+    unsigned int objectId;
+    unsigned int instanceId;
+
+    if (valueType == VALUE_TYPE_STRING)
+    {
+        lwm2m_data_encode_nstring(value, valueLength, dataP);
+        return 0;
+    }
+    if (valueType == VALUE_TYPE_OPAQUE)
+    {
+        lwm2m_data_encode_opaque((uint8_t *)value, valueLength, dataP);
+        return 0;
+    }
+
+    // The other types are short texts
+    if (valueLength <= 0 || valueLength >= (int)sizeof(text)) return -1;
+    memcpy(text, value, valueLength);
+    text[valueLength] = 0;
+
+    switch (valueType)
+    {
+    case VALUE_TYPE_INTEGER:
+    case VALUE_TYPE_TIME:
+        // LwM2M encodes a time as an integer of seconds since the epoch
+        errno = 0;
+        integer = strtoll(text, &end, 10);
+        if (errno != 0 || *end != 0) return -1;
+        lwm2m_data_encode_int(integer, dataP);
+        return 0;
+
+    case VALUE_TYPE_FLOAT:
+        number = strtod(text, &end);
+        if (*end != 0) return -1;
+        lwm2m_data_encode_float(number, dataP);
+        return 0;
+
+    case VALUE_TYPE_BOOLEAN:
+        if (0 == strcmp(text, "1")) lwm2m_data_encode_bool(true, dataP);
+        else if (0 == strcmp(text, "0")) lwm2m_data_encode_bool(false, dataP);
+        else return -1;
+        return 0;
+
+    case VALUE_TYPE_OBJLINK:
+        if (2 != sscanf(text, "%u:%u", &objectId, &instanceId)) return -1;
+        if (objectId > LWM2M_MAX_ID || instanceId > LWM2M_MAX_ID) return -1;
+        lwm2m_data_encode_objlink(objectId, instanceId, dataP);
+        return 0;
+
+    default:
+        return -1;
+    }
+}
+
+static void prv_value_changed(lwm2m_context_t * lwm2mH,
+                              lwm2m_uri_t * uri,
+                              lwm2m_data_t * dataP)
+{
+    lwm2m_object_t * object = (lwm2m_object_t *)LWM2M_LIST_FIND(lwm2mH->objectList, uri->objectId);
+    lwm2m_data_t * readP;
+    int numData = 1;
+    uint8_t result;
+
+    if (NULL == object)
     {
         fprintf(stderr, "Object not found !\n");
+        return;
+    }
+    if (object->readFunc == NULL)
+    {
+        fprintf(stderr, "read not supported for specified resource!\n");
+        return;
+    }
+
+    // The values are read from Go, so the resource is read back to check it
+    // exists. It is not written, as the server cannot write the read-only resources.
+    readP = lwm2m_data_new(1);
+    if (readP == NULL)
+    {
+        fprintf(stderr, "Internal allocation failure !\n");
+        return;
+    }
+    readP->id = dataP->id;
+    result = object->readFunc(lwm2mH, uri->instanceId, &numData, &readP, object);
+    lwm2m_data_free(numData, readP);
+    if (COAP_205_CONTENT != result) return;
+
+    // Indicate that the value has changed
+    lwm2m_resource_value_changed(lwm2mH, uri);
+
+    // A sleeping client wakes to notify the observers
+    if (prv_is_observed(lwm2mH, uri))
+    {
+        wakeRequested = true;
+    }
+}
+
+void handle_value_changed(lwm2m_context_t * lwm2mH,
+                          lwm2m_uri_t * uri,
+                          const char * value,
+                          size_t valueLength)
+{
+    lwm2m_data_t * dataP;
+
+    dataP = lwm2m_data_new(1);
+    if (dataP == NULL)
+    {
+        fprintf(stderr, "Internal allocation failure !\n");
+        return;
     }
+    dataP->id = uri->resourceId;
+    lwm2m_data_encode_nstring(value, valueLength, dataP);
+
+    prv_value_changed(lwm2mH, uri, dataP);
+    lwm2m_data_free(1, dataP);
 }
 
 int closeServer() {
@@ -779,10 +1253,6 @@ int closeServer() {
      * Finally when the loop is left, we unregister our client from it
      */
 
-#ifdef WITH_TINYDTLS
-    free(pskBuffer);
-#endif
-
 #ifdef LWM2M_BOOTSTRAP
     close_backup_object();
 #endif
@@ -795,6 +1265,14 @@ int closeServer() {
     free_object_device(objArray[2]);
     free_snap_control_object(objArray[3]);
     free_snap_object(objArray[4]);
+    free_system_object(objArray[5]);
+    free_object_firmware(objArray[6]);
+    free_software_object(objArray[7]);
+    free_object_conn_m(objArray[8]);
+    free_object_conn_s(objArray[9]);
+    free_object_location(objArray[10]);
+    free_operations_object(objArray[11]);
+    free_services_object(objArray[12]);
 
     fprintf(stdout, "\r\n\n");
 
@@ -821,17 +1299,97 @@ int waitForTimeout() {
 int sendData() {
 
     int result;
+    lwm2m_server_t * serverP;
+    time_t now;
+    int wakeFd;
 
     // Set the timeout value
-    struct timeval tv;
     tv.tv_sec = 60;
     tv.tv_usec = 0;
 
     FD_ZERO(&readfds);
-    FD_SET(data.sock, &readfds);
 
     print_state(lwm2mH);
 
+    if (REBOOT_REQUESTED == g_reboot)
+    {
+        time_t tv_sec;
+
+        tv_sec = lwm2m_gettime();
+
+        if (0 == reboot_time)
+        {
+            // The Execute has been acknowledged, so deregister from the servers
+            fprintf(stdout, "Deregistering before reboot\r\n");
+            lwm2m_deregister(lwm2mH);
+            reboot_time = tv_sec + REBOOT_DELAY;
+        }
+        else if (reboot_time < tv_sec)
+        {
+            fprintf(stderr, "reboot time expired, rebooting ...\r\n");
+
+            // Go callback to request the reboot
+            if (0 == DeviceReboot())
+            {
+                // Wait for the system to go down
+                g_reboot = REBOOT_PENDING;
+            }
+            else
+            {
+                // The reboot was refused, so register again with the servers
+                fprintf(stderr, "Reboot failed, registering again\r\n");
+                g_reboot = 0;
+                reboot_time = 0;
+                lwm2mH->state = STATE_INITIAL;
+            }
+        }
+    }
+    else if (REBOOT_PENDING == g_reboot && reboot_time + REBOOT_TIMEOUT < lwm2m_gettime())
+    {
+        fprintf(stderr, "The device did not reboot, registering again\r\n");
+        g_reboot = 0;
+        reboot_time = 0;
+        lwm2mH->state = STATE_INITIAL;
+    }
+
+    // The library would register again once the servers have deregistered, so
+    // it is not stepped until the device has rebooted
+    if (0 != g_reboot && 0 != reboot_time && prv_deregistered(lwm2mH))
+    {
+        fprintf(stdout, " -> State: rebooting\r\n");
+        if (REBOOT_REQUESTED == g_reboot)
+        {
+            // Wake for the reboot
+            tv.tv_sec = reboot_time - lwm2m_gettime() + 1;
+            if (tv.tv_sec < 1) tv.tv_sec = 1;
+        }
+        return 0;
+    }
+
+    now = lwm2m_gettime();
+    if (wakeRequested)
+    {
+        wakeRequested = false;
+        if (now >= awakeUntil && prv_can_sleep(lwm2mH))
+        {
+            // The servers send their queued requests once the client is awake
+            fprintf(stdout, "Waking up to notify the servers\r\n");
+            lwm2m_update_registration(lwm2mH, 0, false);
+        }
+        awakeUntil = now + QUEUE_AWAKE_TIME;
+    }
+
+#ifdef LWM2M_BOOTSTRAP
+    if (BOOTSTRAP_REQUESTED == g_bootstrap_requested)
+    {
+        // The Execute has been acknowledged, so request the bootstrap
+        fprintf(stdout, "Bootstrap requested by the server\r\n");
+        g_bootstrap_requested = 0;
+        bootstrapTriggered = true;
+        prv_initiate_bootstrap(NULL, lwm2mH);
+    }
+#endif
+
     /*
     * This function does two things:
     *  - first it does the work needed by liblwm2m (eg. (re)sending some packets).
@@ -875,21 +1433,131 @@ int sendData() {
             printf("[BOOTSTRAP] restore security and server objects\r\n");
             prv_restore_objects(lwm2mH);
             lwm2mH->state = STATE_INITIAL;
+
+            // The restored objects are already stored
+            previousState = STATE_INITIAL;
         }
         else return -1;
     }
 
+    // Go callback to track the registration with each server
+    for (serverP = lwm2mH->serverList ; serverP != NULL ; serverP = serverP->next)
+    {
+        ServerRegistration(serverP->shortID, serverP->status);
+    }
+
 #ifdef LWM2M_BOOTSTRAP
+    // The lwm2m library bootstraps as soon as the registration fails. Go decides
+    // whether the registration has failed often enough to bootstrap again.
+    if (lwm2mH->state == STATE_BOOTSTRAPPING && !bootstrapTriggered
+     && (previousState == STATE_REGISTERING || previousState == STATE_READY))
+    {
+        if (0 == BootstrapNeeded())
+        {
+            fprintf(stdout, "Registration failed, registering again\r\n");
+            lwm2mH->state = STATE_INITIAL;
+        }
+    }
+    if (lwm2mH->state == STATE_BOOTSTRAPPING)
+    {
+        bootstrapTriggered = false;
+    }
+
     update_bootstrap_info(&previousState, lwm2mH);
 #endif
 
+    // The client stays awake while a registration is pending, and while a
+    // server in non-queue mode may send a request
+    if (!prv_can_sleep(lwm2mH))
+    {
+        awakeUntil = now + QUEUE_AWAKE_TIME;
+    }
+
+    // A sleeping client does not listen, so the server requests stay queued
+    // until the next registration update
+    if (now < awakeUntil)
+    {
+        FD_SET(data.sock, &readfds);
+
+        // Go signals the packets of the connections that it drives
+        wakeFd = TransportWakeFd();
+        if (wakeFd >= 0)
+        {
+            FD_SET(wakeFd, &readfds);
+        }
+    }
+    else
+    {
+        fprintf(stdout, "Sleeping in queue mode\r\n");
+    }
+
+    // Wake regularly to check the resources for changes
+    if (tv.tv_sec > REFRESH_INTERVAL)
+    {
+        tv.tv_sec = REFRESH_INTERVAL;
+    }
+
     return 0;
 }
 
+// Handle the packets received by the connections that Go drives
+static void prv_read_transports(int wakeFd)
+{
+#ifdef WITH_TINYDTLS
+    dtls_connection_t * connP;
+#else
+    connection_t * connP;
+#endif
+    lwm2m_server_t * serverP;
+    char * packet;
+    char byte;
+    int transportId;
+    int length;
+
+    while (read(wakeFd, &byte, 1) > 0);
+
+    // Go callback to get the next packet, the packet is ours to free
+    while (NULL != (packet = TransportReceive(&transportId, &length)))
+    {
+        for (connP = data.connList ; connP != NULL && connP->transportId != transportId ; connP = connP->next);
+
+        if (connP == NULL)
+        {
+            fprintf(stderr, "received bytes ignored!\r\n");
+        }
+        else if (0 == length)
+        {
+            // An empty packet reports a reconnection, which the server learns
+            // about from a registration update
+            for (serverP = lwm2mH->serverList ; serverP != NULL ; serverP = serverP->next)
+            {
+                if (serverP->sessionH == connP && serverP->status == STATE_REGISTERED)
+                {
+                    lwm2m_update_registration(lwm2mH, serverP->shortID, false);
+                }
+            }
+        }
+        else
+        {
+            awakeUntil = lwm2m_gettime() + QUEUE_AWAKE_TIME;
+            lwm2m_handle_packet(lwm2mH, (uint8_t *)packet, length, connP);
+        }
+
+        free(packet);
+    }
+}
+
 int readData() {
 
     uint8_t buffer[MAX_PACKET_SIZE];
     int numBytes;
+    int wakeFd;
+
+    wakeFd = TransportWakeFd();
+    if (wakeFd >= 0 && FD_ISSET(wakeFd, &readfds))
+    {
+        prv_read_transports(wakeFd);
+    }
 
     /*
      * If an event happens on the socket
@@ -918,6 +1586,9 @@ int readData() {
             connection_t * connP;
 #endif
 
+            // The server may have more requests for the client
+            awakeUntil = lwm2m_gettime() + QUEUE_AWAKE_TIME;
+
             connP = connection_find(data.connList, &addr, addrLen);
             if (connP != NULL)
             {
@@ -949,16 +1620,31 @@ int readData() {
 void handleValueRefresh(
         char * resourceUri,
         int resourceUriLength,
+        int valueType,
         char * value,
         int valueLength) {
     lwm2m_uri_t uri;
+    lwm2m_data_t * dataP;
 
     if (lwm2m_stringToUri(resourceUri, resourceUriLength, &uri)) {
-        handle_value_changed(lwm2mH, &uri, value, valueLength);
-        tv.tv_sec = 10;
+        dataP = lwm2m_data_new(1);
+        if (dataP == NULL)
+        {
+            fprintf(stderr, "Internal allocation failure !\n");
+            return;
+        }
+        dataP->id = uri.resourceId;
+        if (0 == encode_value(valueType, value, valueLength, dataP))
+        {
+            prv_value_changed(lwm2mH, &uri, dataP);
+        }
+        else
+        {
+            fprintf(stderr, "Invalid value for %.*s\n", resourceUriLength, resourceUri);
+        }
+        lwm2m_data_free(1, dataP);
     }
 
-    refreshObjects();
     tv.tv_sec = 10;
 }
 
@@ -967,18 +1653,91 @@ void sendFullObjectList() {
     free_snap_object(objArray[4]);
     objArray[4] = get_snap_object();
     lwm2m_add_object(lwm2mH, objArray[4]);
+
+    lwm2m_remove_object(lwm2mH, LWM2M_SOFTWARE_MANAGEMENT_OBJECT_ID);
+    free_software_object(objArray[7]);
+    objArray[7] = get_software_object();
+    lwm2m_add_object(lwm2mH, objArray[7]);
     tv.tv_sec = 10;
 }
 
+// Go callback with the response of a server to a Send
+static void prv_send_result(lwm2m_context_t * context,
+                            uint16_t shortServerID,
+                            uint8_t status,
+                            void * userData)
+{
+    SendResult((intptr_t)userData, shortServerID, status);
+}
+
+// Send the resources at the newline-separated paths to the registered servers
+int sendPaths(char * paths, int sendId) {
+    lwm2m_uri_t * uris;
+    char * path;
+    char * next;
+    int count = 1;
+    int result;
+
+    // The paths stay queued while the client is not registered
+    if (NULL == lwm2mH || lwm2mH->state != STATE_READY) return 0;
+
+    for (path = paths; *path; path++) {
+        if (*path == '\n') count++;
+    }
+    uris = (lwm2m_uri_t *)lwm2m_malloc(count * sizeof(lwm2m_uri_t));
+    if (NULL == uris) return -1;
+
+    count = 0;
+    for (path = paths; NULL != path && *path; path = next) {
+        size_t length;
+
+        next = strchr(path, '\n');
+        length = (NULL != next) ? (size_t)(next - path) : strlen(path);
+        if (NULL != next) next++;
+
+        if (lwm2m_stringToUri(path, length, uris + count)) {
+            count++;
+        }
+    }
+
+    result = lwm2m_send(lwm2mH, 0, uris, count, prv_send_result, (void *)(intptr_t)sendId);
+    lwm2m_free(uris);
+
+    // Stay awake in queue mode for the responses
+    if (result > 0) {
+        awakeUntil = lwm2m_gettime() + QUEUE_AWAKE_TIME;
+    }
+    return result;
+}
+
 // Send a full object registry update to the server
 void refreshObjects() {
 
     // Go callback to get the number of snaps installed
     int count = GetSnapCount();
 
-    // If the number of snaps changes, then send the full object list
+    // The software packages are the snaps, so they change with the number of snaps
     if (count != totalSnaps) {
-        sendFullObjectList();
+        lwm2m_remove_object(lwm2mH, LWM2M_SOFTWARE_MANAGEMENT_OBJECT_ID);
+        free_software_object(objArray[7]);
+        objArray[7] = get_software_object();
+        lwm2m_add_object(lwm2mH, objArray[7]);
         totalSnaps = count;
     }
+
+    // The snap instances keep their IDs, so only the installed and removed
+    // snaps are sent with the registration update
+    if (update_snap_instances(objArray[4])) {
+        lwm2m_update_registration(lwm2mH, 0, true);
+    }
+
+    // The operations are listed as they are started and forgotten
+    if (update_operation_instances(objArray[11])) {
+        lwm2m_update_registration(lwm2mH, 0, true);
+    }
+
+    // The services are listed as the snaps with daemons are installed and removed
+    if (update_service_instances(objArray[12])) {
+        lwm2m_update_registration(lwm2mH, 0, true);
+    }
 }
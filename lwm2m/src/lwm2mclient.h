@@ -1,10 +1,47 @@
-extern int createServer(char server[50], char port[4], char localPort[5], char name[20], int bootstrapRequested);
+extern int createServer(char localPort[5], char name[20], int addressFamily);
+extern int addServer(char * serverUri, int serverId, int lifetime, char * binding, int bootstrap, int holdOffTime, int securityMode, char * publicId, int publicIdLen, char * serverKey, int serverKeyLen, char * secretKey, int secretKeyLen);
+extern int restoreInstance(int objectId, int instanceId, char * buffer, int length);
 extern int closeServer();
 extern int sendData();
 extern int readData();
 extern int waitForTimeout();
-extern void handleValueRefresh(char * resourceUri, int resourceUriLength, char * value, int valueLength);
+extern void handleValueRefresh(char * resourceUri, int resourceUriLength, int valueType, char * value, int valueLength);
 extern void refreshObjects();
+extern int sendPaths(char * paths, int sendId);
+
+// Types of the resource values passed by Go. The value is the text of the number,
+// 1 or 0 for a boolean, the Unix time in seconds for a time, "objectId:instanceId"
+// for an object link, and the bytes of a string or opaque value.
+#define VALUE_TYPE_STRING   0
+#define VALUE_TYPE_INTEGER  1
+#define VALUE_TYPE_FLOAT    2
+#define VALUE_TYPE_BOOLEAN  3
+#define VALUE_TYPE_TIME     4
+#define VALUE_TYPE_OPAQUE   5
+#define VALUE_TYPE_OBJLINK  6
+
+struct _lwm2m_data_t;
+extern int encode_value(int valueType, const char * value, int valueLength, struct _lwm2m_data_t * dataP);
+
+// Values of the addressFamily of createServer
+#define ADDRESS_FAMILY_IPV4 4
+#define ADDRESS_FAMILY_IPV6 6
+#define ADDRESS_FAMILY_DUAL 0
+
+// Values of g_reboot
+#define REBOOT_REQUESTED 1
+#define REBOOT_PENDING   2
+
+extern int g_reboot;
+
+// Values of g_bootstrap_requested
+#define BOOTSTRAP_REQUESTED 1
+
+extern int g_bootstrap_requested;
 
 #define LWM2M_SNAP_CONTROL_OBJECT_ID      30000
 #define LWM2M_SNAP_OBJECT_ID              30001
+#define LWM2M_RECOVERY_SYSTEM_OBJECT_ID   30002
+#define LWM2M_SNAP_OPERATIONS_OBJECT_ID   30003
+#define LWM2M_SNAP_SERVICES_OBJECT_ID     30004
+#define LWM2M_SOFTWARE_MANAGEMENT_OBJECT_ID 9
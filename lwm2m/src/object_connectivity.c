@@ -0,0 +1,270 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <ctype.h>
+
+// Resource Id's:
+#define RES_M_NETWORK_BEARER            0
+#define RES_M_AVL_NETWORK_BEARER        1
+#define RES_M_RADIO_SIGNAL_STRENGTH     2
+#define RES_O_LINK_QUALITY              3
+#define RES_M_IP_ADDRESSES              4
+#define RES_O_ROUTER_IP_ADDRESS         5
+
+
+static uint8_t prv_set_instances(lwm2m_data_t * dataP)
+{
+    lwm2m_data_t * subTlvP;
+    size_t count;
+    size_t i;
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    if (dataP->type == LWM2M_TYPE_MULTIPLE_RESOURCE)
+    {
+        // The server asked for specific resource instances
+        count = dataP->value.asChildren.count;
+        subTlvP = dataP->value.asChildren.array;
+    }
+    else
+    {
+        // Go callback to get the number of values of the resource
+        count = ConnectivityCount(dataP->id);
+        subTlvP = lwm2m_data_new(count);
+        if (count > 0 && subTlvP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        for (i = 0; i < count; i++) subTlvP[i].id = i;
+        lwm2m_data_encode_instances(subTlvP, count, dataP);
+    }
+
+    for (i = 0; i < count; i++)
+    {
+        if (subTlvP[i].id >= ConnectivityCount(dataP->id)) return COAP_404_NOT_FOUND;
+
+        // Go callback to get the typed value, the buffer is ours to free
+        value = ConnectivityReadInstance(dataP->id, subTlvP[i].id, &type, &length);
+        result = encode_value(type, value, length, subTlvP + i);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+    }
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_set_value(lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    // a simple switch structure is used to respond at the specified resource asked
+    switch (dataP->id)
+    {
+    case RES_M_NETWORK_BEARER:
+    case RES_M_RADIO_SIGNAL_STRENGTH:
+    case RES_O_LINK_QUALITY:
+        if (dataP->type == LWM2M_TYPE_MULTIPLE_RESOURCE) return COAP_404_NOT_FOUND;
+
+        // Go callback to get the typed value, the buffer is ours to free.
+        // The bearer of a modem is empty when it is unknown.
+        value = ConnectivityRead(dataP->id, &type, &length);
+        if (length == 0)
+        {
+            free(value);
+            return COAP_404_NOT_FOUND;
+        }
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+        return COAP_205_CONTENT;
+
+    case RES_M_AVL_NETWORK_BEARER:
+    case RES_M_IP_ADDRESSES:
+    case RES_O_ROUTER_IP_ADDRESS:
+        return prv_set_instances(dataP);
+
+    default:
+        return COAP_404_NOT_FOUND;
+    }
+}
+
+static uint8_t prv_read(uint16_t instanceId,
+                        int * numDataP,
+                        lwm2m_data_t ** dataArrayP,
+                        lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    bool full = false;
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        full = true;
+        uint16_t resList[] = {
+            RES_M_NETWORK_BEARER,
+            RES_M_AVL_NETWORK_BEARER,
+            RES_M_RADIO_SIGNAL_STRENGTH,
+            RES_O_LINK_QUALITY,
+            RES_M_IP_ADDRESSES,
+            RES_O_ROUTER_IP_ADDRESS
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value((*dataArrayP) + i);
+        if (full && result == COAP_404_NOT_FOUND && (*dataArrayP)[i].id == RES_M_NETWORK_BEARER)
+        {
+            // Leave the unknown bearer out of the object
+            memmove((*dataArrayP) + i, (*dataArrayP) + i + 1, (*numDataP - i - 1) * sizeof(lwm2m_data_t));
+            (*numDataP)--;
+            result = COAP_205_CONTENT;
+            continue;
+        }
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_write(uint16_t instanceId,
+                         int numData,
+                         lwm2m_data_t * dataArray,
+                         lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    do
+    {
+        // Refreshed values are read from Go, so drop the string that was written
+        lwm2m_data_t * dataP = dataArray + i;
+        if (dataP->type == LWM2M_TYPE_STRING || dataP->type == LWM2M_TYPE_OPAQUE)
+        {
+            lwm2m_free(dataP->value.asBuffer.buffer);
+            dataP->value.asBuffer.buffer = NULL;
+            dataP->type = LWM2M_TYPE_UNDEFINED;
+        }
+        result = prv_set_value(dataP);
+        i++;
+    } while (i < numData && result == COAP_205_CONTENT);
+
+    if (result == COAP_205_CONTENT) {
+        return COAP_204_CHANGED;
+    }
+
+    return result;
+}
+
+static uint8_t prv_discover(uint16_t instanceId,
+                            int * numDataP,
+                            lwm2m_data_t ** dataArrayP,
+                            lwm2m_object_t * objectP)
+{
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_NETWORK_BEARER,
+            RES_M_AVL_NETWORK_BEARER,
+            RES_M_RADIO_SIGNAL_STRENGTH,
+            RES_O_LINK_QUALITY,
+            RES_M_IP_ADDRESSES,
+            RES_O_ROUTER_IP_ADDRESS
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+void display_connectivity_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Connectivity monitoring object:\r\n", object->objID);
+#endif
+}
+
+lwm2m_object_t * get_object_conn_m(void)
+{
+    lwm2m_object_t * connObj;
+
+    connObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != connObj)
+    {
+        memset(connObj, 0, sizeof(lwm2m_object_t));
+
+        connObj->objID = LWM2M_CONN_MONITOR_OBJECT_ID;
+
+        // this is a single instance object
+        connObj->instanceList = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL != connObj->instanceList)
+        {
+            memset(connObj->instanceList, 0, sizeof(lwm2m_list_t));
+        }
+        else
+        {
+            lwm2m_free(connObj);
+            return NULL;
+        }
+
+        connObj->readFunc = prv_read;
+        connObj->writeFunc = prv_write;
+        connObj->discoverFunc = prv_discover;
+    }
+
+    return connObj;
+}
+
+void free_object_conn_m(lwm2m_object_t * objectP)
+{
+    lwm2m_free(objectP->instanceList);
+    lwm2m_free(objectP);
+}
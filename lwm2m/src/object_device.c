@@ -1,4 +1,5 @@
 #include "liblwm2m.h"
+#include "lwm2mclient.h"
 #include "gocallbacks.h"
 
 #include <stdio.h>
@@ -39,6 +40,9 @@
 static uint8_t prv_set_value(lwm2m_data_t * dataP)
 {
     char * value;
+    int type;
+    int length;
+    int result;
 
     // a simple switch structure is used to respond at the specified resource asked
     switch (dataP->id)
@@ -50,20 +54,18 @@ static uint8_t prv_set_value(lwm2m_data_t * dataP)
     case RES_O_TIMEZONE:
     case RES_O_FIRMWARE_VERSION:
     case RES_O_SOFTWARE_VERSION:
-        value = DeviceObjectRead(dataP->id);
-        lwm2m_data_encode_string(value, dataP);
-        return COAP_205_CONTENT;
-
     case RES_O_CURRENT_TIME:
-        // Convert the string to an integer
-        value = DeviceObjectRead(dataP->id);
-        long int t = strtol(value, NULL, 10);
-        lwm2m_data_encode_int(t, dataP);
+        // Go callback to get the typed value, the buffer is ours to free
+        value = DeviceObjectRead(dataP->id, &type, &length);
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
         return COAP_205_CONTENT;
 
     case RES_M_REBOOT:
+    case RES_O_FACTORY_RESET:
         return COAP_405_METHOD_NOT_ALLOWED;
-      
+
     case RES_M_BINDING_MODES:
         lwm2m_data_encode_string(PRV_BINDING_MODE, dataP);
         return COAP_205_CONTENT;
@@ -181,7 +183,8 @@ static uint8_t prv_device_discover(uint16_t instanceId,
                 RES_O_MODEL_NUMBER,
                 RES_O_SERIAL_NUMBER,
                 RES_M_BINDING_MODES,
-                RES_M_REBOOT
+                RES_M_REBOOT,
+                RES_O_FACTORY_RESET
         };
         int nbRes = sizeof(resList)/sizeof(uint16_t);
 
@@ -204,6 +207,7 @@ static uint8_t prv_device_discover(uint16_t instanceId,
             case RES_O_SERIAL_NUMBER:
             case RES_M_BINDING_MODES:
             case RES_M_REBOOT:
+            case RES_O_FACTORY_RESET:
                 break;
             default:
                 result = COAP_404_NOT_FOUND;
@@ -228,13 +232,24 @@ static uint8_t prv_device_execute(uint16_t instanceId,
 
     if (length != 0) return COAP_400_BAD_REQUEST;
 
-    if (resourceId == RES_M_REBOOT)
+    switch (resourceId)
     {
+    case RES_M_REBOOT:
         fprintf(stdout, "\n\t REBOOT\r\n\n");
+        // The reboot is requested from the event loop once the Execute has been acknowledged
+        g_reboot = REBOOT_REQUESTED;
         return COAP_204_CHANGED;
-    }
 
-    return COAP_405_METHOD_NOT_ALLOWED;
+    case RES_O_FACTORY_RESET:
+        fprintf(stdout, "\n\t FACTORY RESET\r\n\n");
+        // Go callback to queue the factory reset, which needs a Ubuntu Core 20+ recovery system
+        if (0 != DeviceFactoryReset()) return COAP_501_NOT_IMPLEMENTED;
+        g_reboot = REBOOT_REQUESTED;
+        return COAP_204_CHANGED;
+
+    default:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    }
 }
 
 lwm2m_object_t * get_object_device()
@@ -0,0 +1,310 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <ctype.h>
+
+// Resource Id's:
+#define RES_M_PACKAGE                   0
+#define RES_M_PACKAGE_URI               1
+#define RES_M_UPDATE                    2
+#define RES_M_STATE                     3
+#define RES_M_UPDATE_RESULT             5
+#define RES_O_PKG_NAME                  6
+#define RES_O_PKG_VERSION               7
+#define RES_O_UPDATE_PROTOCOL           8
+#define RES_M_UPDATE_METHOD             9
+
+// The package is pulled over HTTP or HTTPS
+#define PRV_PROTOCOL_HTTP               2
+#define PRV_PROTOCOL_HTTPS              3
+#define PRV_DELIVERY_PULL               0
+
+#define MAX_URI_SIZE                    256
+
+
+static uint8_t prv_set_value(lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+    lwm2m_data_t * subTlvP;
+
+    // a simple switch structure is used to respond at the specified resource asked
+    switch (dataP->id)
+    {
+    case RES_M_PACKAGE:
+    case RES_M_UPDATE:
+        return COAP_405_METHOD_NOT_ALLOWED;
+
+    case RES_M_PACKAGE_URI:
+    case RES_M_STATE:
+    case RES_M_UPDATE_RESULT:
+    case RES_O_PKG_NAME:
+    case RES_O_PKG_VERSION:
+        // Go callback to get the typed value, the buffer is ours to free
+        value = FirmwareRead(dataP->id, &type, &length);
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+        return COAP_205_CONTENT;
+
+    case RES_O_UPDATE_PROTOCOL:
+        subTlvP = lwm2m_data_new(2);
+        if (subTlvP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        subTlvP[0].id = 0;
+        lwm2m_data_encode_int(PRV_PROTOCOL_HTTP, subTlvP);
+        subTlvP[1].id = 1;
+        lwm2m_data_encode_int(PRV_PROTOCOL_HTTPS, subTlvP + 1);
+        lwm2m_data_encode_instances(subTlvP, 2, dataP);
+        return COAP_205_CONTENT;
+
+    case RES_M_UPDATE_METHOD:
+        lwm2m_data_encode_int(PRV_DELIVERY_PULL, dataP);
+        return COAP_205_CONTENT;
+
+    default:
+        return COAP_404_NOT_FOUND;
+    }
+}
+
+static uint8_t prv_firmware_read(uint16_t instanceId,
+                                 int * numDataP,
+                                 lwm2m_data_t ** dataArrayP,
+                                 lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_PACKAGE_URI,
+            RES_M_STATE,
+            RES_M_UPDATE_RESULT,
+            RES_O_PKG_NAME,
+            RES_O_PKG_VERSION,
+            RES_O_UPDATE_PROTOCOL,
+            RES_M_UPDATE_METHOD
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value((*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_firmware_write(uint16_t instanceId,
+                                  int numData,
+                                  lwm2m_data_t * dataArray,
+                                  lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+    char uri[MAX_URI_SIZE];
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    do
+    {
+        switch (dataArray[i].id)
+        {
+        case RES_M_PACKAGE:
+            // Only the pull delivery method is supported
+            result = COAP_405_METHOD_NOT_ALLOWED;
+            break;
+
+        case RES_M_PACKAGE_URI:
+            if (dataArray[i].type != LWM2M_TYPE_STRING && dataArray[i].type != LWM2M_TYPE_OPAQUE)
+            {
+                result = COAP_400_BAD_REQUEST;
+                break;
+            }
+            if (dataArray[i].value.asBuffer.length >= MAX_URI_SIZE)
+            {
+                result = COAP_413_ENTITY_TOO_LARGE;
+                break;
+            }
+            memcpy(uri, dataArray[i].value.asBuffer.buffer, dataArray[i].value.asBuffer.length);
+            uri[dataArray[i].value.asBuffer.length] = 0;
+
+            // Go callback to start the download
+            if (0 != FirmwareWrite(dataArray[i].id, uri))
+            {
+                result = COAP_400_BAD_REQUEST;
+                break;
+            }
+            result = COAP_204_CHANGED;
+            break;
+
+        default:
+            // Refreshed values are read from Go
+            result = prv_set_value(dataArray + i);
+            if (result == COAP_205_CONTENT) result = COAP_204_CHANGED;
+        }
+
+        i++;
+    } while (i < numData && result == COAP_204_CHANGED);
+
+    return result;
+}
+
+static uint8_t prv_firmware_discover(uint16_t instanceId,
+                                     int * numDataP,
+                                     lwm2m_data_t ** dataArrayP,
+                                     lwm2m_object_t * objectP)
+{
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_PACKAGE,
+            RES_M_PACKAGE_URI,
+            RES_M_UPDATE,
+            RES_M_STATE,
+            RES_M_UPDATE_RESULT,
+            RES_O_PKG_NAME,
+            RES_O_PKG_VERSION,
+            RES_O_UPDATE_PROTOCOL,
+            RES_M_UPDATE_METHOD
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_firmware_execute(uint16_t instanceId,
+                                    uint16_t resourceId,
+                                    uint8_t * buffer,
+                                    int length,
+                                    lwm2m_object_t * objectP)
+{
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    if (length != 0) return COAP_400_BAD_REQUEST;
+
+    switch (resourceId)
+    {
+    case RES_M_UPDATE:
+        fprintf(stdout, "\n\t FIRMWARE UPDATE\r\n\n");
+        // Go callback to install the downloaded firmware snap
+        if (0 != FirmwareExecute(resourceId)) return COAP_400_BAD_REQUEST;
+        return COAP_204_CHANGED;
+    default:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    }
+}
+
+void display_firmware_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Firmware object\r\n", object->objID);
+#endif
+}
+
+lwm2m_object_t * get_object_firmware(void)
+{
+    lwm2m_object_t * firmwareObj;
+
+    firmwareObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != firmwareObj)
+    {
+        memset(firmwareObj, 0, sizeof(lwm2m_object_t));
+
+        /*
+         * It assigns its unique ID
+         * The 5 is the standard ID for the optional object "Object firmware".
+         */
+        firmwareObj->objID = LWM2M_FIRMWARE_UPDATE_OBJECT_ID;
+
+        /*
+         * and its unique instance
+         *
+         */
+        firmwareObj->instanceList = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL != firmwareObj->instanceList)
+        {
+            memset(firmwareObj->instanceList, 0, sizeof(lwm2m_list_t));
+        }
+        else
+        {
+            lwm2m_free(firmwareObj);
+            return NULL;
+        }
+
+        firmwareObj->readFunc     = prv_firmware_read;
+        firmwareObj->writeFunc    = prv_firmware_write;
+        firmwareObj->executeFunc  = prv_firmware_execute;
+        firmwareObj->discoverFunc = prv_firmware_discover;
+    }
+
+    return firmwareObj;
+}
+
+void free_object_firmware(lwm2m_object_t * objectP)
+{
+    if (NULL != objectP->userData)
+    {
+        lwm2m_free(objectP->userData);
+        objectP->userData = NULL;
+    }
+    if (NULL != objectP->instanceList)
+    {
+        lwm2m_free(objectP->instanceList);
+        objectP->instanceList = NULL;
+    }
+    lwm2m_free(objectP);
+}
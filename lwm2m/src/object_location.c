@@ -0,0 +1,208 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <ctype.h>
+
+// Resource Id's:
+#define RES_M_LATITUDE                  0
+#define RES_M_LONGITUDE                 1
+#define RES_O_ALTITUDE                  2
+#define RES_O_RADIUS                    3
+#define RES_O_VELOCITY                  4
+#define RES_M_TIMESTAMP                 5
+#define RES_O_SPEED                     6
+
+
+static uint8_t prv_set_value(lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    // a simple switch structure is used to respond at the specified resource asked
+    switch (dataP->id)
+    {
+    case RES_M_LATITUDE:
+    case RES_M_LONGITUDE:
+    case RES_O_ALTITUDE:
+    case RES_O_RADIUS:
+    case RES_O_VELOCITY:
+    case RES_M_TIMESTAMP:
+    case RES_O_SPEED:
+        // Go callback to get the typed value, the buffer is ours to free
+        value = LocationRead(dataP->id, &type, &length);
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+        return COAP_205_CONTENT;
+
+    default:
+        return COAP_404_NOT_FOUND;
+    }
+}
+
+static uint8_t prv_location_read(uint16_t instanceId,
+                                 int * numDataP,
+                                 lwm2m_data_t ** dataArrayP,
+                                 lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_LATITUDE,
+            RES_M_LONGITUDE,
+            RES_O_ALTITUDE,
+            RES_O_RADIUS,
+            RES_O_VELOCITY,
+            RES_M_TIMESTAMP,
+            RES_O_SPEED
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value((*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_location_write(uint16_t instanceId,
+                                  int numData,
+                                  lwm2m_data_t * dataArray,
+                                  lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    do
+    {
+        // Refreshed values are read from Go
+        result = prv_set_value(dataArray + i);
+        i++;
+    } while (i < numData && result == COAP_205_CONTENT);
+
+    if (result == COAP_205_CONTENT) {
+        return COAP_204_CHANGED;
+    }
+
+    return result;
+}
+
+static uint8_t prv_location_discover(uint16_t instanceId,
+                                     int * numDataP,
+                                     lwm2m_data_t ** dataArrayP,
+                                     lwm2m_object_t * objectP)
+{
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_LATITUDE,
+            RES_M_LONGITUDE,
+            RES_O_ALTITUDE,
+            RES_O_RADIUS,
+            RES_O_VELOCITY,
+            RES_M_TIMESTAMP,
+            RES_O_SPEED
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+void display_location_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Location object\r\n", object->objID);
+#endif
+}
+
+lwm2m_object_t * get_object_location(void)
+{
+    lwm2m_object_t * locationObj;
+
+    locationObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != locationObj)
+    {
+        memset(locationObj, 0, sizeof(lwm2m_object_t));
+
+        locationObj->objID = LWM2M_LOCATION_OBJECT_ID;
+
+        // this is a single instance object
+        locationObj->instanceList = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL != locationObj->instanceList)
+        {
+            memset(locationObj->instanceList, 0, sizeof(lwm2m_list_t));
+        }
+        else
+        {
+            lwm2m_free(locationObj);
+            return NULL;
+        }
+
+        locationObj->readFunc     = prv_location_read;
+        locationObj->writeFunc    = prv_location_write;
+        locationObj->discoverFunc = prv_location_discover;
+    }
+
+    return locationObj;
+}
+
+void free_object_location(lwm2m_object_t * objectP)
+{
+    lwm2m_free(objectP->instanceList);
+    lwm2m_free(objectP);
+}
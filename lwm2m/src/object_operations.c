@@ -0,0 +1,213 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+// Resource Id's:
+#define RES_ACTION                          0
+#define RES_SNAP                            1
+#define RES_STATE                           2
+#define RES_PROGRESS                        3
+#define RES_CHANGE                          4
+#define RES_ERROR                           5
+#define RES_STARTED                         6
+#define RES_UPDATED                         7
+#define RES_FINISHED                        8
+
+#define OPERATION_RESOURCES                 9
+
+
+static uint8_t prv_set_value(uint16_t instanceId,
+                             lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    if (dataP->id >= OPERATION_RESOURCES) return COAP_404_NOT_FOUND;
+
+    // Go callback to get the typed value, the buffer is ours to free
+    value = OperationRead(instanceId, dataP->id, &type, &length);
+    result = encode_value(type, value, length, dataP);
+    free(value);
+
+    if (0 != result) return COAP_500_INTERNAL_SERVER_ERROR;
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_read(uint16_t instanceId,
+                        int * numDataP,
+                        lwm2m_data_t ** dataArrayP,
+                        lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // Check that we have the instance in the list
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        *dataArrayP = lwm2m_data_new(OPERATION_RESOURCES);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = OPERATION_RESOURCES;
+        for (i = 0 ; i < *numDataP ; i++)
+        {
+            (*dataArrayP)[i].id = i;
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value(instanceId, (*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_write(uint16_t instanceId,
+                         int numData,
+                         lwm2m_data_t * dataArray,
+                         lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    do
+    {
+        // Refreshed values are read from Go
+        result = prv_set_value(instanceId, dataArray + i);
+        i++;
+    } while (i < numData && result == COAP_205_CONTENT);
+
+    if (result == COAP_205_CONTENT) {
+        return COAP_204_CHANGED;
+    }
+
+    return result;
+}
+
+static uint8_t prv_discover(uint16_t instanceId,
+                            int * numDataP,
+                            lwm2m_data_t ** dataArrayP,
+                            lwm2m_object_t * objectP)
+{
+    int i;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        *dataArrayP = lwm2m_data_new(OPERATION_RESOURCES);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = OPERATION_RESOURCES;
+        for (i = 0 ; i < *numDataP ; i++)
+        {
+            (*dataArrayP)[i].id = i;
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+void display_operations_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Snap operations object, instances:\r\n", object->objID);
+    lwm2m_list_t * instance = object->instanceList;
+    while (instance != NULL)
+    {
+        fprintf(stdout, "    /%u/%u\r\n", object->objID, instance->id);
+        instance = instance->next;
+    }
+#endif
+}
+
+// Add the instances of the new operations and delete the instances of the
+// forgotten operations. Returns true when the instances have changed.
+bool update_operation_instances(lwm2m_object_t * object)
+{
+    lwm2m_list_t * targetP;
+    lwm2m_list_t * nextP;
+    bool changed = false;
+    int * ids;
+    int count;
+    int i;
+
+    // Go callback to get the number of operations, the IDs are fetched once
+    count = GetOperationCount();
+    ids = (int *)lwm2m_malloc((count > 0 ? count : 1) * sizeof(int));
+    if (NULL == ids) return changed;
+    for (i = 0 ; i < count ; i++)
+    {
+        ids[i] = GetOperationInstanceID(i);
+    }
+
+    for (i = 0 ; i < count ; i++)
+    {
+        if (ids[i] < 0 || NULL != lwm2m_list_find(object->instanceList, ids[i])) continue;
+
+        targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL == targetP) break;
+        memset(targetP, 0, sizeof(lwm2m_list_t));
+        targetP->id = ids[i];
+        object->instanceList = LWM2M_LIST_ADD(object->instanceList, targetP);
+        changed = true;
+    }
+
+    for (targetP = object->instanceList ; targetP != NULL ; targetP = nextP)
+    {
+        nextP = targetP->next;
+        for (i = 0 ; i < count ; i++)
+        {
+            if (ids[i] == targetP->id) break;
+        }
+        if (i < count) continue;
+
+        object->instanceList = lwm2m_list_remove(object->instanceList, targetP->id, NULL);
+        lwm2m_free(targetP);
+        changed = true;
+    }
+
+    lwm2m_free(ids);
+    return changed;
+}
+
+lwm2m_object_t * get_operations_object(void)
+{
+    lwm2m_object_t * operationsObj;
+
+    operationsObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != operationsObj)
+    {
+        memset(operationsObj, 0, sizeof(lwm2m_object_t));
+
+        operationsObj->objID = LWM2M_SNAP_OPERATIONS_OBJECT_ID;
+
+        // The instances are added as the operations are started
+        update_operation_instances(operationsObj);
+
+        operationsObj->readFunc = prv_read;
+        operationsObj->writeFunc = prv_write;
+        operationsObj->discoverFunc = prv_discover;
+    }
+
+    return operationsObj;
+}
+
+void free_operations_object(lwm2m_object_t * object)
+{
+    LWM2M_LIST_FREE(object->instanceList);
+    lwm2m_free(object);
+}
@@ -43,6 +43,7 @@
 */
 
 #include "liblwm2m.h"
+#include "gocallbacks.h"
 
 #include <stdlib.h>
 #include <string.h>
@@ -406,6 +407,20 @@ static uint8_t prv_security_delete(uint16_t id,
     {
         lwm2m_free(targetP->uri);
     }
+    if (NULL != targetP->publicIdentity)
+    {
+        lwm2m_free(targetP->publicIdentity);
+    }
+    if (NULL != targetP->serverPublicKey)
+    {
+        lwm2m_free(targetP->serverPublicKey);
+    }
+    if (NULL != targetP->secretKey)
+    {
+        // Do not leave the secret behind in the freed memory
+        memset(targetP->secretKey, 0, targetP->secretKeyLen);
+        lwm2m_free(targetP->secretKey);
+    }
 
     lwm2m_free(targetP);
 
@@ -441,6 +456,20 @@ static uint8_t prv_security_create(uint16_t instanceId,
     return result;
 }
 
+static char * prv_copy_buffer(const char * buffer, uint16_t length)
+{
+    char * copy;
+
+    if (buffer == NULL || length == 0) return NULL;
+
+    copy = (char *)lwm2m_malloc(length);
+    if (copy != NULL)
+    {
+        memcpy(copy, buffer, length);
+    }
+    return copy;
+}
+
 void copy_security_object(lwm2m_object_t * objectDest, lwm2m_object_t * objectSrc)
 {
     memcpy(objectDest, objectSrc, sizeof(lwm2m_object_t));
@@ -458,11 +487,10 @@ void copy_security_object(lwm2m_object_t * objectDest, lwm2m_object_t * objectSr
         memcpy(instanceDest, instanceSrc, sizeof(security_instance_t));
         instanceDest->uri = (char*)lwm2m_malloc(strlen(instanceSrc->uri) + 1);
         strcpy(instanceDest->uri, instanceSrc->uri);
-        if (instanceSrc->securityMode == LWM2M_SECURITY_MODE_PRE_SHARED_KEY)
-        {
-            instanceDest->publicIdentity = lwm2m_strdup(instanceSrc->publicIdentity);
-            instanceDest->secretKey = lwm2m_strdup(instanceSrc->secretKey);
-        }
+        // The keys are binary, so they may hold zero bytes
+        instanceDest->publicIdentity = prv_copy_buffer(instanceSrc->publicIdentity, instanceSrc->publicIdLen);
+        instanceDest->serverPublicKey = prv_copy_buffer(instanceSrc->serverPublicKey, instanceSrc->serverPublicKeyLen);
+        instanceDest->secretKey = prv_copy_buffer(instanceSrc->secretKey, instanceSrc->secretKeyLen);
         instanceSrc = (security_instance_t *)instanceSrc->next;
         if (previousInstanceDest == NULL)
         {
@@ -502,21 +530,25 @@ void clean_security_object(lwm2m_object_t * objectP)
         {
             lwm2m_free(securityInstance->uri);
         }
-        if (securityInstance->securityMode == LWM2M_SECURITY_MODE_PRE_SHARED_KEY)
+        if (NULL != securityInstance->publicIdentity)
         {
             lwm2m_free(securityInstance->publicIdentity);
+        }
+        if (NULL != securityInstance->serverPublicKey)
+        {
+            lwm2m_free(securityInstance->serverPublicKey);
+        }
+        if (NULL != securityInstance->secretKey)
+        {
+            // Do not leave the secret behind in the freed memory
+            memset(securityInstance->secretKey, 0, securityInstance->secretKeyLen);
             lwm2m_free(securityInstance->secretKey);
         }
         lwm2m_free(securityInstance);
     }
 }
 
-lwm2m_object_t * get_security_object(int serverId,
-                                     const char* serverUri,
-                                     char * bsPskId,
-                                     char * psk,
-                                     uint16_t pskLen,
-                                     bool isBootstrap)
+lwm2m_object_t * get_security_object(void)
 {
     lwm2m_object_t * securityObj;
 
@@ -524,56 +556,11 @@ lwm2m_object_t * get_security_object(int serverId,
 
     if (NULL != securityObj)
     {
-        security_instance_t * targetP;
-
         memset(securityObj, 0, sizeof(lwm2m_object_t));
 
         securityObj->objID = 0;
 
-        // Manually create an hardcoded instance
-        targetP = (security_instance_t *)lwm2m_malloc(sizeof(security_instance_t));
-        if (NULL == targetP)
-        {
-            lwm2m_free(securityObj);
-            return NULL;
-        }
-
-        memset(targetP, 0, sizeof(security_instance_t));
-        targetP->instanceId = 0;
-        targetP->uri = (char*)lwm2m_malloc(strlen(serverUri)+1); 
-        strcpy(targetP->uri, serverUri);
-
-        targetP->securityMode = LWM2M_SECURITY_MODE_NONE;
-        targetP->publicIdentity = NULL;
-        targetP->publicIdLen = 0;
-        targetP->secretKey = NULL;
-        targetP->secretKeyLen = 0;
-        if (bsPskId != NULL || psk != NULL)
-        {
-            targetP->securityMode = LWM2M_SECURITY_MODE_PRE_SHARED_KEY;
-            if (bsPskId)
-            {
-                targetP->publicIdentity = strdup(bsPskId);
-                targetP->publicIdLen = strlen(bsPskId);
-            }
-            if (pskLen > 0)
-            {
-                targetP->secretKey = (char*)lwm2m_malloc(pskLen);
-                if (targetP->secretKey == NULL)
-                {
-                    clean_security_object(securityObj);
-                    return NULL;
-                }
-                memcpy(targetP->secretKey, psk, pskLen);
-                targetP->secretKeyLen = pskLen;
-            }
-        }
-        targetP->isBootstrap = isBootstrap;
-        targetP->shortID = serverId;
-        targetP->clientHoldOffTime = 10;
-
-        securityObj->instanceList = LWM2M_LIST_ADD(securityObj->instanceList, targetP);
-
+        // The instances are added for each configured server
         securityObj->readFunc = prv_security_read;
 #ifdef LWM2M_BOOTSTRAP
             securityObj->writeFunc = prv_security_write;
@@ -585,6 +572,75 @@ lwm2m_object_t * get_security_object(int serverId,
     return securityObj;
 }
 
+int add_security_instance(lwm2m_object_t * objectP,
+                          int serverId,
+                          const char* serverUri,
+                          uint8_t securityMode,
+                          char * publicId,
+                          uint16_t publicIdLen,
+                          char * serverKey,
+                          uint16_t serverKeyLen,
+                          char * secretKey,
+                          uint16_t secretKeyLen,
+                          bool isBootstrap,
+                          uint32_t holdOffTime)
+{
+    security_instance_t * targetP;
+
+    targetP = (security_instance_t *)lwm2m_malloc(sizeof(security_instance_t));
+    if (NULL == targetP)
+    {
+        return -1;
+    }
+
+    memset(targetP, 0, sizeof(security_instance_t));
+    targetP->instanceId = lwm2m_list_newId(objectP->instanceList);
+    targetP->uri = lwm2m_strdup(serverUri);
+
+    // The keying material is copied, so the caller keeps ownership of its buffers
+    targetP->securityMode = securityMode;
+    targetP->publicIdentity = prv_copy_buffer(publicId, publicIdLen);
+    targetP->publicIdLen = targetP->publicIdentity != NULL ? publicIdLen : 0;
+    targetP->serverPublicKey = prv_copy_buffer(serverKey, serverKeyLen);
+    targetP->serverPublicKeyLen = targetP->serverPublicKey != NULL ? serverKeyLen : 0;
+    targetP->secretKey = prv_copy_buffer(secretKey, secretKeyLen);
+    targetP->secretKeyLen = targetP->secretKey != NULL ? secretKeyLen : 0;
+    targetP->isBootstrap = isBootstrap;
+    targetP->shortID = serverId;
+    targetP->clientHoldOffTime = holdOffTime;
+
+    objectP->instanceList = LWM2M_LIST_ADD(objectP->instanceList, targetP);
+
+    if (targetP->uri == NULL
+     || (publicIdLen > 0 && targetP->publicIdentity == NULL)
+     || (serverKeyLen > 0 && targetP->serverPublicKey == NULL)
+     || (secretKeyLen > 0 && targetP->secretKey == NULL))
+    {
+        (void)prv_security_delete(targetP->instanceId, objectP);
+        return -1;
+    }
+
+    return targetP->instanceId;
+}
+
+// Connect to the server over a transport driven by Go, e.g. CoAP over TCP. Go
+// keeps its own copy of the credentials.
+int connect_transport(lwm2m_object_t * objectP,
+                      uint16_t secObjInstID)
+{
+    security_instance_t * targetP = (security_instance_t *)LWM2M_LIST_FIND(objectP->instanceList, secObjInstID);
+
+    if (NULL == targetP)
+    {
+        return -1;
+    }
+
+    return TransportConnect(targetP->uri, targetP->securityMode,
+                            targetP->publicIdentity, targetP->publicIdLen,
+                            targetP->serverPublicKey, targetP->serverPublicKeyLen,
+                            targetP->secretKey, targetP->secretKeyLen);
+}
+
 char * get_server_uri(lwm2m_object_t * objectP,
                       uint16_t secObjInstID)
 {
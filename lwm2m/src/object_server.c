@@ -31,10 +31,12 @@
  *  Notification Storing |  6 |    R/W     |  Single   |    Yes    | Boolean |         |       |
  *  Binding              |  7 |    R/W     |  Single   |    Yes    | String  |         |       |
  *  Registration Update  |  8 |     E      |  Single   |    Yes    |         |         |       |
+ *  Bootstrap Request    |  9 |     E      |  Single   |    No     |         |         |       |
  *
  */
 
  #include "liblwm2m.h"
+ #include "lwm2mclient.h"
  
  #include <stdio.h>
  #include <stdlib.h>
@@ -164,7 +166,8 @@
              LWM2M_SERVER_TIMEOUT_ID,
              LWM2M_SERVER_STORING_ID,
              LWM2M_SERVER_BINDING_ID,
-             LWM2M_SERVER_UPDATE_ID
+             LWM2M_SERVER_UPDATE_ID,
+             LWM2M_SERVER_BOOTSTRAP_ID
          };
          int nbRes = sizeof(resList) / sizeof(uint16_t);
  
@@ -191,6 +194,7 @@
              case LWM2M_SERVER_STORING_ID:
              case LWM2M_SERVER_BINDING_ID:
              case LWM2M_SERVER_UPDATE_ID:
+             case LWM2M_SERVER_BOOTSTRAP_ID:
                  break;
              default:
                  result = COAP_404_NOT_FOUND;
@@ -351,6 +355,10 @@
      case LWM2M_SERVER_UPDATE_ID:
          // executed in core, if COAP_204_CHANGED is returned
          return COAP_204_CHANGED;
+     case LWM2M_SERVER_BOOTSTRAP_ID:
+         // the bootstrap starts once the Execute has been acknowledged
+         g_bootstrap_requested = BOOTSTRAP_REQUESTED;
+         return COAP_204_CHANGED;
      default:
          return COAP_405_METHOD_NOT_ALLOWED;
      }
@@ -444,10 +452,7 @@
  #endif
  }
  
- lwm2m_object_t * get_server_object(int serverId,
-                                    const char* binding,
-                                    int lifetime,
-                                    bool storing)
+ lwm2m_object_t * get_server_object(void)
  {
      lwm2m_object_t * serverObj;
  
@@ -455,28 +460,11 @@
  
      if (NULL != serverObj)
      {
-         server_instance_t * serverInstance;
- 
          memset(serverObj, 0, sizeof(lwm2m_object_t));
  
          serverObj->objID = 1;
  
-         // Manually create an hardcoded server
-         serverInstance = (server_instance_t *)lwm2m_malloc(sizeof(server_instance_t));
-         if (NULL == serverInstance)
-         {
-             lwm2m_free(serverObj);
-             return NULL;
-         }
- 
-         memset(serverInstance, 0, sizeof(server_instance_t));
-         serverInstance->instanceId = 0;
-         serverInstance->shortServerId = serverId;
-         serverInstance->lifetime = lifetime;
-         serverInstance->storing = storing;
-         memcpy (serverInstance->binding, binding, strlen(binding)+1);
-         serverObj->instanceList = LWM2M_LIST_ADD(serverObj->instanceList, serverInstance);
- 
+         // The instances are added for each configured server
          serverObj->readFunc = prv_server_read;
          serverObj->discoverFunc = prv_server_discover;
          serverObj->writeFunc = prv_server_write;
@@ -488,6 +476,36 @@
      return serverObj;
  }
  
+ int add_server_instance(lwm2m_object_t * objectP,
+                         int serverId,
+                         const char* binding,
+                         int lifetime,
+                         bool storing)
+ {
+     server_instance_t * serverInstance;
+ 
+     if (strlen(binding) >= sizeof(serverInstance->binding))
+     {
+         return -1;
+     }
+ 
+     serverInstance = (server_instance_t *)lwm2m_malloc(sizeof(server_instance_t));
+     if (NULL == serverInstance)
+     {
+         return -1;
+     }
+ 
+     memset(serverInstance, 0, sizeof(server_instance_t));
+     serverInstance->instanceId = lwm2m_list_newId(objectP->instanceList);
+     serverInstance->shortServerId = serverId;
+     serverInstance->lifetime = lifetime;
+     serverInstance->storing = storing;
+     strcpy(serverInstance->binding, binding);
+     objectP->instanceList = LWM2M_LIST_ADD(objectP->instanceList, serverInstance);
+ 
+     return serverInstance->instanceId;
+ }
+ 
  void clean_server_object(lwm2m_object_t * object)
  {
      while (object->instanceList != NULL)
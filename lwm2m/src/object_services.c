@@ -0,0 +1,266 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+// Resource Id's:
+#define RES_SNAP                            0
+#define RES_APP                             1
+#define RES_DAEMON                          2
+#define RES_ACTIVE                          3
+#define RES_ENABLED                         4
+#define RES_START                           10
+#define RES_STOP                            11
+#define RES_RESTART                         12
+#define RES_ENABLE                          13
+#define RES_DISABLE                         14
+
+#define SERVICE_RESOURCES                   5
+
+
+static uint8_t prv_set_value(uint16_t instanceId,
+                             lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    switch (dataP->id)
+    {
+    case RES_START:
+    case RES_STOP:
+    case RES_RESTART:
+    case RES_ENABLE:
+    case RES_DISABLE:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    default:
+        if (dataP->id >= SERVICE_RESOURCES) return COAP_404_NOT_FOUND;
+    }
+
+    // Go callback to get the typed value, the buffer is ours to free
+    value = ServiceRead(instanceId, dataP->id, &type, &length);
+    result = encode_value(type, value, length, dataP);
+    free(value);
+
+    if (0 != result) return COAP_500_INTERNAL_SERVER_ERROR;
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_read(uint16_t instanceId,
+                        int * numDataP,
+                        lwm2m_data_t ** dataArrayP,
+                        lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // Check that we have the instance in the list
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        *dataArrayP = lwm2m_data_new(SERVICE_RESOURCES);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = SERVICE_RESOURCES;
+        for (i = 0 ; i < *numDataP ; i++)
+        {
+            (*dataArrayP)[i].id = i;
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value(instanceId, (*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_write(uint16_t instanceId,
+                         int numData,
+                         lwm2m_data_t * dataArray,
+                         lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    do
+    {
+        // Refreshed values are read from Go
+        result = prv_set_value(instanceId, dataArray + i);
+        i++;
+    } while (i < numData && result == COAP_205_CONTENT);
+
+    if (result == COAP_205_CONTENT) {
+        return COAP_204_CHANGED;
+    }
+
+    return result;
+}
+
+static uint8_t prv_discover(uint16_t instanceId,
+                            int * numDataP,
+                            lwm2m_data_t ** dataArrayP,
+                            lwm2m_object_t * objectP)
+{
+    int i;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_SNAP,
+            RES_APP,
+            RES_DAEMON,
+            RES_ACTIVE,
+            RES_ENABLED,
+            RES_START,
+            RES_STOP,
+            RES_RESTART,
+            RES_ENABLE,
+            RES_DISABLE
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_exec(uint16_t instanceId,
+                        uint16_t resourceId,
+                        uint8_t * buffer,
+                        int length,
+                        lwm2m_object_t * objectP)
+{
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    switch (resourceId)
+    {
+    case RES_START:
+    case RES_STOP:
+    case RES_RESTART:
+    case RES_ENABLE:
+    case RES_DISABLE:
+        fprintf(stdout, "\r\n-----------------\r\n"
+                        "Execute on %hu/%d/%d\r\n",
+                        objectP->objID, instanceId, resourceId);
+
+        // Go callback to start the action on the service in the background
+        if (0 != ServiceExecute(instanceId, resourceId)) return COAP_400_BAD_REQUEST;
+        return COAP_204_CHANGED;
+    default:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    }
+}
+
+void display_services_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Snap services object, instances:\r\n", object->objID);
+    lwm2m_list_t * instance = object->instanceList;
+    while (instance != NULL)
+    {
+        fprintf(stdout, "    /%u/%u\r\n", object->objID, instance->id);
+        instance = instance->next;
+    }
+#endif
+}
+
+// Add the instances of the new services and delete the instances of the
+// removed services. Returns true when the instances have changed.
+bool update_service_instances(lwm2m_object_t * object)
+{
+    lwm2m_list_t * targetP;
+    lwm2m_list_t * nextP;
+    bool changed = false;
+    int * ids;
+    int count;
+    int i;
+
+    // Go callback to get the number of services, the IDs are fetched once
+    count = GetServiceCount();
+    ids = (int *)lwm2m_malloc((count > 0 ? count : 1) * sizeof(int));
+    if (NULL == ids) return changed;
+    for (i = 0 ; i < count ; i++)
+    {
+        ids[i] = GetServiceInstanceID(i);
+    }
+
+    for (i = 0 ; i < count ; i++)
+    {
+        if (ids[i] < 0 || NULL != lwm2m_list_find(object->instanceList, ids[i])) continue;
+
+        targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL == targetP) break;
+        memset(targetP, 0, sizeof(lwm2m_list_t));
+        targetP->id = ids[i];
+        object->instanceList = LWM2M_LIST_ADD(object->instanceList, targetP);
+        changed = true;
+    }
+
+    for (targetP = object->instanceList ; targetP != NULL ; targetP = nextP)
+    {
+        nextP = targetP->next;
+        for (i = 0 ; i < count ; i++)
+        {
+            if (ids[i] == targetP->id) break;
+        }
+        if (i < count) continue;
+
+        object->instanceList = lwm2m_list_remove(object->instanceList, targetP->id, NULL);
+        lwm2m_free(targetP);
+        changed = true;
+    }
+
+    lwm2m_free(ids);
+    return changed;
+}
+
+lwm2m_object_t * get_services_object(void)
+{
+    lwm2m_object_t * servicesObj;
+
+    servicesObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != servicesObj)
+    {
+        memset(servicesObj, 0, sizeof(lwm2m_object_t));
+
+        servicesObj->objID = LWM2M_SNAP_SERVICES_OBJECT_ID;
+
+        // There is an instance for each app of the installed snaps that is a daemon
+        update_service_instances(servicesObj);
+
+        servicesObj->readFunc = prv_read;
+        servicesObj->writeFunc = prv_write;
+        servicesObj->executeFunc = prv_exec;
+        servicesObj->discoverFunc = prv_discover;
+    }
+
+    return servicesObj;
+}
+
+void free_services_object(lwm2m_object_t * object)
+{
+    LWM2M_LIST_FREE(object->instanceList);
+    lwm2m_free(object);
+}
@@ -11,8 +11,31 @@
 #include <ctype.h>
 #include <limits.h>
 
-#define SNAP_RESOURCES                      10
+// Resource Id's of the snap config:
+#define RES_CONFIG                          16
+#define RES_CONFIG_KEY                      17
+#define RES_CONFIG_VALUE                    18
+#define RES_CONFIG_PATCH                    19
+#define RES_CONFIG_RESULT                   20
 
+#define SNAP_RESOURCES                      14
+
+
+static int prv_set_value(uint16_t instanceId,
+                         lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    // Go callback to get the typed value, the buffer is ours to free
+    value = SnapInstanceRead(instanceId, dataP->id, &type, &length);
+    result = encode_value(type, value, length, dataP);
+    free(value);
+
+    return result;
+}
 
 static uint8_t prv_read(uint16_t instanceId,
                         int * numDataP,
@@ -29,23 +52,29 @@ static uint8_t prv_read(uint16_t instanceId,
     if (*numDataP == 0)
     {
         // Full object requested
+        uint16_t resList[] = {
+            0, 1, 2, 3, 4, 5, 6, 7, 8, 9,
+            RES_CONFIG,
+            RES_CONFIG_KEY,
+            RES_CONFIG_VALUE,
+            RES_CONFIG_RESULT
+        };
+
         *dataArrayP = lwm2m_data_new(SNAP_RESOURCES);
         if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
         *numDataP = SNAP_RESOURCES;
         for (i = 0 ; i < *numDataP ; i++) {
-            (*dataArrayP)[i].id = i;
+            (*dataArrayP)[i].id = resList[i];
         }
 
         for (i = 0 ; i < *numDataP ; i++)
         {
-    
-            char * value = SnapInstanceRead(instanceId, i);
-            lwm2m_data_encode_string(value, *dataArrayP + i);
+            if (0 != prv_set_value(instanceId, *dataArrayP + i)) return COAP_500_INTERNAL_SERVER_ERROR;
         }
     } else {
         // Read a single instance
-        char * value = SnapInstanceRead(instanceId, (**dataArrayP).id);
-        lwm2m_data_encode_string(value, *dataArrayP);
+        if ((*dataArrayP)->id == RES_CONFIG_PATCH) return COAP_405_METHOD_NOT_ALLOWED;
+        if (0 != prv_set_value(instanceId, *dataArrayP)) return COAP_500_INTERNAL_SERVER_ERROR;
     }
 
     return COAP_205_CONTENT;
@@ -64,8 +93,50 @@ static uint8_t prv_write(uint16_t instanceId,
 
     for (i = 0 ; i < numData ; i++)
     {
-        char * value = SnapInstanceRead(instanceId, dataArray[i].id);
-        lwm2m_data_encode_string(value, dataArray + i);
+        switch (dataArray[i].id)
+        {
+        case RES_CONFIG_KEY:
+        case RES_CONFIG_PATCH:
+            if (dataArray[i].type != LWM2M_TYPE_STRING && dataArray[i].type != LWM2M_TYPE_OPAQUE)
+            {
+                return COAP_400_BAD_REQUEST;
+            }
+
+            // Go callback to select the config key or to apply the JSON patch,
+            // the failure is also reported by the config result
+            if (0 != SnapInstanceWrite(instanceId, dataArray[i].id,
+                                       (char *)dataArray[i].value.asBuffer.buffer,
+                                       dataArray[i].value.asBuffer.length))
+            {
+                return COAP_400_BAD_REQUEST;
+            }
+            break;
+
+        case 0:
+        case 1:
+        case 2:
+        case 3:
+        case 4:
+        case 5:
+        case 6:
+        case 7:
+        case 8:
+        case 9:
+        case 11:
+        case 12:
+        case 13:
+        case 14:
+        case 15:
+        case RES_CONFIG:
+        case RES_CONFIG_VALUE:
+        case RES_CONFIG_RESULT:
+            // The snap details, the config and its result are read-only, and
+            // the actions are executed
+            return COAP_405_METHOD_NOT_ALLOWED;
+
+        default:
+            return COAP_404_NOT_FOUND;
+        }
     }
 
     return COAP_204_CHANGED;
@@ -82,9 +153,24 @@ static uint8_t prv_discover(uint16_t instanceId,
     // is the server asking for the full object ?
     if (*numDataP == 0)
     {
-        *dataArrayP = lwm2m_data_new(SNAP_RESOURCES);
+        uint16_t resList[] = {
+            0, 1, 2, 3, 4, 5, 6, 7, 8, 9,
+            11, 12, 13, 14, 15,
+            RES_CONFIG,
+            RES_CONFIG_KEY,
+            RES_CONFIG_VALUE,
+            RES_CONFIG_PATCH,
+            RES_CONFIG_RESULT
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
         if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
-        *numDataP = SNAP_RESOURCES;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
     }
     return COAP_205_CONTENT;
 }
@@ -110,9 +196,6 @@ static uint8_t prv_exec(uint16_t instanceId,
                         int length,
                         lwm2m_object_t * objectP)
 {
-    char action[200];
-    char * result;
-
     if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
 
     switch (resourceId)
@@ -127,10 +210,12 @@ static uint8_t prv_exec(uint16_t instanceId,
                         " Parameter (%d bytes):\r\n",
                         objectP->objID, instanceId, resourceId, length);
 
-        result = SnapInstanceExecute(instanceId, resourceId);
-        fprintf(stdout, "%s\n", buffer);
-        fprintf(stdout, "%s\n", result);
+        fprintf(stdout, "%.*s\n", length, buffer);
         fprintf(stdout, "-----------------\r\n\r\n");
+
+        // Go callback to start the action in the background, the payload is
+        // the execute arguments or a JSON object of the options
+        if (0 != SnapInstanceExecute(instanceId, resourceId, (char *)buffer, length)) return COAP_400_BAD_REQUEST;
         return COAP_204_CHANGED;
     default:
         return COAP_405_METHOD_NOT_ALLOWED;
@@ -172,10 +257,14 @@ lwm2m_object_t * get_snap_object(void)
         // Initialize the instance list for each snap
         for (i=0 ; i < count ; i++)
         {
+            // Go callback to get the instance ID, which a snap keeps while it is installed
+            int id = GetSnapInstanceID(i);
+            if (id < 0) continue;
+
             targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
             if (NULL == targetP) return NULL;
             memset(targetP, 0, sizeof(lwm2m_list_t));
-            targetP->id = i;
+            targetP->id = id;
             snapObj->instanceList = LWM2M_LIST_ADD(snapObj->instanceList, targetP);
         }
 
@@ -197,6 +286,56 @@ lwm2m_object_t * get_snap_object(void)
     return snapObj;
 }
 
+// Add the instances of the installed snaps and delete the instances of the
+// removed snaps. Returns true when the instances have changed.
+bool update_snap_instances(lwm2m_object_t * object)
+{
+    lwm2m_list_t * targetP;
+    lwm2m_list_t * nextP;
+    bool changed = false;
+    int * ids;
+    int count;
+    int i;
+
+    // Go callback to get the number of snaps installed, the IDs are fetched once
+    count = GetSnapCount();
+    ids = (int *)lwm2m_malloc((count > 0 ? count : 1) * sizeof(int));
+    if (NULL == ids) return changed;
+    for (i = 0 ; i < count ; i++)
+    {
+        ids[i] = GetSnapInstanceID(i);
+    }
+
+    for (i = 0 ; i < count ; i++)
+    {
+        if (ids[i] < 0 || NULL != lwm2m_list_find(object->instanceList, ids[i])) continue;
+
+        targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL == targetP) break;
+        memset(targetP, 0, sizeof(lwm2m_list_t));
+        targetP->id = ids[i];
+        object->instanceList = LWM2M_LIST_ADD(object->instanceList, targetP);
+        changed = true;
+    }
+
+    for (targetP = object->instanceList ; targetP != NULL ; targetP = nextP)
+    {
+        nextP = targetP->next;
+        for (i = 0 ; i < count ; i++)
+        {
+            if (ids[i] == targetP->id) break;
+        }
+        if (i < count) continue;
+
+        object->instanceList = lwm2m_list_remove(object->instanceList, targetP->id, NULL);
+        lwm2m_free(targetP);
+        changed = true;
+    }
+
+    lwm2m_free(ids);
+    return changed;
+}
+
 void free_snap_object(lwm2m_object_t * object)
 {
     LWM2M_LIST_FREE(object->instanceList);
@@ -22,6 +22,7 @@ static uint8_t prv_set_value(lwm2m_data_t * dataP)
 {
     char * value;
     int i, val, count;
+    lwm2m_data_t * subTlvP;
 
     // a simple switch structure is used to respond at the specified resource asked
     switch (dataP->id)
@@ -33,19 +34,37 @@ static uint8_t prv_set_value(lwm2m_data_t * dataP)
     case 1:
         // Get the snaps into an array
         count = GetSnapCount();
-        lwm2m_data_t* subTlvP = lwm2m_data_new(count);
+        subTlvP = lwm2m_data_new(count);
+        if (count > 0 && subTlvP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
         for (i=0; i < count; i++) {
-            value = SnapDelimited(i);
-            subTlvP[i].id = i;
+            // The resource instances have the IDs of the snap instances
+            subTlvP[i].id = GetSnapInstanceID(i);
+            // Go callback to describe the snap, the string is ours to free
+            value = SnapDelimited(subTlvP[i].id);
             lwm2m_data_encode_string(value, subTlvP + i);
+            free(value);
         }
         lwm2m_data_encode_instances(subTlvP, count, dataP);
         return COAP_205_CONTENT;
     case 2:
-        // count = GetSnapCount();
-        // for (i=0; i < count; i++) {
-        //     SnapConfig(i);
-        // }
+        // Go callback to get the config of the snaps as a JSON document, the string is ours to free
+        value = SnapControlConfig();
+        lwm2m_data_encode_string(value, dataP);
+        free(value);
+        return COAP_205_CONTENT;
+    case 3:
+        // Get the recent errors into an array, the oldest first
+        count = GetSnapErrorCount();
+        subTlvP = lwm2m_data_new(count);
+        if (count > 0 && subTlvP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        for (i=0; i < count; i++) {
+            subTlvP[i].id = i;
+            // Go callback to describe the error, the string is ours to free
+            value = SnapErrorRead(i);
+            lwm2m_data_encode_string(value, subTlvP + i);
+            free(value);
+        }
+        lwm2m_data_encode_instances(subTlvP, count, dataP);
         return COAP_205_CONTENT;
     default:
         return COAP_404_NOT_FOUND;
@@ -69,7 +88,7 @@ static uint8_t prv_read(uint16_t instanceId,
     // is the server asking for the full object ?
     if (*numDataP == 0)
     {
-        uint16_t resList[] = {0,1};
+        uint16_t resList[] = {0,1,2,3};
         int nbRes = sizeof(resList)/sizeof(uint16_t);
 
         *dataArrayP = lwm2m_data_new(nbRes);
@@ -104,7 +123,9 @@ static uint8_t prv_discover(uint16_t instanceId,
     {
         uint16_t resList[] = {
             0,
-            1
+            1,
+            2,
+            3
         };
         int nbRes = sizeof(resList)/sizeof(uint16_t);
 
@@ -129,13 +150,37 @@ static uint8_t prv_write(uint16_t instanceId,
 
     do
     {
-        result = prv_set_value(dataArray + i);
-        i++;
-    } while (i < numData && result == COAP_205_CONTENT);
+        switch (dataArray[i].id)
+        {
+        case 2:
+            if (dataArray[i].type != LWM2M_TYPE_STRING && dataArray[i].type != LWM2M_TYPE_OPAQUE)
+            {
+                result = COAP_400_BAD_REQUEST;
+                break;
+            }
+
+            // Go callback to configure the snaps with the JSON document
+            if (0 != SnapControlSetConfig((char *)dataArray[i].value.asBuffer.buffer, dataArray[i].value.asBuffer.length))
+            {
+                result = COAP_400_BAD_REQUEST;
+                break;
+            }
+            result = COAP_204_CHANGED;
+            break;
+
+        case 0:
+        case 1:
+        case 3:
+            // The snap count, the snap list and the recent errors are read-only
+            result = COAP_405_METHOD_NOT_ALLOWED;
+            break;
+
+        default:
+            result = COAP_404_NOT_FOUND;
+        }
 
-    if (result == COAP_205_CONTENT) {
-        return COAP_204_CHANGED;
-    }
+        i++;
+    } while (i < numData && result == COAP_204_CHANGED);
 
     return result;
 
@@ -161,9 +206,6 @@ static uint8_t prv_exec(uint16_t instanceId,
                         int length,
                         lwm2m_object_t * objectP)
 {
-    char name[200];
-    char * result;
-
     if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
 
     switch (resourceId)
@@ -174,13 +216,9 @@ static uint8_t prv_exec(uint16_t instanceId,
     case 13:
     case 14:
     case 15:
-        if (length > 200) {
-            length = 199;
-        }
-        snprintf(name, length+1, "%s", buffer);
-        result = SnapExecute(resourceId, name);
-        fprintf(stdout, "%s\n", result);
-        fprintf(stdout, "-----------------\r\n\r\n");
+        // Go callback to start the action in the background, the payload is
+        // the snap name, the execute arguments or a JSON object of the options
+        if (0 != SnapExecute(resourceId, (char *)buffer, length)) return COAP_400_BAD_REQUEST;
         return COAP_204_CHANGED;
     default:
         return COAP_405_METHOD_NOT_ALLOWED;
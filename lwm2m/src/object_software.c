@@ -0,0 +1,306 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <ctype.h>
+#include <limits.h>
+
+// Resource Id's:
+#define RES_M_PKG_NAME                  0
+#define RES_M_PKG_VERSION               1
+#define RES_O_PACKAGE                   2
+#define RES_O_PACKAGE_URI               3
+#define RES_M_INSTALL                   4
+#define RES_M_UNINSTALL                 6
+#define RES_M_UPDATE_STATE              7
+#define RES_M_UPDATE_RESULT             9
+#define RES_M_ACTIVATE                  10
+#define RES_M_DEACTIVATE                11
+#define RES_M_ACTIVATION_STATE          12
+
+#define MAX_NAME_SIZE                   256
+
+
+static uint8_t prv_set_value(uint16_t instanceId,
+                             lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    // a simple switch structure is used to respond at the specified resource asked
+    switch (dataP->id)
+    {
+    case RES_M_PKG_NAME:
+    case RES_M_PKG_VERSION:
+    case RES_M_UPDATE_STATE:
+    case RES_M_UPDATE_RESULT:
+    case RES_M_ACTIVATION_STATE:
+        // Go callback to get the typed value, the buffer is ours to free
+        value = SoftwareRead(instanceId, dataP->id, &type, &length);
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+        return COAP_205_CONTENT;
+
+    case RES_O_PACKAGE:
+    case RES_O_PACKAGE_URI:
+    case RES_M_INSTALL:
+    case RES_M_UNINSTALL:
+    case RES_M_ACTIVATE:
+    case RES_M_DEACTIVATE:
+        return COAP_405_METHOD_NOT_ALLOWED;
+
+    default:
+        return COAP_404_NOT_FOUND;
+    }
+}
+
+static uint8_t prv_read(uint16_t instanceId,
+                        int * numDataP,
+                        lwm2m_data_t ** dataArrayP,
+                        lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // Check that we have the instance in the list
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_PKG_NAME,
+            RES_M_PKG_VERSION,
+            RES_M_UPDATE_STATE,
+            RES_M_UPDATE_RESULT,
+            RES_M_ACTIVATION_STATE
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value(instanceId, (*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_write(uint16_t instanceId,
+                         int numData,
+                         lwm2m_data_t * dataArray,
+                         lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    do
+    {
+        // Refreshed values are read from Go
+        result = prv_set_value(instanceId, dataArray + i);
+        i++;
+    } while (i < numData && result == COAP_205_CONTENT);
+
+    if (result == COAP_205_CONTENT) {
+        return COAP_204_CHANGED;
+    }
+
+    return result;
+}
+
+static uint8_t prv_discover(uint16_t instanceId,
+                            int * numDataP,
+                            lwm2m_data_t ** dataArrayP,
+                            lwm2m_object_t * objectP)
+{
+    int i;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_M_PKG_NAME,
+            RES_M_PKG_VERSION,
+            RES_M_INSTALL,
+            RES_M_UNINSTALL,
+            RES_M_UPDATE_STATE,
+            RES_M_UPDATE_RESULT,
+            RES_M_ACTIVATE,
+            RES_M_DEACTIVATE,
+            RES_M_ACTIVATION_STATE
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_create(uint16_t instanceId,
+                          int numData,
+                          lwm2m_data_t * dataArray,
+                          lwm2m_object_t * objectP)
+{
+    lwm2m_list_t * targetP;
+    char name[MAX_NAME_SIZE];
+    int i;
+
+    name[0] = 0;
+
+    // The snap name is given by the package name or the package URI
+    for (i = 0 ; i < numData ; i++)
+    {
+        if (dataArray[i].id != RES_M_PKG_NAME && dataArray[i].id != RES_O_PACKAGE_URI) continue;
+        if (dataArray[i].type != LWM2M_TYPE_STRING && dataArray[i].type != LWM2M_TYPE_OPAQUE) return COAP_400_BAD_REQUEST;
+        if (dataArray[i].value.asBuffer.length >= MAX_NAME_SIZE) return COAP_400_BAD_REQUEST;
+
+        memcpy(name, dataArray[i].value.asBuffer.buffer, dataArray[i].value.asBuffer.length);
+        name[dataArray[i].value.asBuffer.length] = 0;
+    }
+
+    // Go callback to add the package to the list
+    if (0 != SoftwareCreate(instanceId, name)) return COAP_400_BAD_REQUEST;
+
+    targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+    if (NULL == targetP) return COAP_500_INTERNAL_SERVER_ERROR;
+    memset(targetP, 0, sizeof(lwm2m_list_t));
+    targetP->id = instanceId;
+    objectP->instanceList = LWM2M_LIST_ADD(objectP->instanceList, targetP);
+
+    return COAP_201_CREATED;
+}
+
+static uint8_t prv_delete(uint16_t id,
+                          lwm2m_object_t * objectP)
+{
+    lwm2m_list_t * targetP;
+
+    if (NULL == lwm2m_list_find(objectP->instanceList, id)) return COAP_404_NOT_FOUND;
+
+    // Go callback to uninstall the snap
+    if (0 != SoftwareDelete(id)) return COAP_400_BAD_REQUEST;
+
+    objectP->instanceList = lwm2m_list_remove(objectP->instanceList, id, &targetP);
+    lwm2m_free(targetP);
+
+    return COAP_202_DELETED;
+}
+
+static uint8_t prv_exec(uint16_t instanceId,
+                        uint16_t resourceId,
+                        uint8_t * buffer,
+                        int length,
+                        lwm2m_object_t * objectP)
+{
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    switch (resourceId)
+    {
+    case RES_M_INSTALL:
+    case RES_M_UNINSTALL:
+    case RES_M_ACTIVATE:
+    case RES_M_DEACTIVATE:
+        fprintf(stdout, "\r\n-----------------\r\n"
+                        "Execute on %hu/%d/%d\r\n",
+                        objectP->objID, instanceId, resourceId);
+
+        // Go callback to start the snapd change, the update state follows it
+        if (0 != SoftwareExecute(instanceId, resourceId)) return COAP_400_BAD_REQUEST;
+        return COAP_204_CHANGED;
+    default:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    }
+}
+
+void display_software_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Software management object, instances:\r\n", object->objID);
+    lwm2m_list_t * instance = object->instanceList;
+    while (instance != NULL)
+    {
+        fprintf(stdout, "    /%u/%u\r\n", object->objID, instance->id);
+        instance = instance->next;
+    }
+#endif
+}
+
+lwm2m_object_t * get_software_object(void)
+{
+    lwm2m_object_t * softwareObj;
+
+    softwareObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != softwareObj)
+    {
+        int i;
+        lwm2m_list_t * targetP;
+
+        memset(softwareObj, 0, sizeof(lwm2m_object_t));
+
+        softwareObj->objID = LWM2M_SOFTWARE_MANAGEMENT_OBJECT_ID;
+
+        // Go callback to get the number of software packages i.e. snaps
+        int count = GetSoftwareCount();
+
+        // Initialize the instance list for each software package
+        for (i=0 ; i < count ; i++)
+        {
+            int id = GetSoftwareInstanceID(i);
+            if (id < 0) continue;
+
+            targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+            if (NULL == targetP) return NULL;
+            memset(targetP, 0, sizeof(lwm2m_list_t));
+            targetP->id = id;
+            softwareObj->instanceList = LWM2M_LIST_ADD(softwareObj->instanceList, targetP);
+        }
+
+        softwareObj->readFunc = prv_read;
+        softwareObj->writeFunc = prv_write;
+        softwareObj->executeFunc = prv_exec;
+        softwareObj->createFunc = prv_create;
+        softwareObj->deleteFunc = prv_delete;
+        softwareObj->discoverFunc = prv_discover;
+    }
+
+    return softwareObj;
+}
+
+void free_software_object(lwm2m_object_t * object)
+{
+    LWM2M_LIST_FREE(object->instanceList);
+    if (object->userData != NULL)
+    {
+        lwm2m_free(object->userData);
+        object->userData = NULL;
+    }
+    lwm2m_free(object);
+}
@@ -0,0 +1,262 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <ctype.h>
+#include <inttypes.h>
+
+// Resource Id's:
+#define RES_O_SMS_TX_COUNTER            0
+#define RES_O_SMS_RX_COUNTER            1
+#define RES_O_TX_DATA                   2
+#define RES_O_RX_DATA                   3
+#define RES_O_MAX_MESSAGE_SIZE          4
+#define RES_O_AVERAGE_MESSAGE_SIZE      5
+#define RES_M_START                     6
+#define RES_M_STOP                      7
+#define RES_O_COLLECTION_PERIOD         8
+
+#define MAX_PERIOD_SIZE                 24
+
+
+static uint8_t prv_set_value(lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    // a simple switch structure is used to respond at the specified resource asked
+    switch (dataP->id)
+    {
+    case RES_O_TX_DATA:
+    case RES_O_RX_DATA:
+    case RES_O_MAX_MESSAGE_SIZE:
+    case RES_O_AVERAGE_MESSAGE_SIZE:
+    case RES_O_COLLECTION_PERIOD:
+        // Go callback to get the traffic counters, the buffer is ours to free
+        value = StatisticsRead(dataP->id, &type, &length);
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+        return COAP_205_CONTENT;
+
+    case RES_M_START:
+    case RES_M_STOP:
+        return COAP_405_METHOD_NOT_ALLOWED;
+
+    default:
+        // The SMS counters are not supported
+        return COAP_404_NOT_FOUND;
+    }
+}
+
+static uint8_t prv_statistics_read(uint16_t instanceId,
+                                   int * numDataP,
+                                   lwm2m_data_t ** dataArrayP,
+                                   lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_O_TX_DATA,
+            RES_O_RX_DATA,
+            RES_O_MAX_MESSAGE_SIZE,
+            RES_O_AVERAGE_MESSAGE_SIZE,
+            RES_O_COLLECTION_PERIOD
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value((*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_statistics_write(uint16_t instanceId,
+                                    int numData,
+                                    lwm2m_data_t * dataArray,
+                                    lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i = 0;
+    int64_t period;
+    char value[MAX_PERIOD_SIZE];
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    do
+    {
+        switch (dataArray[i].id)
+        {
+        case RES_O_COLLECTION_PERIOD:
+            if (1 != lwm2m_data_decode_int(dataArray + i, &period))
+            {
+                result = COAP_400_BAD_REQUEST;
+                break;
+            }
+            snprintf(value, MAX_PERIOD_SIZE, "%" PRId64, period);
+
+            // Go callback to store the collection period
+            if (0 != StatisticsWrite(dataArray[i].id, value))
+            {
+                result = COAP_400_BAD_REQUEST;
+                break;
+            }
+            result = COAP_204_CHANGED;
+            break;
+
+        default:
+            // Refreshed values are read from Go
+            result = prv_set_value(dataArray + i);
+            if (result == COAP_205_CONTENT) result = COAP_204_CHANGED;
+        }
+
+        i++;
+    } while (i < numData && result == COAP_204_CHANGED);
+
+    return result;
+}
+
+static uint8_t prv_statistics_discover(uint16_t instanceId,
+                                       int * numDataP,
+                                       lwm2m_data_t ** dataArrayP,
+                                       lwm2m_object_t * objectP)
+{
+    int i;
+
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_O_TX_DATA,
+            RES_O_RX_DATA,
+            RES_O_MAX_MESSAGE_SIZE,
+            RES_O_AVERAGE_MESSAGE_SIZE,
+            RES_M_START,
+            RES_M_STOP,
+            RES_O_COLLECTION_PERIOD
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_statistics_execute(uint16_t instanceId,
+                                      uint16_t resourceId,
+                                      uint8_t * buffer,
+                                      int length,
+                                      lwm2m_object_t * objectP)
+{
+    // this is a single instance object
+    if (instanceId != 0)
+    {
+        return COAP_404_NOT_FOUND;
+    }
+
+    if (length != 0) return COAP_400_BAD_REQUEST;
+
+    switch (resourceId)
+    {
+    case RES_M_START:
+    case RES_M_STOP:
+        // Go callback to start or stop the collection of the counters
+        if (0 != StatisticsExecute(resourceId)) return COAP_400_BAD_REQUEST;
+        return COAP_204_CHANGED;
+    default:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    }
+}
+
+void display_statistics_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Connectivity statistics object\r\n", object->objID);
+#endif
+}
+
+lwm2m_object_t * get_object_conn_s(void)
+{
+    lwm2m_object_t * statisticsObj;
+
+    statisticsObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != statisticsObj)
+    {
+        memset(statisticsObj, 0, sizeof(lwm2m_object_t));
+
+        statisticsObj->objID = LWM2M_CONN_STATS_OBJECT_ID;
+
+        // this is a single instance object
+        statisticsObj->instanceList = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+        if (NULL != statisticsObj->instanceList)
+        {
+            memset(statisticsObj->instanceList, 0, sizeof(lwm2m_list_t));
+        }
+        else
+        {
+            lwm2m_free(statisticsObj);
+            return NULL;
+        }
+
+        statisticsObj->readFunc     = prv_statistics_read;
+        statisticsObj->writeFunc    = prv_statistics_write;
+        statisticsObj->executeFunc  = prv_statistics_execute;
+        statisticsObj->discoverFunc = prv_statistics_discover;
+    }
+
+    return statisticsObj;
+}
+
+void free_object_conn_s(lwm2m_object_t * objectP)
+{
+    lwm2m_free(objectP->instanceList);
+    lwm2m_free(objectP);
+}
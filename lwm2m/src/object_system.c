@@ -0,0 +1,208 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#include "liblwm2m.h"
+#include "lwm2mclient.h"
+#include "gocallbacks.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <ctype.h>
+#include <limits.h>
+
+// Resource Id's:
+#define RES_LABEL                           0
+#define RES_MODEL                           1
+#define RES_BRAND                           2
+#define RES_CURRENT                         3
+#define RES_MODES                           4
+#define RES_RUN                             10
+#define RES_RECOVER                         11
+#define RES_INSTALL                         12
+#define RES_FACTORY_RESET                   13
+
+#define SYSTEM_RESOURCES                    5
+
+
+static uint8_t prv_set_value(uint16_t instanceId,
+                             lwm2m_data_t * dataP)
+{
+    char * value;
+    int type;
+    int length;
+    int result;
+
+    switch (dataP->id)
+    {
+    case RES_LABEL:
+    case RES_MODEL:
+    case RES_BRAND:
+    case RES_CURRENT:
+    case RES_MODES:
+        // Go callback to get the typed value, the buffer is ours to free
+        value = SystemInstanceRead(instanceId, dataP->id, &type, &length);
+        result = encode_value(type, value, length, dataP);
+        free(value);
+        if (result != 0) return COAP_500_INTERNAL_SERVER_ERROR;
+        return COAP_205_CONTENT;
+    default:
+        return COAP_404_NOT_FOUND;
+    }
+}
+
+static uint8_t prv_read(uint16_t instanceId,
+                        int * numDataP,
+                        lwm2m_data_t ** dataArrayP,
+                        lwm2m_object_t * objectP)
+{
+    uint8_t result;
+    int i;
+
+    // Check that we have the instance in the list
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        *dataArrayP = lwm2m_data_new(SYSTEM_RESOURCES);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = SYSTEM_RESOURCES;
+        for (i = 0 ; i < *numDataP ; i++)
+        {
+            (*dataArrayP)[i].id = i;
+        }
+    }
+
+    i = 0;
+    do
+    {
+        result = prv_set_value(instanceId, (*dataArrayP) + i);
+        i++;
+    } while (i < *numDataP && result == COAP_205_CONTENT);
+
+    return result;
+}
+
+static uint8_t prv_discover(uint16_t instanceId,
+                            int * numDataP,
+                            lwm2m_data_t ** dataArrayP,
+                            lwm2m_object_t * objectP)
+{
+    int i;
+
+    // is the server asking for the full object ?
+    if (*numDataP == 0)
+    {
+        uint16_t resList[] = {
+            RES_LABEL,
+            RES_MODEL,
+            RES_BRAND,
+            RES_CURRENT,
+            RES_MODES,
+            RES_RUN,
+            RES_RECOVER,
+            RES_INSTALL,
+            RES_FACTORY_RESET
+        };
+        int nbRes = sizeof(resList)/sizeof(uint16_t);
+
+        *dataArrayP = lwm2m_data_new(nbRes);
+        if (*dataArrayP == NULL) return COAP_500_INTERNAL_SERVER_ERROR;
+        *numDataP = nbRes;
+        for (i = 0 ; i < nbRes ; i++)
+        {
+            (*dataArrayP)[i].id = resList[i];
+        }
+    }
+    return COAP_205_CONTENT;
+}
+
+static uint8_t prv_exec(uint16_t instanceId,
+                        uint16_t resourceId,
+                        uint8_t * buffer,
+                        int length,
+                        lwm2m_object_t * objectP)
+{
+    if (NULL == lwm2m_list_find(objectP->instanceList, instanceId)) return COAP_404_NOT_FOUND;
+
+    switch (resourceId)
+    {
+    case RES_RUN:
+    case RES_RECOVER:
+    case RES_INSTALL:
+    case RES_FACTORY_RESET:
+        fprintf(stdout, "\r\n-----------------\r\n"
+                        "Execute on %hu/%d/%d\r\n",
+                        objectP->objID, instanceId, resourceId);
+
+        // Go callback to select the recovery system, which is used by the next reboot
+        if (0 != SystemExecute(instanceId, resourceId)) return COAP_400_BAD_REQUEST;
+
+        // The reboot is requested from the event loop once the Execute has been acknowledged
+        g_reboot = REBOOT_REQUESTED;
+        return COAP_204_CHANGED;
+    default:
+        return COAP_405_METHOD_NOT_ALLOWED;
+    }
+}
+
+void display_system_object(lwm2m_object_t * object)
+{
+#ifdef WITH_LOGS
+    fprintf(stdout, "  /%u: Recovery system object, instances:\r\n", object->objID);
+    lwm2m_list_t * instance = object->instanceList;
+    while (instance != NULL)
+    {
+        fprintf(stdout, "    /%u/%u\r\n", object->objID, instance->id);
+        instance = instance->next;
+    }
+#endif
+}
+
+lwm2m_object_t * get_system_object(void)
+{
+    lwm2m_object_t * systemObj;
+
+    systemObj = (lwm2m_object_t *)lwm2m_malloc(sizeof(lwm2m_object_t));
+
+    if (NULL != systemObj)
+    {
+        int i;
+        lwm2m_list_t * targetP;
+
+        memset(systemObj, 0, sizeof(lwm2m_object_t));
+
+        systemObj->objID = LWM2M_RECOVERY_SYSTEM_OBJECT_ID;
+
+        // Go callback to get the number of recovery systems
+        int count = GetSystemCount();
+
+        // Initialize the instance list for each recovery system
+        for (i=0 ; i < count ; i++)
+        {
+            targetP = (lwm2m_list_t *)lwm2m_malloc(sizeof(lwm2m_list_t));
+            if (NULL == targetP) return NULL;
+            memset(targetP, 0, sizeof(lwm2m_list_t));
+            targetP->id = i;
+            systemObj->instanceList = LWM2M_LIST_ADD(systemObj->instanceList, targetP);
+        }
+
+        systemObj->readFunc = prv_read;
+        systemObj->executeFunc = prv_exec;
+        systemObj->discoverFunc = prv_discover;
+    }
+
+    return systemObj;
+}
+
+void free_system_object(lwm2m_object_t * object)
+{
+    LWM2M_LIST_FREE(object->instanceList);
+    if (object->userData != NULL)
+    {
+        lwm2m_free(object->userData);
+        object->userData = NULL;
+    }
+    lwm2m_free(object);
+}
@@ -0,0 +1,258 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// LWM2M 1.1 Send operation: the client reports resources to the server with
+// a POST on /dp, without a prior request from the server. wakaama does not
+// implement it, so the transactions are built with its internals.
+
+#include "internals.h"
+#include "send.h"
+
+#include <stdlib.h>
+#include <string.h>
+
+
+#if defined(LWM2M_CLIENT_MODE) && !defined(LWM2M_VERSION_1_0) && (defined(LWM2M_SUPPORT_SENML_JSON) || defined(LWM2M_SUPPORT_SENML_CBOR))
+
+#define PRV_SEND_PATH "dp"
+
+typedef struct
+{
+    uint16_t                shortServerID;
+    lwm2m_send_callback_t   callback;
+    void *                  userData;
+} send_data_t;
+
+// find the child with the ID, or add it with the type
+static lwm2m_data_t * prv_findOrAdd(lwm2m_data_t * parentP,
+                                    uint16_t id,
+                                    lwm2m_data_type_t type)
+{
+    lwm2m_data_t * childP;
+
+    childP = json_findDataItem(parentP->value.asChildren.array,
+                               parentP->value.asChildren.count,
+                               id);
+    if (childP != NULL) return childP;
+
+    childP = json_extendData(parentP);
+    if (childP == NULL) return NULL;
+    childP->id = id;
+    childP->type = type;
+
+    return childP;
+}
+
+// add the data read at the URI to the tree of objects
+static bool prv_addData(lwm2m_data_t * rootP,
+                        const lwm2m_uri_t * uriP,
+                        int size,
+                        lwm2m_data_t * dataP)
+{
+    lwm2m_data_t * parentP;
+    int i;
+
+    parentP = prv_findOrAdd(rootP, uriP->objectId, LWM2M_TYPE_OBJECT);
+    if (parentP == NULL) return false;
+
+    if (LWM2M_URI_IS_SET_INSTANCE(uriP))
+    {
+        parentP = prv_findOrAdd(parentP, uriP->instanceId, LWM2M_TYPE_OBJECT_INSTANCE);
+        if (parentP == NULL) return false;
+    }
+    if (LWM2M_URI_IS_SET_RESOURCE_INSTANCE(uriP))
+    {
+        parentP = prv_findOrAdd(parentP, uriP->resourceId, LWM2M_TYPE_MULTIPLE_RESOURCE);
+        if (parentP == NULL) return false;
+    }
+
+    for (i = 0 ; i < size ; i++)
+    {
+        lwm2m_data_t * childP;
+
+        // a path given twice is only sent once
+        if (json_findDataItem(parentP->value.asChildren.array,
+                              parentP->value.asChildren.count,
+                              dataP[i].id) != NULL)
+        {
+            continue;
+        }
+
+        childP = json_extendData(parentP);
+        if (childP == NULL) return false;
+        memcpy(childP, dataP + i, sizeof(lwm2m_data_t));
+        memset(dataP + i, 0, sizeof(lwm2m_data_t));
+    }
+
+    return true;
+}
+
+// add a record without a value for the object instance that no longer exists
+static bool prv_addRemoved(lwm2m_data_t * rootP,
+                           const lwm2m_uri_t * uriP)
+{
+    lwm2m_data_t * parentP;
+
+    parentP = prv_findOrAdd(rootP, uriP->objectId, LWM2M_TYPE_OBJECT);
+    if (parentP == NULL) return false;
+
+    return prv_findOrAdd(parentP, uriP->instanceId, LWM2M_TYPE_UNDEFINED) != NULL;
+}
+
+static void prv_handleSendReply(lwm2m_context_t * contextP,
+                                lwm2m_transaction_t * transacP,
+                                void * message)
+{
+    coap_packet_t * packet = (coap_packet_t *)message;
+    send_data_t * dataP = (send_data_t *)transacP->userData;
+    uint8_t status;
+
+    // the next block of the payload is sent
+    if (packet != NULL && packet->code == COAP_231_CONTINUE) return;
+
+    status = packet != NULL ? packet->code : COAP_503_SERVICE_UNAVAILABLE;
+    LOG_ARG("%d Send result: %u.%02u", dataP->shortServerID, (status & 0xE0) >> 5, status & 0x1F);
+
+    if (dataP->callback != NULL)
+    {
+        dataP->callback(contextP, dataP->shortServerID, status, dataP->userData);
+    }
+
+    transaction_free_userData(contextP, transacP);
+}
+
+static int prv_sendPayload(lwm2m_context_t * contextP,
+                           lwm2m_server_t * serverP,
+                           lwm2m_media_type_t format,
+                           uint8_t * payload,
+                           size_t length,
+                           lwm2m_send_callback_t callback,
+                           void * userData)
+{
+    lwm2m_transaction_t * transaction;
+    send_data_t * dataP;
+
+    transaction = transaction_new(serverP->sessionH, COAP_POST, NULL, NULL, contextP->nextMID++, 4, NULL);
+    if (transaction == NULL) return -1;
+
+    coap_set_header_uri_path(transaction->message, "/"PRV_SEND_PATH);
+    coap_set_header_content_type(transaction->message, format);
+    if (!transaction_set_payload(transaction, payload, length))
+    {
+        transaction_free(transaction);
+        return -1;
+    }
+
+    dataP = (send_data_t *)lwm2m_malloc(sizeof(send_data_t));
+    if (dataP == NULL)
+    {
+        transaction_free(transaction);
+        return -1;
+    }
+    dataP->shortServerID = serverP->shortID;
+    dataP->callback = callback;
+    dataP->userData = userData;
+
+    transaction->callback = prv_handleSendReply;
+    transaction->userData = (void *)dataP;
+
+    contextP->transactionList = (lwm2m_transaction_t *)LWM2M_LIST_ADD(contextP->transactionList, transaction);
+
+    // a failed transmission is reported through the callback
+    (void)transaction_send(contextP, transaction);
+    return 0;
+}
+
+int lwm2m_send(lwm2m_context_t * contextP,
+               uint16_t shortServerID,
+               const lwm2m_uri_t * urisP,
+               size_t numUris,
+               lwm2m_send_callback_t callback,
+               void * userData)
+{
+    lwm2m_data_t root;
+    lwm2m_server_t * serverP;
+    lwm2m_media_type_t format;
+    uint8_t * payload = NULL;
+    int length;
+    size_t i;
+    int count;
+    uint8_t result;
+
+    LOG_ARG("shortServerID: %d, numUris: %d", shortServerID, numUris);
+
+    memset(&root, 0, sizeof(root));
+    for (i = 0 ; i < numUris ; i++)
+    {
+        lwm2m_uri_t uri;
+        lwm2m_data_t * dataP = NULL;
+        int size = 0;
+
+        if (!LWM2M_URI_IS_SET_OBJECT(urisP + i)) continue;
+
+        memcpy(&uri, urisP + i, sizeof(uri));
+        result = object_readData(contextP, &uri, &size, &dataP);
+        if (result == COAP_404_NOT_FOUND
+         && LWM2M_URI_IS_SET_INSTANCE(&uri)
+         && !LWM2M_URI_IS_SET_RESOURCE(&uri))
+        {
+            // the server is told about the removal of the instance
+            if (!prv_addRemoved(&root, &uri))
+            {
+                lwm2m_data_free(root.value.asChildren.count, root.value.asChildren.array);
+                return -1;
+            }
+            continue;
+        }
+        if (COAP_205_CONTENT != result)
+        {
+            // the resource may have been removed since it was reported
+            LOG_URI(&uri);
+            LOG("Send skips an unreadable URI");
+            continue;
+        }
+
+        if (!prv_addData(&root, &uri, size, dataP))
+        {
+            lwm2m_data_free(size, dataP);
+            lwm2m_data_free(root.value.asChildren.count, root.value.asChildren.array);
+            return -1;
+        }
+        lwm2m_data_free(size, dataP);
+    }
+
+    if (root.value.asChildren.count == 0) return -1;
+
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+    format = LWM2M_CONTENT_SENML_CBOR;
+#else
+    format = LWM2M_CONTENT_SENML_JSON;
+#endif
+    length = lwm2m_data_serialize(NULL, root.value.asChildren.count, root.value.asChildren.array, &format, &payload);
+    lwm2m_data_free(root.value.asChildren.count, root.value.asChildren.array);
+    if (length <= 0) return -1;
+
+    count = 0;
+    for (serverP = contextP->serverList ; serverP != NULL ; serverP = serverP->next)
+    {
+        if (shortServerID != 0 && serverP->shortID != shortServerID) continue;
+        if (serverP->status != STATE_REGISTERED
+         && serverP->status != STATE_REG_UPDATE_NEEDED
+         && serverP->status != STATE_REG_FULL_UPDATE_NEEDED
+         && serverP->status != STATE_REG_UPDATE_PENDING)
+        {
+            continue;
+        }
+
+        if (0 == prv_sendPayload(contextP, serverP, format, payload, (size_t)length, callback, userData))
+        {
+            count++;
+        }
+    }
+
+    lwm2m_free(payload);
+
+    return count;
+}
+
+#endif
@@ -0,0 +1,17 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+#ifndef SEND_H_
+#define SEND_H_
+
+#include "liblwm2m.h"
+
+// Send the resources at the URIs to the server specified by the server short identifier,
+// or all registered servers if the ID is 0. An object instance that no longer exists is
+// sent as a record without a value. Returns the number of servers the data was sent to,
+// or -1 if none of the URIs could be read. The callback is called with the CoAP code of
+// the response of each server, or COAP_503_SERVICE_UNAVAILABLE on timeout.
+typedef void (*lwm2m_send_callback_t)(lwm2m_context_t * contextP, uint16_t shortServerID, uint8_t status, void * userData);
+int lwm2m_send(lwm2m_context_t * contextP, uint16_t shortServerID, const lwm2m_uri_t * urisP, size_t numUris, lwm2m_send_callback_t callback, void * userData);
+
+#endif
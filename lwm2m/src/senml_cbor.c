@@ -0,0 +1,1366 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// SenML CBOR (RFC 8428) content format, as required by LWM2M 1.1. The records
+// are the same as in the senml_json.c of wakaama, with the integer labels of
+// the SenML CBOR representation. wakaama calls the codec from its data.c.
+
+#include "internals.h"
+#include <stdlib.h>
+#include <string.h>
+#include <inttypes.h>
+
+
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+
+#ifdef LWM2M_VERSION_1_0
+#error SenML CBOR not supported with LWM2M 1.0
+#endif
+
+// The records are converted with the helpers of wakaama's json_common.c
+#if !defined(LWM2M_SUPPORT_JSON) && !defined(LWM2M_SUPPORT_SENML_JSON)
+#error SenML CBOR needs LWM2M_SUPPORT_SENML_JSON
+#endif
+
+#define CBOR_MAJOR_UNSIGNED               0
+#define CBOR_MAJOR_NEGATIVE               1
+#define CBOR_MAJOR_BYTES                  2
+#define CBOR_MAJOR_TEXT                   3
+#define CBOR_MAJOR_ARRAY                  4
+#define CBOR_MAJOR_MAP                    5
+#define CBOR_MAJOR_TAG                    6
+#define CBOR_MAJOR_SIMPLE                 7
+
+#define CBOR_INFO_UINT8                   24
+#define CBOR_INFO_UINT16                  25
+#define CBOR_INFO_UINT32                  26
+#define CBOR_INFO_UINT64                  27
+#define CBOR_INFO_INDEFINITE              31
+
+#define CBOR_SIMPLE_FALSE                 20
+#define CBOR_SIMPLE_TRUE                  21
+#define CBOR_SIMPLE_HALF_FLOAT            25
+#define CBOR_SIMPLE_FLOAT                 26
+#define CBOR_SIMPLE_DOUBLE                27
+#define CBOR_BREAK                        0xFF
+
+/* Nesting of the items skipped in unknown labels */
+#define CBOR_MAX_DEPTH                    8
+
+#define SENML_LABEL_BASE_VERSION          -1
+#define SENML_LABEL_BASE_NAME             -2
+#define SENML_LABEL_BASE_TIME             -3
+#define SENML_LABEL_BASE_VALUE            -5
+#define SENML_LABEL_NAME                  0
+#define SENML_LABEL_VALUE                 2
+#define SENML_LABEL_STRING_VALUE          3
+#define SENML_LABEL_BOOLEAN_VALUE         4
+#define SENML_LABEL_TIME                  6
+#define SENML_LABEL_DATA_VALUE            8
+#define SENML_LABEL_OBJECT_LINK           "vlo"
+#define SENML_LABEL_OBJECT_LINK_SIZE      3
+
+typedef struct
+{
+    uint16_t        ids[4];
+    lwm2m_data_t    value; /* Any buffer will be within the parsed data */
+    time_t          time;
+} _record_t;
+
+typedef struct
+{
+    uint8_t *       buffer; /* NULL when only measuring the length */
+    size_t          length;
+    size_t          head;
+} _writer_t;
+
+static bool prv_readHead(const uint8_t * buffer,
+                         size_t bufferLen,
+                         size_t * indexP,
+                         uint8_t * majorP,
+                         uint8_t * infoP,
+                         uint64_t * valueP)
+{
+    size_t size;
+    size_t i;
+
+    if (*indexP >= bufferLen) return false;
+
+    *majorP = buffer[*indexP] >> 5;
+    *infoP = buffer[*indexP] & 0x1F;
+    (*indexP)++;
+
+    switch (*infoP)
+    {
+    case CBOR_INFO_UINT8:
+        size = 1;
+        break;
+    case CBOR_INFO_UINT16:
+        size = 2;
+        break;
+    case CBOR_INFO_UINT32:
+        size = 4;
+        break;
+    case CBOR_INFO_UINT64:
+        size = 8;
+        break;
+    case CBOR_INFO_INDEFINITE:
+        if (*majorP == CBOR_MAJOR_UNSIGNED
+         || *majorP == CBOR_MAJOR_NEGATIVE
+         || *majorP == CBOR_MAJOR_TAG)
+        {
+            return false;
+        }
+        *valueP = 0;
+        return true;
+    default:
+        /* 28 to 30 are reserved */
+        if (*infoP > CBOR_INFO_UINT64) return false;
+        *valueP = *infoP;
+        return true;
+    }
+
+    if (bufferLen - *indexP < size) return false;
+
+    *valueP = 0;
+    for (i = 0 ; i < size ; i++)
+    {
+        *valueP = (*valueP << 8) | buffer[*indexP + i];
+    }
+    *indexP += size;
+
+    return true;
+}
+
+static bool prv_isBreak(const uint8_t * buffer,
+                        size_t bufferLen,
+                        size_t index)
+{
+    return index < bufferLen && buffer[index] == CBOR_BREAK;
+}
+
+static bool prv_skipItem(const uint8_t * buffer,
+                         size_t bufferLen,
+                         size_t * indexP,
+                         int depth)
+{
+    uint8_t major;
+    uint8_t info;
+    uint64_t value;
+    uint64_t i;
+
+    if (depth > CBOR_MAX_DEPTH) return false;
+    if (!prv_readHead(buffer, bufferLen, indexP, &major, &info, &value)) return false;
+
+    switch (major)
+    {
+    case CBOR_MAJOR_UNSIGNED:
+    case CBOR_MAJOR_NEGATIVE:
+        return true;
+
+    case CBOR_MAJOR_BYTES:
+    case CBOR_MAJOR_TEXT:
+        if (info == CBOR_INFO_INDEFINITE)
+        {
+            while (!prv_isBreak(buffer, bufferLen, *indexP))
+            {
+                if (!prv_skipItem(buffer, bufferLen, indexP, depth + 1)) return false;
+            }
+            (*indexP)++;
+            return true;
+        }
+        if (value > bufferLen - *indexP) return false;
+        *indexP += (size_t)value;
+        return true;
+
+    case CBOR_MAJOR_ARRAY:
+    case CBOR_MAJOR_MAP:
+        if (info == CBOR_INFO_INDEFINITE)
+        {
+            while (!prv_isBreak(buffer, bufferLen, *indexP))
+            {
+                if (!prv_skipItem(buffer, bufferLen, indexP, depth + 1)) return false;
+                if (major == CBOR_MAJOR_MAP
+                 && !prv_skipItem(buffer, bufferLen, indexP, depth + 1))
+                {
+                    return false;
+                }
+            }
+            (*indexP)++;
+            return true;
+        }
+        if (major == CBOR_MAJOR_MAP)
+        {
+            if (value > UINT64_MAX / 2) return false;
+            value *= 2;
+        }
+        for (i = 0 ; i < value ; i++)
+        {
+            if (!prv_skipItem(buffer, bufferLen, indexP, depth + 1)) return false;
+        }
+        return true;
+
+    case CBOR_MAJOR_TAG:
+        return prv_skipItem(buffer, bufferLen, indexP, depth + 1);
+
+    default:
+        /* A break outside of an indefinite length item is malformed */
+        return info != CBOR_INFO_INDEFINITE;
+    }
+}
+
+static bool prv_readString(const uint8_t * buffer,
+                           size_t bufferLen,
+                           size_t * indexP,
+                           uint8_t expectedMajor,
+                           const uint8_t ** stringP,
+                           size_t * lengthP)
+{
+    uint8_t major;
+    uint8_t info;
+    uint64_t value;
+
+    if (!prv_readHead(buffer, bufferLen, indexP, &major, &info, &value)) return false;
+    /* Indefinite length strings are not supported */
+    if (major != expectedMajor || info == CBOR_INFO_INDEFINITE) return false;
+    if (value > bufferLen - *indexP) return false;
+
+    *stringP = buffer + *indexP;
+    *lengthP = (size_t)value;
+    *indexP += (size_t)value;
+
+    return true;
+}
+
+static double prv_halfToDouble(uint16_t half)
+{
+    uint32_t exponent = (half >> 10) & 0x1F;
+    uint32_t mantissa = half & 0x3FF;
+    double result;
+
+    if (exponent == 0)
+    {
+        result = mantissa / 16777216.0;
+    }
+    else
+    {
+        uint32_t bits;
+        float single;
+
+        bits = ((exponent - 15 + 127) << 23) | (mantissa << 13);
+        memcpy(&single, &bits, sizeof(single));
+        result = single;
+    }
+
+    return (half & 0x8000) ? -result : result;
+}
+
+static bool prv_readNumber(const uint8_t * buffer,
+                           size_t bufferLen,
+                           size_t * indexP,
+                           lwm2m_data_t * dataP)
+{
+    uint8_t major;
+    uint8_t info;
+    uint64_t value;
+
+    if (!prv_readHead(buffer, bufferLen, indexP, &major, &info, &value)) return false;
+
+    switch (major)
+    {
+    case CBOR_MAJOR_UNSIGNED:
+        if (value > INT64_MAX)
+        {
+            lwm2m_data_encode_uint(value, dataP);
+        }
+        else
+        {
+            lwm2m_data_encode_int((int64_t)value, dataP);
+        }
+        return true;
+
+    case CBOR_MAJOR_NEGATIVE:
+        if (value > INT64_MAX) return false;
+        lwm2m_data_encode_int(-1 - (int64_t)value, dataP);
+        return true;
+
+    case CBOR_MAJOR_SIMPLE:
+        switch (info)
+        {
+        case CBOR_SIMPLE_HALF_FLOAT:
+            /* Infinity and NaN are not valid resource values */
+            if (((value >> 10) & 0x1F) == 0x1F) return false;
+            lwm2m_data_encode_float(prv_halfToDouble((uint16_t)value), dataP);
+            return true;
+
+        case CBOR_SIMPLE_FLOAT:
+        {
+            uint32_t bits = (uint32_t)value;
+            float single;
+
+            if (((bits >> 23) & 0xFF) == 0xFF) return false;
+            memcpy(&single, &bits, sizeof(single));
+            lwm2m_data_encode_float(single, dataP);
+            return true;
+        }
+
+        case CBOR_SIMPLE_DOUBLE:
+        {
+            double number;
+
+            if (((value >> 52) & 0x7FF) == 0x7FF) return false;
+            memcpy(&number, &value, sizeof(number));
+            lwm2m_data_encode_float(number, dataP);
+            return true;
+        }
+
+        default:
+            return false;
+        }
+
+    default:
+        return false;
+    }
+}
+
+static bool prv_readTime(const uint8_t * buffer,
+                         size_t bufferLen,
+                         size_t * indexP,
+                         time_t * timeP)
+{
+    lwm2m_data_t data;
+
+    memset(&data, 0, sizeof(data));
+    if (!prv_readNumber(buffer, bufferLen, indexP, &data)) return false;
+
+    switch (data.type)
+    {
+    case LWM2M_TYPE_INTEGER:
+        *timeP = (time_t)data.value.asInteger;
+        break;
+    case LWM2M_TYPE_UNSIGNED_INTEGER:
+        *timeP = (time_t)data.value.asUnsigned;
+        break;
+    default:
+        *timeP = (time_t)data.value.asFloat;
+        break;
+    }
+
+    return true;
+}
+
+static bool prv_addBaseValue(lwm2m_data_t * valueP,
+                             const lwm2m_data_t * baseValue)
+{
+    double base;
+
+    switch (baseValue->type)
+    {
+    case LWM2M_TYPE_INTEGER:
+        base = (double)baseValue->value.asInteger;
+        break;
+    case LWM2M_TYPE_UNSIGNED_INTEGER:
+        base = (double)baseValue->value.asUnsigned;
+        break;
+    default:
+        base = baseValue->value.asFloat;
+        break;
+    }
+
+    switch (valueP->type)
+    {
+    case LWM2M_TYPE_UNDEFINED:
+        memcpy(valueP, baseValue, sizeof(*baseValue));
+        break;
+    case LWM2M_TYPE_INTEGER:
+        if (baseValue->type == LWM2M_TYPE_INTEGER)
+        {
+            valueP->value.asInteger += baseValue->value.asInteger;
+        }
+        else
+        {
+            valueP->value.asInteger += base;
+        }
+        break;
+    case LWM2M_TYPE_UNSIGNED_INTEGER:
+        if (baseValue->type == LWM2M_TYPE_UNSIGNED_INTEGER)
+        {
+            valueP->value.asUnsigned += baseValue->value.asUnsigned;
+        }
+        else
+        {
+            valueP->value.asUnsigned += base;
+        }
+        break;
+    case LWM2M_TYPE_FLOAT:
+        valueP->value.asFloat += base;
+        break;
+    default:
+        return false;
+    }
+
+    return true;
+}
+
+static int prv_parseItem(const uint8_t * buffer,
+                         size_t bufferLen,
+                         size_t * indexP,
+                         _record_t * recordP,
+                         char * baseUri,
+                         time_t * baseTime,
+                         lwm2m_data_t * baseValue)
+{
+    uint8_t major;
+    uint8_t info;
+    uint64_t count;
+    uint64_t pair;
+    const uint8_t * name = NULL;
+    size_t nameLength = 0;
+    bool timeSeen = false;
+    bool bnSeen = false;
+    bool btSeen = false;
+    bool bvSeen = false;
+    bool bverSeen = false;
+
+    memset(recordP->ids, 0xFF, 4*sizeof(uint16_t));
+    memset(&recordP->value, 0, sizeof(recordP->value));
+    recordP->time = 0;
+
+    if (!prv_readHead(buffer, bufferLen, indexP, &major, &info, &count)) return -1;
+    if (major != CBOR_MAJOR_MAP) return -1;
+
+    for (pair = 0 ;
+         info == CBOR_INFO_INDEFINITE ? !prv_isBreak(buffer, bufferLen, *indexP) : pair < count ;
+         pair++)
+    {
+        uint8_t keyMajor;
+        uint8_t keyInfo;
+        uint64_t key;
+        int64_t label;
+        const uint8_t * string;
+        size_t length;
+
+        if (!prv_readHead(buffer, bufferLen, indexP, &keyMajor, &keyInfo, &key)) return -1;
+
+        if (keyMajor == CBOR_MAJOR_TEXT)
+        {
+            if (keyInfo == CBOR_INFO_INDEFINITE || key > bufferLen - *indexP) return -1;
+            string = buffer + *indexP;
+            length = (size_t)key;
+            *indexP += length;
+
+            if (length == SENML_LABEL_OBJECT_LINK_SIZE
+             && 0 == memcmp(string, SENML_LABEL_OBJECT_LINK, SENML_LABEL_OBJECT_LINK_SIZE))
+            {
+                if (recordP->value.type != LWM2M_TYPE_UNDEFINED) return -1;
+                if (!prv_readString(buffer, bufferLen, indexP, CBOR_MAJOR_TEXT, &string, &length)) return -1;
+                if (!utils_textToObjLink(string,
+                                         length,
+                                         &recordP->value.value.asObjLink.objectId,
+                                         &recordP->value.value.asObjLink.objectInstanceId))
+                {
+                    return -1;
+                }
+                recordP->value.type = LWM2M_TYPE_OBJECT_LINK;
+            }
+            else
+            {
+                /* Label ending in _ must be supported or generate error. */
+                if (length > 0 && string[length - 1] == '_') return -1;
+                if (!prv_skipItem(buffer, bufferLen, indexP, 0)) return -1;
+            }
+            continue;
+        }
+
+        if (keyMajor == CBOR_MAJOR_UNSIGNED && key <= INT64_MAX)
+        {
+            label = (int64_t)key;
+        }
+        else if (keyMajor == CBOR_MAJOR_NEGATIVE && key <= INT64_MAX)
+        {
+            label = -1 - (int64_t)key;
+        }
+        else
+        {
+            return -1;
+        }
+
+        switch (label)
+        {
+        case SENML_LABEL_BASE_NAME:
+            if (bnSeen) return -1;
+            bnSeen = true;
+            if (!prv_readString(buffer, bufferLen, indexP, CBOR_MAJOR_TEXT, &string, &length)) return -1;
+            if (length > 0)
+            {
+                if (length == 1 && string[0] != '/') return -1;
+                if (length > URI_MAX_STRING_LEN) return -1;
+                memcpy(baseUri, string, length);
+            }
+            baseUri[length] = '\0';
+            break;
+
+        case SENML_LABEL_BASE_TIME:
+            if (btSeen) return -1;
+            btSeen = true;
+            if (!prv_readTime(buffer, bufferLen, indexP, baseTime)) return -1;
+            break;
+
+        case SENML_LABEL_BASE_VALUE:
+            if (bvSeen) return -1;
+            bvSeen = true;
+            memset(baseValue, 0, sizeof(*baseValue));
+            if (!prv_readNumber(buffer, bufferLen, indexP, baseValue)) return -1;
+            /* Convert explicit 0 to implicit 0 */
+            if ((baseValue->type == LWM2M_TYPE_INTEGER && baseValue->value.asInteger == 0)
+             || (baseValue->type == LWM2M_TYPE_FLOAT && baseValue->value.asFloat == 0.0))
+            {
+                baseValue->type = LWM2M_TYPE_UNDEFINED;
+            }
+            break;
+
+        case SENML_LABEL_BASE_VERSION:
+        {
+            lwm2m_data_t version;
+
+            if (bverSeen) return -1;
+            bverSeen = true;
+            memset(&version, 0, sizeof(version));
+            if (!prv_readNumber(buffer, bufferLen, indexP, &version)) return -1;
+            /* Only the default version (10) is supported */
+            if (version.type != LWM2M_TYPE_INTEGER || version.value.asInteger != 10) return -1;
+            break;
+        }
+
+        case SENML_LABEL_NAME:
+            if (name) return -1;
+            if (!prv_readString(buffer, bufferLen, indexP, CBOR_MAJOR_TEXT, &name, &nameLength)) return -1;
+            break;
+
+        case SENML_LABEL_TIME:
+            if (timeSeen) return -1;
+            timeSeen = true;
+            if (!prv_readTime(buffer, bufferLen, indexP, &recordP->time)) return -1;
+            break;
+
+        case SENML_LABEL_VALUE:
+            if (recordP->value.type != LWM2M_TYPE_UNDEFINED) return -1;
+            if (!prv_readNumber(buffer, bufferLen, indexP, &recordP->value)) return -1;
+            break;
+
+        case SENML_LABEL_BOOLEAN_VALUE:
+        {
+            uint8_t valueMajor;
+            uint8_t valueInfo;
+            uint64_t value;
+
+            if (recordP->value.type != LWM2M_TYPE_UNDEFINED) return -1;
+            if (!prv_readHead(buffer, bufferLen, indexP, &valueMajor, &valueInfo, &value)) return -1;
+            if (valueMajor != CBOR_MAJOR_SIMPLE) return -1;
+            if (valueInfo == CBOR_SIMPLE_TRUE)
+            {
+                lwm2m_data_encode_bool(true, &recordP->value);
+            }
+            else if (valueInfo == CBOR_SIMPLE_FALSE)
+            {
+                lwm2m_data_encode_bool(false, &recordP->value);
+            }
+            else
+            {
+                return -1;
+            }
+            break;
+        }
+
+        case SENML_LABEL_STRING_VALUE:
+        case SENML_LABEL_DATA_VALUE:
+            if (recordP->value.type != LWM2M_TYPE_UNDEFINED) return -1;
+            if (!prv_readString(buffer,
+                                bufferLen,
+                                indexP,
+                                label == SENML_LABEL_DATA_VALUE ? CBOR_MAJOR_BYTES : CBOR_MAJOR_TEXT,
+                                &string,
+                                &length))
+            {
+                return -1;
+            }
+            /* Don't use lwm2m_data_encode_nstring or lwm2m_data_encode_opaque here. They would copy the buffer */
+            recordP->value.type = label == SENML_LABEL_DATA_VALUE ? LWM2M_TYPE_OPAQUE : LWM2M_TYPE_STRING;
+            recordP->value.value.asBuffer.buffer = (uint8_t *)string;
+            recordP->value.value.asBuffer.length = length;
+            break;
+
+        default:
+            if (!prv_skipItem(buffer, bufferLen, indexP, 0)) return -1;
+            break;
+        }
+    }
+
+    if (info == CBOR_INFO_INDEFINITE) (*indexP)++;
+
+    /* Combine with base values */
+    recordP->time += *baseTime;
+    if (baseUri[0] || name)
+    {
+        lwm2m_uri_t uri;
+        size_t length = strlen(baseUri);
+        char uriStr[URI_MAX_STRING_LEN];
+        if (length > sizeof(uriStr)) return -1;
+        memcpy(uriStr, baseUri, length);
+        if (nameLength)
+        {
+            if (nameLength + length > sizeof(uriStr)) return -1;
+            memcpy(uriStr + length, name, nameLength);
+            length += nameLength;
+        }
+        if (!lwm2m_stringToUri(uriStr, length, &uri)) return -1;
+        if (LWM2M_URI_IS_SET_OBJECT(&uri))
+        {
+            recordP->ids[0] = uri.objectId;
+        }
+        if (LWM2M_URI_IS_SET_INSTANCE(&uri))
+        {
+            recordP->ids[1] = uri.instanceId;
+        }
+        if (LWM2M_URI_IS_SET_RESOURCE(&uri))
+        {
+            recordP->ids[2] = uri.resourceId;
+        }
+        if (LWM2M_URI_IS_SET_RESOURCE_INSTANCE(&uri))
+        {
+            recordP->ids[3] = uri.resourceInstanceId;
+        }
+    }
+    if (baseValue->type != LWM2M_TYPE_UNDEFINED)
+    {
+        if (!prv_addBaseValue(&recordP->value, baseValue)) return -1;
+    }
+
+    return 0;
+}
+
+static bool prv_convertValue(const _record_t * recordP,
+                             lwm2m_data_t * targetP)
+{
+    switch (recordP->value.type)
+    {
+    case LWM2M_TYPE_STRING:
+        lwm2m_data_encode_nstring((const char *)recordP->value.value.asBuffer.buffer,
+                                  recordP->value.value.asBuffer.length,
+                                  targetP);
+        break;
+    case LWM2M_TYPE_OPAQUE:
+        lwm2m_data_encode_opaque(recordP->value.value.asBuffer.buffer,
+                                 recordP->value.value.asBuffer.length,
+                                 targetP);
+        break;
+    default:
+        targetP->type = recordP->value.type;
+        memcpy(&targetP->value, &recordP->value.value, sizeof(targetP->value));
+        break;
+    case LWM2M_TYPE_OBJECT:
+    case LWM2M_TYPE_OBJECT_INSTANCE:
+    case LWM2M_TYPE_MULTIPLE_RESOURCE:
+    case LWM2M_TYPE_CORE_LINK:
+        /* Should never happen */
+        return false;
+    }
+
+    return true;
+}
+
+static int prv_convertRecord(const _record_t * recordArray,
+                             int count,
+                             lwm2m_data_t ** dataP)
+{
+    int index;
+    int freeIndex;
+    lwm2m_data_t * rootP;
+
+    rootP = lwm2m_data_new(count);
+    if (NULL == rootP)
+    {
+        *dataP = NULL;
+        return -1;
+    }
+
+    freeIndex = 0;
+    for (index = 0 ; index < count ; index++)
+    {
+        lwm2m_data_t * targetP;
+        int i;
+
+        targetP = json_findDataItem(rootP, count, recordArray[index].ids[0]);
+        if (targetP == NULL)
+        {
+            targetP = rootP + freeIndex;
+            freeIndex++;
+            targetP->id = recordArray[index].ids[0];
+            targetP->type = LWM2M_TYPE_OBJECT;
+        }
+        if (recordArray[index].ids[1] != LWM2M_MAX_ID)
+        {
+            lwm2m_data_t * parentP;
+            uri_depth_t level;
+
+            parentP = targetP;
+            level = URI_DEPTH_OBJECT_INSTANCE;
+            for (i = 1 ; i <= 2 ; i++)
+            {
+                if (recordArray[index].ids[i] == LWM2M_MAX_ID) break;
+                targetP = json_findDataItem(parentP->value.asChildren.array,
+                                           parentP->value.asChildren.count,
+                                           recordArray[index].ids[i]);
+                if (targetP == NULL)
+                {
+                    targetP = json_extendData(parentP);
+                    if (targetP == NULL) goto error;
+                    targetP->id = recordArray[index].ids[i];
+                    targetP->type = utils_depthToDatatype(level);
+                }
+                level = json_decreaseLevel(level);
+                parentP = targetP;
+            }
+            if (recordArray[index].ids[3] != LWM2M_MAX_ID)
+            {
+                targetP->type = LWM2M_TYPE_MULTIPLE_RESOURCE;
+                targetP = json_extendData(targetP);
+                if (targetP == NULL) goto error;
+                targetP->id = recordArray[index].ids[3];
+                targetP->type = LWM2M_TYPE_UNDEFINED;
+            }
+        }
+
+        if (!prv_convertValue(recordArray + index, targetP)) goto error;
+    }
+
+    if (freeIndex < count)
+    {
+        *dataP = lwm2m_data_new(freeIndex);
+        if (*dataP == NULL) goto error;
+        memcpy(*dataP, rootP, freeIndex * sizeof(lwm2m_data_t));
+        lwm2m_free(rootP);     /* do not use lwm2m_data_free() to keep pointed values */
+    }
+    else
+    {
+        *dataP = rootP;
+    }
+
+    return freeIndex;
+
+error:
+    lwm2m_data_free(count, rootP);
+    *dataP = NULL;
+
+    return -1;
+}
+
+int senml_cbor_parse(const lwm2m_uri_t * uriP,
+                     const uint8_t * buffer,
+                     size_t bufferLen,
+                     lwm2m_data_t ** dataP)
+{
+    size_t index;
+    size_t itemsIndex;
+    uint8_t major;
+    uint8_t info;
+    uint64_t items;
+    int count = 0;
+    _record_t * recordArray;
+    lwm2m_data_t * parsedP;
+    int recordIndex;
+    char baseUri[URI_MAX_STRING_LEN + 1];
+    time_t baseTime;
+    lwm2m_data_t baseValue;
+
+    LOG_ARG("bufferLen: %d", bufferLen);
+    LOG_URI(uriP);
+    *dataP = NULL;
+    recordArray = NULL;
+    parsedP = NULL;
+
+    index = 0;
+    if (!prv_readHead(buffer, bufferLen, &index, &major, &info, &items)) return -1;
+    if (major != CBOR_MAJOR_ARRAY) return -1;
+
+    if (info == CBOR_INFO_INDEFINITE)
+    {
+        /* Count the records before allocating them */
+        itemsIndex = index;
+        items = 0;
+        while (!prv_isBreak(buffer, bufferLen, itemsIndex))
+        {
+            if (!prv_skipItem(buffer, bufferLen, &itemsIndex, 0)) return -1;
+            items++;
+        }
+    }
+    /* Each record takes at least one byte */
+    if (items == 0 || items > bufferLen - index) return -1;
+    count = (int)items;
+
+    recordArray = (_record_t*)lwm2m_malloc(count * sizeof(_record_t));
+    if (recordArray == NULL) goto error;
+    baseUri[0] = '\0';
+    baseTime = 0;
+    memset(&baseValue, 0, sizeof(baseValue));
+    for (recordIndex = 0 ; recordIndex < count ; recordIndex++)
+    {
+        if (prv_parseItem(buffer,
+                          bufferLen,
+                          &index,
+                          recordArray + recordIndex,
+                          baseUri,
+                          &baseTime,
+                          &baseValue))
+        {
+            goto error;
+        }
+    }
+
+    if (info == CBOR_INFO_INDEFINITE) index++;
+    if (index != bufferLen) goto error;
+
+    lwm2m_data_t * resultP;
+    int size;
+
+    count = prv_convertRecord(recordArray, count, &parsedP);
+    lwm2m_free(recordArray);
+    recordArray = NULL;
+
+    if (count > 0 && uriP != NULL && LWM2M_URI_IS_SET_OBJECT(uriP))
+    {
+        if (parsedP->type != LWM2M_TYPE_OBJECT) goto error;
+        if (parsedP->id != uriP->objectId) goto error;
+        if (!LWM2M_URI_IS_SET_INSTANCE(uriP))
+        {
+            size = parsedP->value.asChildren.count;
+            resultP = parsedP->value.asChildren.array;
+        }
+        else
+        {
+            int i;
+
+            resultP = NULL;
+            /* be permissive and allow full object CBOR when requesting for a single instance */
+            for (i = 0 ;
+                 i < (int)parsedP->value.asChildren.count && resultP == NULL;
+                 i++)
+            {
+                lwm2m_data_t * targetP;
+
+                targetP = parsedP->value.asChildren.array + i;
+                if (targetP->id == uriP->instanceId)
+                {
+                    resultP = targetP->value.asChildren.array;
+                    size = targetP->value.asChildren.count;
+                }
+            }
+            if (resultP == NULL) goto error;
+            if (LWM2M_URI_IS_SET_RESOURCE(uriP))
+            {
+                lwm2m_data_t * resP;
+
+                resP = NULL;
+                for (i = 0 ; i < size && resP == NULL; i++)
+                {
+                    lwm2m_data_t * targetP;
+
+                    targetP = resultP + i;
+                    if (targetP->id == uriP->resourceId)
+                    {
+                        if (targetP->type == LWM2M_TYPE_MULTIPLE_RESOURCE
+                         && LWM2M_URI_IS_SET_RESOURCE_INSTANCE(uriP))
+                        {
+                            resP = targetP->value.asChildren.array;
+                            size = targetP->value.asChildren.count;
+                        }
+                        else
+                        {
+                            size = json_dataStrip(1, targetP, &resP);
+                            if (size <= 0) goto error;
+                            lwm2m_data_free(count, parsedP);
+                            parsedP = NULL;
+                        }
+                    }
+                }
+                if (resP == NULL) goto error;
+                resultP = resP;
+            }
+            if (LWM2M_URI_IS_SET_RESOURCE_INSTANCE(uriP))
+            {
+                lwm2m_data_t * resP;
+
+                resP = NULL;
+                for (i = 0 ; i < size && resP == NULL; i++)
+                {
+                    lwm2m_data_t * targetP;
+
+                    targetP = resultP + i;
+                    if (targetP->id == uriP->resourceInstanceId)
+                    {
+                        size = json_dataStrip(1, targetP, &resP);
+                        if (size <= 0) goto error;
+                        lwm2m_data_free(count, parsedP);
+                        parsedP = NULL;
+                    }
+                }
+                if (resP == NULL) goto error;
+                resultP = resP;
+            }
+        }
+    }
+    else
+    {
+        resultP = parsedP;
+        size = count;
+    }
+
+    if (parsedP != NULL)
+    {
+        lwm2m_data_t * tempP;
+
+        size = json_dataStrip(size, resultP, &tempP);
+        if (size <= 0) goto error;
+        lwm2m_data_free(count, parsedP);
+        resultP = tempP;
+    }
+    count = size;
+    *dataP = resultP;
+
+    LOG_ARG("Parsing successful. count: %d", count);
+    return count;
+
+error:
+    LOG("Parsing failed");
+    if (parsedP != NULL)
+    {
+        lwm2m_data_free(count, parsedP);
+        parsedP = NULL;
+    }
+    if (recordArray != NULL)
+    {
+        lwm2m_free(recordArray);
+    }
+    return -1;
+}
+
+static bool prv_write(_writer_t * writerP,
+                      const uint8_t * data,
+                      size_t length)
+{
+    if (writerP->buffer != NULL)
+    {
+        if (writerP->length - writerP->head < length) return false;
+        memcpy(writerP->buffer + writerP->head, data, length);
+    }
+    writerP->head += length;
+
+    return true;
+}
+
+static bool prv_writeHead(_writer_t * writerP,
+                          uint8_t major,
+                          uint64_t value)
+{
+    uint8_t head[9];
+    size_t size;
+    size_t i;
+
+    if (value < CBOR_INFO_UINT8)
+    {
+        head[0] = (major << 5) | (uint8_t)value;
+        return prv_write(writerP, head, 1);
+    }
+
+    if (value <= UINT8_MAX)
+    {
+        head[0] = (major << 5) | CBOR_INFO_UINT8;
+        size = 1;
+    }
+    else if (value <= UINT16_MAX)
+    {
+        head[0] = (major << 5) | CBOR_INFO_UINT16;
+        size = 2;
+    }
+    else if (value <= UINT32_MAX)
+    {
+        head[0] = (major << 5) | CBOR_INFO_UINT32;
+        size = 4;
+    }
+    else
+    {
+        head[0] = (major << 5) | CBOR_INFO_UINT64;
+        size = 8;
+    }
+    for (i = 0 ; i < size ; i++)
+    {
+        head[size - i] = (uint8_t)(value >> (8 * i));
+    }
+
+    return prv_write(writerP, head, size + 1);
+}
+
+static bool prv_writeInt(_writer_t * writerP,
+                         int64_t value)
+{
+    if (value < 0)
+    {
+        return prv_writeHead(writerP, CBOR_MAJOR_NEGATIVE, (uint64_t)(-1 - value));
+    }
+    return prv_writeHead(writerP, CBOR_MAJOR_UNSIGNED, (uint64_t)value);
+}
+
+static bool prv_writeString(_writer_t * writerP,
+                            uint8_t major,
+                            const uint8_t * string,
+                            size_t length)
+{
+    if (!prv_writeHead(writerP, major, length)) return false;
+    return prv_write(writerP, string, length);
+}
+
+static bool prv_writeFloat(_writer_t * writerP,
+                           double value)
+{
+    uint8_t buffer[9];
+    size_t size;
+    size_t i;
+    float single = (float)value;
+
+    /* Use the shortest encoding that keeps the value */
+    if ((double)single == value)
+    {
+        uint32_t bits;
+
+        memcpy(&bits, &single, sizeof(bits));
+        buffer[0] = (CBOR_MAJOR_SIMPLE << 5) | CBOR_SIMPLE_FLOAT;
+        size = 4;
+        for (i = 0 ; i < size ; i++)
+        {
+            buffer[size - i] = (uint8_t)(bits >> (8 * i));
+        }
+    }
+    else
+    {
+        uint64_t bits;
+
+        memcpy(&bits, &value, sizeof(bits));
+        buffer[0] = (CBOR_MAJOR_SIMPLE << 5) | CBOR_SIMPLE_DOUBLE;
+        size = 8;
+        for (i = 0 ; i < size ; i++)
+        {
+            buffer[size - i] = (uint8_t)(bits >> (8 * i));
+        }
+    }
+
+    return prv_write(writerP, buffer, size + 1);
+}
+
+static bool prv_serializeValue(_writer_t * writerP,
+                               const lwm2m_data_t * tlvP)
+{
+    switch (tlvP->type)
+    {
+    case LWM2M_TYPE_STRING:
+    case LWM2M_TYPE_CORE_LINK:
+        if (!prv_writeInt(writerP, SENML_LABEL_STRING_VALUE)) return false;
+        return prv_writeString(writerP,
+                               CBOR_MAJOR_TEXT,
+                               tlvP->value.asBuffer.buffer,
+                               tlvP->value.asBuffer.length);
+
+    case LWM2M_TYPE_INTEGER:
+    {
+        int64_t value;
+
+        if (0 == lwm2m_data_decode_int(tlvP, &value)) return false;
+        if (!prv_writeInt(writerP, SENML_LABEL_VALUE)) return false;
+        return prv_writeInt(writerP, value);
+    }
+
+    case LWM2M_TYPE_UNSIGNED_INTEGER:
+    {
+        uint64_t value;
+
+        if (0 == lwm2m_data_decode_uint(tlvP, &value)) return false;
+        if (!prv_writeInt(writerP, SENML_LABEL_VALUE)) return false;
+        return prv_writeHead(writerP, CBOR_MAJOR_UNSIGNED, value);
+    }
+
+    case LWM2M_TYPE_FLOAT:
+    {
+        double value;
+
+        if (0 == lwm2m_data_decode_float(tlvP, &value)) return false;
+        if (!prv_writeInt(writerP, SENML_LABEL_VALUE)) return false;
+        return prv_writeFloat(writerP, value);
+    }
+
+    case LWM2M_TYPE_BOOLEAN:
+    {
+        bool value;
+        uint8_t simple;
+
+        if (0 == lwm2m_data_decode_bool(tlvP, &value)) return false;
+        if (!prv_writeInt(writerP, SENML_LABEL_BOOLEAN_VALUE)) return false;
+        simple = (CBOR_MAJOR_SIMPLE << 5) | (value ? CBOR_SIMPLE_TRUE : CBOR_SIMPLE_FALSE);
+        return prv_write(writerP, &simple, 1);
+    }
+
+    case LWM2M_TYPE_OPAQUE:
+        if (!prv_writeInt(writerP, SENML_LABEL_DATA_VALUE)) return false;
+        return prv_writeString(writerP,
+                               CBOR_MAJOR_BYTES,
+                               tlvP->value.asBuffer.buffer,
+                               tlvP->value.asBuffer.length);
+
+    case LWM2M_TYPE_OBJECT_LINK:
+    {
+        uint8_t link[URI_MAX_STRING_LEN];
+        size_t length;
+
+        length = utils_objLinkToText(tlvP->value.asObjLink.objectId,
+                                     tlvP->value.asObjLink.objectInstanceId,
+                                     link,
+                                     sizeof(link));
+        if (!length) return false;
+        if (!prv_writeString(writerP,
+                             CBOR_MAJOR_TEXT,
+                             (const uint8_t *)SENML_LABEL_OBJECT_LINK,
+                             SENML_LABEL_OBJECT_LINK_SIZE))
+        {
+            return false;
+        }
+        return prv_writeString(writerP, CBOR_MAJOR_TEXT, link, length);
+    }
+
+    default:
+        return false;
+    }
+}
+
+static int prv_countRecords(int size,
+                            const lwm2m_data_t * tlvP)
+{
+    int count = 0;
+    int index;
+
+    for (index = 0 ; index < size ; index++)
+    {
+        switch (tlvP[index].type)
+        {
+        case LWM2M_TYPE_MULTIPLE_RESOURCE:
+        case LWM2M_TYPE_OBJECT:
+        case LWM2M_TYPE_OBJECT_INSTANCE:
+            count += prv_countRecords(tlvP[index].value.asChildren.count,
+                                      tlvP[index].value.asChildren.array);
+            break;
+
+        default:
+            count++;
+            break;
+        }
+    }
+
+    return count;
+}
+
+static bool prv_serializeData(_writer_t * writerP,
+                              const lwm2m_data_t * tlvP,
+                              const uint8_t * baseUriStr,
+                              size_t baseUriLen,
+                              uri_depth_t baseLevel,
+                              const uint8_t * parentUriStr,
+                              size_t parentUriLen,
+                              uri_depth_t level,
+                              bool *baseNameOutput)
+{
+    uint8_t uriStr[URI_MAX_STRING_LEN];
+    size_t uriLen;
+    size_t res;
+
+    if (parentUriLen > 0)
+    {
+        if (URI_MAX_STRING_LEN < parentUriLen) return false;
+        memcpy(uriStr, parentUriStr, parentUriLen);
+    }
+    uriLen = parentUriLen;
+    res = utils_intToText(tlvP->id,
+                          uriStr + uriLen,
+                          URI_MAX_STRING_LEN - uriLen);
+    if (res == 0) return false;
+    uriLen += res;
+
+    switch (tlvP->type)
+    {
+    case LWM2M_TYPE_MULTIPLE_RESOURCE:
+    case LWM2M_TYPE_OBJECT:
+    case LWM2M_TYPE_OBJECT_INSTANCE:
+    {
+        size_t index;
+
+        if (uriLen >= URI_MAX_STRING_LEN) return false;
+        uriStr[uriLen++] = '/';
+
+        for (index = 0 ; index < tlvP->value.asChildren.count; index++)
+        {
+            if (!prv_serializeData(writerP,
+                                   tlvP->value.asChildren.array + index,
+                                   baseUriStr,
+                                   baseUriLen,
+                                   baseLevel,
+                                   uriStr,
+                                   uriLen,
+                                   level,
+                                   baseNameOutput))
+            {
+                return false;
+            }
+        }
+    }
+    break;
+
+    default:
+    {
+        bool withBaseName = !*baseNameOutput && baseUriLen > 0;
+        bool withName = !baseUriLen || level > baseLevel;
+        bool withValue = tlvP->type != LWM2M_TYPE_UNDEFINED;
+
+        if (!prv_writeHead(writerP,
+                           CBOR_MAJOR_MAP,
+                           (withBaseName ? 1 : 0) + (withName ? 1 : 0) + (withValue ? 1 : 0)))
+        {
+            return false;
+        }
+
+        if (withBaseName)
+        {
+            if (!prv_writeInt(writerP, SENML_LABEL_BASE_NAME)) return false;
+            if (!prv_writeString(writerP, CBOR_MAJOR_TEXT, baseUriStr, baseUriLen)) return false;
+            *baseNameOutput = true;
+        }
+
+        if (withName)
+        {
+            if (!prv_writeInt(writerP, SENML_LABEL_NAME)) return false;
+            if (!prv_writeString(writerP, CBOR_MAJOR_TEXT, uriStr, uriLen)) return false;
+        }
+
+        if (withValue)
+        {
+            if (!prv_serializeValue(writerP, tlvP)) return false;
+        }
+    }
+    break;
+    }
+
+    return true;
+}
+
+static bool prv_serializeRecords(_writer_t * writerP,
+                                 int num,
+                                 const lwm2m_data_t * targetP,
+                                 const uint8_t * baseUriStr,
+                                 size_t baseUriLen,
+                                 uri_depth_t baseLevel,
+                                 const uint8_t * parentUriStr,
+                                 size_t parentUriLen,
+                                 uri_depth_t rootLevel)
+{
+    bool baseNameOutput = false;
+    int index;
+
+    if (!prv_writeHead(writerP, CBOR_MAJOR_ARRAY, prv_countRecords(num, targetP))) return false;
+
+    for (index = 0 ; index < num ; index++)
+    {
+        if (!prv_serializeData(writerP,
+                               targetP + index,
+                               baseUriStr,
+                               baseUriLen,
+                               baseLevel,
+                               parentUriStr,
+                               parentUriLen,
+                               rootLevel,
+                               &baseNameOutput))
+        {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+int senml_cbor_serialize(const lwm2m_uri_t * uriP,
+                         int size,
+                         const lwm2m_data_t * tlvP,
+                         uint8_t ** bufferP)
+{
+    _writer_t writer;
+    uint8_t baseUriStr[URI_MAX_STRING_LEN];
+    int baseUriLen;
+    uri_depth_t rootLevel;
+    uri_depth_t baseLevel;
+    int num;
+    lwm2m_data_t * targetP;
+    const uint8_t *parentUriStr = NULL;
+    size_t parentUriLen = 0;
+
+    LOG_ARG("size: %d", size);
+    LOG_URI(uriP);
+    if (size != 0 && tlvP == NULL) return -1;
+
+    baseUriLen = uri_toString(uriP, baseUriStr, URI_MAX_STRING_LEN, &baseLevel);
+    if (baseUriLen < 0) return -1;
+    if (baseUriLen > 1
+     && baseLevel != URI_DEPTH_RESOURCE
+     && baseLevel != URI_DEPTH_RESOURCE_INSTANCE)
+    {
+        if (baseUriLen >= URI_MAX_STRING_LEN -1) return 0;
+        baseUriStr[baseUriLen++] = '/';
+    }
+
+    num = json_findAndCheckData(uriP, baseLevel, size, tlvP, &targetP, &rootLevel);
+    if (num < 0) return -1;
+
+    if (baseLevel < rootLevel
+     && baseUriLen > 1
+     && baseUriStr[baseUriLen - 1] != '/')
+    {
+        if (baseUriLen >= URI_MAX_STRING_LEN -1) return 0;
+        baseUriStr[baseUriLen++] = '/';
+    }
+
+    if (!baseUriLen || baseUriStr[baseUriLen - 1] != '/')
+    {
+        parentUriStr = (const uint8_t *)"/";
+        parentUriLen = 1;
+    }
+
+    /* The first pass measures the payload, the second one writes it */
+    memset(&writer, 0, sizeof(writer));
+    if (!prv_serializeRecords(&writer, num, targetP,
+                              baseUriStr, baseUriLen, baseLevel,
+                              parentUriStr, parentUriLen, rootLevel))
+    {
+        return -1;
+    }
+
+    writer.buffer = (uint8_t *)lwm2m_malloc(writer.head);
+    if (writer.buffer == NULL) return -1;
+    writer.length = writer.head;
+    writer.head = 0;
+    if (!prv_serializeRecords(&writer, num, targetP,
+                              baseUriStr, baseUriLen, baseLevel,
+                              parentUriStr, parentUriLen, rootLevel))
+    {
+        lwm2m_free(writer.buffer);
+        return -1;
+    }
+
+    *bufferP = writer.buffer;
+
+    return (int)writer.head;
+}
+
+#endif
@@ -0,0 +1,383 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// Tests of the DTLS sessions of the certificate security mode, against an
+// OpenSSL DTLS server that requires a client certificate. The datagrams are
+// exchanged in memory and the certificates are made by the tests. Run under
+// AddressSanitizer, see run.sh.
+
+#include "dtls_x509.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <openssl/ec.h>
+#include <openssl/err.h>
+#include <openssl/ssl.h>
+#include <openssl/x509v3.h>
+
+#define MAX_DATAGRAMS 32
+#define MAX_DATAGRAM 16384
+
+static int failures = 0;
+
+#define CHECK(cond) do { \
+        if (!(cond)) { \
+            printf("%s:%d: %s: check failed: %s\n", __FILE__, __LINE__, __func__, #cond); \
+            failures++; \
+        } \
+    } while (0)
+
+// A key and a certificate, with their DER encodings for the client
+typedef struct
+{
+    EVP_PKEY *      key;
+    X509 *          cert;
+    unsigned char * keyDer;
+    int             keyDerLength;
+    unsigned char * certDer;
+    int             certDerLength;
+} identity_t;
+
+typedef struct
+{
+    uint8_t data[MAX_DATAGRAM];
+    size_t  length;
+} datagram_t;
+
+// The server and the datagrams sent to it by the client
+typedef struct
+{
+    SSL_CTX *  ctx;
+    SSL *      ssl;
+    BIO *      in;
+    BIO *      out;
+    datagram_t queue[MAX_DATAGRAMS];
+    int        queued;
+    char       received[256];
+    bool       failed;
+} server_t;
+
+// The data passed to the receive callback of the client
+static char received[256];
+
+static int prv_send(void * userData, uint8_t * buffer, size_t length)
+{
+    server_t * serverP = (server_t *)userData;
+
+    if (serverP->queued == MAX_DATAGRAMS || length > MAX_DATAGRAM) return -1;
+    memcpy(serverP->queue[serverP->queued].data, buffer, length);
+    serverP->queue[serverP->queued].length = length;
+    serverP->queued++;
+    return length;
+}
+
+static void prv_receive(void * userData, uint8_t * buffer, size_t length)
+{
+    if (length >= sizeof(received)) length = sizeof(received) - 1;
+    memcpy(received, buffer, length);
+    received[length] = 0;
+}
+
+static EVP_PKEY * prv_new_key(void)
+{
+    EVP_PKEY * key = NULL;
+    EVP_PKEY_CTX * ctx;
+
+    ctx = EVP_PKEY_CTX_new_id(EVP_PKEY_EC, NULL);
+    EVP_PKEY_keygen_init(ctx);
+    EVP_PKEY_CTX_set_ec_paramgen_curve_nid(ctx, NID_X9_62_prime256v1);
+    EVP_PKEY_keygen(ctx, &key);
+    EVP_PKEY_CTX_free(ctx);
+
+    return key;
+}
+
+static void prv_add_extension(X509 * cert, X509 * issuer, int nid, const char * value)
+{
+    X509V3_CTX ctx;
+    X509_EXTENSION * ext;
+
+    X509V3_set_ctx(&ctx, issuer, cert, NULL, NULL, 0);
+    ext = X509V3_EXT_conf_nid(NULL, &ctx, nid, value);
+    X509_add_ext(cert, ext, -1);
+    X509_EXTENSION_free(ext);
+}
+
+// Make the identity, signed by the issuer or self-signed if it is NULL. The
+// subject alternative names are not added when names is NULL.
+static void prv_new_identity(identity_t * idP, const char * commonName, const char * names, identity_t * issuerP)
+{
+    static long serial = 1;
+    X509_NAME * name;
+
+    memset(idP, 0, sizeof(identity_t));
+    idP->key = prv_new_key();
+    idP->cert = X509_new();
+    X509_set_version(idP->cert, 2);
+    ASN1_INTEGER_set(X509_get_serialNumber(idP->cert), serial++);
+    X509_gmtime_adj(X509_getm_notBefore(idP->cert), -3600);
+    X509_gmtime_adj(X509_getm_notAfter(idP->cert), 3600);
+    X509_set_pubkey(idP->cert, idP->key);
+
+    name = X509_get_subject_name(idP->cert);
+    X509_NAME_add_entry_by_txt(name, "CN", MBSTRING_ASC, (const unsigned char *)commonName, -1, -1, 0);
+    if (issuerP == NULL)
+    {
+        X509_set_issuer_name(idP->cert, name);
+        prv_add_extension(idP->cert, idP->cert, NID_basic_constraints, "critical,CA:TRUE");
+        X509_sign(idP->cert, idP->key, EVP_sha256());
+    }
+    else
+    {
+        X509_set_issuer_name(idP->cert, X509_get_subject_name(issuerP->cert));
+        if (names != NULL) prv_add_extension(idP->cert, issuerP->cert, NID_subject_alt_name, names);
+        X509_sign(idP->cert, issuerP->key, EVP_sha256());
+    }
+
+    idP->keyDerLength = i2d_PrivateKey(idP->key, &idP->keyDer);
+    idP->certDerLength = i2d_X509(idP->cert, &idP->certDer);
+}
+
+static void prv_free_identity(identity_t * idP)
+{
+    EVP_PKEY_free(idP->key);
+    X509_free(idP->cert);
+    OPENSSL_free(idP->keyDer);
+    OPENSSL_free(idP->certDer);
+}
+
+static void prv_server_start(server_t * serverP, identity_t * serverIdP, identity_t * caP)
+{
+    memset(serverP, 0, sizeof(server_t));
+    serverP->ctx = SSL_CTX_new(DTLS_server_method());
+    SSL_CTX_use_certificate(serverP->ctx, serverIdP->cert);
+    SSL_CTX_use_PrivateKey(serverP->ctx, serverIdP->key);
+    X509_STORE_add_cert(SSL_CTX_get_cert_store(serverP->ctx), caP->cert);
+    SSL_CTX_set_verify(serverP->ctx, SSL_VERIFY_PEER | SSL_VERIFY_FAIL_IF_NO_PEER_CERT, NULL);
+
+    serverP->ssl = SSL_new(serverP->ctx);
+    serverP->in = BIO_new(BIO_s_mem());
+    serverP->out = BIO_new(BIO_s_mem());
+    BIO_set_mem_eof_return(serverP->in, -1);
+    BIO_set_mem_eof_return(serverP->out, -1);
+    SSL_set_bio(serverP->ssl, serverP->in, serverP->out);
+    SSL_set_options(serverP->ssl, SSL_OP_NO_QUERY_MTU);
+    DTLS_set_link_mtu(serverP->ssl, DTLS_X509_MTU);
+    SSL_set_accept_state(serverP->ssl);
+}
+
+static void prv_server_stop(server_t * serverP)
+{
+    SSL_free(serverP->ssl);
+    SSL_CTX_free(serverP->ctx);
+}
+
+// Handle the datagram read by the server
+static void prv_server_step(server_t * serverP)
+{
+    int result;
+
+    if (!SSL_is_init_finished(serverP->ssl))
+    {
+        result = SSL_do_handshake(serverP->ssl);
+        if (result <= 0 && SSL_get_error(serverP->ssl, result) != SSL_ERROR_WANT_READ)
+        {
+            ERR_clear_error();
+            serverP->failed = true;
+            return;
+        }
+    }
+    if (SSL_is_init_finished(serverP->ssl))
+    {
+        result = SSL_read(serverP->ssl, serverP->received, sizeof(serverP->received) - 1);
+        if (result > 0) serverP->received[result] = 0;
+    }
+}
+
+// Exchange the datagrams until both sides wait. Returns -1 if the client
+// failed to handle a datagram.
+static int prv_exchange(server_t * serverP, dtls_x509_session_t * sessionP)
+{
+    static uint8_t buffer[MAX_DATAGRAM * MAX_DATAGRAMS];
+    int i;
+    int result = 0;
+
+    for (i = 0 ; i < 20 ; i++)
+    {
+        int j;
+        int length;
+
+        for (j = 0 ; j < serverP->queued ; j++)
+        {
+            BIO_write(serverP->in, serverP->queue[j].data, serverP->queue[j].length);
+            prv_server_step(serverP);
+        }
+        serverP->queued = 0;
+
+        length = BIO_read(serverP->out, buffer, sizeof(buffer));
+        if (length <= 0) break;
+        if (dtls_x509_handle_packet(sessionP, buffer, length) != 0) result = -1;
+    }
+
+    return result;
+}
+
+static void prv_credentials(dtls_x509_credentials_t * credentialsP, identity_t * clientP, identity_t * caP, const char * host)
+{
+    credentialsP->certificate = clientP->certDer;
+    credentialsP->certificateLength = clientP->certDerLength;
+    credentialsP->privateKey = clientP->keyDer;
+    credentialsP->privateKeyLength = clientP->keyDerLength;
+    credentialsP->serverCertificate = caP->certDer;
+    credentialsP->serverCertificateLength = caP->certDerLength;
+    credentialsP->host = host;
+}
+
+static identity_t ca;
+static identity_t otherCA;
+static identity_t serverId;
+static identity_t client;
+
+static void test_exchange(const char * host)
+{
+    server_t server;
+    dtls_x509_credentials_t credentials;
+    dtls_x509_session_t * sessionP;
+
+    prv_server_start(&server, &serverId, &ca);
+    prv_credentials(&credentials, &client, &ca, host);
+    sessionP = dtls_x509_new(&credentials, prv_send, prv_receive, &server);
+    CHECK(sessionP != NULL);
+    if (sessionP == NULL) return;
+
+    // the data written during the handshake is dropped
+    CHECK(dtls_x509_write(sessionP, (uint8_t *)"dropped", 7) == 7);
+    CHECK(prv_exchange(&server, sessionP) == 0);
+    CHECK(dtls_x509_is_connected(sessionP));
+    CHECK(!server.failed);
+    CHECK(server.received[0] == 0);
+
+    CHECK(dtls_x509_write(sessionP, (uint8_t *)"ping", 4) == 4);
+    CHECK(prv_exchange(&server, sessionP) == 0);
+    CHECK(strcmp(server.received, "ping") == 0);
+
+    received[0] = 0;
+    CHECK(SSL_write(server.ssl, "pong", 4) == 4);
+    CHECK(prv_exchange(&server, sessionP) == 0);
+    CHECK(strcmp(received, "pong") == 0);
+
+    dtls_x509_free(sessionP);
+    prv_server_stop(&server);
+}
+
+static void test_rejected_server(identity_t * trustedP, const char * host)
+{
+    server_t server;
+    dtls_x509_credentials_t credentials;
+    dtls_x509_session_t * sessionP;
+
+    prv_server_start(&server, &serverId, &ca);
+    prv_credentials(&credentials, &client, trustedP, host);
+    sessionP = dtls_x509_new(&credentials, prv_send, prv_receive, &server);
+    CHECK(sessionP != NULL);
+    if (sessionP == NULL) return;
+
+    CHECK(dtls_x509_write(sessionP, (uint8_t *)"dropped", 7) == 7);
+    CHECK(prv_exchange(&server, sessionP) == -1);
+    CHECK(!dtls_x509_is_connected(sessionP));
+    CHECK(server.received[0] == 0);
+    ERR_clear_error();
+
+    dtls_x509_free(sessionP);
+    prv_server_stop(&server);
+}
+
+static void test_closed(void)
+{
+    server_t server;
+    dtls_x509_credentials_t credentials;
+    dtls_x509_session_t * sessionP;
+
+    prv_server_start(&server, &serverId, &ca);
+    prv_credentials(&credentials, &client, &ca, "localhost");
+    sessionP = dtls_x509_new(&credentials, prv_send, prv_receive, &server);
+    CHECK(sessionP != NULL);
+    if (sessionP == NULL) return;
+
+    dtls_x509_write(sessionP, (uint8_t *)"dropped", 7);
+    CHECK(prv_exchange(&server, sessionP) == 0);
+    CHECK(dtls_x509_is_connected(sessionP));
+
+    // the server closes the session, and the next write starts a handshake
+    SSL_shutdown(server.ssl);
+    CHECK(prv_exchange(&server, sessionP) == -1);
+    CHECK(!dtls_x509_is_connected(sessionP));
+
+    // the session sends to the same server, which is restarted
+    prv_server_stop(&server);
+    prv_server_start(&server, &serverId, &ca);
+    CHECK(dtls_x509_write(sessionP, (uint8_t *)"dropped", 7) == 7);
+    CHECK(prv_exchange(&server, sessionP) == 0);
+    CHECK(dtls_x509_is_connected(sessionP));
+    CHECK(dtls_x509_write(sessionP, (uint8_t *)"ping", 4) == 4);
+    CHECK(prv_exchange(&server, sessionP) == 0);
+    CHECK(strcmp(server.received, "ping") == 0);
+
+    dtls_x509_free(sessionP);
+    prv_server_stop(&server);
+}
+
+static void test_invalid_credentials(void)
+{
+    dtls_x509_credentials_t credentials;
+    uint8_t garbage[] = { 0x30, 0x03, 0x02, 0x01, 0x01 };
+
+    // the key does not match the certificate
+    prv_credentials(&credentials, &client, &ca, "localhost");
+    credentials.privateKey = serverId.keyDer;
+    credentials.privateKeyLength = serverId.keyDerLength;
+    CHECK(dtls_x509_new(&credentials, prv_send, prv_receive, NULL) == NULL);
+
+    prv_credentials(&credentials, &client, &ca, "localhost");
+    credentials.certificate = garbage;
+    credentials.certificateLength = sizeof(garbage);
+    CHECK(dtls_x509_new(&credentials, prv_send, prv_receive, NULL) == NULL);
+
+    prv_credentials(&credentials, &client, &ca, "localhost");
+    credentials.serverCertificate = garbage;
+    credentials.serverCertificateLength = sizeof(garbage);
+    CHECK(dtls_x509_new(&credentials, prv_send, prv_receive, NULL) == NULL);
+
+    ERR_clear_error();
+}
+
+int main(void)
+{
+    prv_new_identity(&ca, "Test CA", NULL, NULL);
+    prv_new_identity(&otherCA, "Other CA", NULL, NULL);
+    prv_new_identity(&serverId, "localhost", "DNS:localhost,IP:127.0.0.1", &ca);
+    prv_new_identity(&client, "client", NULL, &ca);
+
+    test_exchange("localhost");
+    test_exchange("127.0.0.1");
+    test_rejected_server(&otherCA, "localhost");
+    test_rejected_server(&ca, "server.example.com");
+    test_rejected_server(&ca, "127.0.0.2");
+    test_closed();
+    test_invalid_credentials();
+
+    prv_free_identity(&ca);
+    prv_free_identity(&otherCA);
+    prv_free_identity(&serverId);
+    prv_free_identity(&client);
+
+    if (failures > 0)
+    {
+        printf("%d checks failed\n", failures);
+        return 1;
+    }
+    printf("ok\n");
+    return 0;
+}
@@ -0,0 +1,83 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// Tests of the writes to the snap control object: only the config can be
+// written. The object is built in, with stubs of the Go callbacks. Run under
+// AddressSanitizer, see run.sh.
+
+#include "../src/object_snap_control.c"
+
+static int failures = 0;
+static int configured = 0;
+
+int GetSnapCount() { return 0; }
+int GetSnapInstanceID(GoInt index) { return -1; }
+char * SnapDelimited(GoInt id) { return strdup(""); }
+char * SnapControlConfig() { return strdup("{}"); }
+int GetSnapErrorCount() { return 0; }
+char * SnapErrorRead(GoInt index) { return strdup(""); }
+int SnapExecute(GoInt resourceId, char * args, int length) { return 0; }
+
+int SnapControlSetConfig(char * config, int length)
+{
+    configured++;
+    return 0;
+}
+
+#define CHECK(cond) do { \
+        if (!(cond)) { \
+            printf("%s:%d: %s: check failed: %s\n", __FILE__, __LINE__, __func__, #cond); \
+            failures++; \
+        } \
+    } while (0)
+
+static uint8_t prv_write_string(uint16_t resourceId, const char * value)
+{
+    lwm2m_data_t * dataP;
+    uint8_t result;
+
+    dataP = lwm2m_data_new(1);
+    dataP->id = resourceId;
+    lwm2m_data_encode_string(value, dataP);
+    result = prv_write(0, 1, dataP, NULL);
+    lwm2m_data_free(1, dataP);
+
+    return result;
+}
+
+static void test_write_config(void)
+{
+    configured = 0;
+    CHECK(prv_write_string(2, "{\"hello\": {}}") == COAP_204_CHANGED);
+    CHECK(configured == 1);
+}
+
+static void test_write_read_only(void)
+{
+    configured = 0;
+    CHECK(prv_write_string(0, "3") == COAP_405_METHOD_NOT_ALLOWED);
+    CHECK(prv_write_string(1, "hello") == COAP_405_METHOD_NOT_ALLOWED);
+    CHECK(prv_write_string(3, "error") == COAP_405_METHOD_NOT_ALLOWED);
+    CHECK(configured == 0);
+}
+
+static void test_write_unknown(void)
+{
+    CHECK(prv_write_string(4, "") == COAP_404_NOT_FOUND);
+    CHECK(prv_write_string(99, "") == COAP_404_NOT_FOUND);
+}
+
+int main(void)
+{
+    test_write_config();
+    test_write_read_only();
+    test_write_unknown();
+
+    if (failures > 0)
+    {
+        printf("%d checks failed\n", failures);
+        return 1;
+    }
+    printf("ok\n");
+    return 0;
+}
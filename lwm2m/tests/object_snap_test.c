@@ -0,0 +1,112 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// Tests of the writes to the snap object: only the config key and the config
+// patch can be written. The object is built in, with stubs of the Go
+// callbacks. Run under AddressSanitizer, see run.sh.
+
+#include "../src/object_snap.c"
+
+// The instance ID of the only installed snap
+#define SNAP_INSTANCE 3
+
+static int failures = 0;
+static int written = 0;
+
+int GetSnapCount() { return 1; }
+int GetSnapInstanceID(GoInt index) { return index == 0 ? SNAP_INSTANCE : -1; }
+int SnapInstanceExecute(GoInt instanceId, GoInt resourceId, char * args, int length) { return 0; }
+
+char * SnapInstanceRead(GoInt instanceId, GoInt resourceId, int * valueType, int * length)
+{
+    *valueType = 0;
+    *length = 0;
+    return NULL;
+}
+
+int SnapInstanceWrite(GoInt instanceId, GoInt resourceId, char * value, int length)
+{
+    written++;
+    return 0;
+}
+
+int encode_value(int valueType, const char * value, int valueLength, lwm2m_data_t * dataP)
+{
+    lwm2m_data_encode_nstring(value, valueLength, dataP);
+    return 0;
+}
+
+#define CHECK(cond) do { \
+        if (!(cond)) { \
+            printf("%s:%d: %s: check failed: %s\n", __FILE__, __LINE__, __func__, #cond); \
+            failures++; \
+        } \
+    } while (0)
+
+static uint8_t prv_write_string(lwm2m_object_t * objectP, uint16_t instanceId, uint16_t resourceId, const char * value)
+{
+    lwm2m_data_t * dataP;
+    uint8_t result;
+
+    dataP = lwm2m_data_new(1);
+    dataP->id = resourceId;
+    lwm2m_data_encode_string(value, dataP);
+    result = prv_write(instanceId, 1, dataP, objectP);
+    lwm2m_data_free(1, dataP);
+
+    return result;
+}
+
+static void test_write_config(lwm2m_object_t * objectP)
+{
+    written = 0;
+    CHECK(prv_write_string(objectP, SNAP_INSTANCE, RES_CONFIG_KEY, "port") == COAP_204_CHANGED);
+    CHECK(prv_write_string(objectP, SNAP_INSTANCE, RES_CONFIG_PATCH, "{\"port\": 1883}") == COAP_204_CHANGED);
+    CHECK(written == 2);
+}
+
+static void test_write_read_only(lwm2m_object_t * objectP)
+{
+    uint16_t resources[] = {
+        0, 1, 2, 3, 4, 5, 6, 7, 8, 9,
+        11, 12, 13, 14, 15,
+        RES_CONFIG,
+        RES_CONFIG_VALUE,
+        RES_CONFIG_RESULT
+    };
+    size_t i;
+
+    written = 0;
+    for (i = 0 ; i < sizeof(resources)/sizeof(uint16_t) ; i++)
+    {
+        CHECK(prv_write_string(objectP, SNAP_INSTANCE, resources[i], "1") == COAP_405_METHOD_NOT_ALLOWED);
+    }
+    CHECK(written == 0);
+}
+
+static void test_write_unknown(lwm2m_object_t * objectP)
+{
+    written = 0;
+    CHECK(prv_write_string(objectP, SNAP_INSTANCE, 10, "") == COAP_404_NOT_FOUND);
+    CHECK(prv_write_string(objectP, SNAP_INSTANCE, 99, "") == COAP_404_NOT_FOUND);
+    CHECK(prv_write_string(objectP, SNAP_INSTANCE + 1, RES_CONFIG_KEY, "port") == COAP_404_NOT_FOUND);
+    CHECK(written == 0);
+}
+
+int main(void)
+{
+    lwm2m_object_t * objectP = get_snap_object();
+
+    test_write_config(objectP);
+    test_write_read_only(objectP);
+    test_write_unknown(objectP);
+    free_snap_object(objectP);
+
+    if (failures > 0)
+    {
+        printf("%d checks failed\n", failures);
+        return 1;
+    }
+    printf("ok\n");
+    return 0;
+}
@@ -0,0 +1,32 @@
+#!/bin/sh
+# Build the tests of the C code of the client with the sources of wakaama they
+# need, and run them under AddressSanitizer and UndefinedBehaviorSanitizer.
+set -e
+
+cd "$(dirname "$0")/.."
+
+CC=${CC:-gcc}
+OUT=$(mktemp -d)
+trap 'rm -rf "$OUT"' EXIT
+
+SOURCES="tests/stubs.c src/senml_cbor.c wakaama/data/data.c wakaama/data/json_common.c wakaama/data/json.c \
+    wakaama/data/senml_json.c wakaama/data/tlv.c \
+    wakaama/core/list.c wakaama/core/uri.c wakaama/core/utils.c wakaama/examples/shared/platform.c"
+
+# run_test <name> <sources>: build the test with the shared sources and run it
+run_test() {
+    name=$1
+    shift
+    $CC -g -fsanitize=address,undefined -fno-omit-frame-pointer -fno-sanitize-recover=all \
+        -Wno-incompatible-pointer-types -DLWM2M_CLIENT_MODE -DLWM2M_LITTLE_ENDIAN \
+        -DLWM2M_SUPPORT_TLV -DLWM2M_SUPPORT_JSON -DLWM2M_SUPPORT_SENML_JSON -DLWM2M_SUPPORT_SENML_CBOR \
+        -Isrc -Iwakaama/include -Iwakaama/core -Iwakaama/coap -Iwakaama/data -Iwakaama/examples/shared \
+        $SOURCES "$@" -o "$OUT/test"
+    echo "$name"
+    ASAN_OPTIONS="abort_on_error=1" "$OUT/test"
+}
+
+run_test "SenML CBOR" tests/senml_cbor_test.c
+run_test "Snap control object" tests/object_snap_control_test.c
+run_test "Snap object" tests/object_snap_test.c
+run_test "DTLS X.509" tests/dtls_x509_test.c src/dtls_x509.c -lssl -lcrypto
@@ -0,0 +1,371 @@
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// Tests of the SenML CBOR codec: the records serialized by the client are
+// parsed back, and the malformed payloads are rejected without reading past
+// their end. Run under AddressSanitizer, see run.sh.
+
+#include "internals.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int failures = 0;
+
+#define CHECK(cond) do { \
+        if (!(cond)) { \
+            printf("%s:%d: %s: check failed: %s\n", __FILE__, __LINE__, __func__, #cond); \
+            failures++; \
+        } \
+    } while (0)
+
+// Parse a copy of the payload that is exactly as long as the payload, so that
+// AddressSanitizer reports any read past its end
+static int prv_parse(const char * uri, const uint8_t * payload, size_t length, lwm2m_data_t ** dataP)
+{
+    lwm2m_uri_t uriStorage;
+    lwm2m_uri_t * uriP = NULL;
+    uint8_t * buffer;
+    int count;
+
+    if (uri != NULL)
+    {
+        lwm2m_stringToUri(uri, strlen(uri), &uriStorage);
+        uriP = &uriStorage;
+    }
+
+    buffer = (uint8_t *)malloc(length > 0 ? length : 1);
+    memcpy(buffer, payload, length);
+    count = senml_cbor_parse(uriP, buffer, length, dataP);
+    free(buffer);
+
+    return count;
+}
+
+static lwm2m_data_t * prv_find(lwm2m_data_t * dataP, int count, uint16_t id)
+{
+    int i;
+
+    for (i = 0 ; i < count ; i++)
+    {
+        if (dataP[i].id == id) return dataP + i;
+    }
+    return NULL;
+}
+
+// The resources of a device object instance, with every type of value
+static lwm2m_data_t * prv_device(int * countP)
+{
+    static const uint8_t opaque[] = { 0x00, 0x01, 0xFF };
+    lwm2m_data_t * dataP;
+    lwm2m_data_t * powerP;
+
+    dataP = lwm2m_data_new(7);
+    dataP[0].id = 0;
+    lwm2m_data_encode_string("Canonical", dataP + 0);
+    dataP[1].id = 9;
+    lwm2m_data_encode_int(-75, dataP + 1);
+    dataP[2].id = 13;
+    lwm2m_data_encode_int(5000000000LL, dataP + 2);
+    dataP[3].id = 20;
+    lwm2m_data_encode_float(3.25, dataP + 3);
+    dataP[4].id = 21;
+    lwm2m_data_encode_bool(true, dataP + 4);
+    dataP[5].id = 22;
+    lwm2m_data_encode_opaque(opaque, sizeof(opaque), dataP + 5);
+
+    powerP = lwm2m_data_new(2);
+    powerP[0].id = 0;
+    lwm2m_data_encode_int(3800, powerP + 0);
+    powerP[1].id = 1;
+    lwm2m_data_encode_objlink(30000, 4, powerP + 1);
+    dataP[6].id = 7;
+    lwm2m_data_encode_instances(powerP, 2, dataP + 6);
+
+    *countP = 7;
+    return dataP;
+}
+
+static void test_round_trip(void)
+{
+    lwm2m_uri_t uri;
+    lwm2m_data_t * dataP;
+    lwm2m_data_t * parsedP = NULL;
+    lwm2m_data_t * resourceP;
+    uint8_t * buffer = NULL;
+    int count;
+    int length;
+    int64_t i;
+    double f;
+    bool b;
+
+    dataP = prv_device(&count);
+    lwm2m_stringToUri("/3/0", 4, &uri);
+    length = senml_cbor_serialize(&uri, count, dataP, &buffer);
+    CHECK(length > 0);
+    if (length <= 0) goto exit;
+
+    count = prv_parse("/3/0", buffer, length, &parsedP);
+    CHECK(count == 7);
+    if (count != 7) goto exit;
+
+    resourceP = prv_find(parsedP, count, 0);
+    CHECK(resourceP != NULL && resourceP->type == LWM2M_TYPE_STRING
+          && resourceP->value.asBuffer.length == 9
+          && memcmp(resourceP->value.asBuffer.buffer, "Canonical", 9) == 0);
+    resourceP = prv_find(parsedP, count, 9);
+    CHECK(resourceP != NULL && lwm2m_data_decode_int(resourceP, &i) && i == -75);
+    resourceP = prv_find(parsedP, count, 13);
+    CHECK(resourceP != NULL && lwm2m_data_decode_int(resourceP, &i) && i == 5000000000LL);
+    resourceP = prv_find(parsedP, count, 20);
+    CHECK(resourceP != NULL && lwm2m_data_decode_float(resourceP, &f) && f == 3.25);
+    resourceP = prv_find(parsedP, count, 21);
+    CHECK(resourceP != NULL && lwm2m_data_decode_bool(resourceP, &b) && b);
+    resourceP = prv_find(parsedP, count, 22);
+    CHECK(resourceP != NULL && resourceP->type == LWM2M_TYPE_OPAQUE
+          && resourceP->value.asBuffer.length == 3
+          && memcmp(resourceP->value.asBuffer.buffer, "\x00\x01\xFF", 3) == 0);
+
+    resourceP = prv_find(parsedP, count, 7);
+    CHECK(resourceP != NULL && resourceP->type == LWM2M_TYPE_MULTIPLE_RESOURCE
+          && resourceP->value.asChildren.count == 2);
+    if (resourceP != NULL && resourceP->type == LWM2M_TYPE_MULTIPLE_RESOURCE)
+    {
+        lwm2m_data_t * childP;
+
+        childP = prv_find(resourceP->value.asChildren.array, resourceP->value.asChildren.count, 0);
+        CHECK(childP != NULL && lwm2m_data_decode_int(childP, &i) && i == 3800);
+        childP = prv_find(resourceP->value.asChildren.array, resourceP->value.asChildren.count, 1);
+        CHECK(childP != NULL && childP->type == LWM2M_TYPE_OBJECT_LINK
+              && childP->value.asObjLink.objectId == 30000
+              && childP->value.asObjLink.objectInstanceId == 4);
+    }
+
+exit:
+    lwm2m_data_free(count, parsedP);
+    lwm2m_data_free(7, dataP);
+    lwm2m_free(buffer);
+}
+
+// Every prefix of a valid payload is rejected
+static void test_truncated(void)
+{
+    lwm2m_uri_t uri;
+    lwm2m_data_t * dataP;
+    lwm2m_data_t * parsedP;
+    uint8_t * buffer = NULL;
+    int count;
+    int length;
+    int i;
+
+    dataP = prv_device(&count);
+    lwm2m_stringToUri("/3/0", 4, &uri);
+    length = senml_cbor_serialize(&uri, count, dataP, &buffer);
+    CHECK(length > 0);
+
+    for (i = 0 ; i < length ; i++)
+    {
+        count = prv_parse("/3/0", buffer, i, &parsedP);
+        if (count != -1)
+        {
+            printf("%s: a payload truncated to %d of %d bytes was parsed\n", __func__, i, length);
+            failures++;
+            lwm2m_data_free(count, parsedP);
+        }
+        CHECK(parsedP == NULL);
+    }
+
+    lwm2m_data_free(7, dataP);
+    lwm2m_free(buffer);
+}
+
+// [{-2: "/3/0/", 0: "0", 3: "Open Mobile Alliance"}]
+static const uint8_t manufacturer[] = {
+    0x81, 0xA3,
+    0x21, 0x65, '/', '3', '/', '0', '/',
+    0x00, 0x61, '0',
+    0x03, 0x74, 'O', 'p', 'e', 'n', ' ', 'M', 'o', 'b', 'i', 'l', 'e', ' ',
+                'A', 'l', 'l', 'i', 'a', 'n', 'c', 'e'
+};
+
+static void test_known_payload(void)
+{
+    lwm2m_data_t * parsedP;
+    lwm2m_data_t * resourceP;
+    int count;
+
+    count = prv_parse("/3/0", manufacturer, sizeof(manufacturer), &parsedP);
+    CHECK(count == 1);
+    if (count != 1) return;
+
+    resourceP = prv_find(parsedP, count, 0);
+    CHECK(resourceP != NULL && resourceP->type == LWM2M_TYPE_STRING
+          && resourceP->value.asBuffer.length == 20
+          && memcmp(resourceP->value.asBuffer.buffer, "Open Mobile Alliance", 20) == 0);
+    lwm2m_data_free(count, parsedP);
+
+    // Trailing bytes after the array
+    {
+        uint8_t trailing[sizeof(manufacturer) + 1];
+
+        memcpy(trailing, manufacturer, sizeof(manufacturer));
+        trailing[sizeof(manufacturer)] = 0x00;
+        CHECK(prv_parse("/3/0", trailing, sizeof(trailing), &parsedP) == -1);
+    }
+}
+
+static void test_indefinite_lengths(void)
+{
+    // [_ {_ -2: "/3/0/", 0: "9", 2: 42}]
+    static const uint8_t indefinite[] = {
+        0x9F, 0xBF,
+        0x21, 0x65, '/', '3', '/', '0', '/',
+        0x00, 0x61, '9',
+        0x02, 0x18, 0x2A,
+        0xFF, 0xFF
+    };
+    // [{0: (_ "/3/0/", "9"), 2: 42}]
+    static const uint8_t indefiniteString[] = {
+        0x81, 0xA2,
+        0x00, 0x7F, 0x66, '/', '3', '/', '0', '/', '9', 0xFF,
+        0x02, 0x18, 0x2A
+    };
+    // [_ {0: "/3/0/9", 2: 42}] without the break of the array
+    static const uint8_t unterminated[] = {
+        0x9F, 0xA2,
+        0x00, 0x66, '/', '3', '/', '0', '/', '9',
+        0x02, 0x18, 0x2A
+    };
+    // A break outside of an indefinite length item
+    static const uint8_t strayBreak[] = { 0x81, 0xFF };
+    lwm2m_data_t * parsedP;
+    lwm2m_data_t * resourceP;
+    int64_t value;
+    int count;
+
+    count = prv_parse("/3/0", indefinite, sizeof(indefinite), &parsedP);
+    CHECK(count == 1);
+    if (count == 1)
+    {
+        resourceP = prv_find(parsedP, count, 9);
+        CHECK(resourceP != NULL && lwm2m_data_decode_int(resourceP, &value) && value == 42);
+        lwm2m_data_free(count, parsedP);
+    }
+
+    CHECK(prv_parse("/3/0", indefiniteString, sizeof(indefiniteString), &parsedP) == -1);
+    CHECK(prv_parse("/3/0", unterminated, sizeof(unterminated), &parsedP) == -1);
+    CHECK(prv_parse("/3/0", strayBreak, sizeof(strayBreak), &parsedP) == -1);
+}
+
+// Writes [{0: "/3/0/9", 2: 42, 9: {1: {1: ... {1: 0}}}}] with the given
+// nesting of the maps under the unknown label 9
+static size_t prv_nested(uint8_t * buffer, int depth)
+{
+    static const uint8_t head[] = {
+        0x81, 0xA3,
+        0x00, 0x66, '/', '3', '/', '0', '/', '9',
+        0x02, 0x18, 0x2A,
+        0x09
+    };
+    size_t length;
+    int i;
+
+    memcpy(buffer, head, sizeof(head));
+    length = sizeof(head);
+    for (i = 0 ; i < depth ; i++)
+    {
+        buffer[length++] = 0xA1;
+        buffer[length++] = 0x01;
+    }
+    buffer[length++] = 0x00;
+
+    return length;
+}
+
+static void test_nested_maps(void)
+{
+    // [{0: "/3/0/9", 2: {1: 2}}]
+    static const uint8_t mapValue[] = {
+        0x81, 0xA2,
+        0x00, 0x66, '/', '3', '/', '0', '/', '9',
+        0x02, 0xA1, 0x01, 0x02
+    };
+    // [[{0: "/3/0/9", 2: 42}]]
+    static const uint8_t nestedRecord[] = {
+        0x81, 0x81, 0xA2,
+        0x00, 0x66, '/', '3', '/', '0', '/', '9',
+        0x02, 0x18, 0x2A
+    };
+    uint8_t buffer[256];
+    lwm2m_data_t * parsedP;
+    size_t length;
+    int count;
+
+    CHECK(prv_parse("/3/0", mapValue, sizeof(mapValue), &parsedP) == -1);
+    CHECK(prv_parse("/3/0", nestedRecord, sizeof(nestedRecord), &parsedP) == -1);
+
+    // The maps of an unknown label are skipped, up to a maximum depth
+    length = prv_nested(buffer, 4);
+    count = prv_parse("/3/0", buffer, length, &parsedP);
+    CHECK(count == 1);
+    if (count > 0) lwm2m_data_free(count, parsedP);
+
+    length = prv_nested(buffer, 100);
+    CHECK(prv_parse("/3/0", buffer, length, &parsedP) == -1);
+}
+
+// An object instance without a value, as sent for a removed instance, is a
+// record with only a name: [{0: "/30001/5"}, {0: "/30000/0/0", 2: 3}]
+static void test_record_without_value(void)
+{
+    static const uint8_t expected[] = {
+        0x82,
+        0xA1, 0x00, 0x68, '/', '3', '0', '0', '0', '1', '/', '5',
+        0xA2, 0x00, 0x6A, '/', '3', '0', '0', '0', '0', '/', '0', '/', '0', 0x02, 0x03
+    };
+    lwm2m_data_t * dataP;
+    lwm2m_data_t * childP;
+    uint8_t * buffer = NULL;
+    int length;
+
+    dataP = lwm2m_data_new(2);
+    dataP[0].id = 30001;
+    childP = lwm2m_data_new(1);
+    childP->id = 5;
+    lwm2m_data_encode_instances(childP, 1, dataP + 0);
+    dataP[0].type = LWM2M_TYPE_OBJECT;
+
+    childP = lwm2m_data_new(1);
+    childP->id = 0;
+    lwm2m_data_encode_instances(lwm2m_data_new(1), 1, childP);
+    childP->type = LWM2M_TYPE_OBJECT_INSTANCE;
+    lwm2m_data_encode_int(3, childP->value.asChildren.array);
+    dataP[1].id = 30000;
+    lwm2m_data_encode_instances(childP, 1, dataP + 1);
+    dataP[1].type = LWM2M_TYPE_OBJECT;
+
+    length = senml_cbor_serialize(NULL, 2, dataP, &buffer);
+    CHECK(length == (int)sizeof(expected) && memcmp(buffer, expected, sizeof(expected)) == 0);
+
+    lwm2m_data_free(2, dataP);
+    lwm2m_free(buffer);
+}
+
+int main(void)
+{
+    test_round_trip();
+    test_truncated();
+    test_known_payload();
+    test_indefinite_lengths();
+    test_nested_maps();
+    test_record_without_value();
+
+    if (failures > 0)
+    {
+        printf("%d checks failed\n", failures);
+        return 1;
+    }
+    printf("ok\n");
+    return 0;
+}
@@ -0,0 +1,642 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CoAP over TCP and TLS (RFC 8323). The lwm2m library only speaks CoAP over
+// UDP, so the messages are translated between the two framings here, and the
+// keepalive and reconnection policy live with the connection.
+const (
+	transportKeepalive    = 30 * time.Second
+	transportPongTimeout  = 10 * time.Second
+	transportWriteTimeout = 10 * time.Second
+	transportMinBackoff   = time.Second
+	transportMaxBackoff   = 5 * time.Minute
+	transportMaxQueue     = 64
+	transportMaxMessage   = 65804 // the largest body with a 2 byte extended length
+)
+
+// Message types of the UDP framing
+const (
+	coapCON = 0
+	coapNON = 1
+	coapACK = 2
+	coapRST = 3
+)
+
+// Signaling codes of the TCP framing
+const (
+	coapCSM     = 0xe1 // 7.01
+	coapPing    = 0xe2 // 7.02
+	coapPong    = 0xe3 // 7.03
+	coapRelease = 0xe4 // 7.04
+	coapAbort   = 0xe5 // 7.05
+
+	coapOptionHoldOff = 4
+)
+
+// coapMessage is a CoAP message apart from the framing of its transport. The
+// type and message ID are only used by the UDP framing.
+type coapMessage struct {
+	Type  byte
+	Code  byte
+	MID   uint16
+	Token []byte
+	Body  []byte // the options and the payload
+}
+
+func (m coapMessage) isRequest() bool {
+	return m.Code > 0 && m.Code < 0x40
+}
+
+func (m coapMessage) isResponse() bool {
+	return m.Code >= 0x40 && m.Code < 0xe0
+}
+
+func parseUDPMessage(b []byte) (coapMessage, error) {
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return coapMessage{}, errors.New("invalid CoAP message")
+	}
+	tkl := int(b[0] & 0x0f)
+	if tkl > 8 || len(b) < 4+tkl {
+		return coapMessage{}, errors.New("invalid CoAP token")
+	}
+
+	return coapMessage{
+		Type:  (b[0] >> 4) & 0x03,
+		Code:  b[1],
+		MID:   binary.BigEndian.Uint16(b[2:4]),
+		Token: b[4 : 4+tkl],
+		Body:  b[4+tkl:],
+	}, nil
+}
+
+func (m coapMessage) marshalUDP() []byte {
+	b := make([]byte, 4, 4+len(m.Token)+len(m.Body))
+	b[0] = 1<<6 | m.Type<<4 | byte(len(m.Token))
+	b[1] = m.Code
+	binary.BigEndian.PutUint16(b[2:4], m.MID)
+	b = append(b, m.Token...)
+	return append(b, m.Body...)
+}
+
+// marshalTCP frames the message with the length of its options and payload
+func (m coapMessage) marshalTCP() []byte {
+	n := len(m.Body)
+
+	var b []byte
+	switch {
+	case n < 13:
+		b = []byte{byte(n) << 4}
+	case n < 269:
+		b = []byte{13 << 4, byte(n - 13)}
+	case n < 65805:
+		b = []byte{14 << 4, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n-269))
+	default:
+		b = []byte{15 << 4, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n-65805))
+	}
+	b[0] |= byte(len(m.Token))
+
+	b = append(b, m.Code)
+	b = append(b, m.Token...)
+	return append(b, m.Body...)
+}
+
+func readTCPMessage(r io.Reader) (coapMessage, error) {
+	var m coapMessage
+
+	head := make([]byte, 1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return m, err
+	}
+	n := int(head[0] >> 4)
+	tkl := int(head[0] & 0x0f)
+	if tkl > 8 {
+		return m, errors.New("invalid CoAP token")
+	}
+
+	// The extended length takes 1, 2 or 4 bytes
+	if n >= 13 {
+		ext := make([]byte, 1<<uint(n-13))
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return m, err
+		}
+		switch n {
+		case 13:
+			n = 13 + int(ext[0])
+		case 14:
+			n = 269 + int(binary.BigEndian.Uint16(ext))
+		default:
+			l := binary.BigEndian.Uint32(ext)
+			if l > transportMaxMessage {
+				return m, errors.New("CoAP message too large")
+			}
+			n = 65805 + int(l)
+		}
+	}
+	if n > transportMaxMessage {
+		return m, errors.New("CoAP message too large")
+	}
+
+	rest := make([]byte, 1+tkl+n)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return m, err
+	}
+	m.Code = rest[0]
+	m.Token = rest[1 : 1+tkl]
+	m.Body = rest[1+tkl:]
+	return m, nil
+}
+
+// option returns the value of the first option with the number
+func (m coapMessage) option(number int) ([]byte, bool) {
+	b := m.Body
+	current := 0
+	for len(b) > 0 && b[0] != 0xff {
+		delta, length := int(b[0]>>4), int(b[0]&0x0f)
+		b = b[1:]
+
+		var ok bool
+		if delta, b, ok = optionExtended(delta, b); !ok {
+			return nil, false
+		}
+		if length, b, ok = optionExtended(length, b); !ok || len(b) < length {
+			return nil, false
+		}
+
+		current += delta
+		if current == number {
+			return b[:length], true
+		}
+		b = b[length:]
+	}
+	return nil, false
+}
+
+// optionExtended decodes the extended option delta or length
+func optionExtended(v int, b []byte) (int, []byte, bool) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, b, false
+		}
+		return 13 + int(b[0]), b[1:], true
+	case 14:
+		if len(b) < 2 {
+			return 0, b, false
+		}
+		return 269 + int(binary.BigEndian.Uint16(b)), b[2:], true
+	case 15:
+		return 0, b, false
+	}
+	return v, b, true
+}
+
+func decodeUint(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// transport is a CoAP over TCP connection to a server, which is reconnected
+// with a backoff when it fails
+type transport struct {
+	id      int
+	uri     string
+	address string
+	tls     *tls.Config
+	done    chan struct{}
+
+	mu       sync.Mutex
+	conn     net.Conn
+	queue    [][]byte
+	requests map[string]uint16 // message IDs of the requests awaiting a response, by token
+	nextMID  uint16
+}
+
+type transportPacket struct {
+	id   int
+	data []byte
+}
+
+// transports holds the connections and the packets received for the lwm2m
+// library. The event loop is woken through a pipe, as it waits on a socket.
+var transports = struct {
+	sync.Mutex
+	nextID  int
+	conns   map[int]*transport
+	inbound []transportPacket
+	wakeR   int
+	wakeW   int
+	network string
+}{conns: map[int]*transport{}, wakeR: -1, wakeW: -1, network: "tcp"}
+
+// useTransportNetwork restricts the connections to an address family, with
+// tcp4 or tcp6
+func useTransportNetwork(network string) {
+	transports.Lock()
+	defer transports.Unlock()
+	transports.network = network
+}
+
+// connectTransport starts connecting to the server, and returns the ID of the transport
+func connectTransport(uri string, c Credentials) (int, error) {
+	u, err := url.Parse(uri)
+	if err != nil || len(u.Host) == 0 {
+		return 0, fmt.Errorf("invalid server URI: %s", uri)
+	}
+
+	t := &transport{
+		uri:      uri,
+		address:  u.Host,
+		done:     make(chan struct{}),
+		requests: map[string]uint16{},
+		nextMID:  uint16(time.Now().UnixNano()),
+	}
+
+	switch u.Scheme {
+	case "coap+tcp":
+		if len(u.Port()) == 0 {
+			t.address = net.JoinHostPort(u.Hostname(), "5683")
+		}
+	case "coaps+tcp":
+		if len(u.Port()) == 0 {
+			t.address = net.JoinHostPort(u.Hostname(), "5684")
+		}
+		if t.tls, err = transportTLSConfig(u.Hostname(), c); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported transport: %s", uri)
+	}
+
+	transports.Lock()
+	if transports.wakeR < 0 {
+		var fds [2]int
+		if err = syscall.Pipe2(fds[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+			transports.Unlock()
+			return 0, err
+		}
+		transports.wakeR, transports.wakeW = fds[0], fds[1]
+	}
+	transports.nextID++
+	t.id = transports.nextID
+	transports.conns[t.id] = t
+	transports.Unlock()
+
+	go t.run()
+	return t.id, nil
+}
+
+// transportTLSConfig authenticates the client with its X.509 certificate, and
+// the server with the certificate of its CA
+func transportTLSConfig(host string, c Credentials) (*tls.Config, error) {
+	if c.Mode != SecurityModeCertificate {
+		return nil, errors.New("CoAP over TLS needs an X.509 certificate")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(c.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(c.ServerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{c.PublicKeyOrIdentity},
+			PrivateKey:  key,
+		}},
+		RootCAs:    pool,
+		ServerName: host,
+		NextProtos: []string{"coap"},
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+func findTransport(id int) *transport {
+	transports.Lock()
+	defer transports.Unlock()
+	return transports.conns[id]
+}
+
+// closeTransport stops the connection, which is not reconnected
+func closeTransport(id int) {
+	transports.Lock()
+	t, ok := transports.conns[id]
+	delete(transports.conns, id)
+	transports.Unlock()
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	close(t.done)
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+	t.mu.Unlock()
+}
+
+// pushTransportPacket queues the packet for the lwm2m library. An empty packet
+// reports that the connection was re-established.
+func pushTransportPacket(id int, data []byte) {
+	transports.Lock()
+	defer transports.Unlock()
+
+	if len(transports.inbound) >= transportMaxQueue {
+		transports.inbound = transports.inbound[1:]
+	}
+	transports.inbound = append(transports.inbound, transportPacket{id: id, data: data})
+	syscall.Write(transports.wakeW, []byte{0})
+}
+
+func popTransportPacket() (transportPacket, bool) {
+	transports.Lock()
+	defer transports.Unlock()
+
+	if len(transports.inbound) == 0 {
+		return transportPacket{}, false
+	}
+	p := transports.inbound[0]
+	transports.inbound = transports.inbound[1:]
+	return p, true
+}
+
+func transportWakeFd() int {
+	transports.Lock()
+	defer transports.Unlock()
+	return transports.wakeR
+}
+
+func (t *transport) run() {
+	backoff := transportMinBackoff
+	connected := false
+
+	for {
+		conn, err := t.dial()
+		if err != nil {
+			log.Printf("Error connecting to %s, retrying in %s: %v\n", t.uri, backoff, err)
+			if !t.wait(backoff) {
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		if !t.attach(conn) {
+			conn.Close()
+			return
+		}
+		log.Printf("Connected to %s\n", t.uri)
+
+		if connected {
+			pushTransportPacket(t.id, nil)
+		}
+		connected = true
+
+		start := time.Now()
+		holdOff, err := t.serve(conn)
+		t.detach(conn)
+		log.Printf("Connection to %s closed: %v\n", t.uri, err)
+
+		// The backoff only grows while the connections fail quickly
+		if time.Since(start) > transportMaxBackoff {
+			backoff = transportMinBackoff
+		}
+		if holdOff < backoff {
+			holdOff = backoff
+		}
+		if !t.wait(holdOff) {
+			return
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > transportMaxBackoff {
+		return transportMaxBackoff
+	}
+	return backoff
+}
+
+// wait returns false when the transport is closed meanwhile
+func (t *transport) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *transport) dial() (net.Conn, error) {
+	transports.Lock()
+	network := transports.network
+	transports.Unlock()
+
+	d := &net.Dialer{Timeout: transportWriteTimeout, KeepAlive: transportKeepalive}
+	if t.tls != nil {
+		return tls.DialWithDialer(d, network, t.address, t.tls)
+	}
+	return d.Dial(network, t.address)
+}
+
+// attach starts using the connection, with the capabilities and settings
+// message that must come first, then the messages queued while disconnected
+func (t *transport) attach(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return false
+	default:
+	}
+
+	t.conn = conn
+	queue := t.queue
+	t.queue = nil
+
+	t.write(coapMessage{Code: coapCSM}.marshalTCP())
+	for _, frame := range queue {
+		t.write(frame)
+	}
+	return true
+}
+
+// detach stops using the connection. The requests awaiting a response are
+// sent again by the lwm2m library.
+func (t *transport) detach(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == conn {
+		t.conn = nil
+	}
+	t.requests = map[string]uint16{}
+	conn.Close()
+}
+
+// write sends the frame, or queues it while disconnected. The lock is held.
+func (t *transport) write(frame []byte) {
+	if t.conn != nil {
+		t.conn.SetWriteDeadline(time.Now().Add(transportWriteTimeout))
+		if _, err := t.conn.Write(frame); err == nil {
+			return
+		}
+
+		// The reader fails on the closed connection, and reconnects
+		t.conn.Close()
+		t.conn = nil
+	}
+
+	if len(t.queue) >= transportMaxQueue {
+		t.queue = t.queue[1:]
+	}
+	t.queue = append(t.queue, frame)
+}
+
+func (t *transport) signal(code byte, token []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.write(coapMessage{Code: code, Token: token}.marshalTCP())
+}
+
+// serve handles the messages from the server until the connection fails. The
+// server may ask for a delay before the client reconnects.
+func (t *transport) serve(conn net.Conn) (time.Duration, error) {
+	r := bufio.NewReader(conn)
+	pinged := false
+
+	for {
+		// An idle connection is checked with a ping
+		timeout := transportKeepalive
+		if pinged {
+			timeout = transportPongTimeout
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		if _, err := r.Peek(1); err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() && !pinged {
+				t.signal(coapPing, nil)
+				pinged = true
+				continue
+			}
+			return 0, err
+		}
+		pinged = false
+
+		conn.SetReadDeadline(time.Now().Add(transportWriteTimeout))
+		m, err := readTCPMessage(r)
+		if err != nil {
+			return 0, err
+		}
+
+		switch m.Code {
+		case coapCSM, coapPong:
+		case coapPing:
+			t.signal(coapPong, m.Token)
+		case coapRelease:
+			var holdOff time.Duration
+			if v, ok := m.option(coapOptionHoldOff); ok {
+				holdOff = time.Duration(decodeUint(v)) * time.Second
+			}
+			return holdOff, errors.New("released by the server")
+		case coapAbort:
+			return 0, errors.New("aborted by the server")
+		default:
+			t.receive(m)
+		}
+	}
+}
+
+// receive passes the message from the server to the lwm2m library in the UDP
+// framing. A response is the acknowledgement of the confirmable request.
+func (t *transport) receive(m coapMessage) {
+	t.mu.Lock()
+	switch {
+	case m.isRequest():
+		m.Type = coapCON
+		m.MID = t.newMID()
+	case m.isResponse():
+		if mid, ok := t.requests[string(m.Token)]; ok {
+			delete(t.requests, string(m.Token))
+			m.Type = coapACK
+			m.MID = mid
+		} else {
+			m.Type = coapNON
+			m.MID = t.newMID()
+		}
+	default:
+		// Empty messages have no meaning over TCP
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	pushTransportPacket(t.id, m.marshalUDP())
+}
+
+func (t *transport) newMID() uint16 {
+	t.nextMID++
+	return t.nextMID
+}
+
+// send passes the message from the lwm2m library to the server in the TCP
+// framing. TCP is reliable, so there are no acknowledgements, resets or
+// retransmissions.
+func (t *transport) send(b []byte) error {
+	m, err := parseUDPMessage(b)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case m.Code == 0 || m.Type == coapRST:
+		return nil
+
+	case m.isRequest() && m.Type == coapCON:
+		if mid, ok := t.requests[string(m.Token)]; ok && mid == m.MID {
+			return nil
+		}
+		t.requests[string(m.Token)] = m.MID
+
+	case m.isResponse() && m.Type == coapCON:
+		// A confirmable notification is acknowledged right away
+		ack := coapMessage{Type: coapACK, MID: m.MID}
+		defer pushTransportPacket(t.id, ack.marshalUDP())
+	}
+
+	t.write(m.marshalTCP())
+	return nil
+}
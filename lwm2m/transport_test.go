@@ -0,0 +1,358 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package lwm2m
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPFramingRoundTrip(t *testing.T) {
+	tests := []struct {
+		length int
+		header int // the length nibble, extended length and code
+	}{
+		{0, 2},
+		{12, 2},
+		{13, 3},
+		{268, 3},
+		{269, 4},
+		{65804, 4},
+	}
+
+	for _, tt := range tests {
+		m := coapMessage{Code: 0x45, Token: []byte{1, 2, 3}, Body: bytes.Repeat([]byte{0xab}, tt.length)}
+		frame := m.marshalTCP()
+		if len(frame) != tt.header+len(m.Token)+tt.length {
+			t.Errorf("%d bytes: frame of %d bytes, want a %d byte header", tt.length, len(frame), tt.header)
+		}
+
+		got, err := readTCPMessage(bytes.NewReader(frame))
+		if err != nil {
+			t.Errorf("%d bytes: %v", tt.length, err)
+			continue
+		}
+		if got.Code != m.Code || !bytes.Equal(got.Token, m.Token) || !bytes.Equal(got.Body, m.Body) {
+			t.Errorf("%d bytes: read code %x token %x and %d bytes", tt.length, got.Code, got.Token, len(got.Body))
+		}
+	}
+}
+
+func TestTCPFramingInvalid(t *testing.T) {
+	// 65805 bytes need the 4 byte extended length, which is above the limit
+	large := coapMessage{Code: 0x45, Body: make([]byte, transportMaxMessage+1)}.marshalTCP()
+	if large[0]>>4 != 15 {
+		t.Errorf("length nibble %d, want 15", large[0]>>4)
+	}
+
+	frames := map[string][]byte{
+		"too large":            large,
+		"too large header":     {15 << 4, 0xff, 0xff, 0xff, 0xff, 0x45},
+		"invalid token length": {9, 0x45},
+		"truncated length":     {14 << 4, 0x01},
+		"truncated body":       {3 << 4, 0x45, 0xff},
+		"empty":                {},
+	}
+	for name, frame := range frames {
+		if _, err := readTCPMessage(bytes.NewReader(frame)); err == nil {
+			t.Errorf("%s: the frame was read", name)
+		}
+	}
+}
+
+func TestCoAPOption(t *testing.T) {
+	m := coapMessage{Body: []byte{
+		0x42, 0x00, 0x3c, // option 4, 2 bytes
+		0xd1, 0x02, 0x07, // option 4+13+2 = 19, 1 byte
+		0xe0, 0x00, 0x01, // option 19+269+1 = 289, empty
+		0xff, 0x61, // payload
+	}}
+
+	tests := []struct {
+		number int
+		value  []byte
+		ok     bool
+	}{
+		{4, []byte{0x00, 0x3c}, true},
+		{19, []byte{0x07}, true},
+		{289, []byte{}, true},
+		{5, nil, false},
+		{0x61, nil, false},
+	}
+	for _, tt := range tests {
+		v, ok := m.option(tt.number)
+		if ok != tt.ok || !bytes.Equal(v, tt.value) {
+			t.Errorf("option %d = %x, %v, want %x, %v", tt.number, v, ok, tt.value, tt.ok)
+		}
+	}
+	if v, _ := m.option(4); decodeUint(v) != 60 {
+		t.Errorf("option 4 decodes to %d, want 60", decodeUint(v))
+	}
+
+	invalid := map[string][]byte{
+		"reserved delta":      {0xf1, 0x00},
+		"truncated delta":     {0xd1},
+		"truncated length":    {0x1e, 0x01},
+		"truncated value":     {0x43, 0x00},
+		"truncated extension": {0xe1, 0x00},
+	}
+	for name, body := range invalid {
+		if v, ok := (coapMessage{Body: body}).option(4); ok {
+			t.Errorf("%s: option = %x", name, v)
+		}
+	}
+}
+
+func TestOptionExtended(t *testing.T) {
+	tests := []struct {
+		v    int
+		b    []byte
+		want int
+		rest int
+		ok   bool
+	}{
+		{12, []byte{0x01}, 12, 1, true},
+		{13, []byte{0x00, 0x01}, 13, 1, true},
+		{13, []byte{0xff}, 268, 0, true},
+		{14, []byte{0x00, 0x00}, 269, 0, true},
+		{14, []byte{0xff, 0xff, 0x01}, 65804, 1, true},
+		{13, nil, 0, 0, false},
+		{14, []byte{0x01}, 0, 1, false},
+		{15, []byte{0x01}, 0, 1, false},
+	}
+	for _, tt := range tests {
+		v, rest, ok := optionExtended(tt.v, tt.b)
+		if v != tt.want || len(rest) != tt.rest || ok != tt.ok {
+			t.Errorf("optionExtended(%d, %x) = %d, %x, %v, want %d, %d bytes left, %v", tt.v, tt.b, v, rest, ok, tt.want, tt.rest, tt.ok)
+		}
+	}
+}
+
+// newPipeTransport returns a transport connected to the returned server end
+// of a pipe. The packets for the lwm2m library are read with popPacket.
+func newPipeTransport(t *testing.T, id int) (*transport, net.Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return &transport{
+		id:       id,
+		uri:      "coap+tcp://localhost",
+		done:     make(chan struct{}),
+		conn:     client,
+		requests: map[string]uint16{},
+		nextMID:  100,
+	}, server
+}
+
+// popPacket returns the next packet of the transport for the lwm2m library
+func popPacket(t *testing.T, id int) coapMessage {
+	t.Helper()
+
+	transports.Lock()
+	defer transports.Unlock()
+	for i, p := range transports.inbound {
+		if p.id != id {
+			continue
+		}
+		transports.inbound = append(transports.inbound[:i], transports.inbound[i+1:]...)
+		m, err := parseUDPMessage(p.data)
+		if err != nil {
+			t.Fatalf("invalid packet %x: %v", p.data, err)
+		}
+		return m
+	}
+	t.Fatal("no packet for the lwm2m library")
+	return coapMessage{}
+}
+
+// readFrame reads a frame sent by the transport
+func readFrame(t *testing.T, conn net.Conn) coapMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := readTCPMessage(conn)
+	if err != nil {
+		t.Fatalf("reading the frame: %v", err)
+	}
+	return m
+}
+
+// sendAsync sends the UDP message through the transport, as the pipe blocks
+// until the frame is read
+func sendAsync(tr *transport, m coapMessage) chan error {
+	result := make(chan error, 1)
+	go func() { result <- tr.send(m.marshalUDP()) }()
+	return result
+}
+
+// checkNotWritten checks that no frame was written, which fails on the pipe
+// as nothing reads it, and is then queued
+func checkNotWritten(t *testing.T, tr *transport) {
+	t.Helper()
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.conn == nil || len(tr.queue) > 0 {
+		t.Errorf("%d frames were written", len(tr.queue))
+	}
+}
+
+func TestTransportConfirmableRequest(t *testing.T) {
+	tr, server := newPipeTransport(t, 1001)
+	token := []byte{0xca, 0xfe}
+
+	// The registration request is sent without its type and message ID
+	request := coapMessage{Type: coapCON, Code: 0x02, MID: 7, Token: token, Body: []byte{0xb2, 0x72, 0x64}}
+	result := sendAsync(tr, request)
+	m := readFrame(t, server)
+	if err := <-result; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if m.Code != request.Code || !bytes.Equal(m.Token, token) || !bytes.Equal(m.Body, request.Body) {
+		t.Errorf("sent code %x token %x body %x", m.Code, m.Token, m.Body)
+	}
+
+	// A retransmission is not sent again over TCP
+	if err := tr.send(request.marshalUDP()); err != nil {
+		t.Errorf("send: %v", err)
+	}
+	checkNotWritten(t, tr)
+
+	// The response is the acknowledgement of the request
+	tr.receive(coapMessage{Code: 0x41, Token: token})
+	ack := popPacket(t, tr.id)
+	if ack.Type != coapACK || ack.MID != request.MID || ack.Code != 0x41 || !bytes.Equal(ack.Token, token) {
+		t.Errorf("response type %d MID %d code %x token %x, want an ACK with MID %d", ack.Type, ack.MID, ack.Code, ack.Token, request.MID)
+	}
+
+	// A second response has no request left to acknowledge
+	tr.receive(coapMessage{Code: 0x41, Token: token})
+	if m := popPacket(t, tr.id); m.Type != coapNON {
+		t.Errorf("response type %d, want NON", m.Type)
+	}
+}
+
+func TestTransportServerRequest(t *testing.T) {
+	tr, server := newPipeTransport(t, 1002)
+
+	// A request of the server gets a message ID, and is confirmable so that
+	// the lwm2m library answers with a piggybacked response
+	tr.receive(coapMessage{Code: 0x01, Token: []byte{1}})
+	tr.receive(coapMessage{Code: 0x01, Token: []byte{2}})
+	first, second := popPacket(t, tr.id), popPacket(t, tr.id)
+	if first.Type != coapCON || second.Type != coapCON || first.MID == second.MID {
+		t.Errorf("requests of type %d and %d with MIDs %d and %d", first.Type, second.Type, first.MID, second.MID)
+	}
+
+	// The response is sent without the acknowledgement
+	result := sendAsync(tr, coapMessage{Type: coapACK, Code: 0x45, MID: first.MID, Token: []byte{1}, Body: []byte{0xff, 0x31}})
+	m := readFrame(t, server)
+	if err := <-result; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if m.Code != 0x45 || !bytes.Equal(m.Token, []byte{1}) || !bytes.Equal(m.Body, []byte{0xff, 0x31}) {
+		t.Errorf("sent code %x token %x body %x", m.Code, m.Token, m.Body)
+	}
+
+	// The empty acknowledgements and resets are not sent, and the empty
+	// messages from the server are dropped
+	for _, empty := range []coapMessage{{Type: coapACK, MID: 3}, {Type: coapRST, MID: 4}} {
+		if err := tr.send(empty.marshalUDP()); err != nil {
+			t.Errorf("send: %v", err)
+		}
+	}
+	checkNotWritten(t, tr)
+	tr.receive(coapMessage{})
+
+	// A confirmable notification is acknowledged for the lwm2m library
+	result = sendAsync(tr, coapMessage{Type: coapCON, Code: 0x45, MID: 9, Token: []byte{3}})
+	readFrame(t, server)
+	if err := <-result; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if ack := popPacket(t, tr.id); ack.Type != coapACK || ack.MID != 9 || ack.Code != 0 {
+		t.Errorf("acknowledgement type %d MID %d code %x", ack.Type, ack.MID, ack.Code)
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+	for _, p := range transports.inbound {
+		if p.id == tr.id {
+			t.Errorf("unexpected packet %x", p.data)
+		}
+	}
+}
+
+// serveAsync serves the connection of the transport until it is closed
+func serveAsync(tr *transport) (chan time.Duration, chan error) {
+	holdOff := make(chan time.Duration, 1)
+	result := make(chan error, 1)
+	go func() {
+		d, err := tr.serve(tr.conn)
+		holdOff <- d
+		result <- err
+	}()
+	return holdOff, result
+}
+
+func TestTransportPingRelease(t *testing.T) {
+	tr, server := newPipeTransport(t, 1003)
+	holdOff, result := serveAsync(tr)
+
+	// A ping is answered with a pong with the same token
+	server.Write(coapMessage{Code: coapPing, Token: []byte{5}}.marshalTCP())
+	if m := readFrame(t, server); m.Code != coapPong || !bytes.Equal(m.Token, []byte{5}) {
+		t.Errorf("answered code %x token %x, want a pong", m.Code, m.Token)
+	}
+
+	// The settings and the pongs of the server are ignored
+	server.Write(coapMessage{Code: coapCSM}.marshalTCP())
+	server.Write(coapMessage{Code: coapPong}.marshalTCP())
+
+	// The release asks the client to wait 90 seconds before it reconnects
+	server.Write(coapMessage{Code: coapRelease, Body: []byte{0x41, 90}}.marshalTCP())
+	select {
+	case d := <-holdOff:
+		if d != 90*time.Second {
+			t.Errorf("hold off %s, want 90s", d)
+		}
+		if err := <-result; err == nil {
+			t.Error("the release did not end the connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the release did not end the connection")
+	}
+}
+
+func TestTransportAbort(t *testing.T) {
+	tr, server := newPipeTransport(t, 1004)
+	holdOff, result := serveAsync(tr)
+
+	server.Write(coapMessage{Code: coapAbort}.marshalTCP())
+	select {
+	case d := <-holdOff:
+		if d != 0 {
+			t.Errorf("hold off %s after an abort", d)
+		}
+		if err := <-result; err == nil {
+			t.Error("the abort did not end the connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the abort did not end the connection")
+	}
+
+	// A release without the hold-off option has no delay
+	tr, server = newPipeTransport(t, 1005)
+	holdOff, _ = serveAsync(tr)
+	server.Write(coapMessage{Code: coapRelease}.marshalTCP())
+	if d := <-holdOff; d != 0 {
+		t.Errorf("hold off %s without the option", d)
+	}
+}
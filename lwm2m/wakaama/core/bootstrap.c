@@ -1080,6 +1080,10 @@ uint8_t bootstrap_handleRequest(lwm2m_context_t * contextP,
 #ifdef LWM2M_SUPPORT_SENML_JSON
         case LWM2M_CONTENT_SENML_JSON:
             break;
+#endif
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+        case LWM2M_CONTENT_SENML_CBOR:
+            break;
 #endif
         default:
 #ifdef LWM2M_SUPPORT_TLV
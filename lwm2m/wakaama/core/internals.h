@@ -114,7 +114,8 @@
 ((M) == LWM2M_CONTENT_TLV ? "LWM2M_CONTENT_TLV" :                \
 ((M) == LWM2M_CONTENT_JSON ? "LWM2M_CONTENT_JSON" :              \
 ((M) == LWM2M_CONTENT_SENML_JSON ? "LWM2M_CONTENT_SENML_JSON" :  \
-"Unknown"))))))
+((M) == LWM2M_CONTENT_SENML_CBOR ? "LWM2M_CONTENT_SENML_CBOR" :  \
+"Unknown")))))))
 #define STR_STATE(S)                                \
 ((S) == STATE_INITIAL ? "STATE_INITIAL" :      \
 ((S) == STATE_BOOTSTRAP_REQUIRED ? "STATE_BOOTSTRAP_REQUIRED" :      \
@@ -382,6 +383,12 @@ int senml_json_parse(const lwm2m_uri_t * uriP, const uint8_t * buffer, size_t bu
 int senml_json_serialize(const lwm2m_uri_t * uriP, int size, const lwm2m_data_t * tlvP, uint8_t ** bufferP);
 #endif
 
+// defined in the senml_cbor.c of the client
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+int senml_cbor_parse(const lwm2m_uri_t * uriP, const uint8_t * buffer, size_t bufferLen, lwm2m_data_t ** dataP);
+int senml_cbor_serialize(const lwm2m_uri_t * uriP, int size, const lwm2m_data_t * tlvP, uint8_t ** bufferP);
+#endif
+
 // defined in json_common.c
 #if defined(LWM2M_SUPPORT_JSON) || defined(LWM2M_SUPPORT_SENML_JSON)
 size_t json_skipSpace(const uint8_t * buffer,size_t bufferLen);
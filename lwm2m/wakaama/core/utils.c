@@ -708,6 +708,9 @@ lwm2m_media_type_t utils_convertMediaType(coap_content_type_t type)
     case LWM2M_CONTENT_SENML_JSON:
         result = LWM2M_CONTENT_SENML_JSON;
         break;
+    case LWM2M_CONTENT_SENML_CBOR:
+        result = LWM2M_CONTENT_SENML_CBOR;
+        break;
     case APPLICATION_LINK_FORMAT:
         result = LWM2M_CONTENT_LINK;
         break;
@@ -803,6 +806,13 @@ uint8_t utils_getResponseFormat(uint8_t accept_num,
                 break;
 #endif
 
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+            case LWM2M_CONTENT_SENML_CBOR:
+                *format = LWM2M_CONTENT_SENML_CBOR;
+                found = true;
+                break;
+#endif
+
             default:
                 break;
             }
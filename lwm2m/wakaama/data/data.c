@@ -711,6 +711,11 @@ int lwm2m_data_parse(lwm2m_uri_t * uriP,
         return senml_json_parse(uriP, buffer, bufferLen, dataP);
 #endif
 
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+    case LWM2M_CONTENT_SENML_CBOR:
+        return senml_cbor_parse(uriP, buffer, bufferLen, dataP);
+#endif
+
     default:
         return 0;
     }
@@ -813,6 +818,11 @@ int lwm2m_data_serialize(lwm2m_uri_t * uriP,
         return senml_json_serialize(uriP, size, dataP, bufferP);
 #endif
 
+#ifdef LWM2M_SUPPORT_SENML_CBOR
+    case LWM2M_CONTENT_SENML_CBOR:
+        return senml_cbor_serialize(uriP, size, dataP, bufferP);
+#endif
+
     default:
         return -1;
     }
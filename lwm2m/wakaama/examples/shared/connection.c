@@ -94,6 +94,7 @@ connection_t * connection_new_incoming(connection_t * connList,
         connP->sock = sock;
         memcpy(&(connP->addr), addr, addrLen);
         connP->addrLen = addrLen;
+        connP->transportId = 0;
         connP->next = connList;
     }
 
@@ -117,6 +118,11 @@ connection_t * connection_create(connection_t * connList,
     memset(&hints, 0, sizeof(hints));
     hints.ai_family = addressFamily;
     hints.ai_socktype = SOCK_DGRAM;
+    if (addressFamily == AF_INET6)
+    {
+        // an IPv6 socket reaches the IPv4 servers through mapped addresses
+        hints.ai_flags = AI_V4MAPPED | AI_ALL;
+    }
 
     if (0 != getaddrinfo(host, port, &hints, &servinfo) || servinfo == NULL) return NULL;
 
@@ -168,6 +174,11 @@ int connection_send(connection_t *connP,
     int nbSent;
     size_t offset;
 
+    if (connP->transportId > 0)
+    {
+        return transport_send(connP->transportId, buffer, length);
+    }
+
 #ifdef LWM2M_WITH_LOGS
     char s[INET6_ADDRSTRLEN];
     in_port_t port;
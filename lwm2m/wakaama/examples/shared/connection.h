@@ -40,6 +40,7 @@ typedef struct _connection_t
     int                     sock;
     struct sockaddr_in6     addr;
     size_t                  addrLen;
+    int                     transportId; // set when the connection does not use the UDP socket, e.g. CoAP over TCP
 } connection_t;
 
 int create_socket(const char * portStr, int ai_family);
@@ -52,4 +53,7 @@ void connection_free(connection_t * connList);
 
 int connection_send(connection_t *connP, uint8_t * buffer, size_t length);
 
+// implemented by the application for the connections with a transportId
+int transport_send(int transportId, uint8_t * buffer, size_t length);
+
 #endif
@@ -127,6 +127,31 @@ char * security_get_secret_key(lwm2m_context_t * lwm2mH, lwm2m_object_t * obj, i
     }
 }
 
+char * security_get_server_public_key(lwm2m_context_t * lwm2mH, lwm2m_object_t * obj, int instanceId, int * length){
+    int size = 1;
+    lwm2m_data_t * dataP = lwm2m_data_new(size);
+    dataP->id = 4; // server public key
+
+    obj->readFunc(lwm2mH, instanceId, &size, &dataP, obj);
+    if (dataP != NULL &&
+        dataP->type == LWM2M_TYPE_OPAQUE)
+    {
+        char * buff;
+
+        buff = (char*)lwm2m_malloc(dataP->value.asBuffer.length);
+        if (buff != 0)
+        {
+            memcpy(buff, dataP->value.asBuffer.buffer, dataP->value.asBuffer.length);
+            *length = dataP->value.asBuffer.length;
+        }
+        lwm2m_data_free(size, dataP);
+
+        return buff;
+    } else {
+        return NULL;
+    }
+}
+
 /********************* Security Obj Helpers Ends **********************/
 
 /* Returns the number sent, or -1 for errors */
@@ -237,6 +262,222 @@ static int get_psk_info(struct dtls_context_t *ctx,
     return dtls_alert_fatal_create(DTLS_ALERT_INTERNAL_ERROR);
 }
 
+#ifdef DTLS_ECC
+#define ECC_KEY_SIZE 32
+
+#define DER_INTEGER      0x02
+#define DER_BIT_STRING   0x03
+#define DER_OCTET_STRING 0x04
+#define DER_OID          0x06
+#define DER_SEQUENCE     0x30
+#define DER_CONTEXT_0    0xA0
+
+/* id-ecPublicKey (1.2.840.10045.2.1) and the prime256v1 curve (1.2.840.10045.3.1.7) */
+static const unsigned char OID_EC_PUBLIC_KEY[] = { 0x2A, 0x86, 0x48, 0xCE, 0x3D, 0x02, 0x01 };
+static const unsigned char OID_PRIME256V1[] = { 0x2A, 0x86, 0x48, 0xCE, 0x3D, 0x03, 0x01, 0x07 };
+
+typedef struct
+{
+    unsigned char tag;
+    const unsigned char * content;
+    size_t length;
+} der_element_t;
+
+/* Reads the DER element at the start of the buffer. It returns the length of
+ * the whole element, or 0 when the element is not valid or does not fit. */
+static size_t der_read(const unsigned char * buffer, size_t length, der_element_t * element)
+{
+    size_t header = 2;
+    size_t contentLength;
+    size_t i;
+
+    if (buffer == NULL || length < 2) return 0;
+
+    element->tag = buffer[0];
+    contentLength = buffer[1];
+    if (contentLength & 0x80)
+    {
+        // The long form, as DER has no indefinite length. The keys are far shorter than 64 KiB.
+        size_t count = contentLength & 0x7F;
+        if (count == 0 || count > 2 || length < 2 + count) return 0;
+
+        contentLength = 0;
+        for (i = 0; i < count; i++)
+        {
+            contentLength = (contentLength << 8) | buffer[2 + i];
+        }
+        header += count;
+    }
+    if (contentLength > length - header) return 0;
+
+    element->content = buffer + header;
+    element->length = contentLength;
+    return header + contentLength;
+}
+
+static bool der_is_oid(const der_element_t * element, const unsigned char * oid, size_t length)
+{
+    return element->tag == DER_OID && element->length == length && 0 == memcmp(element->content, oid, length);
+}
+
+/* Reads the uncompressed point of a P-256 key from a DER-encoded
+ * SubjectPublicKeyInfo:
+ *   SEQUENCE { SEQUENCE { id-ecPublicKey, prime256v1 }, BIT STRING { 0x04 X Y } } */
+static int get_spki_point(const char * spki, int length, unsigned char * x, unsigned char * y)
+{
+    der_element_t info;
+    der_element_t algorithm;
+    der_element_t key;
+    der_element_t oid;
+    der_element_t curve;
+    size_t n;
+
+    if (spki == NULL || length <= 0) return -1;
+    if ((size_t)length != der_read((const unsigned char *)spki, length, &info) || info.tag != DER_SEQUENCE) return -1;
+
+    n = der_read(info.content, info.length, &algorithm);
+    if (n == 0 || algorithm.tag != DER_SEQUENCE) return -1;
+    if (info.length - n != der_read(info.content + n, info.length - n, &key) || key.tag != DER_BIT_STRING) return -1;
+
+    n = der_read(algorithm.content, algorithm.length, &oid);
+    if (n == 0 || !der_is_oid(&oid, OID_EC_PUBLIC_KEY, sizeof(OID_EC_PUBLIC_KEY))) return -1;
+    if (algorithm.length - n != der_read(algorithm.content + n, algorithm.length - n, &curve)
+     || !der_is_oid(&curve, OID_PRIME256V1, sizeof(OID_PRIME256V1)))
+    {
+        return -1;
+    }
+
+    // The bit string has no unused bits
+    if (key.length != 2 + 2 * ECC_KEY_SIZE || key.content[0] != 0x00 || key.content[1] != 0x04) return -1;
+
+    memcpy(x, key.content + 2, ECC_KEY_SIZE);
+    memcpy(y, key.content + 2 + ECC_KEY_SIZE, ECC_KEY_SIZE);
+    return 0;
+}
+
+/* Reads the scalar of a P-256 key from a DER-encoded SEC1 EC private key:
+ *   SEQUENCE { INTEGER 1, OCTET STRING scalar, [0] prime256v1 OPTIONAL, [1] public key OPTIONAL } */
+static int get_sec1_scalar(const char * secretKey, int length, unsigned char * scalar)
+{
+    der_element_t sequence;
+    der_element_t version;
+    der_element_t privateKey;
+    der_element_t element;
+    der_element_t curve;
+    size_t offset;
+    size_t n;
+
+    if (secretKey == NULL || length <= 0) return -1;
+    if ((size_t)length != der_read((const unsigned char *)secretKey, length, &sequence) || sequence.tag != DER_SEQUENCE) return -1;
+
+    offset = der_read(sequence.content, sequence.length, &version);
+    if (offset == 0 || version.tag != DER_INTEGER || version.length != 1 || version.content[0] != 1) return -1;
+
+    n = der_read(sequence.content + offset, sequence.length - offset, &privateKey);
+    if (n == 0 || privateKey.tag != DER_OCTET_STRING || privateKey.length != ECC_KEY_SIZE) return -1;
+    offset += n;
+
+    // The curve is optional, but it must be P-256 when it is given
+    n = der_read(sequence.content + offset, sequence.length - offset, &element);
+    if (n != 0 && element.tag == DER_CONTEXT_0)
+    {
+        if (element.length != der_read(element.content, element.length, &curve)
+         || !der_is_oid(&curve, OID_PRIME256V1, sizeof(OID_PRIME256V1)))
+        {
+            return -1;
+        }
+    }
+
+    memcpy(scalar, privateKey.content, ECC_KEY_SIZE);
+    return 0;
+}
+
+/* This function returns the raw public key of the client and its private key
+ * for the session. The keys are kept in the connection for the handshake. */
+static int get_ecdsa_key(struct dtls_context_t *ctx,
+        const session_t *session,
+        const dtls_ecdsa_key_t **result) {
+
+    dtls_app_context_t *appContext = (dtls_app_context_t *)ctx->app;
+    char * publicKey;
+    char * secretKey;
+    int publicKeyLen = 0;
+    int secretKeyLen = 0;
+    int res = 0;
+
+    // find connection
+    dtls_connection_t* cnx = connection_find(appContext->connList, &(session->addr.st),session->size);
+    if (cnx == NULL)
+    {
+        printf("GET ECDSA KEY session not found\n");
+        return dtls_alert_fatal_create(DTLS_ALERT_INTERNAL_ERROR);
+    }
+
+    publicKey = security_get_public_id(appContext->lwm2mH, cnx->securityObj, cnx->securityInstId, &publicKeyLen);
+    secretKey = security_get_secret_key(appContext->lwm2mH, cnx->securityObj, cnx->securityInstId, &secretKeyLen);
+
+    if (0 != get_spki_point(publicKey, publicKeyLen, cnx->ecdsaPublicX, cnx->ecdsaPublicY)
+     || 0 != get_sec1_scalar(secretKey, secretKeyLen, cnx->ecdsaPrivate))
+    {
+        printf("cannot set ecdsa key -- not a P-256 raw public key\n");
+        res = dtls_alert_fatal_create(DTLS_ALERT_INTERNAL_ERROR);
+    }
+    else
+    {
+        cnx->ecdsaKey.curve = DTLS_ECDH_CURVE_SECP256R1;
+        cnx->ecdsaKey.priv_key = cnx->ecdsaPrivate;
+        cnx->ecdsaKey.pub_key_x = cnx->ecdsaPublicX;
+        cnx->ecdsaKey.pub_key_y = cnx->ecdsaPublicY;
+        *result = &cnx->ecdsaKey;
+    }
+
+    if (publicKey != NULL) lwm2m_free(publicKey);
+    if (secretKey != NULL)
+    {
+        memset(secretKey, 0, secretKeyLen);
+        lwm2m_free(secretKey);
+    }
+    return res;
+}
+
+/* This function checks that the server presents the raw public key that
+ * is stored in the security object. */
+static int verify_ecdsa_key(struct dtls_context_t *ctx,
+        const session_t *session,
+        const unsigned char *other_pub_x,
+        const unsigned char *other_pub_y,
+        size_t key_size) {
+
+    dtls_app_context_t *appContext = (dtls_app_context_t *)ctx->app;
+    unsigned char x[ECC_KEY_SIZE];
+    unsigned char y[ECC_KEY_SIZE];
+    char * serverKey;
+    int serverKeyLen = 0;
+    int res;
+
+    // find connection
+    dtls_connection_t* cnx = connection_find(appContext->connList, &(session->addr.st),session->size);
+    if (cnx == NULL)
+    {
+        printf("VERIFY ECDSA KEY session not found\n");
+        return dtls_alert_fatal_create(DTLS_ALERT_INTERNAL_ERROR);
+    }
+
+    serverKey = security_get_server_public_key(appContext->lwm2mH, cnx->securityObj, cnx->securityInstId, &serverKeyLen);
+    res = get_spki_point(serverKey, serverKeyLen, x, y);
+    if (serverKey != NULL) lwm2m_free(serverKey);
+
+    if (res != 0 || key_size != ECC_KEY_SIZE
+     || 0 != memcmp(x, other_pub_x, ECC_KEY_SIZE)
+     || 0 != memcmp(y, other_pub_y, ECC_KEY_SIZE))
+    {
+        printf("the server raw public key does not match\n");
+        return dtls_alert_fatal_create(DTLS_ALERT_HANDSHAKE_FAILURE);
+    }
+    return 0;
+}
+#endif /* DTLS_ECC */
+
 /* The callback function must return the number of bytes
  * that were sent, or a value less than zero to indicate an
  * error. */
@@ -278,6 +519,67 @@ static int read_from_peer(struct dtls_context_t *ctx,
 }
 /**************************   TinyDTLS Callbacks Ends ************************/
 
+/**************************  X.509 Session Callbacks  ************************/
+
+static int x509_send(void * userData, uint8_t * buffer, size_t length)
+{
+    return send_data((dtls_connection_t *)userData, buffer, length);
+}
+
+static void x509_receive(void * userData, uint8_t * buffer, size_t length)
+{
+    dtls_connection_t * connP = (dtls_connection_t *)userData;
+
+    lwm2m_handle_packet(connP->lwm2mH, buffer, length, (void *)connP);
+}
+
+// The session of the certificate mode: the public key or identity is the
+// certificate of the client, and the server public key the certificate the
+// chain of the server is verified against, as for the TLS connections.
+static dtls_x509_session_t * x509_session_new(dtls_connection_t * connP, const char * host)
+{
+    dtls_x509_credentials_t credentials;
+    dtls_x509_session_t * sessionP = NULL;
+    char * certificate;
+    char * privateKey;
+    char * serverCertificate;
+    int certificateLength = 0;
+    int privateKeyLength = 0;
+    int serverCertificateLength = 0;
+
+    certificate = security_get_public_id(connP->lwm2mH, connP->securityObj, connP->securityInstId, &certificateLength);
+    privateKey = security_get_secret_key(connP->lwm2mH, connP->securityObj, connP->securityInstId, &privateKeyLength);
+    serverCertificate = security_get_server_public_key(connP->lwm2mH, connP->securityObj, connP->securityInstId, &serverCertificateLength);
+
+    if (certificate != NULL && privateKey != NULL && serverCertificate != NULL)
+    {
+        credentials.certificate = (uint8_t *)certificate;
+        credentials.certificateLength = certificateLength;
+        credentials.privateKey = (uint8_t *)privateKey;
+        credentials.privateKeyLength = privateKeyLength;
+        credentials.serverCertificate = (uint8_t *)serverCertificate;
+        credentials.serverCertificateLength = serverCertificateLength;
+        credentials.host = host;
+        sessionP = dtls_x509_new(&credentials, x509_send, x509_receive, connP);
+    }
+    else
+    {
+        fprintf(stderr, "The certificate mode needs the certificates and the private key of the security object\r\n");
+    }
+
+    if (certificate != NULL) lwm2m_free(certificate);
+    if (privateKey != NULL)
+    {
+        memset(privateKey, 0, privateKeyLength);
+        lwm2m_free(privateKey);
+    }
+    if (serverCertificate != NULL) lwm2m_free(serverCertificate);
+
+    return sessionP;
+}
+
+/**************************  X.509 Session Callbacks Ends ************************/
+
 static dtls_handler_t cb = {
   .write = send_to_peer,
   .read  = read_from_peer,
@@ -285,10 +587,10 @@ static dtls_handler_t cb = {
 //#ifdef DTLS_PSK
   .get_psk_info = get_psk_info,
 //#endif /* DTLS_PSK */
-//#ifdef DTLS_ECC
-//  .get_ecdsa_key = get_ecdsa_key,
-//  .verify_ecdsa_key = verify_ecdsa_key
-//#endif /* DTLS_ECC */
+#ifdef DTLS_ECC
+  .get_ecdsa_key = get_ecdsa_key,
+  .verify_ecdsa_key = verify_ecdsa_key
+#endif /* DTLS_ECC */
 };
 
 dtls_context_t * get_dtls_context(lwm2m_context_t * lwm2mH, dtls_connection_t * connList) {
@@ -457,6 +759,11 @@ dtls_connection_t * connection_create(dtls_connection_t * connList,
     memset(&hints, 0, sizeof(hints));
     hints.ai_family = addressFamily;
     hints.ai_socktype = SOCK_DGRAM;
+    if (addressFamily == AF_INET6)
+    {
+        // an IPv6 socket reaches the IPv4 servers through mapped addresses
+        hints.ai_flags = AI_V4MAPPED | AI_ALL;
+    }
 
     uri = security_get_uri(lwm2mH, securityObj, instanceId, uriBuf, URI_LENGTH);
     if (uri == NULL) return NULL;
@@ -532,8 +839,20 @@ dtls_connection_t * connection_create(dtls_connection_t * connList,
             connP->securityInstId = instanceId;
             connP->lwm2mH = lwm2mH;
 
-            if (security_get_mode(lwm2mH, connP->securityObj,connP->securityInstId)
-                     != LWM2M_SECURITY_MODE_NONE)
+            int64_t mode = security_get_mode(lwm2mH, connP->securityObj, connP->securityInstId);
+            if (mode == LWM2M_SECURITY_MODE_CERTIFICATE)
+            {
+                // tinydtls only supports pre-shared keys and raw public keys
+                free(connP->dtlsSession);
+                connP->dtlsSession = NULL;
+                connP->x509Session = x509_session_new(connP, host);
+                if (connP->x509Session == NULL)
+                {
+                    free(connP);
+                    connP = NULL;
+                }
+            }
+            else if (mode != LWM2M_SECURITY_MODE_NONE)
             {
                 connP->dtlsContext = get_dtls_context(lwm2mH, connP);
             }
@@ -559,6 +878,10 @@ void connection_free(dtls_connection_t * connList)
         dtls_connection_t * nextP;
 
         nextP = connList->next;
+#ifdef DTLS_ECC
+        memset(connList->ecdsaPrivate, 0, sizeof(connList->ecdsaPrivate));
+#endif
+        dtls_x509_free(connList->x509Session);
         free(connList);
 
         connList = nextP;
@@ -566,7 +889,24 @@ void connection_free(dtls_connection_t * connList)
 }
 
 int connection_send(dtls_connection_t *connP, uint8_t * buffer, size_t length){
-    if (connP->dtlsSession == NULL) {
+    if (connP->transportId > 0) {
+        // not over the UDP socket, the application sends the data
+        return transport_send(connP->transportId, buffer, length);
+    }
+    if (connP->x509Session != NULL) {
+        if (DTLS_NAT_TIMEOUT > 0 && (lwm2m_gettime() - connP->lastSend) > DTLS_NAT_TIMEOUT)
+        {
+            // same as the tinydtls sessions below
+            if ( connection_rehandshake(connP, false) != 0 )
+            {
+                printf("can't send due to rehandshake error\n");
+                return -1;
+            }
+        }
+        if (-1 == dtls_x509_write(connP->x509Session, buffer, length)) {
+            return -1;
+        }
+    } else if (connP->dtlsSession == NULL) {
         // no security
         if (0 >= send_data(connP, buffer, length)) {
             return -1 ;
@@ -591,7 +931,16 @@ int connection_send(dtls_connection_t *connP, uint8_t * buffer, size_t length){
 
 int connection_handle_packet(dtls_connection_t *connP, uint8_t * buffer, size_t numBytes){
 
-    if (connP->dtlsSession != NULL)
+    if (connP->x509Session != NULL)
+    {
+        // the decrypted data is given to liblwm2m by x509_receive
+        int result = dtls_x509_handle_packet(connP->x509Session, buffer, numBytes);
+        if (result !=0) {
+             printf("error dtls handling message %d\n",result);
+        }
+        return result;
+    }
+    else if (connP->dtlsSession != NULL)
     {
         // Let liblwm2m respond to the query depending on the context
         int result = dtls_handle_message(connP->dtlsContext, connP->dtlsSession, buffer, numBytes);
@@ -608,6 +957,11 @@ int connection_handle_packet(dtls_connection_t *connP, uint8_t * buffer, size_t
 
 int connection_rehandshake(dtls_connection_t *connP, bool sendCloseNotify) {
 
+    // the certificate sessions are dropped without a close notify
+    if (connP->x509Session != NULL) {
+        return dtls_x509_connect(connP->x509Session);
+    }
+
     // if not a dtls connection we do nothing
     if (connP->dtlsSession == NULL) {
         return 0;
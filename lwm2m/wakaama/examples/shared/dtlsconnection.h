@@ -32,6 +32,7 @@
 #include "tinydtls/tinydtls.h"
 #include "tinydtls/dtls.h"
 #include "liblwm2m.h"
+#include "dtls_x509.h"
 
 #define LWM2M_STANDARD_PORT_STR "5683"
 #define LWM2M_STANDARD_PORT      5683
@@ -55,6 +56,14 @@ typedef struct _dtls_connection_t
     lwm2m_context_t * lwm2mH;
     dtls_context_t * dtlsContext;
     time_t lastSend; // last time a data was sent to the server (used for NAT timeouts)
+    int transportId; // set when the connection does not use the UDP socket, e.g. CoAP over TCP
+    dtls_x509_session_t * x509Session; // set for the certificate mode, which tinydtls does not support
+#ifdef DTLS_ECC
+    dtls_ecdsa_key_t ecdsaKey; // raw public key of the client, used during the handshake
+    unsigned char ecdsaPrivate[32];
+    unsigned char ecdsaPublicX[32];
+    unsigned char ecdsaPublicY[32];
+#endif
 } dtls_connection_t;
 
 int create_socket(const char * portStr, int ai_family);
@@ -66,6 +75,9 @@ dtls_connection_t * connection_create(dtls_connection_t * connList, int sock, lw
 void connection_free(dtls_connection_t * connList);
 
 int connection_send(dtls_connection_t *connP, uint8_t * buffer, size_t length);
+
+// implemented by the application for the connections with a transportId
+int transport_send(int transportId, uint8_t * buffer, size_t length);
 int connection_handle_packet(dtls_connection_t *connP, uint8_t * buffer, size_t length);
 
 // rehandshake a connection, useful when your NAT timed out and your client has a new IP/PORT
@@ -390,7 +390,8 @@ typedef enum
     LWM2M_CONTENT_TLV        = 11542,
     LWM2M_CONTENT_JSON_OLD   = 1543,     // Keep old value for backward-compatibility
     LWM2M_CONTENT_JSON       = 11543,
-    LWM2M_CONTENT_SENML_JSON = 110
+    LWM2M_CONTENT_SENML_JSON = 110,
+    LWM2M_CONTENT_SENML_CBOR = 112
 } lwm2m_media_type_t;
 
 lwm2m_data_t * lwm2m_data_new(int size);
@@ -5,15 +5,67 @@
 package objects
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
 )
 
 // Only refresh the data if that last retrieval was older than this time
 const apiRefresh = 10
 
+// The environment variable that holds the directory for the persistent data
+const dataEnvVar = "SNAP_DATA"
+
+// newClient creates the snapd client used by the objects
+var newClient = func() snapdapi.SnapdClient {
+	return snapdapi.NewClientAdapter()
+}
+
+// UseSnapdClient sets the snapd client used by the objects e.g. a snapdtest.FakeSnapdClient.
+// It must be called before the objects are first retrieved.
+func UseSnapdClient(c snapdapi.SnapdClient) {
+	newClient = func() snapdapi.SnapdClient {
+		return c
+	}
+}
+
 func dataIsStale(lastRefresh int64) bool {
 	if time.Now().Unix()-lastRefresh > apiRefresh {
 		return true
 	}
 	return false
 }
+
+// dataDir returns the directory where the objects keep their persistent data
+func dataDir() string {
+	return filepath.Clean(os.Getenv(dataEnvVar))
+}
+
+// WriteFileAtomic writes the data to a temporary file and renames it, so the
+// file is never left partially written
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
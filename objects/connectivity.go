@@ -0,0 +1,314 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Network bearers, as defined by the LwM2M Connectivity Monitoring object. The
+// bearer of a modem is unknown when ModemManager does not report it.
+const (
+	BearerUnknown   = -1
+	BearerGSM       = 0
+	BearerWCDMA     = 2
+	BearerCDMA2000  = 3
+	BearerLTEFDD    = 6
+	BearerWLAN      = 21
+	BearerBluetooth = 22
+	BearerIEEE15_4  = 23
+	BearerEthernet  = 41
+)
+
+// Hardware types from /sys/class/net/<interface>/type
+const (
+	arphrdEther    = 1
+	arphrdIEEE15_4 = 804
+)
+
+// modemBearers maps the access technologies of ModemManager to the bearers, from
+// the newest generation. ModemManager does not tell LTE-FDD from LTE-TDD, so LTE
+// is reported as LTE-FDD, which most networks use.
+var modemBearers = []struct {
+	technologies []string
+	bearer       int
+}{
+	{[]string{"lte"}, BearerLTEFDD},
+	{[]string{"umts", "hsdpa", "hsupa", "hspa", "hspa-plus"}, BearerWCDMA},
+	{[]string{"1xrtt", "evdo0", "evdoa", "evdob"}, BearerCDMA2000},
+	{[]string{"gsm", "gsm-compact", "gprs", "edge"}, BearerGSM},
+}
+
+// Network reads the state of the network that is not in the /proc and /sys trees
+type Network interface {
+	Addresses() (map[string][]string, error)
+	DefaultRoutes() ([]DefaultRoute, error)
+	AccessTechnologies(name string) ([]string, error)
+}
+
+// The root of the /proc and /sys trees and the network read by the Connectivity Monitoring object
+var networkRoot = "/"
+var network Network = systemNetwork{}
+
+// UseNetwork sets the root of the /proc and /sys trees that describe the network
+// and the reader of the network state e.g. a fake tree and network. It must be
+// called before the object is first retrieved.
+func UseNetwork(root string, n Network) {
+	networkRoot = root
+	network = n
+}
+
+// Connectivity defines the Connectivity Monitoring object
+type Connectivity struct {
+	Bearer           int
+	AvailableBearers []int
+	SignalStrength   int
+	LinkQuality      int
+	IPAddresses      []string
+	RouterAddresses  []string
+	Interface        string
+	root             string
+	network          Network
+	lastRefresh      int64
+}
+
+// Using a singleton to define the connectivity
+var connectivityInstance *Connectivity
+var connectivityOnce sync.Once
+
+// GetConnectivityInstance returns an instance of the Connectivity Monitoring object
+func GetConnectivityInstance() *Connectivity {
+	connectivityOnce.Do(func() {
+		connectivityInstance = &Connectivity{root: networkRoot, network: network}
+	})
+	if dataIsStale(connectivityInstance.lastRefresh) {
+		connectivityInstance.refresh()
+		connectivityInstance.lastRefresh = time.Now().Unix()
+	}
+
+	return connectivityInstance
+}
+
+// refresh the network state from the kernel
+func (c *Connectivity) refresh() {
+	interfaces := c.interfaces()
+
+	// Classify the physical interfaces by their bearer
+	bearers := map[string]int{}
+	available := map[int]bool{}
+	for _, name := range interfaces {
+		bearer, ok := c.bearer(name)
+		if !ok {
+			continue
+		}
+		bearers[name] = bearer
+		available[bearer] = true
+	}
+
+	c.AvailableBearers = []int{}
+	for b := range available {
+		if b != BearerUnknown {
+			c.AvailableBearers = append(c.AvailableBearers, b)
+		}
+	}
+	sort.Ints(c.AvailableBearers)
+
+	routes, err := c.network.DefaultRoutes()
+	if err != nil {
+		log.Printf("Error reading the default routes: %v", err)
+	}
+	addresses, err := c.network.Addresses()
+	if err != nil {
+		log.Printf("Error reading the IP addresses: %v", err)
+	}
+
+	// The active bearer carries the default route with the lowest metric
+	c.Interface = ""
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Metric < routes[j].Metric })
+	for _, r := range routes {
+		if _, ok := bearers[r.Interface]; ok {
+			c.Interface = r.Interface
+			break
+		}
+	}
+	if len(c.Interface) == 0 {
+		for _, name := range interfaces {
+			if _, ok := bearers[name]; ok && len(addresses[name]) > 0 {
+				c.Interface = name
+				break
+			}
+		}
+	}
+
+	c.Bearer = BearerEthernet
+	if b, ok := bearers[c.Interface]; ok {
+		c.Bearer = b
+	} else if len(c.AvailableBearers) > 0 {
+		c.Bearer = c.AvailableBearers[0]
+	}
+
+	c.IPAddresses = []string{}
+	for _, a := range addresses[c.Interface] {
+		if strings.Contains(a, ":") && c.ipv6Disabled(c.Interface) {
+			continue
+		}
+		c.IPAddresses = append(c.IPAddresses, a)
+	}
+
+	c.RouterAddresses = []string{}
+	for _, r := range routes {
+		if r.Interface == c.Interface && len(r.Gateway) > 0 {
+			c.RouterAddresses = append(c.RouterAddresses, r.Gateway)
+		}
+	}
+
+	c.SignalStrength, c.LinkQuality = c.wireless(c.Interface)
+}
+
+// interfaces lists the network interfaces configured in /proc/sys/net
+func (c *Connectivity) interfaces() []string {
+	files, err := ioutil.ReadDir(c.path("proc/sys/net/ipv4/conf"))
+	if err != nil {
+		log.Printf("Error reading the network interfaces: %v", err)
+		return []string{}
+	}
+
+	names := []string{}
+	for _, f := range files {
+		switch f.Name() {
+		case "all", "default", "lo":
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+// bearer identifies the network bearer of a physical interface from /sys/class/net
+func (c *Connectivity) bearer(name string) (int, bool) {
+	// Bridges, tunnels and other virtual interfaces are not bearers
+	if exists(c.path("sys/devices/virtual/net", name)) {
+		return 0, false
+	}
+
+	dir := c.path("sys/class/net", name)
+	if exists(filepath.Join(dir, "wireless")) || exists(filepath.Join(dir, "phy80211")) {
+		return BearerWLAN, true
+	}
+
+	switch c.devType(dir) {
+	case "wwan":
+		// The radio technology of a modem is not visible to the kernel
+		return c.modemBearer(name), true
+	case "bluetooth":
+		return BearerBluetooth, true
+	case "wpan":
+		return BearerIEEE15_4, true
+	}
+
+	switch readInt(filepath.Join(dir, "type")) {
+	case arphrdEther:
+		return BearerEthernet, true
+	case arphrdIEEE15_4:
+		return BearerIEEE15_4, true
+	}
+	return 0, false
+}
+
+// modemBearer identifies the bearer of a modem from its access technologies
+func (c *Connectivity) modemBearer(name string) int {
+	technologies, err := c.network.AccessTechnologies(name)
+	if err != nil {
+		log.Printf("Error reading the access technology of %s: %v", name, err)
+		return BearerUnknown
+	}
+
+	for _, m := range modemBearers {
+		for _, t := range m.technologies {
+			for _, technology := range technologies {
+				if technology == t {
+					return m.bearer
+				}
+			}
+		}
+	}
+	return BearerUnknown
+}
+
+// devType reads the device type from the uevent of the interface
+func (c *Connectivity) devType(dir string) string {
+	f, err := os.Open(filepath.Join(dir, "uevent"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "DEVTYPE=") {
+			return strings.TrimPrefix(scanner.Text(), "DEVTYPE=")
+		}
+	}
+	return ""
+}
+
+// ipv6Disabled checks whether IPv6 is turned off for the interface in /proc/sys/net
+func (c *Connectivity) ipv6Disabled(name string) bool {
+	return readInt(c.path("proc/sys/net/ipv6/conf", name, "disable_ipv6")) == 1
+}
+
+// wireless reads the signal level (dBm) and link quality of a wireless interface from /proc/net/wireless
+func (c *Connectivity) wireless(name string) (int, int) {
+	f, err := os.Open(c.path("proc/net/wireless"))
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	// e.g. "wlan0: 0000   54.  -56.  -256        0      0      0      0      0        0"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != name+":" {
+			continue
+		}
+
+		quality, _ := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		level, _ := strconv.ParseFloat(strings.TrimSuffix(fields[3], "."), 64)
+		return int(level), int(quality)
+	}
+	return 0, 0
+}
+
+func (c *Connectivity) path(elem ...string) string {
+	return filepath.Join(append([]string{c.root}, elem...)...)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readInt(path string) int {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+
+	i, err := strconv.Atoi(strings.TrimSpace(string(dat)))
+	if err != nil {
+		return -1
+	}
+	return i
+}
@@ -0,0 +1,212 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeNetwork is the network state that is not read from the /proc and /sys trees
+type fakeNetwork struct {
+	addresses    map[string][]string
+	routes       []DefaultRoute
+	technologies map[string][]string
+}
+
+func (n *fakeNetwork) Addresses() (map[string][]string, error) {
+	return n.addresses, nil
+}
+
+func (n *fakeNetwork) DefaultRoutes() ([]DefaultRoute, error) {
+	return n.routes, nil
+}
+
+func (n *fakeNetwork) AccessTechnologies(name string) ([]string, error) {
+	t, ok := n.technologies[name]
+	if !ok {
+		return nil, errors.New("ModemManager is not running")
+	}
+	return t, nil
+}
+
+// writeFiles creates the files of a fake /proc and /sys tree
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newNetworkTree creates a tree with an ethernet, a wireless, a modem and a bridge interface
+func newNetworkTree(t *testing.T) string {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"proc/sys/net/ipv4/conf/all/forwarding":     "0",
+		"proc/sys/net/ipv4/conf/default/forwarding": "0",
+		"proc/sys/net/ipv4/conf/lo/forwarding":      "0",
+		"proc/sys/net/ipv4/conf/br0/forwarding":     "0",
+		"proc/sys/net/ipv4/conf/eth0/forwarding":    "0",
+		"proc/sys/net/ipv4/conf/wlan0/forwarding":   "0",
+		"proc/sys/net/ipv4/conf/wwan0/forwarding":   "0",
+		"proc/sys/net/ipv6/conf/eth0/disable_ipv6":  "1",
+		"proc/sys/net/ipv6/conf/wlan0/disable_ipv6": "0",
+		"sys/devices/virtual/net/br0/type":          "1",
+		"sys/class/net/br0/type":                    "1",
+		"sys/class/net/eth0/type":                   "1",
+		"sys/class/net/wlan0/type":                  "1",
+		"sys/class/net/wlan0/wireless/link":         "0",
+		"sys/class/net/wwan0/type":                  "1",
+		"sys/class/net/wwan0/uevent":                "INTERFACE=wwan0\nIFINDEX=5\nDEVTYPE=wwan\n",
+		"proc/net/wireless": "Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE\n" +
+			" face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22\n" +
+			"wlan0: 0000   54.  -56.  -256        0      0      0      0      0        0\n",
+	})
+	return root
+}
+
+func TestConnectivityEthernet(t *testing.T) {
+	n := &fakeNetwork{
+		addresses: map[string][]string{
+			"eth0":  {"192.168.1.10", "2001:db8::10"},
+			"wlan0": {"10.0.0.5"},
+		},
+		routes: []DefaultRoute{
+			{Interface: "wlan0", Gateway: "10.0.0.1", Metric: 600},
+			{Interface: "eth0", Gateway: "192.168.1.1", Metric: 100},
+		},
+		technologies: map[string][]string{"wwan0": {"lte"}},
+	}
+	c := &Connectivity{root: newNetworkTree(t), network: n}
+	c.refresh()
+
+	if c.Interface != "eth0" || c.Bearer != BearerEthernet {
+		t.Errorf("bearer = %d on %s, want ethernet on eth0", c.Bearer, c.Interface)
+	}
+	if want := []int{BearerLTEFDD, BearerWLAN, BearerEthernet}; !reflect.DeepEqual(c.AvailableBearers, want) {
+		t.Errorf("available bearers = %v, want %v", c.AvailableBearers, want)
+	}
+	if want := []string{"192.168.1.10"}; !reflect.DeepEqual(c.IPAddresses, want) {
+		t.Errorf("IP addresses = %v, want %v without the disabled IPv6 address", c.IPAddresses, want)
+	}
+	if want := []string{"192.168.1.1"}; !reflect.DeepEqual(c.RouterAddresses, want) {
+		t.Errorf("router addresses = %v, want %v", c.RouterAddresses, want)
+	}
+	if c.SignalStrength != 0 || c.LinkQuality != 0 {
+		t.Errorf("signal = %d, quality = %d, want none for ethernet", c.SignalStrength, c.LinkQuality)
+	}
+}
+
+func TestConnectivityWireless(t *testing.T) {
+	n := &fakeNetwork{
+		addresses: map[string][]string{"wlan0": {"10.0.0.5", "2001:db8::5"}},
+		routes:    []DefaultRoute{{Interface: "wlan0", Gateway: "10.0.0.1", Metric: 600}},
+	}
+	c := &Connectivity{root: newNetworkTree(t), network: n}
+	c.refresh()
+
+	if c.Interface != "wlan0" || c.Bearer != BearerWLAN {
+		t.Errorf("bearer = %d on %s, want WLAN on wlan0", c.Bearer, c.Interface)
+	}
+	if c.SignalStrength != -56 || c.LinkQuality != 54 {
+		t.Errorf("signal = %d, quality = %d, want -56 and 54", c.SignalStrength, c.LinkQuality)
+	}
+	if want := []string{"10.0.0.5", "2001:db8::5"}; !reflect.DeepEqual(c.IPAddresses, want) {
+		t.Errorf("IP addresses = %v, want %v", c.IPAddresses, want)
+	}
+}
+
+func TestConnectivityModemBearer(t *testing.T) {
+	tests := []struct {
+		technologies map[string][]string
+		bearer       int
+	}{
+		{map[string][]string{"wwan0": {"lte"}}, BearerLTEFDD},
+		{map[string][]string{"wwan0": {"umts", "hsdpa"}}, BearerWCDMA},
+		{map[string][]string{"wwan0": {"gsm", "edge"}}, BearerGSM},
+		{map[string][]string{"wwan0": {"evdoa"}}, BearerCDMA2000},
+		{map[string][]string{"wwan0": {"unknown"}}, BearerUnknown},
+		{nil, BearerUnknown},
+	}
+	root := newNetworkTree(t)
+
+	for _, tt := range tests {
+		n := &fakeNetwork{
+			addresses:    map[string][]string{"wwan0": {"100.64.0.2"}},
+			routes:       []DefaultRoute{{Interface: "wwan0", Metric: 700}},
+			technologies: tt.technologies,
+		}
+		c := &Connectivity{root: root, network: n}
+		c.refresh()
+
+		if c.Interface != "wwan0" || c.Bearer != tt.bearer {
+			t.Errorf("%v: bearer = %d on %s, want %d on wwan0", tt.technologies, c.Bearer, c.Interface, tt.bearer)
+		}
+		for _, b := range c.AvailableBearers {
+			if b == BearerUnknown {
+				t.Errorf("%v: the unknown bearer is available", tt.technologies)
+			}
+		}
+	}
+}
+
+func TestConnectivityInstanceUsesTheNetwork(t *testing.T) {
+	n := &fakeNetwork{
+		addresses: map[string][]string{"eth0": {"192.168.1.10"}},
+	}
+	UseNetwork(newNetworkTree(t), n)
+	defer UseNetwork("/", systemNetwork{})
+
+	// Without a default route, the interface with an address is active
+	c := GetConnectivityInstance()
+	if c.Interface != "eth0" || !reflect.DeepEqual(c.IPAddresses, []string{"192.168.1.10"}) {
+		t.Errorf("connectivity = %+v, want the fake network", c)
+	}
+}
+
+func TestModemManagerAccessTechnologies(t *testing.T) {
+	// The fake mmcli lists a modem, and describes it in the key-value format
+	dir := t.TempDir()
+	script := `#!/bin/sh
+if [ "$2" = "-L" ]; then
+	echo "modem-list.length : 1"
+	echo "modem-list.value[1] : /org/freedesktop/ModemManager1/Modem/0"
+	exit 0
+fi
+echo "modem.generic.ports.length : 2"
+echo "modem.generic.ports.value[1] : cdc-wdm0 (qmi)"
+echo "modem.generic.ports.value[2] : wwan0 (net)"
+echo "modem.generic.access-technologies.length : 2"
+echo "modem.generic.access-technologies.value[1] : umts"
+echo "modem.generic.access-technologies.value[2] : lte"
+echo "modem.generic.operator-name : --"
+`
+	mmcliCommand = filepath.Join(dir, "mmcli")
+	defer func() { mmcliCommand = "mmcli" }()
+	if err := ioutil.WriteFile(mmcliCommand, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	n := systemNetwork{}
+	technologies, err := n.AccessTechnologies("wwan0")
+	if err != nil {
+		t.Fatalf("AccessTechnologies: %v", err)
+	}
+	if want := []string{"umts", "lte"}; !reflect.DeepEqual(technologies, want) {
+		t.Errorf("access technologies = %v, want %v", technologies, want)
+	}
+	if _, err = n.AccessTechnologies("wwan1"); err == nil {
+		t.Error("an interface without a modem has access technologies")
+	}
+}
@@ -15,11 +15,20 @@ import (
 
 // Device defines a device object
 type Device struct {
-	client      *snapdapi.ClientAdapter
+	client      snapdapi.SnapdClient
+	rebooter    Rebooter
+	mu          sync.Mutex
+	pending     *systemRequest
 	Info        snapdapi.DeviceInfo
 	lastRefresh int64
 }
 
+// systemRequest is a recovery system action to run in place of a plain reboot
+type systemRequest struct {
+	label string
+	mode  string
+}
+
 // Using a singleton to define the device
 var deviceInstance *Device
 var deviceOnce sync.Once
@@ -27,7 +36,8 @@ var deviceOnce sync.Once
 // GetDeviceInstance returns an instance of a device object
 func GetDeviceInstance() *Device {
 	deviceOnce.Do(func() {
-		deviceInstance = &Device{client: snapdapi.NewClientAdapter()}
+		c := newClient()
+		deviceInstance = &Device{client: c, rebooter: NewSnapdRebooter(c)}
 	})
 	if dataIsStale(deviceInstance.lastRefresh) {
 		deviceInstance.refresh()
@@ -48,3 +58,40 @@ func (d *Device) refresh() {
 
 	d.Info = details
 }
+
+// SetRebooter replaces the mechanism used to restart the device
+func (d *Device) SetRebooter(r Rebooter) {
+	d.rebooter = r
+}
+
+// RequestSystemAction queues a recovery system action that is run by the next Reboot
+func (d *Device) RequestSystemAction(label, mode string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending = &systemRequest{label: label, mode: mode}
+}
+
+// RequestFactoryReset queues a factory reset of the current recovery system
+func (d *Device) RequestFactoryReset() error {
+	s, err := GetSystemsInstance().Current()
+	if err != nil {
+		return err
+	}
+
+	d.RequestSystemAction(s.Label, ModeFactoryReset)
+	return nil
+}
+
+// Reboot restarts the device, running the queued recovery system action if there is one
+func (d *Device) Reboot() error {
+	d.mu.Lock()
+	r := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+
+	if r != nil {
+		return d.rebooter.RebootToSystem(r.label, r.mode)
+	}
+	return d.rebooter.Reboot()
+}
@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"reflect"
+	"testing"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
+	"launchpad.net/ce-web/alpaca/snapdapi/snapdtest"
+)
+
+// useSystems makes the recovery systems of the fake snapd the current ones
+func useSystems(t *testing.T, systems []snapdapi.System) {
+	fakeSnapd.SystemList = systems
+	GetSystemsInstance().lastRefresh = 0
+	if s := GetSystemsInstance(); !reflect.DeepEqual(s.Systems, systems) {
+		t.Fatalf("systems = %v, want %v", s.Systems, systems)
+	}
+}
+
+var testSystems = []snapdapi.System{
+	{Label: "20200101", Actions: []snapdapi.SystemAction{{Mode: ModeInstall}}},
+	{Label: "20201212", Current: true, Actions: []snapdapi.SystemAction{
+		{Mode: ModeRun}, {Mode: ModeRecover}, {Mode: ModeFactoryReset},
+	}},
+}
+
+func TestDeviceFactoryReset(t *testing.T) {
+	useSystems(t, testSystems)
+
+	r := &FakeRebooter{}
+	d := &Device{rebooter: r}
+	if err := d.RequestFactoryReset(); err != nil {
+		t.Fatalf("RequestFactoryReset: %v", err)
+	}
+	if r.Count() != 0 {
+		t.Fatal("the factory reset rebooted before the Reboot")
+	}
+
+	if err := d.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if !reflect.DeepEqual(r.Systems, []string{"20201212 factory-reset"}) {
+		t.Errorf("systems = %q, want the factory reset of the current system", r.Systems)
+	}
+
+	// The request is only run once
+	if err := d.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if r.Count() != 2 || len(r.Systems) != 1 {
+		t.Errorf("reboots = %d, systems = %q, want a plain reboot", r.Count(), r.Systems)
+	}
+}
+
+func TestDeviceFactoryResetWithoutSystems(t *testing.T) {
+	// Ubuntu Core 18 has no recovery systems
+	useSystems(t, nil)
+
+	r := &FakeRebooter{}
+	d := &Device{rebooter: r}
+	if err := d.RequestFactoryReset(); err == nil {
+		t.Fatal("RequestFactoryReset succeeded without a current recovery system")
+	}
+	if err := d.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if len(r.Systems) != 0 {
+		t.Errorf("systems = %q, want a plain reboot", r.Systems)
+	}
+}
+
+func TestDeviceRecoverySystemRequest(t *testing.T) {
+	useSystems(t, testSystems)
+	s := GetSystemsInstance()
+
+	tests := []struct {
+		instance int
+		mode     string
+		ok       bool
+	}{
+		{0, ModeInstall, true},
+		{0, ModeRecover, false},
+		{1, ModeRecover, true},
+		{1, ModeRun, true},
+		{2, ModeRun, false},
+		{-1, ModeRun, false},
+	}
+	for _, tt := range tests {
+		if err := s.Supports(tt.instance, tt.mode); (err == nil) != tt.ok {
+			t.Errorf("Supports(%d, %s) = %v, want ok %v", tt.instance, tt.mode, err, tt.ok)
+		}
+	}
+
+	f := snapdtest.NewFakeSnapdClient()
+	d := &Device{rebooter: &SnapdRebooter{client: f, fallback: &FakeRebooter{}}}
+	d.RequestSystemAction(s.Systems[1].Label, ModeRecover)
+	if err := d.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if !reflect.DeepEqual(f.Actions, []string{"system 20201212 recover"}) {
+		t.Errorf("snapd actions = %q, want the recover action", f.Actions)
+	}
+}
@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+)
+
+// Kinds of the snap errors that are not reported by snapd with a kind
+const (
+	ErrorKindChangeFailed  = "change-failed"
+	ErrorKindRequestFailed = "request-failed"
+)
+
+// maxSnapErrors is the number of snap errors that are kept
+const maxSnapErrors = 10
+
+// SnapError is the failure of a snap operation
+type SnapError struct {
+	Time    time.Time
+	Action  string
+	Snap    string
+	Kind    string
+	Message string
+}
+
+// SnapErrors is a ring buffer of the recent failures of the snap operations
+type SnapErrors struct {
+	mu      sync.Mutex
+	entries [maxSnapErrors]SnapError
+	next    int
+	count   int
+}
+
+// Using a singleton to define the snap errors
+var errorsInstance *SnapErrors
+var errorsOnce sync.Once
+
+// GetErrorsInstance returns the snap errors
+func GetErrorsInstance() *SnapErrors {
+	errorsOnce.Do(func() {
+		errorsInstance = &SnapErrors{}
+	})
+	return errorsInstance
+}
+
+// Add records the failure of the action on the snap, with the kind of the
+// snapd error
+func (e *SnapErrors) Add(action, snap string, err error) {
+	kind := ErrorKindRequestFailed
+	if cerr, ok := err.(*client.Error); ok && cerr.Kind != "" {
+		kind = cerr.Kind
+	}
+	e.add(SnapError{Action: action, Snap: snap, Kind: kind, Message: err.Error()})
+}
+
+// AddChange records the failure of the snapd change of the action on the snap
+func (e *SnapErrors) AddChange(action, snap string, chg *client.Change) {
+	e.add(SnapError{Action: action, Snap: snap, Kind: ErrorKindChangeFailed, Message: chg.Err})
+}
+
+// add records the error, overwriting the oldest when the buffer is full
+func (e *SnapErrors) add(s SnapError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s.Time = time.Now()
+	e.entries[e.next] = s
+	e.next = (e.next + 1) % maxSnapErrors
+	if e.count < maxSnapErrors {
+		e.count++
+	}
+}
+
+// Errors returns the recorded errors, the oldest first
+func (e *SnapErrors) Errors() []SnapError {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	errors := []SnapError{}
+	for i := 0; i < e.count; i++ {
+		errors = append(errors, e.entries[(e.next-e.count+i+maxSnapErrors)%maxSnapErrors])
+	}
+	return errors
+}
@@ -0,0 +1,523 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/squashfs"
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+// Firmware update states, as defined by the LwM2M Firmware Update object
+const (
+	FirmwareStateIdle        = 0
+	FirmwareStateDownloading = 1
+	FirmwareStateDownloaded  = 2
+	FirmwareStateUpdating    = 3
+)
+
+// Firmware update results, as defined by the LwM2M Firmware Update object
+const (
+	FirmwareResultInitial             = 0
+	FirmwareResultSuccess             = 1
+	FirmwareResultNoStorage           = 2
+	FirmwareResultNoMemory            = 3
+	FirmwareResultConnectionLost      = 4
+	FirmwareResultIntegrityFailure    = 5
+	FirmwareResultUnsupportedType     = 6
+	FirmwareResultInvalidURI          = 7
+	FirmwareResultFailed              = 8
+	FirmwareResultUnsupportedProtocol = 9
+)
+
+const (
+	firmwareStatusFile  = "firmware.json"
+	firmwarePackageFile = "firmware.snap"
+
+	// Largest assertion that is downloaded with the package
+	maxAssertionSize = 1 << 20
+
+	// Number of attempts to resume an interrupted download
+	downloadAttempts = 3
+)
+
+// firmwareSnapTypes are the snap types that the firmware update object installs
+var firmwareSnapTypes = []snap.Type{snap.TypeKernel, snap.TypeOS, snap.TypeBase, snap.TypeGadget}
+
+// readPackageYaml reads the snap.yaml of the downloaded package
+var readPackageYaml = func(p string) ([]byte, error) {
+	return squashfs.New(p).ReadFile("meta/snap.yaml")
+}
+
+// FirmwareStatus is the state of the firmware update, persisted across restarts
+type FirmwareStatus struct {
+	State   int    `json:"state"`
+	Result  int    `json:"result"`
+	URI     string `json:"uri"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Change  string `json:"change"`
+
+	// Asserted is true when snapd has acknowledged the assertions of the
+	// package, so that snapd verifies the signature of the package
+	Asserted bool `json:"asserted"`
+}
+
+// Firmware defines the firmware update object. The firmware is a kernel, core
+// or gadget snap that is downloaded from the package URI
+type Firmware struct {
+	mu         sync.Mutex
+	status     FirmwareStatus
+	client     snapdapi.SnapdClient
+	httpClient *http.Client
+	dir        string
+
+	// cancel stops the download in progress, which closes done once it has stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Using a singleton to define the firmware update
+var firmwareInstance *Firmware
+var firmwareOnce sync.Once
+
+// GetFirmwareInstance returns an instance of the firmware update object
+func GetFirmwareInstance() *Firmware {
+	firmwareOnce.Do(func() {
+		firmwareInstance = NewFirmware(newClient(), http.DefaultClient, dataDir())
+	})
+	return firmwareInstance
+}
+
+// NewFirmware creates a firmware update object that keeps its state in the directory.
+// An interrupted download or update is resumed.
+func NewFirmware(c snapdapi.SnapdClient, httpClient *http.Client, dir string) *Firmware {
+	f := &Firmware{client: c, httpClient: httpClient, dir: dir}
+	f.load()
+
+	switch f.status.State {
+	case FirmwareStateDownloading:
+		f.startDownload(f.status.URI)
+	case FirmwareStateUpdating:
+		// The device may have rebooted to complete the update
+		f.Refresh()
+	}
+	return f
+}
+
+// Status returns the current state of the firmware update
+func (f *Firmware) Status() FirmwareStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// SetPackageURI starts the download of the firmware from the URI. The package
+// is verified by its assertions, served next to it with the .assert extension,
+// or by the SHA-256 checksum in the fragment of the URI e.g. #sha256=abcd.
+// An empty URI cancels the firmware update.
+func (f *Firmware) SetPackageURI(uri string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status.State == FirmwareStateUpdating {
+		return errors.New("firmware update in progress")
+	}
+
+	if len(uri) == 0 {
+		f.stopDownload()
+		os.Remove(f.packagePath())
+		f.status = FirmwareStatus{}
+		f.save()
+		return nil
+	}
+
+	// The download keeps writing the package, so its state is kept until it
+	// completes or is cancelled
+	if f.status.State == FirmwareStateDownloading {
+		return errors.New("firmware download in progress")
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil || len(u.Host) == 0 {
+		f.setResult(FirmwareStateIdle, FirmwareResultInvalidURI)
+		return fmt.Errorf("invalid package URI: %s", uri)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		f.setResult(FirmwareStateIdle, FirmwareResultUnsupportedProtocol)
+		return fmt.Errorf("unsupported package URI protocol: %s", u.Scheme)
+	}
+
+	// A partial download can only be resumed from the same URI
+	if uri != f.status.URI {
+		os.Remove(f.packagePath())
+	}
+
+	f.status = FirmwareStatus{
+		State: FirmwareStateDownloading,
+		URI:   uri,
+	}
+	f.save()
+
+	f.startDownload(uri)
+	return nil
+}
+
+// startDownload downloads the package in the background. The caller holds the lock.
+func (f *Firmware) startDownload(uri string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	f.cancel = cancel
+	f.done = done
+
+	go func() {
+		defer close(done)
+		f.download(ctx, uri)
+	}()
+}
+
+// stopDownload cancels the download in progress and waits for it to stop, so
+// that the package is not written any more. The caller holds the lock, which
+// is released while waiting.
+func (f *Firmware) stopDownload() {
+	if f.cancel == nil {
+		return
+	}
+	f.cancel()
+	done := f.done
+	f.cancel = nil
+	f.done = nil
+
+	f.mu.Unlock()
+	<-done
+	f.mu.Lock()
+}
+
+// Update installs the downloaded firmware snap
+func (f *Firmware) Update() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status.State != FirmwareStateDownloaded {
+		return errors.New("no firmware has been downloaded")
+	}
+
+	// snapd verifies the package with its assertions, and the package that
+	// has none was verified with its checksum
+	log.Printf("---Firmware update: %s", f.status.Name)
+	change, err := f.client.InstallPath(f.packagePath(), &client.SnapOptions{Dangerous: !f.status.Asserted})
+	if err != nil {
+		log.Printf("Error installing the firmware: %v", err)
+		f.setResult(FirmwareStateDownloaded, FirmwareResultFailed)
+		return err
+	}
+
+	f.status.Change = change
+	f.setResult(FirmwareStateUpdating, FirmwareResultInitial)
+	return nil
+}
+
+// Refresh checks the progress of the snapd change that installs the firmware
+func (f *Firmware) Refresh() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status.State != FirmwareStateUpdating {
+		return
+	}
+
+	chg, err := f.client.Change(f.status.Change)
+	if err != nil {
+		log.Printf("Error checking the firmware update: %v", err)
+		return
+	}
+
+	if !chg.Ready {
+		// Kernel, core and gadget changes complete after the device reboots
+		log.Printf("Firmware update %s: %s (reboot pending)", chg.ID, chg.Status)
+		return
+	}
+
+	if chg.Status != "Done" {
+		log.Printf("Firmware update failed: %s", chg.Err)
+		f.setResult(FirmwareStateDownloaded, FirmwareResultFailed)
+		return
+	}
+
+	if s, _, err := f.client.Snap(f.status.Name); err == nil {
+		f.status.Version = s.Version
+	}
+	os.Remove(f.packagePath())
+	f.setResult(FirmwareStateIdle, FirmwareResultSuccess)
+}
+
+// download fetches the package, resuming a partial download, and verifies it
+func (f *Firmware) download(ctx context.Context, uri string) {
+	var result int
+	var err error
+
+	for i := 0; i < downloadAttempts && ctx.Err() == nil; i++ {
+		result, err = f.fetch(ctx, uri)
+		if result != FirmwareResultConnectionLost {
+			break
+		}
+		log.Printf("Firmware download interrupted, resuming: %v", err)
+	}
+
+	var v packageVerification
+	if err == nil {
+		v, result, err = f.verify(ctx, uri)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ctx.Err() != nil {
+		// The download was cancelled
+		return
+	}
+	f.cancel()
+	f.cancel = nil
+	f.done = nil
+
+	if err != nil {
+		log.Printf("Error downloading the firmware: %v", err)
+		if result != FirmwareResultConnectionLost {
+			os.Remove(f.packagePath())
+		}
+		f.setResult(FirmwareStateIdle, result)
+		return
+	}
+
+	log.Printf("Firmware downloaded: %s", v.name)
+	f.status.Name = v.name
+	f.status.Asserted = v.asserted
+	f.setResult(FirmwareStateDownloaded, FirmwareResultInitial)
+}
+
+// fetch downloads the package from the URI, appending to a partial download
+func (f *Firmware) fetch(ctx context.Context, uri string) (int, error) {
+	p := f.packagePath()
+
+	var offset int64
+	if info, err := os.Stat(p); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return FirmwareResultInvalidURI, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return FirmwareResultConnectionLost, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server does not support ranges, so start again
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial download is complete
+		return FirmwareResultInitial, nil
+	case http.StatusNotFound:
+		return FirmwareResultInvalidURI, fmt.Errorf("package not found: %s", uri)
+	default:
+		return FirmwareResultConnectionLost, fmt.Errorf("unexpected response downloading the package: %s", resp.Status)
+	}
+
+	out, err := os.OpenFile(p, flags, 0600)
+	if err != nil {
+		return FirmwareResultNoStorage, err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return FirmwareResultNoStorage, err
+		}
+		return FirmwareResultConnectionLost, err
+	}
+
+	return FirmwareResultInitial, out.Sync()
+}
+
+// packageVerification is the outcome of the verification of a package
+type packageVerification struct {
+	name     string
+	asserted bool
+}
+
+// verify checks the integrity of the package, with its assertions or its
+// checksum, and that the snap.yaml of the package is a firmware snap
+func (f *Firmware) verify(ctx context.Context, uri string) (packageVerification, int, error) {
+	var v packageVerification
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return v, FirmwareResultInvalidURI, err
+	}
+
+	if strings.HasPrefix(u.Fragment, "sha256=") {
+		expected := strings.ToLower(strings.TrimPrefix(u.Fragment, "sha256="))
+		sum, err := fileSHA256(f.packagePath())
+		if err != nil {
+			return v, FirmwareResultIntegrityFailure, err
+		}
+		if sum != expected {
+			return v, FirmwareResultIntegrityFailure, fmt.Errorf("package checksum mismatch: expected %s, got %s", expected, sum)
+		}
+	}
+
+	assertions, err := f.fetchAssertions(ctx, assertionURI(u))
+	if err != nil {
+		return v, FirmwareResultConnectionLost, err
+	}
+	if assertions != nil {
+		// snapd checks the signatures, and verifies the package against them on install
+		if err := f.client.Ack(assertions); err != nil {
+			return v, FirmwareResultIntegrityFailure, fmt.Errorf("invalid package assertions: %v", err)
+		}
+		v.asserted = true
+	} else if !strings.HasPrefix(u.Fragment, "sha256=") {
+		return v, FirmwareResultIntegrityFailure, errors.New("the package has no assertions and the URI has no sha256 checksum")
+	}
+
+	yaml, err := readPackageYaml(f.packagePath())
+	if err != nil {
+		return v, FirmwareResultUnsupportedType, fmt.Errorf("cannot read the snap.yaml of the package: %v", err)
+	}
+	info, err := snap.InfoFromSnapYaml(yaml)
+	if err != nil {
+		return v, FirmwareResultUnsupportedType, fmt.Errorf("invalid snap.yaml in the package: %v", err)
+	}
+	v.name = info.Name()
+
+	for _, t := range firmwareSnapTypes {
+		if info.Type == t {
+			return v, FirmwareResultInitial, nil
+		}
+	}
+	return v, FirmwareResultUnsupportedType, fmt.Errorf("snap %s of type %s is not firmware", v.name, info.Type)
+}
+
+// fetchAssertions downloads the assertions of the package. There are none
+// when the server does not have them.
+func (f *Firmware) fetchAssertions(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected response downloading the package assertions: %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAssertionSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxAssertionSize {
+		return nil, errors.New("the package assertions are too large")
+	}
+	return b, nil
+}
+
+// setResult updates the state and result, and persists them
+func (f *Firmware) setResult(state, result int) {
+	f.status.State = state
+	f.status.Result = result
+	f.save()
+}
+
+func (f *Firmware) packagePath() string {
+	return filepath.Join(f.dir, firmwarePackageFile)
+}
+
+// load reads the persisted firmware update state
+func (f *Firmware) load() {
+	dat, err := ioutil.ReadFile(filepath.Join(f.dir, firmwareStatusFile))
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(dat, &f.status); err != nil {
+		log.Printf("Error parsing the firmware update state: %v", err)
+	}
+}
+
+// save persists the firmware update state
+func (f *Firmware) save() {
+	b, err := json.Marshal(f.status)
+	if err != nil {
+		log.Printf("Error marshalling the firmware update state: %v", err)
+		return
+	}
+
+	if err = WriteFileAtomic(filepath.Join(f.dir, firmwareStatusFile), b, 0600); err != nil {
+		log.Printf("Error storing the firmware update state: %v", err)
+	}
+}
+
+// assertionURI is the URI of the assertions of the package, the package file
+// with the .assert extension e.g. pc-kernel_123.assert
+func assertionURI(u *url.URL) string {
+	a := *u
+	a.Fragment = ""
+	a.RawQuery = ""
+	a.Path = strings.TrimSuffix(u.Path, ".snap") + ".assert"
+	a.RawPath = ""
+	return a.String()
+}
+
+func fileSHA256(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
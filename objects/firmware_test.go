@@ -0,0 +1,331 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+
+	"launchpad.net/ce-web/alpaca/snapdapi/snapdtest"
+)
+
+// The test packages are their snap.yaml, which readPackageYaml reads as is
+const (
+	kernelPackage = "name: pc-kernel\nversion: 5.4\ntype: kernel\n"
+	appPackage    = "name: hello\nversion: 1.0\n"
+)
+
+// packageServer serves the firmware packages and their assertions
+type packageServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	files map[string]string
+	gets  []string
+}
+
+func newPackageServer(t *testing.T, files map[string]string) *packageServer {
+	s := &packageServer{files: files}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.gets = append(s.gets, r.URL.Path)
+		content, ok := s.files[r.URL.Path]
+		s.mu.Unlock()
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader([]byte(content)))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newTestFirmware creates a firmware update object with a fake snapd that
+// keeps its state in a temporary directory
+func newTestFirmware(t *testing.T) (*Firmware, *snapdtest.FakeSnapdClient) {
+	readYaml := readPackageYaml
+	readPackageYaml = ioutil.ReadFile
+	t.Cleanup(func() { readPackageYaml = readYaml })
+
+	c := snapdtest.NewFakeSnapdClient()
+	return NewFirmware(c, http.DefaultClient, t.TempDir()), c
+}
+
+// waitForDownload waits for the download to complete or fail
+func waitForDownload(t *testing.T, f *Firmware) FirmwareStatus {
+	for i := 0; i < 500; i++ {
+		if s := f.Status(); s.State != FirmwareStateDownloading {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the firmware download did not complete")
+	return FirmwareStatus{}
+}
+
+func sha256Fragment(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "#sha256=" + hex.EncodeToString(sum[:])
+}
+
+func TestFirmwareUpdateWithChecksum(t *testing.T) {
+	srv := newPackageServer(t, map[string]string{"/pc-kernel_12.snap": kernelPackage})
+	f, c := newTestFirmware(t)
+
+	if err := f.SetPackageURI(srv.URL + "/pc-kernel_12.snap" + sha256Fragment(kernelPackage)); err != nil {
+		t.Fatalf("SetPackageURI: %v", err)
+	}
+	s := waitForDownload(t, f)
+	if s.State != FirmwareStateDownloaded || s.Result != FirmwareResultInitial {
+		t.Fatalf("state %d, result %d, want downloaded", s.State, s.Result)
+	}
+	if s.Name != "pc-kernel" || s.Asserted {
+		t.Errorf("name %q, asserted %v, want pc-kernel without assertions", s.Name, s.Asserted)
+	}
+
+	if err := f.Update(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want := "install-path " + f.packagePath() + " dangerous=true"
+	if len(c.Actions) != 1 || c.Actions[0] != want {
+		t.Errorf("snapd actions = %q, want %q", c.Actions, want)
+	}
+	if s := f.Status(); s.State != FirmwareStateUpdating {
+		t.Fatalf("state %d, want updating", s.State)
+	}
+
+	c.Snaps = []*client.Snap{{Name: "pc-kernel", Version: "5.4", Type: "kernel"}}
+	f.Refresh()
+	s = f.Status()
+	if s.State != FirmwareStateIdle || s.Result != FirmwareResultSuccess || s.Version != "5.4" {
+		t.Errorf("state %d, result %d, version %q, want a successful update", s.State, s.Result, s.Version)
+	}
+	if _, err := os.Stat(f.packagePath()); !os.IsNotExist(err) {
+		t.Errorf("the package was not removed: %v", err)
+	}
+}
+
+func TestFirmwareUpdateWithAssertions(t *testing.T) {
+	srv := newPackageServer(t, map[string]string{
+		"/pc-kernel_12.snap":   kernelPackage,
+		"/pc-kernel_12.assert": "type: snap-revision\n",
+	})
+	f, c := newTestFirmware(t)
+
+	if err := f.SetPackageURI(srv.URL + "/pc-kernel_12.snap"); err != nil {
+		t.Fatalf("SetPackageURI: %v", err)
+	}
+	s := waitForDownload(t, f)
+	if s.State != FirmwareStateDownloaded || !s.Asserted {
+		t.Fatalf("state %d, result %d, asserted %v, want downloaded with assertions", s.State, s.Result, s.Asserted)
+	}
+
+	if err := f.Update(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want := []string{"ack", "install-path " + f.packagePath() + " dangerous=false"}
+	if len(c.Actions) != 2 || c.Actions[0] != want[0] || c.Actions[1] != want[1] {
+		t.Errorf("snapd actions = %q, want %q", c.Actions, want)
+	}
+}
+
+func TestFirmwareVerificationFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		uri    string
+		ackErr error
+		result int
+	}{{
+		name:   "no assertions or checksum",
+		files:  map[string]string{"/pc-kernel_12.snap": kernelPackage},
+		uri:    "/pc-kernel_12.snap",
+		result: FirmwareResultIntegrityFailure,
+	}, {
+		name:   "checksum mismatch",
+		files:  map[string]string{"/pc-kernel_12.snap": kernelPackage},
+		uri:    "/pc-kernel_12.snap" + sha256Fragment(appPackage),
+		result: FirmwareResultIntegrityFailure,
+	}, {
+		name: "unsigned assertions",
+		files: map[string]string{
+			"/pc-kernel_12.snap":   kernelPackage,
+			"/pc-kernel_12.assert": "type: snap-revision\n",
+		},
+		uri:    "/pc-kernel_12.snap",
+		ackErr: errors.New("cannot resolve prerequisite assertion"),
+		result: FirmwareResultIntegrityFailure,
+	}, {
+		// The type is read from the package, not from its file name
+		name:   "app snap",
+		files:  map[string]string{"/pc-kernel_12.snap": appPackage},
+		uri:    "/pc-kernel_12.snap" + sha256Fragment(appPackage),
+		result: FirmwareResultUnsupportedType,
+	}, {
+		name:   "not a snap",
+		files:  map[string]string{"/pc-kernel_12.snap": "{]"},
+		uri:    "/pc-kernel_12.snap" + sha256Fragment("{]"),
+		result: FirmwareResultUnsupportedType,
+	}, {
+		name:   "package not found",
+		files:  map[string]string{},
+		uri:    "/pc-kernel_12.snap",
+		result: FirmwareResultInvalidURI,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newPackageServer(t, tt.files)
+			f, c := newTestFirmware(t)
+			c.AckErr = tt.ackErr
+
+			if err := f.SetPackageURI(srv.URL + tt.uri); err != nil {
+				t.Fatalf("SetPackageURI: %v", err)
+			}
+			s := waitForDownload(t, f)
+			if s.State != FirmwareStateIdle || s.Result != tt.result {
+				t.Errorf("state %d, result %d, want idle with result %d", s.State, s.Result, tt.result)
+			}
+			if _, err := os.Stat(f.packagePath()); !os.IsNotExist(err) {
+				t.Errorf("the package was not removed: %v", err)
+			}
+			if err := f.Update(); err == nil {
+				t.Error("Update succeeded without a verified package")
+			}
+		})
+	}
+}
+
+func TestFirmwareInvalidPackageURI(t *testing.T) {
+	f, _ := newTestFirmware(t)
+
+	if err := f.SetPackageURI("ftp://example.com/pc-kernel.snap"); err == nil {
+		t.Error("SetPackageURI accepted an FTP URI")
+	}
+	if s := f.Status(); s.Result != FirmwareResultUnsupportedProtocol {
+		t.Errorf("result %d, want unsupported protocol", s.Result)
+	}
+
+	if err := f.SetPackageURI("pc-kernel.snap"); err == nil {
+		t.Error("SetPackageURI accepted a URI without a host")
+	}
+	if s := f.Status(); s.Result != FirmwareResultInvalidURI {
+		t.Errorf("result %d, want invalid URI", s.Result)
+	}
+}
+
+func TestFirmwareCancelStopsTheDownload(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	f, _ := newTestFirmware(t)
+	if err := f.SetPackageURI(srv.URL + "/pc-kernel_12.snap"); err != nil {
+		t.Fatalf("SetPackageURI: %v", err)
+	}
+	<-started
+
+	// The cancel returns once the download has stopped writing the package
+	if err := f.SetPackageURI(""); err != nil {
+		t.Fatalf("SetPackageURI: %v", err)
+	}
+	if f.cancel != nil || f.done != nil {
+		t.Error("the download is still tracked after the cancel")
+	}
+	if s := f.Status(); s.State != FirmwareStateIdle || s.URI != "" {
+		t.Errorf("state %d, URI %q, want idle", s.State, s.URI)
+	}
+	if _, err := os.Stat(f.packagePath()); !os.IsNotExist(err) {
+		t.Errorf("the package was not removed: %v", err)
+	}
+}
+
+func TestFirmwareURIDuringTheDownload(t *testing.T) {
+	started := make(chan struct{}, 2)
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	f, _ := newTestFirmware(t)
+	uri := srv.URL + "/pc-kernel_12.snap"
+	if err := f.SetPackageURI(uri); err != nil {
+		t.Fatalf("SetPackageURI: %v", err)
+	}
+	<-started
+
+	// Neither an invalid URI nor a new one interrupts the download
+	for _, u := range []string{"pc-kernel.snap", "ftp://example.com/pc-kernel.snap", srv.URL + "/pc-kernel_13.snap"} {
+		if err := f.SetPackageURI(u); err == nil {
+			t.Errorf("SetPackageURI(%q) succeeded during the download", u)
+		}
+		if s := f.Status(); s.State != FirmwareStateDownloading || s.URI != uri || s.Result != FirmwareResultInitial {
+			t.Errorf("after %q: state %d, result %d, URI %q, want the download of %q", u, s.State, s.Result, s.URI, uri)
+		}
+	}
+	if err := f.Update(); err == nil {
+		t.Error("Update succeeded during the download")
+	}
+
+	if err := f.SetPackageURI(""); err != nil {
+		t.Fatalf("SetPackageURI: %v", err)
+	}
+}
+
+func TestFirmwareResumesTheDownload(t *testing.T) {
+	srv := newPackageServer(t, map[string]string{"/pc-kernel_12.snap": kernelPackage})
+	readYaml := readPackageYaml
+	readPackageYaml = ioutil.ReadFile
+	defer func() { readPackageYaml = readYaml }()
+
+	// The client restarted with half of the package downloaded
+	dir := t.TempDir()
+	uri := srv.URL + "/pc-kernel_12.snap" + sha256Fragment(kernelPackage)
+	b, _ := json.Marshal(FirmwareStatus{State: FirmwareStateDownloading, URI: uri})
+	if err := ioutil.WriteFile(filepath.Join(dir, firmwareStatusFile), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, firmwarePackageFile), []byte(kernelPackage[:10]), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFirmware(snapdtest.NewFakeSnapdClient(), http.DefaultClient, dir)
+	s := waitForDownload(t, f)
+	if s.State != FirmwareStateDownloaded {
+		t.Fatalf("state %d, result %d, want downloaded", s.State, s.Result)
+	}
+	b, err := ioutil.ReadFile(f.packagePath())
+	if err != nil || string(b) != kernelPackage {
+		t.Errorf("package %q (%v), want the full package", b, err)
+	}
+}
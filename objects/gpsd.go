@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	gpsdWatch       = `?WATCH={"enable":true,"json":true};`
+	gpsdDialTimeout = 5 * time.Second
+	gpsdMinBackoff  = time.Second
+	gpsdMaxBackoff  = time.Minute
+
+	// A gpsd report has a position from a 2D fix onwards
+	gpsdMode2D = 2
+)
+
+// gpsdReport is a report from gpsd. Only the time-position-velocity (TPV) reports are used.
+type gpsdReport struct {
+	Class  string    `json:"class"`
+	Mode   int       `json:"mode"`
+	Time   time.Time `json:"time"`
+	Lat    float64   `json:"lat"`
+	Lon    float64   `json:"lon"`
+	Alt    float64   `json:"alt"`
+	AltMSL *float64  `json:"altMSL"`
+	Speed  float64   `json:"speed"`
+	Track  float64   `json:"track"`
+	Epx    float64   `json:"epx"`
+	Epy    float64   `json:"epy"`
+}
+
+// GPSDLocation follows the position reported by gpsd over its JSON protocol.
+// The fallback position is used while there is no fix.
+type GPSDLocation struct {
+	addr     string
+	fallback LocationProvider
+
+	mu       sync.Mutex
+	position Position
+	fix      bool
+}
+
+// NewGPSDLocation connects to gpsd at the address e.g. localhost:2947. The
+// connection is retried in the background until it succeeds.
+func NewGPSDLocation(addr string, fallback LocationProvider) *GPSDLocation {
+	if fallback == nil {
+		fallback = noLocation{}
+	}
+
+	g := &GPSDLocation{addr: addr, fallback: fallback}
+	go g.run()
+	return g
+}
+
+// Position returns the last fix from gpsd, or the fallback position
+func (g *GPSDLocation) Position() (Position, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.fix {
+		return g.fallback.Position()
+	}
+	return g.position, true
+}
+
+// run keeps the connection to gpsd, backing off when it is not available
+func (g *GPSDLocation) run() {
+	backoff := gpsdMinBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", g.addr, gpsdDialTimeout)
+		if err == nil {
+			backoff = gpsdMinBackoff
+			err = g.watch(conn)
+			conn.Close()
+		}
+		log.Printf("Error reading the position from gpsd: %v", err)
+		g.setFix(Position{}, false)
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > gpsdMaxBackoff {
+			backoff = gpsdMaxBackoff
+		}
+	}
+}
+
+// watch enables the reports from gpsd and follows them until the connection is closed
+func (g *GPSDLocation) watch(conn net.Conn) error {
+	if _, err := fmt.Fprintln(conn, gpsdWatch); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		r := gpsdReport{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.Printf("Error parsing the gpsd report: %v", err)
+			continue
+		}
+		if r.Class != "TPV" {
+			continue
+		}
+
+		if r.Mode < gpsdMode2D {
+			g.setFix(Position{}, false)
+			continue
+		}
+		g.setFix(r.position(), true)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection to %s closed", g.addr)
+}
+
+func (g *GPSDLocation) setFix(p Position, fix bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.position = p
+	g.fix = fix
+}
+
+// position converts the report to a position
+func (r gpsdReport) position() Position {
+	p := Position{
+		Latitude:  r.Lat,
+		Longitude: r.Lon,
+		Altitude:  r.Alt,
+		Radius:    math.Max(r.Epx, r.Epy),
+		Speed:     r.Speed,
+		Track:     r.Track,
+		Timestamp: r.Time.Unix(),
+	}
+
+	// Newer versions of gpsd report the altitude above mean sea level separately
+	if r.AltMSL != nil {
+		p.Altitude = *r.AltMSL
+	}
+	if r.Time.IsZero() {
+		p.Timestamp = time.Now().Unix()
+	}
+	return p
+}
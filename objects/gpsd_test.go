@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeGPSD is a local stand-in for gpsd that sends the positions it is given
+// to the clients that have enabled the reports
+type FakeGPSD struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients []net.Conn
+}
+
+// NewFakeGPSD listens for gpsd clients on a free local port
+func NewFakeGPSD() (*FakeGPSD, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	g := &FakeGPSD{listener: l}
+	go g.accept()
+	return g, nil
+}
+
+// Addr returns the address that the gpsd clients connect to
+func (g *FakeGPSD) Addr() string {
+	return g.listener.Addr().String()
+}
+
+// Send reports the position to the clients, as a 3D fix
+func (g *FakeGPSD) Send(p Position) {
+	g.send(map[string]interface{}{
+		"class":  "TPV",
+		"mode":   3,
+		"time":   time.Unix(p.Timestamp, 0).UTC().Format(time.RFC3339),
+		"lat":    p.Latitude,
+		"lon":    p.Longitude,
+		"altMSL": p.Altitude,
+		"speed":  p.Speed,
+		"track":  p.Track,
+		"epx":    p.Radius,
+		"epy":    p.Radius,
+	})
+}
+
+// LoseFix reports to the clients that there is no fix
+func (g *FakeGPSD) LoseFix() {
+	g.send(map[string]interface{}{"class": "TPV", "mode": 1})
+}
+
+// Disconnect closes the connections of the clients, which connect again
+func (g *FakeGPSD) Disconnect() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, c := range g.clients {
+		c.Close()
+	}
+	g.clients = nil
+}
+
+// Close disconnects the clients and stops listening
+func (g *FakeGPSD) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, c := range g.clients {
+		c.Close()
+	}
+	g.clients = nil
+	return g.listener.Close()
+}
+
+// accept waits for a client to enable the reports with a WATCH command
+func (g *FakeGPSD) accept() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+				conn.Close()
+				return
+			}
+			fmt.Fprintln(conn, `{"class":"VERSION","release":"fake","proto_major":3,"proto_minor":14}`)
+
+			g.mu.Lock()
+			g.clients = append(g.clients, conn)
+			g.mu.Unlock()
+		}(conn)
+	}
+}
+
+func (g *FakeGPSD) send(report map[string]interface{}) {
+	b, _ := json.Marshal(report)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, c := range g.clients {
+		fmt.Fprintln(c, string(b))
+	}
+}
+
+// waitForPosition sends the position until the location reports it, as the
+// client may not have enabled the reports yet
+func waitForPosition(t *testing.T, fake *FakeGPSD, g *GPSDLocation, p Position) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fake.Send(p)
+		time.Sleep(20 * time.Millisecond)
+		if got, ok := g.Position(); ok && got == p {
+			return
+		}
+	}
+	got, _ := g.Position()
+	t.Fatalf("position = %+v, want %+v", got, p)
+}
+
+func TestGPSDLocationFollowsTheFix(t *testing.T) {
+	fake, err := NewFakeGPSD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	fallback := NewStaticLocation(51.5, -0.1, 10)
+	g := NewGPSDLocation(fake.Addr(), fallback)
+
+	p := Position{Latitude: 48.85, Longitude: 2.35, Altitude: 35, Radius: 4, Speed: 1.5, Track: 90, Timestamp: 1600000000}
+	waitForPosition(t, fake, g, p)
+
+	// Without a fix, the fallback position is used
+	fake.LoseFix()
+	want, _ := fallback.Position()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, ok := g.Position(); ok && got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the fallback position was not used after the fix was lost")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGPSDLocationReconnects(t *testing.T) {
+	fake, err := NewFakeGPSD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	g := NewGPSDLocation(fake.Addr(), nil)
+	if _, ok := g.Position(); ok {
+		t.Error("a position was reported before the first fix")
+	}
+
+	waitForPosition(t, fake, g, Position{Latitude: 1, Longitude: 2, Timestamp: 1600000000})
+	fake.Disconnect()
+	waitForPosition(t, fake, g, Position{Latitude: 3, Longitude: 4, Timestamp: 1600000001})
+}
+
+func TestGPSDReportPosition(t *testing.T) {
+	msl := 12.5
+	r := gpsdReport{Class: "TPV", Mode: 3, Lat: 1, Lon: 2, Alt: 60, AltMSL: &msl, Epx: 3, Epy: 7, Time: time.Unix(1600000000, 0)}
+	p := r.position()
+	if p.Altitude != msl || p.Radius != 7 || p.Timestamp != 1600000000 {
+		t.Errorf("position = %+v, want the altitude above sea level and the largest error", p)
+	}
+
+	// Older versions of gpsd only report the altitude, and the time may be missing
+	r = gpsdReport{Class: "TPV", Mode: 2, Lat: 1, Lon: 2, Alt: 60}
+	p = r.position()
+	if p.Altitude != 60 || p.Timestamp < time.Now().Unix()-1 {
+		t.Errorf("position = %+v, want the altitude and the current time", p)
+	}
+}
@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// maxInstanceID is the highest LwM2M object instance ID (65535 is reserved)
+const maxInstanceID = 65534
+
+// InstanceIDs assigns stable LwM2M object instance IDs to named items, so an
+// item keeps its instance ID when other items are added or removed. The IDs
+// are assigned in increasing order, so the ID of a removed item is not given
+// to a new item until the IDs wrap around.
+type InstanceIDs struct {
+	mu   sync.Mutex
+	ids  map[string]int
+	next int
+}
+
+// NewInstanceIDs creates an empty set of instance IDs
+func NewInstanceIDs() *InstanceIDs {
+	return &InstanceIDs{ids: map[string]int{}}
+}
+
+// ID returns the instance ID of the name, assigning the next free ID to a new name
+func (n *InstanceIDs) ID(name string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if id, ok := n.ids[name]; ok {
+		return id
+	}
+
+	used := map[int]bool{}
+	for _, id := range n.ids {
+		used[id] = true
+	}
+
+	id := n.next
+	for used[id] {
+		id = nextInstanceID(id)
+	}
+	n.ids[name] = id
+	n.next = nextInstanceID(id)
+	return id
+}
+
+// Assign sets the instance ID of the name e.g. for an instance created by the server
+func (n *InstanceIDs) Assign(name string, id int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if id < 0 || id > maxInstanceID {
+		return fmt.Errorf("invalid instance ID %d", id)
+	}
+	for k, v := range n.ids {
+		if v == id && k != name {
+			return fmt.Errorf("instance ID %d is used by %s", id, k)
+		}
+	}
+	n.ids[name] = id
+	if id >= n.next {
+		n.next = nextInstanceID(id)
+	}
+	return nil
+}
+
+// Next returns the instance ID that is tried first for a new name
+func (n *InstanceIDs) Next() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.next
+}
+
+// SetNext sets the instance ID that is tried first for a new name e.g. when
+// the IDs are restored
+func (n *InstanceIDs) SetNext(next int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if next < 0 || next > maxInstanceID {
+		return fmt.Errorf("invalid instance ID %d", next)
+	}
+	n.next = next
+	return nil
+}
+
+// nextInstanceID returns the ID after the instance ID, wrapping around after
+// the highest ID
+func nextInstanceID(id int) int {
+	if id >= maxInstanceID {
+		return 0
+	}
+	return id + 1
+}
+
+// Name returns the name with the instance ID
+func (n *InstanceIDs) Name(id int) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for k, v := range n.ids {
+		if v == id {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// Remove releases the instance ID of the name
+func (n *InstanceIDs) Remove(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.ids, name)
+}
+
+// IDs returns the assigned instance IDs in ascending order
+func (n *InstanceIDs) IDs() []int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ids := []int{}
+	for _, v := range n.ids {
+		ids = append(ids, v)
+	}
+	sort.Ints(ids)
+	return ids
+}
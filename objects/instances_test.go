@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"testing"
+)
+
+func TestInstanceIDsAreNotReused(t *testing.T) {
+	n := NewInstanceIDs()
+	if a, b := n.ID("a"), n.ID("b"); a != 0 || b != 1 {
+		t.Fatalf("IDs = %d, %d, want 0 and 1", a, b)
+	}
+	if n.ID("a") != 0 {
+		t.Error("the ID of a name changed")
+	}
+
+	// The ID of a removed name is not given to the next name
+	n.Remove("a")
+	if c := n.ID("c"); c != 2 {
+		t.Errorf("ID = %d, want 2", c)
+	}
+
+	// An assigned ID moves the next ID past it
+	if err := n.Assign("d", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Assign("e", 10); err == nil {
+		t.Error("an ID was assigned twice")
+	}
+	if e := n.ID("e"); e != 11 {
+		t.Errorf("ID = %d, want 11", e)
+	}
+}
+
+func TestInstanceIDsWrapAround(t *testing.T) {
+	n := NewInstanceIDs()
+	n.ID("a")
+	if err := n.Assign("b", maxInstanceID); err != nil {
+		t.Fatal(err)
+	}
+	if n.Next() != 0 {
+		t.Errorf("next ID = %d, want 0 after the highest ID", n.Next())
+	}
+
+	// The used IDs are skipped
+	if c := n.ID("c"); c != 1 {
+		t.Errorf("ID = %d, want 1", c)
+	}
+
+	if err := n.SetNext(maxInstanceID + 1); err == nil {
+		t.Error("an invalid next ID was accepted")
+	}
+}
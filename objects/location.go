@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+// Position is the location of the device
+type Position struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+	Radius    float64
+	Speed     float64 // metres per second
+	Track     float64 // degrees from true north
+	Timestamp int64
+}
+
+// Velocity encodes the horizontal velocity of the position as defined by 3GPP TS 23.032
+func (p Position) Velocity() []byte {
+	bearing := uint16(math.Mod(math.Max(p.Track, 0), 360))
+	speed := uint16(math.Min(math.Max(p.Speed, 0)*3.6, math.MaxUint16))
+
+	// The velocity type is zero for a horizontal velocity
+	b := make([]byte, 4)
+	b[0] = byte(bearing >> 8 & 0x01)
+	b[1] = byte(bearing)
+	binary.BigEndian.PutUint16(b[2:], speed)
+	return b
+}
+
+// LocationProvider is a source of the device position
+type LocationProvider interface {
+	Position() (Position, bool)
+}
+
+// StaticLocation is a fixed position e.g. of a device installed in a cabinet
+type StaticLocation struct {
+	position Position
+}
+
+// NewStaticLocation creates a fixed position
+func NewStaticLocation(latitude, longitude, altitude float64) *StaticLocation {
+	return &StaticLocation{Position{
+		Latitude:  latitude,
+		Longitude: longitude,
+		Altitude:  altitude,
+		Timestamp: time.Now().Unix(),
+	}}
+}
+
+// Position returns the fixed position
+func (s *StaticLocation) Position() (Position, bool) {
+	return s.position, true
+}
+
+// noLocation is used when there is no source for the position
+type noLocation struct{}
+
+func (noLocation) Position() (Position, bool) {
+	return Position{}, false
+}
+
+// locationProvider is the source of the position for the Location object
+var locationProvider LocationProvider = noLocation{}
+
+// UseLocationProvider sets the source of the device position e.g. a gpsd client.
+// It must be called before the object is first retrieved.
+func UseLocationProvider(p LocationProvider) {
+	locationProvider = p
+}
+
+// Location defines the Location object
+type Location struct {
+	mu       sync.Mutex
+	provider LocationProvider
+	last     Position
+}
+
+// Using a singleton to define the location
+var locationInstance *Location
+var locationOnce sync.Once
+
+// GetLocationInstance returns an instance of the Location object
+func GetLocationInstance() *Location {
+	locationOnce.Do(func() {
+		locationInstance = &Location{provider: locationProvider}
+	})
+	return locationInstance
+}
+
+// Position returns the current position of the device
+func (l *Location) Position() Position {
+	p, _ := l.provider.Position()
+	return p
+}
+
+// Changed returns the current position if the device has moved or a new fix
+// has been received since the last call
+func (l *Location) Changed() (Position, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, ok := l.provider.Position()
+	if !ok || p == l.last {
+		return p, false
+	}
+
+	l.last = p
+	return p, true
+}
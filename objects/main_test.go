@@ -0,0 +1,30 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"launchpad.net/ce-web/alpaca/snapdapi/snapdtest"
+)
+
+// fakeSnapd is the snapd client of the object singletons in the tests
+var fakeSnapd = snapdtest.NewFakeSnapdClient()
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "objects")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv(dataEnvVar, dir)
+	UseSnapdClient(fakeSnapd)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// mmcliCommand is the ModemManager command line client
+var mmcliCommand = "mmcli"
+
+// AccessTechnologies returns the access technologies of the modem of a network
+// interface e.g. "lte", as reported by ModemManager
+func (systemNetwork) AccessTechnologies(name string) ([]string, error) {
+	modems, err := mmcli("-L")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range modems["modem-list"] {
+		info, err := mmcli("-m", m)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, port := range info["modem.generic.ports"] {
+			if port == name+" (net)" {
+				return info["modem.generic.access-technologies"], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no modem has the network interface %s", name)
+}
+
+// mmcli runs the ModemManager client and parses its key-value output. The
+// values of a list are given as "key.value[n] : value", or separated by commas.
+func mmcli(args ...string) (map[string][]string, error) {
+	out, err := exec.Command(mmcliCommand, append([]string{"-K"}, args...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %v", mmcliCommand, err)
+	}
+
+	values := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " : ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(fields[0])
+		if strings.HasSuffix(key, ".length") {
+			continue
+		}
+		if i := strings.Index(key, ".value["); i >= 0 {
+			key = key[:i]
+		}
+
+		for _, v := range strings.Split(fields[1], ",") {
+			v = strings.TrimSpace(v)
+			if len(v) > 0 && v != "--" {
+				values[key] = append(values[key], v)
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// DefaultRoute is a default route from the main routing table
+type DefaultRoute struct {
+	Interface string
+	Gateway   string
+	Metric    uint32
+}
+
+// systemNetwork reads the state of the network from the kernel and ModemManager
+type systemNetwork struct{}
+
+// Addresses returns the global IP addresses of each interface, read from netlink
+func (systemNetwork) Addresses() (map[string][]string, error) {
+	msgs, err := netlinkDump(syscall.RTM_GETADDR)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := map[string][]string{}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		// Skip the link-local and host addresses
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if ifa.Scope != syscall.RT_SCOPE_UNIVERSE {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+
+		// The local address is the address of a point-to-point link, not its peer
+		var ip net.IP
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.IFA_LOCAL:
+				ip = net.IP(a.Value)
+			case syscall.IFA_ADDRESS:
+				if ip == nil {
+					ip = net.IP(a.Value)
+				}
+			}
+		}
+		if ip == nil {
+			continue
+		}
+
+		name := interfaceName(int(ifa.Index))
+		addresses[name] = append(addresses[name], ip.String())
+	}
+
+	return addresses, nil
+}
+
+// DefaultRoutes returns the default routes of the main routing table, read from netlink
+func (systemNetwork) DefaultRoutes() ([]DefaultRoute, error) {
+	msgs, err := netlinkDump(syscall.RTM_GETROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := []DefaultRoute{}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Table != syscall.RT_TABLE_MAIN || rt.Type != syscall.RTN_UNICAST || rt.Dst_len != 0 {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+
+		r := DefaultRoute{}
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_GATEWAY:
+				r.Gateway = net.IP(a.Value).String()
+			case syscall.RTA_OIF:
+				r.Interface = interfaceName(int(nativeUint32(a.Value)))
+			case syscall.RTA_PRIORITY:
+				r.Metric = nativeUint32(a.Value)
+			}
+		}
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// netlinkDump requests a dump of the routing information of all address families
+func netlinkDump(request int) ([]syscall.NetlinkMessage, error) {
+	tab, err := syscall.NetlinkRIB(request, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_DONE {
+			return msgs[:i], nil
+		}
+	}
+	return msgs, nil
+}
+
+func interfaceName(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return ""
+	}
+	return iface.Name
+}
+
+// nativeUint32 decodes a netlink attribute, which uses the byte order of the host
+func nativeUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return *(*uint32)(unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,256 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+)
+
+// States of a snap operation
+const (
+	OperationQueued  = 0
+	OperationRunning = 1
+	OperationDone    = 2
+	OperationFailed  = 3
+)
+
+// maxOperations is the number of operations that are kept. The oldest finished
+// operation is forgotten to make room for a new one.
+const maxOperations = 16
+
+// Operation is a snap action that runs in the background as a snapd change
+type Operation struct {
+	Action   string
+	Snap     string
+	State    int
+	Progress int
+	Change   string
+	Error    string
+	Started  time.Time
+	Updated  time.Time
+	Finished time.Time
+}
+
+// OperationList defines the snap operations, with an instance per operation
+type OperationList struct {
+	mu         sync.Mutex
+	operations map[int]*Operation
+}
+
+// Using a singleton to define the snap operations
+var operationsInstance *OperationList
+var operationsOnce sync.Once
+
+// GetOperationsInstance returns the snap operations
+func GetOperationsInstance() *OperationList {
+	l := CachedOperationsInstance()
+	l.refresh(GetSnapsInstance())
+	return l
+}
+
+// CachedOperationsInstance returns the snap operations without checking their
+// snapd changes
+func CachedOperationsInstance() *OperationList {
+	operationsOnce.Do(func() {
+		operationsInstance = &OperationList{operations: map[int]*Operation{}}
+	})
+
+	return operationsInstance
+}
+
+// IDs returns the instance IDs of the operations in ascending order
+func (l *OperationList) IDs() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ids := []int{}
+	for id := range l.operations {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Operation returns a copy of the operation with the instance ID
+func (l *OperationList) Operation(id int) (Operation, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	op, ok := l.operations[id]
+	if !ok {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// Start queues the action on the snap and returns the instance ID of the
+// operation right away. The action is run in the background, and returns the
+// ID of the snapd change that it started.
+func (l *OperationList) Start(action, snap string, run func() (string, error)) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id, err := l.newID()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	op := &Operation{Action: action, Snap: snap, State: OperationQueued, Started: now, Updated: now}
+	l.operations[id] = op
+
+	go func() {
+		change, err := run()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		op.Updated = time.Now()
+		if err != nil {
+			log.Printf("Error starting the %s of %s: %v", action, snap, err)
+			GetErrorsInstance().Add(action, snap, err)
+			op.State = OperationFailed
+			op.Error = err.Error()
+			op.Finished = op.Updated
+			return
+		}
+		op.State = OperationRunning
+		op.Change = change
+	}()
+
+	return id, nil
+}
+
+// newID returns the lowest free instance ID, forgetting the oldest finished
+// operation when the list is full. The caller holds the lock.
+func (l *OperationList) newID() (int, error) {
+	if len(l.operations) >= maxOperations {
+		oldest := -1
+		for id, op := range l.operations {
+			if op.State != OperationDone && op.State != OperationFailed {
+				continue
+			}
+			if oldest < 0 || op.Finished.Before(l.operations[oldest].Finished) {
+				oldest = id
+			}
+		}
+		if oldest < 0 {
+			return 0, fmt.Errorf("%d snap operations are in progress", len(l.operations))
+		}
+		delete(l.operations, oldest)
+	}
+
+	id := 0
+	for l.operations[id] != nil {
+		id++
+	}
+	return id, nil
+}
+
+// runningOperation is an operation whose snapd change is being checked
+type runningOperation struct {
+	id     int
+	op     *Operation
+	action string
+	snap   string
+	change string
+}
+
+// refresh updates the running operations from their snapd changes. The
+// changes are checked without holding the lock.
+func (l *OperationList) refresh(s *SnapList) {
+	l.mu.Lock()
+	running := []runningOperation{}
+	for id, op := range l.operations {
+		if op.State == OperationRunning {
+			running = append(running, runningOperation{id, op, op.Action, op.Snap, op.Change})
+		}
+	}
+	l.mu.Unlock()
+
+	changes := map[int]*client.Change{}
+	for _, r := range running {
+		chg, err := s.Change(r.change)
+		if err != nil {
+			log.Printf("Error checking the %s of %s: %v", r.action, r.snap, err)
+			continue
+		}
+		changes[r.id] = chg
+	}
+
+	if l.update(running, changes) {
+		// The snaps and their services have changed
+		s.Invalidate()
+		if servicesInstance != nil {
+			servicesInstance.Invalidate()
+		}
+	}
+}
+
+// update applies the changes to the operations that are still running, and
+// returns whether any of them finished
+func (l *OperationList) update(running []runningOperation, changes map[int]*client.Change) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	finished := false
+	for _, r := range running {
+		chg, ok := changes[r.id]
+		if !ok {
+			continue
+		}
+
+		// The operation may have been forgotten meanwhile
+		op := l.operations[r.id]
+		if op != r.op || op.State != OperationRunning {
+			continue
+		}
+
+		progress := changeProgress(chg)
+		if progress != op.Progress {
+			op.Progress = progress
+			op.Updated = time.Now()
+		}
+		if !chg.Ready {
+			continue
+		}
+
+		op.Updated = time.Now()
+		op.Finished = op.Updated
+		if chg.Status != "Done" {
+			GetErrorsInstance().AddChange(op.Action, op.Snap, chg)
+			op.State = OperationFailed
+			op.Error = chg.Err
+		} else {
+			op.State = OperationDone
+			op.Progress = 100
+		}
+		finished = true
+	}
+	return finished
+}
+
+// changeProgress is the percentage of the work of the change that is done
+func changeProgress(chg *client.Change) int {
+	if chg.Ready {
+		return 100
+	}
+
+	done, total := 0, 0
+	for _, t := range chg.Tasks {
+		done += t.Progress.Done
+		total += t.Progress.Total
+	}
+	if total == 0 {
+		return 0
+	}
+	return done * 100 / total
+}
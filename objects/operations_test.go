@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func newTestOperations() *OperationList {
+	return &OperationList{operations: map[int]*Operation{}}
+}
+
+// waitForStart waits until the action of the operation was run
+func waitForStart(t *testing.T, l *OperationList, id int) Operation {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if op, ok := l.Operation(id); ok && op.State != OperationQueued {
+			return op
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("operation %d did not start", id)
+	return Operation{}
+}
+
+// lastError returns the last recorded snap error
+func lastError() SnapError {
+	errs := GetErrorsInstance().Errors()
+	if len(errs) == 0 {
+		return SnapError{}
+	}
+	return errs[len(errs)-1]
+}
+
+func TestOperationStartAndProgress(t *testing.T) {
+	l := newTestOperations()
+	fakeSnapd.Changes["op-progress"] = &client.Change{
+		ID:     "op-progress",
+		Status: "Doing",
+		Tasks: []*client.Task{
+			{Progress: client.TaskProgress{Done: 1, Total: 4}},
+			{Progress: client.TaskProgress{Done: 0, Total: 4}},
+		},
+	}
+
+	release := make(chan struct{})
+	id, err := l.Start("install", "hello", func() (string, error) {
+		<-release
+		return "op-progress", nil
+	})
+	if err != nil || id != 0 {
+		t.Fatalf("Start = %d, %v, want instance 0", id, err)
+	}
+	if op, _ := l.Operation(id); op.State != OperationQueued || op.Action != "install" || op.Snap != "hello" {
+		t.Errorf("operation = %+v, want a queued install of hello", op)
+	}
+	close(release)
+
+	op := waitForStart(t, l, id)
+	if op.State != OperationRunning || op.Change != "op-progress" {
+		t.Fatalf("operation = %+v, want the running change", op)
+	}
+
+	l.refresh(GetSnapsInstance())
+	if op, _ := l.Operation(id); op.State != OperationRunning || op.Progress != 12 {
+		t.Errorf("operation = %+v, want 12%% done", op)
+	}
+
+	fakeSnapd.Changes["op-progress"].Status = "Done"
+	fakeSnapd.Changes["op-progress"].Ready = true
+	l.refresh(GetSnapsInstance())
+	op, _ = l.Operation(id)
+	if op.State != OperationDone || op.Progress != 100 || op.Finished.IsZero() {
+		t.Errorf("operation = %+v, want it done", op)
+	}
+}
+
+func TestOperationFailures(t *testing.T) {
+	l := newTestOperations()
+
+	// The action could not be started
+	id, err := l.Start("remove", "core", func() (string, error) {
+		return "", errors.New("cannot remove the base")
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	op := waitForStart(t, l, id)
+	if op.State != OperationFailed || op.Error != "cannot remove the base" || op.Finished.IsZero() {
+		t.Errorf("operation = %+v, want it failed", op)
+	}
+	if e := lastError(); e.Action != "remove" || e.Snap != "core" || e.Kind != ErrorKindRequestFailed {
+		t.Errorf("last snap error = %+v, want the failed request", e)
+	}
+
+	// The snapd change failed
+	fakeSnapd.Changes["op-failed"] = &client.Change{ID: "op-failed", Status: "Error", Ready: true, Err: "download failed"}
+	id, err = l.Start("refresh", "hello", func() (string, error) { return "op-failed", nil })
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitForStart(t, l, id)
+	l.refresh(GetSnapsInstance())
+	if op, _ := l.Operation(id); op.State != OperationFailed || op.Error != "download failed" {
+		t.Errorf("operation = %+v, want it failed", op)
+	}
+	if e := lastError(); e.Action != "refresh" || e.Snap != "hello" || e.Kind != ErrorKindChangeFailed || e.Message != "download failed" {
+		t.Errorf("last snap error = %+v, want the failed change", e)
+	}
+
+	// An operation whose change cannot be checked is still running
+	id, err = l.Start("refresh", "pc", func() (string, error) { return "op-unknown", nil })
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitForStart(t, l, id)
+	l.refresh(GetSnapsInstance())
+	if op, _ := l.Operation(id); op.State != OperationRunning {
+		t.Errorf("operation = %+v, want it running", op)
+	}
+}
+
+func TestOperationNewIDForgetsTheOldestFinished(t *testing.T) {
+	l := newTestOperations()
+	now := time.Now()
+	for id := 0; id < maxOperations; id++ {
+		l.operations[id] = &Operation{State: OperationRunning}
+	}
+	l.operations[3] = &Operation{State: OperationDone, Finished: now}
+	l.operations[5] = &Operation{State: OperationFailed, Finished: now.Add(-time.Minute)}
+	l.operations[9] = &Operation{State: OperationDone, Finished: now.Add(-time.Second)}
+
+	for _, want := range []int{5, 9, 3} {
+		id, err := l.newID()
+		if err != nil || id != want {
+			t.Fatalf("newID = %d, %v, want %d", id, err, want)
+		}
+		l.operations[id] = &Operation{State: OperationRunning}
+	}
+
+	// All the operations are in progress
+	if id, err := l.newID(); err == nil {
+		t.Errorf("newID = %d with %d operations in progress", id, maxOperations)
+	}
+	if _, err := l.Start("install", "hello", func() (string, error) { return "", nil }); err == nil {
+		t.Error("an operation was started with the list full")
+	}
+	if len(l.IDs()) != maxOperations {
+		t.Errorf("%d operations, want %d", len(l.IDs()), maxOperations)
+	}
+
+	// The lowest free ID is used
+	delete(l.operations, 7)
+	delete(l.operations, 2)
+	if id, err := l.newID(); err != nil || id != 2 {
+		t.Errorf("newID = %d, %v, want 2", id, err)
+	}
+}
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/snapcore/snapd/client"
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+// Rebooter restarts the device
+type Rebooter interface {
+	Reboot() error
+	RebootToSystem(label, mode string) error
+}
+
+// SnapdRebooter asks snapd to restart the device. The reboot action of snapd
+// needs Ubuntu Core 20+, so the fallback restarts the older devices.
+type SnapdRebooter struct {
+	client   snapdapi.SnapdClient
+	fallback Rebooter
+}
+
+// NewSnapdRebooter creates a rebooter that uses the snapd API, and the
+// shutdown interface when snapd cannot reboot
+func NewSnapdRebooter(c snapdapi.SnapdClient) *SnapdRebooter {
+	return &SnapdRebooter{client: c, fallback: &ShutdownRebooter{}}
+}
+
+// Reboot requests the restart from snapd, or from the fallback when snapd
+// does not support it. The other snapd errors are returned.
+func (r *SnapdRebooter) Reboot() error {
+	log.Println("---Reboot device")
+	err := r.client.Reboot("")
+	if err == nil {
+		return nil
+	}
+	if !rebootUnsupported(err) {
+		return err
+	}
+
+	log.Printf("Error rebooting through snapd, using the shutdown interface: %v", err)
+	return r.fallback.Reboot()
+}
+
+// rebootUnsupported checks if the error is from a snapd without the reboot
+// action: the systems API is missing, or it does not know the action
+func rebootUnsupported(err error) bool {
+	e, ok := err.(*client.Error)
+	if !ok {
+		return false
+	}
+
+	switch e.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(e.Message, "unsupported action")
+	}
+	return false
+}
+
+// RebootToSystem asks snapd to restart the device into a recovery system mode
+func (r *SnapdRebooter) RebootToSystem(label, mode string) error {
+	log.Printf("---Reboot device into system %s (%s)", label, mode)
+	return r.client.SystemAction(label, mode)
+}
+
+// shutdownCommand restarts the device through logind, which the shutdown
+// interface of the snap allows
+var shutdownCommand = []string{"shutdown", "-r", "now"}
+
+// ShutdownRebooter restarts the device with the shutdown command
+type ShutdownRebooter struct{}
+
+// Reboot runs the shutdown command
+func (r *ShutdownRebooter) Reboot() error {
+	log.Println("---Reboot device with shutdown")
+	out, err := exec.Command(shutdownCommand[0], shutdownCommand[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RebootToSystem fails, as only snapd can select a recovery system
+func (r *ShutdownRebooter) RebootToSystem(label, mode string) error {
+	return fmt.Errorf("cannot reboot into system %s (%s) without the snapd system actions", label, mode)
+}
@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/snapcore/snapd/client"
+
+	"launchpad.net/ce-web/alpaca/snapdapi/snapdtest"
+)
+
+// FakeRebooter records the reboot requests without restarting the device
+type FakeRebooter struct {
+	mu      sync.Mutex
+	count   int
+	Systems []string
+	Err     error
+}
+
+// Reboot records the request and returns the configured error
+func (r *FakeRebooter) Reboot() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count++
+	return r.Err
+}
+
+// Count returns the number of reboot requests received
+func (r *FakeRebooter) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+// RebootToSystem records the system and mode that were requested
+func (r *FakeRebooter) RebootToSystem(label, mode string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count++
+	r.Systems = append(r.Systems, label+" "+mode)
+	return r.Err
+}
+
+func TestSnapdRebooterReboot(t *testing.T) {
+	f := snapdtest.NewFakeSnapdClient()
+	fallback := &FakeRebooter{}
+	r := &SnapdRebooter{client: f, fallback: fallback}
+
+	if err := r.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if !reflect.DeepEqual(f.Actions, []string{"reboot "}) {
+		t.Errorf("snapd actions = %q, want the reboot", f.Actions)
+	}
+	if fallback.Count() != 0 {
+		t.Errorf("the fallback rebooted %d times, want 0", fallback.Count())
+	}
+}
+
+func TestSnapdRebooterFallback(t *testing.T) {
+	// snapd before Ubuntu Core 20 has no reboot action
+	f := snapdtest.NewFakeSnapdClient()
+	f.Err = &client.Error{Kind: "", Message: "not found", StatusCode: 404}
+
+	fallback := &FakeRebooter{}
+	r := &SnapdRebooter{client: f, fallback: fallback}
+
+	if err := r.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if fallback.Count() != 1 {
+		t.Errorf("the fallback rebooted %d times, want 1", fallback.Count())
+	}
+
+	// snapd without the reboot action in the systems API
+	f.Err = &client.Error{Message: `unsupported action "reboot"`, StatusCode: 400}
+	if err := r.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if fallback.Count() != 2 {
+		t.Errorf("the fallback rebooted %d times, want 2", fallback.Count())
+	}
+
+	fallback.Err = errors.New("access denied")
+	if err := r.Reboot(); err == nil {
+		t.Error("Reboot succeeded when the fallback failed")
+	}
+}
+
+func TestSnapdRebooterErrorHasNoFallback(t *testing.T) {
+	errs := []error{
+		errors.New("cannot communicate with server: connection refused"),
+		&client.Error{Kind: "login-required", Message: "access denied", StatusCode: 401},
+		&client.Error{Message: "internal error", StatusCode: 500},
+	}
+
+	for _, e := range errs {
+		f := snapdtest.NewFakeSnapdClient()
+		f.Err = e
+
+		fallback := &FakeRebooter{}
+		r := &SnapdRebooter{client: f, fallback: fallback}
+
+		if err := r.Reboot(); err != e {
+			t.Errorf("Reboot error = %v, want the snapd error %v", err, e)
+		}
+		if fallback.Count() != 0 {
+			t.Errorf("the fallback rebooted %d times after %v, want 0", fallback.Count(), e)
+		}
+	}
+}
+
+func TestSnapdRebooterSystemHasNoFallback(t *testing.T) {
+	f := snapdtest.NewFakeSnapdClient()
+	f.Err = errors.New("not supported")
+
+	fallback := &FakeRebooter{}
+	r := &SnapdRebooter{client: f, fallback: fallback}
+
+	if err := r.RebootToSystem("20201212", ModeRecover); err == nil {
+		t.Error("RebootToSystem succeeded when snapd failed")
+	}
+	if fallback.Count() != 0 {
+		t.Errorf("the fallback rebooted %d times, want 0", fallback.Count())
+	}
+}
+
+func TestShutdownRebooter(t *testing.T) {
+	defer func(cmd []string) { shutdownCommand = cmd }(shutdownCommand)
+
+	r := &ShutdownRebooter{}
+
+	shutdownCommand = []string{"true"}
+	if err := r.Reboot(); err != nil {
+		t.Errorf("Reboot: %v", err)
+	}
+
+	shutdownCommand = []string{"false"}
+	if err := r.Reboot(); err == nil {
+		t.Error("Reboot succeeded when the shutdown command failed")
+	}
+
+	if err := r.RebootToSystem("20201212", ModeRun); err == nil {
+		t.Error("RebootToSystem succeeded without snapd")
+	}
+}
+
+func TestDeviceReboot(t *testing.T) {
+	r := &FakeRebooter{}
+	d := &Device{}
+	d.SetRebooter(r)
+
+	if err := d.Reboot(); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if r.Count() != 1 || len(r.Systems) != 0 {
+		t.Errorf("reboots = %d, systems = %q, want a plain reboot", r.Count(), r.Systems)
+	}
+
+	r.Err = errors.New("refused")
+	if err := d.Reboot(); err == nil {
+		t.Error("Reboot succeeded when the rebooter failed")
+	}
+}
@@ -0,0 +1,220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+// servicesInstancesFile holds the instance IDs of the snap services, so a
+// service keeps its instance ID when the client restarts
+const servicesInstancesFile = "service-instances.json"
+
+// ServiceList defines the snap service objects, one for each app of a snap
+// that is a daemon
+type ServiceList struct {
+	Services    []client.AppInfo
+	lastRefresh int64
+	client      snapdapi.SnapdClient
+	ids         *InstanceIDs
+	dir         string
+}
+
+// Using a singleton to define the snap service objects
+var servicesInstance *ServiceList
+var servicesOnce sync.Once
+
+// ByService implements sort.Interface for the service list
+type ByService []client.AppInfo
+
+func (a ByService) Len() int           { return len(a) }
+func (a ByService) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByService) Less(i, j int) bool { return ServiceName(a[i]) < ServiceName(a[j]) }
+
+// GetServicesInstance returns the snap services
+func GetServicesInstance() *ServiceList {
+	l := CachedServicesInstance()
+	if dataIsStale(l.lastRefresh) {
+		l.refresh()
+		l.lastRefresh = time.Now().Unix()
+	}
+
+	return l
+}
+
+// CachedServicesInstance returns the snap services as of the last refresh.
+// Only the first call lists the services from the snapd API.
+func CachedServicesInstance() *ServiceList {
+	servicesOnce.Do(func() {
+		servicesInstance = &ServiceList{client: newClient(), ids: NewInstanceIDs(), dir: dataDir()}
+		servicesInstance.load()
+		servicesInstance.refresh()
+		servicesInstance.lastRefresh = time.Now().Unix()
+	})
+
+	return servicesInstance
+}
+
+// ServiceName returns the snap.app name of the service
+func ServiceName(app client.AppInfo) string {
+	return app.Snap + "." + app.Name
+}
+
+// refresh the services from the snapd API
+func (l *ServiceList) refresh() {
+	apps, err := l.client.Apps(nil, client.AppOptions{Service: true})
+	if err != nil {
+		log.Printf("Error refreshing the list of snap services: %v", err)
+		return
+	}
+
+	l.Services = []client.AppInfo{}
+	for _, a := range apps {
+		if a.IsService() {
+			l.Services = append(l.Services, *a)
+		}
+	}
+	sort.Sort(ByService(l.Services))
+
+	l.assignIDs()
+}
+
+// assignIDs gives an instance ID to the new services and releases the
+// instance IDs of the removed services
+func (l *ServiceList) assignIDs() {
+	before := l.ids.IDs()
+
+	listed := map[string]bool{}
+	for _, app := range l.Services {
+		listed[ServiceName(app)] = true
+		l.ids.ID(ServiceName(app))
+	}
+
+	// The IDs are released last, so a new service does not get the ID of a removed one
+	for _, id := range before {
+		name, _ := l.ids.Name(id)
+		if !listed[name] {
+			l.ids.Remove(name)
+		}
+	}
+
+	after := l.ids.IDs()
+	if len(before) != len(after) {
+		l.save()
+		return
+	}
+	for i := range before {
+		if before[i] != after[i] {
+			l.save()
+			return
+		}
+	}
+}
+
+// IDs returns the instance IDs of the services in ascending order
+func (l *ServiceList) IDs() []int {
+	return l.ids.IDs()
+}
+
+// Service returns the service with the instance ID
+func (l *ServiceList) Service(id int) (client.AppInfo, bool) {
+	name, ok := l.ids.Name(id)
+	if !ok {
+		return client.AppInfo{}, false
+	}
+
+	for _, app := range l.Services {
+		if ServiceName(app) == name {
+			return app, true
+		}
+	}
+	return client.AppInfo{}, false
+}
+
+// load reads the persisted instance IDs of the services
+func (l *ServiceList) load() {
+	dat, err := ioutil.ReadFile(filepath.Join(l.dir, servicesInstancesFile))
+	if err != nil {
+		return
+	}
+
+	ids := map[string]int{}
+	if err = json.Unmarshal(dat, &ids); err != nil {
+		log.Printf("Error parsing the service instance IDs: %v", err)
+		return
+	}
+
+	for name, id := range ids {
+		if err = l.ids.Assign(name, id); err != nil {
+			log.Printf("Error restoring the instance ID of service %s: %v", name, err)
+		}
+	}
+}
+
+// save persists the instance IDs of the services
+func (l *ServiceList) save() {
+	ids := map[string]int{}
+	for _, id := range l.ids.IDs() {
+		if name, ok := l.ids.Name(id); ok {
+			ids[name] = id
+		}
+	}
+
+	b, err := json.Marshal(ids)
+	if err != nil {
+		log.Printf("Error marshalling the service instance IDs: %v", err)
+		return
+	}
+
+	if err = WriteFileAtomic(filepath.Join(l.dir, servicesInstancesFile), b, 0600); err != nil {
+		log.Printf("Error storing the service instance IDs: %v", err)
+	}
+}
+
+// Start starts a service
+func (l *ServiceList) Start(name string) (string, error) {
+	log.Println("---Start service", name)
+	return l.client.Start([]string{name}, client.StartOptions{})
+}
+
+// Stop stops a service
+func (l *ServiceList) Stop(name string) (string, error) {
+	log.Println("---Stop service", name)
+	return l.client.Stop([]string{name}, client.StopOptions{})
+}
+
+// Restart restarts a service, or starts it when it is not running
+func (l *ServiceList) Restart(name string) (string, error) {
+	log.Println("---Restart service", name)
+	return l.client.Restart([]string{name}, client.RestartOptions{})
+}
+
+// Enable starts a service and enables it at boot, as snapd has no enable
+// without a start
+func (l *ServiceList) Enable(name string) (string, error) {
+	log.Println("---Enable service", name)
+	return l.client.Start([]string{name}, client.StartOptions{Enable: true})
+}
+
+// Disable stops a service and disables it at boot, as snapd has no disable
+// without a stop
+func (l *ServiceList) Disable(name string) (string, error) {
+	log.Println("---Disable service", name)
+	return l.client.Stop([]string{name}, client.StopOptions{Disable: true})
+}
+
+// Invalidate forces the service list to be refreshed on the next retrieval
+func (l *ServiceList) Invalidate() {
+	l.lastRefresh = 0
+}
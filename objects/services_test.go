@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/snapcore/snapd/client"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
+	"launchpad.net/ce-web/alpaca/snapdapi/snapdtest"
+)
+
+// allAppsClient lists all the apps even when only the services are asked for
+type allAppsClient struct {
+	*snapdtest.FakeSnapdClient
+}
+
+func (c allAppsClient) Apps(names []string, opts client.AppOptions) ([]*client.AppInfo, error) {
+	return c.FakeSnapdClient.Apps(names, client.AppOptions{})
+}
+
+// daemon returns an enabled service of the snap
+func daemon(snap, name string) *client.AppInfo {
+	return &client.AppInfo{Snap: snap, Name: name, Daemon: "simple", Enabled: true}
+}
+
+// command returns an app of the snap that is not a service
+func command(snap, name string) *client.AppInfo {
+	return &client.AppInfo{Snap: snap, Name: name}
+}
+
+// useApps makes the apps the apps of the fake snapd for the test
+func useApps(t *testing.T, apps ...*client.AppInfo) {
+	previous := fakeSnapd.AppList
+	t.Cleanup(func() { fakeSnapd.AppList = previous })
+	fakeSnapd.AppList = apps
+}
+
+// newTestServices returns a service list that keeps its instance IDs in the dir
+func newTestServices(c snapdapi.SnapdClient, dir string) *ServiceList {
+	l := &ServiceList{client: c, ids: NewInstanceIDs(), dir: dir}
+	l.load()
+	l.refresh()
+	return l
+}
+
+// serviceIDs returns the instance IDs of the services by name
+func serviceIDs(l *ServiceList) map[string]int {
+	ids := map[string]int{}
+	for _, id := range l.IDs() {
+		app, ok := l.Service(id)
+		if !ok {
+			continue
+		}
+		ids[ServiceName(app)] = id
+	}
+	return ids
+}
+
+func TestServicesOnlyListTheDaemons(t *testing.T) {
+	useApps(t, command("hello", "hello"), daemon("hello", "server"), daemon("mqtt", "broker"), command("mqtt", "pub"))
+	l := newTestServices(allAppsClient{fakeSnapd}, t.TempDir())
+
+	names := []string{}
+	for _, app := range l.Services {
+		names = append(names, ServiceName(app))
+	}
+	if want := []string{"hello.server", "mqtt.broker"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("services = %v, want %v", names, want)
+	}
+	if len(l.IDs()) != 2 {
+		t.Errorf("instance IDs = %v, want one per service", l.IDs())
+	}
+}
+
+func TestServicesKeepTheirIDs(t *testing.T) {
+	dir := t.TempDir()
+	useApps(t, daemon("hello", "server"), daemon("mqtt", "broker"), daemon("nginx", "nginx"))
+	l := newTestServices(fakeSnapd, dir)
+	ids := serviceIDs(l)
+	if len(ids) != 3 {
+		t.Fatalf("instance IDs = %v, want one per service", ids)
+	}
+	if _, err := os.Stat(filepath.Join(dir, servicesInstancesFile)); err != nil {
+		t.Fatalf("the instance IDs were not stored: %v", err)
+	}
+
+	// A removed service releases its ID, which a new service does not get
+	useApps(t, daemon("mqtt", "broker"), daemon("nginx", "nginx"), daemon("web", "server"))
+	l.refresh()
+	refreshed := serviceIDs(l)
+	if refreshed["mqtt.broker"] != ids["mqtt.broker"] || refreshed["nginx.nginx"] != ids["nginx.nginx"] {
+		t.Errorf("instance IDs after a refresh = %v, want %v kept", refreshed, ids)
+	}
+	if _, ok := refreshed["hello.server"]; ok {
+		t.Error("the removed service is listed")
+	}
+	if id, ok := refreshed["web.server"]; !ok || id == ids["hello.server"] {
+		t.Errorf("new service ID = %d, want a new ID", id)
+	}
+
+	// The IDs are restored when the client restarts, in another order
+	useApps(t, daemon("web", "server"), daemon("nginx", "nginx"), daemon("mqtt", "broker"))
+	if restored := serviceIDs(newTestServices(fakeSnapd, dir)); !reflect.DeepEqual(restored, refreshed) {
+		t.Errorf("instance IDs after a restart = %v, want %v", restored, refreshed)
+	}
+}
+
+func TestServiceActions(t *testing.T) {
+	useApps(t, daemon("hello", "server"))
+	l := newTestServices(fakeSnapd, t.TempDir())
+	app := fakeSnapd.AppList[0]
+
+	tests := []struct {
+		action  func(string) (string, error)
+		want    string
+		active  bool
+		enabled bool
+	}{
+		{l.Stop, "stop [hello.server] disable=false", false, true},
+		{l.Start, "start [hello.server] enable=false", true, true},
+		{l.Disable, "stop [hello.server] disable=true", false, false},
+		{l.Restart, "restart [hello.server]", true, false},
+		{l.Enable, "start [hello.server] enable=true", true, true},
+	}
+	for _, tt := range tests {
+		if _, err := tt.action("hello.server"); err != nil {
+			t.Fatalf("%s: %v", tt.want, err)
+		}
+		if action := lastAction(); action != tt.want {
+			t.Errorf("action = %q, want %q", action, tt.want)
+		}
+		if app.Active != tt.active || app.Enabled != tt.enabled {
+			t.Errorf("%s: service active %v enabled %v, want %v and %v", tt.want, app.Active, app.Enabled, tt.active, tt.enabled)
+		}
+	}
+}
@@ -5,7 +5,11 @@
 package objects
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
@@ -14,11 +18,25 @@ import (
 	"launchpad.net/ce-web/alpaca/snapdapi"
 )
 
+// snapInstancesFile holds the instance IDs of the snaps, so a snap keeps its
+// instance ID when the client restarts
+const snapInstancesFile = "snap-instances.json"
+
+// snapInstances is the content of the snap instances file. The older clients
+// stored the IDs alone, keyed by the snap name.
+type snapInstances struct {
+	Next int            `json:"next"`
+	IDs  map[string]int `json:"ids"`
+}
+
 // SnapList defines a snap objects
 type SnapList struct {
 	Snaps       []client.Snap
 	lastRefresh int64
-	client      *snapdapi.ClientAdapter
+	client      snapdapi.SnapdClient
+	ids         *InstanceIDs
+	reserved    map[string]bool
+	dir         string
 }
 
 // Using a singleton to define the snap objects
@@ -34,13 +52,24 @@ func (a ByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
 
 // GetSnapsInstance returns an instance of a device object
 func GetSnapsInstance() *SnapList {
+	s := CachedSnapsInstance()
+	if dataIsStale(s.lastRefresh) {
+		s.refresh()
+		s.lastRefresh = time.Now().Unix()
+	}
+
+	return s
+}
+
+// CachedSnapsInstance returns the snap objects as of the last refresh. Only the
+// first call lists the snaps from the snapd API.
+func CachedSnapsInstance() *SnapList {
 	snapOnce.Do(func() {
-		snapInstance = &SnapList{client: snapdapi.NewClientAdapter()}
-	})
-	if dataIsStale(snapInstance.lastRefresh) {
+		snapInstance = &SnapList{client: newClient(), ids: NewInstanceIDs(), reserved: map[string]bool{}, dir: dataDir()}
+		snapInstance.load()
 		snapInstance.refresh()
 		snapInstance.lastRefresh = time.Now().Unix()
-	}
+	})
 
 	return snapInstance
 }
@@ -60,80 +89,236 @@ func (s *SnapList) refresh() {
 		s.Snaps = append(s.Snaps, snap)
 	}
 	sort.Sort(ByName(s.Snaps))
+
+	s.assignIDs()
 }
 
-// Install installs a snap from the store
-func (s *SnapList) Install(name string) string {
+// assignIDs gives the next instance IDs to the new snaps and releases the
+// instance IDs of the removed snaps. The ID reserved for a snap is kept until
+// it is installed.
+func (s *SnapList) assignIDs() {
+	before := s.ids.IDs()
+
+	installed := map[string]bool{}
+	for _, snap := range s.Snaps {
+		installed[snap.Name] = true
+		delete(s.reserved, snap.Name)
+		s.ids.ID(snap.Name)
+	}
+
+	for _, id := range before {
+		name, _ := s.ids.Name(id)
+		if !installed[name] && !s.reserved[name] {
+			s.ids.Remove(name)
+		}
+	}
+
+	after := s.ids.IDs()
+	if len(before) != len(after) {
+		s.save()
+		return
+	}
+	for i := range before {
+		if before[i] != after[i] {
+			s.save()
+			return
+		}
+	}
+}
+
+// IDs returns the instance IDs of the installed snaps in ascending order
+func (s *SnapList) IDs() []int {
+	ids := []int{}
+	for _, snap := range s.Snaps {
+		if id, ok := s.ID(snap.Name); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// ID returns the instance ID of an installed or reserved snap
+func (s *SnapList) ID(name string) (int, bool) {
+	for _, id := range s.ids.IDs() {
+		if n, _ := s.ids.Name(id); n == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// Reserve sets the instance ID of a snap before it is installed, so the
+// Software Management object and the snap objects share the instance ID
+func (s *SnapList) Reserve(name string, id int) error {
+	if current, ok := s.ID(name); ok && current != id {
+		return fmt.Errorf("snap %s has the instance ID %d", name, current)
+	}
+	if err := s.ids.Assign(name, id); err != nil {
+		return err
+	}
+	if !s.Installed(name) {
+		s.reserved[name] = true
+	}
+	s.save()
+	return nil
+}
+
+// Release frees the instance ID reserved for a snap that was not installed
+func (s *SnapList) Release(name string) {
+	if !s.reserved[name] {
+		return
+	}
+	delete(s.reserved, name)
+	s.ids.Remove(name)
+	s.save()
+}
+
+// Snap returns the installed snap with the instance ID
+func (s *SnapList) Snap(id int) (client.Snap, bool) {
+	name, ok := s.ids.Name(id)
+	if !ok {
+		return client.Snap{}, false
+	}
+
+	for _, snap := range s.Snaps {
+		if snap.Name == name {
+			return snap, true
+		}
+	}
+	return client.Snap{}, false
+}
+
+// load reads the persisted instance IDs of the snaps
+func (s *SnapList) load() {
+	dat, err := ioutil.ReadFile(filepath.Join(s.dir, snapInstancesFile))
+	if err != nil {
+		return
+	}
+
+	instances := snapInstances{}
+	if err = json.Unmarshal(dat, &instances); err != nil || instances.IDs == nil {
+		// The older clients did not store the next ID, which follows the
+		// highest restored ID then
+		instances = snapInstances{Next: -1}
+		if err = json.Unmarshal(dat, &instances.IDs); err != nil {
+			log.Printf("Error parsing the snap instance IDs: %v", err)
+			return
+		}
+	}
+
+	for name, id := range instances.IDs {
+		if err = s.ids.Assign(name, id); err != nil {
+			log.Printf("Error restoring the instance ID of snap %s: %v", name, err)
+		}
+	}
+
+	if instances.Next >= 0 {
+		if err = s.ids.SetNext(instances.Next); err != nil {
+			log.Printf("Error restoring the next snap instance ID: %v", err)
+		}
+	}
+}
+
+// save persists the instance IDs of the snaps
+func (s *SnapList) save() {
+	instances := snapInstances{Next: s.ids.Next(), IDs: map[string]int{}}
+	for _, id := range s.ids.IDs() {
+		if name, ok := s.ids.Name(id); ok {
+			instances.IDs[name] = id
+		}
+	}
+
+	b, err := json.Marshal(instances)
+	if err != nil {
+		log.Printf("Error marshalling the snap instance IDs: %v", err)
+		return
+	}
+
+	if err = WriteFileAtomic(filepath.Join(s.dir, snapInstancesFile), b, 0600); err != nil {
+		log.Printf("Error storing the snap instance IDs: %v", err)
+	}
+}
+
+// Install installs a snap from the store, with optional options
+func (s *SnapList) Install(name string, options *snapdapi.SnapOptions) (string, error) {
 	log.Printf("---Install snap: %s", name)
-	resp, err := s.client.Install(name, nil)
+	resp, err := s.snapAction("install", name, options)
 	if err != nil {
 		log.Println(err)
-		return err.Error()
+		return "", err
 	}
 	log.Println("Response:", resp)
-	return resp
+	return resp, nil
 }
 
 // Uninstall removes a snap
-func (s *SnapList) Uninstall(name string) string {
+func (s *SnapList) Uninstall(name string) (string, error) {
 	log.Printf("---Uninstall snap: %s", name)
 	resp, err := s.client.Remove(name, nil)
 	if err != nil {
 		log.Println(err)
-		return err.Error()
+		return "", err
 	}
 	log.Println("Response:", resp)
-	return resp
+	return resp, nil
 }
 
-// Refresh updates a snap from the store
-func (s *SnapList) Refresh(name string) string {
+// Refresh updates a snap from the store, with optional options
+func (s *SnapList) Refresh(name string, options *snapdapi.SnapOptions) (string, error) {
 	log.Println("---Refresh snap", name)
-	resp, err := s.client.Refresh(name, nil)
-	if err != nil {
-		return err.Error()
+	return s.snapAction("refresh", name, options)
+}
+
+// snapAction installs or refreshes a snap. The options with a cohort key are
+// sent with the raw snapd API, as the vendored snapd client does not support it.
+func (s *SnapList) snapAction(action, name string, options *snapdapi.SnapOptions) (string, error) {
+	if options != nil && len(options.CohortKey) > 0 {
+		return s.client.SnapAction(action, name, options)
 	}
-	return resp
+
+	var opts *client.SnapOptions
+	if options != nil {
+		opts = &options.SnapOptions
+	}
+	if action == "install" {
+		return s.client.Install(name, opts)
+	}
+	return s.client.Refresh(name, opts)
 }
 
 // Remove updates a snap from the store
-func (s *SnapList) Remove(name string) string {
+func (s *SnapList) Remove(name string) (string, error) {
 	log.Println("---Remove snap", name)
-	resp, err := s.client.Remove(name, nil)
-	if err != nil {
-		return err.Error()
-	}
-	return resp
+	return s.client.Remove(name, nil)
 }
 
 // Revert updates a snap from the store
-func (s *SnapList) Revert(name string) string {
+func (s *SnapList) Revert(name string) (string, error) {
 	log.Println("---Revert snap", name)
-	resp, err := s.client.Revert(name, nil)
-	if err != nil {
-		return err.Error()
-	}
-	return resp
+	return s.client.Revert(name, nil)
 }
 
 // Enable updates a snap from the store
-func (s *SnapList) Enable(name string) string {
+func (s *SnapList) Enable(name string) (string, error) {
 	log.Println("---Enable snap", name)
-	resp, err := s.client.Enable(name, nil)
-	if err != nil {
-		return err.Error()
-	}
-	return resp
+	return s.client.Enable(name, nil)
 }
 
 // Disable updates a snap from the store
-func (s *SnapList) Disable(name string) string {
+func (s *SnapList) Disable(name string) (string, error) {
 	log.Println("---Disable snap", name)
-	resp, err := s.client.Disable(name, nil)
-	if err != nil {
-		return err.Error()
-	}
-	return resp
+	return s.client.Disable(name, nil)
+}
+
+// Change returns the progress of a snapd change
+func (s *SnapList) Change(id string) (*client.Change, error) {
+	return s.client.Change(id)
+}
+
+// Invalidate forces the snap list to be refreshed on the next retrieval
+func (s *SnapList) Invalidate() {
+	s.lastRefresh = 0
 }
 
 // Conf gets the snaps config
@@ -141,3 +326,19 @@ func (s *SnapList) Conf(name string) (map[string]interface{}, error) {
 	log.Println("---Snap config", name)
 	return s.client.Conf(name)
 }
+
+// SetConf applies the patch to the config of a snap
+func (s *SnapList) SetConf(name string, patch map[string]interface{}) (string, error) {
+	log.Println("---Set snap config", name)
+	return s.client.SetConf(name, patch)
+}
+
+// Installed returns true when the snap is installed
+func (s *SnapList) Installed(name string) bool {
+	for _, snap := range s.Snaps {
+		if snap.Name == name {
+			return true
+		}
+	}
+	return false
+}
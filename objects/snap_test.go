@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/snapcore/snapd/client"
+)
+
+// newSnapList creates a snap list that stores its instance IDs in the directory
+func newSnapList(dir string, names ...string) *SnapList {
+	s := &SnapList{client: fakeSnapd, ids: NewInstanceIDs(), reserved: map[string]bool{}, dir: dir}
+	s.load()
+	for _, name := range names {
+		s.Snaps = append(s.Snaps, client.Snap{Name: name})
+	}
+	s.assignIDs()
+	return s
+}
+
+func TestSnapIDsAreNotReused(t *testing.T) {
+	dir := t.TempDir()
+	s := newSnapList(dir, "core18", "hello", "pc")
+
+	// The removed snap keeps its ID until the IDs wrap around, also after a
+	// restart of the client
+	s.Snaps = []client.Snap{{Name: "core18"}, {Name: "pc"}}
+	s.assignIDs()
+	s = newSnapList(dir, "aaa", "core18", "pc")
+
+	if id, _ := s.ID("aaa"); id != 3 {
+		t.Errorf("ID of the new snap = %d, want 3", id)
+	}
+	if id, _ := s.ID("pc"); id != 2 {
+		t.Errorf("ID of pc = %d, want 2", id)
+	}
+}
+
+func TestSnapIDsFromAnOlderClient(t *testing.T) {
+	dir := t.TempDir()
+	old := `{"core18": 0, "pc": 4}`
+	if err := ioutil.WriteFile(filepath.Join(dir, snapInstancesFile), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSnapList(dir, "core18", "hello", "pc")
+	if id, _ := s.ID("pc"); id != 4 {
+		t.Errorf("ID of pc = %d, want the stored 4", id)
+	}
+	if id, _ := s.ID("hello"); id != 5 {
+		t.Errorf("ID of the new snap = %d, want 5 after the stored IDs", id)
+	}
+
+	// The IDs are stored with the next ID
+	s = newSnapList(dir, "core18", "pc", "zzz")
+	if id, _ := s.ID("zzz"); id != 6 {
+		t.Errorf("ID of the new snap = %d, want 6", id)
+	}
+}
+
+func TestCachedSnapsAreNotRefreshed(t *testing.T) {
+	useSnaps("core18", "pc")
+	GetSnapsInstance()
+
+	// The snap installed since the last refresh is only listed on the next refresh
+	useSnaps("core18", "hello", "pc")
+	if ids := CachedSnapsInstance().IDs(); len(ids) != 2 {
+		t.Errorf("cached snap IDs = %v, want the 2 snaps of the last refresh", ids)
+	}
+	if ids := GetSnapsInstance().IDs(); len(ids) != 3 {
+		t.Errorf("snap IDs = %v, want the 3 installed snaps", ids)
+	}
+	if ids := CachedSnapsInstance().IDs(); len(ids) != 3 {
+		t.Errorf("cached snap IDs = %v, want the 3 snaps of the last refresh", ids)
+	}
+}
@@ -0,0 +1,336 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// configSecretsFile holds the allowlist of the config keys of each snap that
+// are secrets e.g. {"mqtt-bridge": ["password", "tls.key"]}. A secret key
+// redacts the nested keys too.
+const configSecretsFile = "config-secrets.json"
+
+// redactedValue replaces the value of a secret config key on read
+const redactedValue = "*****"
+
+// Results of the config reads and writes of a snap
+const (
+	ConfigResultInitial  = 0
+	ConfigResultApplied  = 1
+	ConfigResultPending  = 2
+	ConfigResultInvalid  = 3
+	ConfigResultNotFound = 4
+	ConfigResultRejected = 5
+	ConfigResultFailed   = 6
+)
+
+// snapConfigState holds the selected config key and the result of the last
+// config read or write of a snap
+type snapConfigState struct {
+	key       string
+	result    int
+	operation int
+}
+
+// SnapConfigs gets and sets the config of the snaps for the snap instances
+type SnapConfigs struct {
+	mu      sync.Mutex
+	states  map[string]*snapConfigState
+	secrets map[string][]string
+}
+
+// Using a singleton to define the snap configs
+var snapConfigsInstance *SnapConfigs
+var snapConfigsOnce sync.Once
+
+// GetSnapConfigsInstance returns the snap configs
+func GetSnapConfigsInstance() *SnapConfigs {
+	snapConfigsOnce.Do(func() {
+		snapConfigsInstance = &SnapConfigs{
+			states:  map[string]*snapConfigState{},
+			secrets: loadConfigSecrets(dataDir()),
+		}
+	})
+	return snapConfigsInstance
+}
+
+// loadConfigSecrets reads the allowlist of the secret config keys
+func loadConfigSecrets(dir string) map[string][]string {
+	secrets := map[string][]string{}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, configSecretsFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading the secret config keys: %v", err)
+		}
+		return secrets
+	}
+
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		log.Printf("Error parsing the secret config keys: %v", err)
+		return map[string][]string{}
+	}
+	return secrets
+}
+
+// state returns the config state of the snap. The caller holds the lock.
+func (c *SnapConfigs) state(name string) *snapConfigState {
+	st, ok := c.states[name]
+	if !ok {
+		st = &snapConfigState{operation: -1}
+		c.states[name] = st
+	}
+	return st
+}
+
+// Conf returns the config of the snap as a JSON document, with the secrets redacted
+func (c *SnapConfigs) Conf(s *SnapList, name string) (string, error) {
+	conf, err := c.redactedConf(s, name)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Configuration returns the config of the installed snaps, keyed by snap
+// name, with the secrets redacted. The snaps whose config cannot be read are
+// left out.
+func (c *SnapConfigs) Configuration(s *SnapList) map[string]map[string]interface{} {
+	confs := map[string]map[string]interface{}{}
+	for _, snap := range s.Snaps {
+		conf, err := c.redactedConf(s, snap.Name)
+		if err != nil {
+			log.Printf("Error retrieving the config of %s: %v", snap.Name, err)
+			continue
+		}
+		confs[snap.Name] = conf
+	}
+	return confs
+}
+
+// Key returns the selected config key of the snap
+func (c *SnapConfigs) Key(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state(name).key
+}
+
+// SetKey selects the config key of the snap that is read as the config value
+func (c *SnapConfigs) SetKey(name, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state(name)
+	if !validConfigKey(key) {
+		st.result = ConfigResultInvalid
+		return fmt.Errorf("invalid config key: %q", key)
+	}
+	st.key = key
+	return nil
+}
+
+// Value returns the value of the selected config key of the snap as JSON,
+// with the secrets redacted
+func (c *SnapConfigs) Value(s *SnapList, name string) (string, error) {
+	key := c.Key(name)
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	conf, err := c.redactedConf(s, name)
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := lookupConfigKey(conf, key)
+	if !ok {
+		c.setResult(name, ConfigResultNotFound)
+		return "", fmt.Errorf("config key %s of %s is not set", key, name)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Patch applies the JSON document of config options to the snap, in the
+// background as an operation. A null value unsets an option.
+func (c *SnapConfigs) Patch(s *SnapList, name string, doc []byte) error {
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(doc, &patch); err != nil {
+		c.setResult(name, ConfigResultInvalid)
+		return err
+	}
+
+	return c.Apply(s, name, patch)
+}
+
+// Apply applies the config options to the snap, in the background as an operation
+func (c *SnapConfigs) Apply(s *SnapList, name string, patch map[string]interface{}) error {
+	for key, v := range patch {
+		if !validConfigKey(key) {
+			c.setResult(name, ConfigResultInvalid)
+			return fmt.Errorf("invalid config key: %q", key)
+		}
+		// A redacted config that is written back would overwrite the secrets
+		if c.redacted(name, key, v) {
+			c.setResult(name, ConfigResultInvalid)
+			return fmt.Errorf("config key %s of %s is redacted", key, name)
+		}
+	}
+
+	id, err := GetOperationsInstance().Start("configure", name, func() (string, error) { return s.SetConf(name, patch) })
+	if err != nil {
+		c.setResult(name, ConfigResultFailed)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state(name)
+	st.result = ConfigResultPending
+	st.operation = id
+	return nil
+}
+
+// Result returns the result of the last config read or write of the snap
+func (c *SnapConfigs) Result(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state(name)
+	if st.result != ConfigResultPending {
+		return st.result
+	}
+
+	op, ok := GetOperationsInstance().Operation(st.operation)
+	switch {
+	case !ok:
+		st.result = ConfigResultFailed
+	case op.State == OperationDone:
+		st.result = ConfigResultApplied
+	case op.State == OperationFailed:
+		st.result = ConfigResultRejected
+	}
+	return st.result
+}
+
+// setResult records the result of a config read or write of the snap
+func (c *SnapConfigs) setResult(name string, result int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state(name).result = result
+}
+
+// secret returns true when the config key of the snap is in the allowlist of
+// secrets, or is nested in a secret key
+func (c *SnapConfigs) secret(name, key string) bool {
+	for _, s := range c.secrets[name] {
+		if key == s || strings.HasPrefix(key, s+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns true when the value of the config key of the snap is, or
+// holds, the redacted value of a secret
+func (c *SnapConfigs) redacted(name, key string, v interface{}) bool {
+	if nested, ok := v.(map[string]interface{}); ok {
+		for k, v := range nested {
+			if c.redacted(name, key+"."+k, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return v == redactedValue && c.secret(name, key)
+}
+
+// redactedConf gets the config of the snap and redacts the secrets
+func (c *SnapConfigs) redactedConf(s *SnapList, name string) (map[string]interface{}, error) {
+	conf, err := s.Conf(name)
+	if err != nil {
+		c.setResult(name, ConfigResultFailed)
+		return nil, err
+	}
+
+	for _, key := range c.secrets[name] {
+		conf = redactConfigKey(conf, strings.Split(key, "."))
+	}
+	return conf, nil
+}
+
+// redactConfigKey replaces the value of the dotted key, copying the maps on
+// the path so that the config returned by snapd is not modified
+func redactConfigKey(conf map[string]interface{}, path []string) map[string]interface{} {
+	v, ok := conf[path[0]]
+	if !ok {
+		return conf
+	}
+
+	redacted := map[string]interface{}{}
+	for k, v := range conf {
+		redacted[k] = v
+	}
+
+	if len(path) == 1 {
+		redacted[path[0]] = redactedValue
+		return redacted
+	}
+
+	nested, ok := v.(map[string]interface{})
+	if !ok {
+		return conf
+	}
+	redacted[path[0]] = redactConfigKey(nested, path[1:])
+	return redacted
+}
+
+// lookupConfigKey returns the value of the dotted key in the config
+func lookupConfigKey(conf map[string]interface{}, key string) (interface{}, bool) {
+	var v interface{} = conf
+	for _, k := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// validConfigKey checks the key is a dotted snapd config key
+func validConfigKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for _, k := range strings.Split(key, ".") {
+		if len(k) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestSnapConfigs returns the snap configs of the mqtt snap, with its
+// secret config keys
+func newTestSnapConfigs() *SnapConfigs {
+	useSnaps("mqtt")
+	fakeSnapd.Config["mqtt"] = map[string]interface{}{
+		"port":     1883.0,
+		"password": "secret",
+		"tls":      map[string]interface{}{"key": "private", "cert": "public"},
+		"auth":     map[string]interface{}{"user": "admin", "token": map[string]interface{}{"value": "t0k3n"}},
+	}
+
+	return &SnapConfigs{
+		states:  map[string]*snapConfigState{},
+		secrets: map[string][]string{"mqtt": {"password", "tls.key", "auth"}},
+	}
+}
+
+// waitForConfigResult waits until the config write of the snap completes
+func waitForConfigResult(t *testing.T, c *SnapConfigs, name string) int {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if result := c.Result(name); result != ConfigResultPending {
+			return result
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the config write of %s did not complete", name)
+	return ConfigResultPending
+}
+
+func TestSnapConfigRedactsTheSecrets(t *testing.T) {
+	c := newTestSnapConfigs()
+	want := map[string]interface{}{
+		"port":     1883.0,
+		"password": redactedValue,
+		"tls":      map[string]interface{}{"key": redactedValue, "cert": "public"},
+		"auth":     redactedValue,
+	}
+
+	doc, err := c.Conf(GetSnapsInstance(), "mqtt")
+	if err != nil {
+		t.Fatalf("Conf: %v", err)
+	}
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(doc), &conf); err != nil {
+		t.Fatalf("invalid config %s: %v", doc, err)
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("Conf = %v, want %v", conf, want)
+	}
+
+	if confs := c.Configuration(GetSnapsInstance()); !reflect.DeepEqual(confs["mqtt"], want) {
+		t.Errorf("Configuration = %v, want %v", confs["mqtt"], want)
+	}
+
+	// The config of snapd is not modified
+	if fakeSnapd.Config["mqtt"]["password"] != "secret" {
+		t.Errorf("the config of snapd was redacted: %v", fakeSnapd.Config["mqtt"])
+	}
+
+	values := map[string]string{
+		"port":     "1883",
+		"password": `"*****"`,
+		"tls.key":  `"*****"`,
+		"tls.cert": `"public"`,
+		"auth":     `"*****"`,
+	}
+	for key, want := range values {
+		if err := c.SetKey("mqtt", key); err != nil {
+			t.Fatalf("SetKey(%s): %v", key, err)
+		}
+		if v, err := c.Value(GetSnapsInstance(), "mqtt"); err != nil || v != want {
+			t.Errorf("Value(%s) = %s, %v, want %s", key, v, err, want)
+		}
+	}
+
+	// The keys nested in a secret are not found
+	for _, key := range []string{"auth.user", "auth.token.value"} {
+		c.SetKey("mqtt", key)
+		if v, err := c.Value(GetSnapsInstance(), "mqtt"); err == nil {
+			t.Errorf("Value(%s) = %s, want an error", key, v)
+		}
+		if result := c.Result("mqtt"); result != ConfigResultNotFound {
+			t.Errorf("Result after reading %s = %d, want not found", key, result)
+		}
+	}
+}
+
+func TestSnapConfigApplyRefusesTheRedactedSecrets(t *testing.T) {
+	c := newTestSnapConfigs()
+	actions := len(fakeSnapd.Actions)
+
+	patches := []map[string]interface{}{
+		{"password": redactedValue},
+		{"tls": map[string]interface{}{"key": redactedValue, "cert": "new"}},
+		{"auth": map[string]interface{}{"user": redactedValue}},
+		{"auth": redactedValue},
+		{"port": 1884.0, "tls.key": redactedValue},
+	}
+	for _, patch := range patches {
+		if err := c.Apply(GetSnapsInstance(), "mqtt", patch); err == nil {
+			t.Errorf("Apply(%v) wrote back a redacted secret", patch)
+		}
+		if result := c.Result("mqtt"); result != ConfigResultInvalid {
+			t.Errorf("Result after Apply(%v) = %d, want invalid", patch, result)
+		}
+	}
+	if len(fakeSnapd.Actions) != actions {
+		t.Errorf("snapd was asked to %v", fakeSnapd.Actions[actions:])
+	}
+
+	// A new secret, and a redacted value of a key that is not a secret, are applied
+	if err := c.Patch(GetSnapsInstance(), "mqtt", []byte(`{"password": "changed", "tls": {"cert": "*****"}}`)); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if result := waitForConfigResult(t, c, "mqtt"); result != ConfigResultApplied {
+		t.Errorf("Result = %d, want applied", result)
+	}
+	if action := lastAction(); action != "set mqtt" {
+		t.Errorf("action = %q, want the config of mqtt", action)
+	}
+	if conf, _ := fakeSnapd.Conf("mqtt"); conf["password"] != "changed" {
+		t.Errorf("password = %v, want the new one", conf["password"])
+	}
+}
+
+func TestSnapConfigResultOfFailures(t *testing.T) {
+	c := newTestSnapConfigs()
+
+	// snapd rejects the config in the background
+	fakeSnapd.Err = errors.New("snapd is not available")
+	err := c.Patch(GetSnapsInstance(), "mqtt", []byte(`{"port": 1884}`))
+	if err != nil {
+		fakeSnapd.Err = nil
+		t.Fatalf("Patch: %v", err)
+	}
+	result := waitForConfigResult(t, c, "mqtt")
+	if result != ConfigResultRejected {
+		t.Errorf("Result = %d, want rejected", result)
+	}
+
+	// The config cannot be read
+	if _, err := c.Conf(GetSnapsInstance(), "mqtt"); err == nil {
+		t.Error("the config was read without snapd")
+	}
+	fakeSnapd.Err = nil
+	if result := c.Result("mqtt"); result != ConfigResultFailed {
+		t.Errorf("Result = %d, want failed", result)
+	}
+
+	if err := c.Patch(GetSnapsInstance(), "mqtt", []byte(`{"port": `)); err == nil {
+		t.Error("an invalid patch was applied")
+	}
+	if result := c.Result("mqtt"); result != ConfigResultInvalid {
+		t.Errorf("Result = %d, want invalid", result)
+	}
+}
@@ -0,0 +1,342 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/snapcore/snapd/client"
+)
+
+// Software update states, as defined by the LwM2M Software Management object
+const (
+	SoftwareStateInitial    = 0
+	SoftwareStateStarted    = 1
+	SoftwareStateDownloaded = 2
+	SoftwareStateDelivered  = 3
+	SoftwareStateInstalled  = 4
+)
+
+// Software update results, as defined by the LwM2M Software Management object
+const (
+	SoftwareResultInitial          = 0
+	SoftwareResultDownloading      = 1
+	SoftwareResultInstalled        = 2
+	SoftwareResultDelivered        = 3
+	SoftwareResultNoStorage        = 50
+	SoftwareResultNoMemory         = 51
+	SoftwareResultConnectionLost   = 52
+	SoftwareResultIntegrityFailure = 53
+	SoftwareResultUnsupportedType  = 54
+	SoftwareResultInvalidURI       = 56
+	SoftwareResultDeviceError      = 57
+	SoftwareResultInstallFailed    = 58
+	SoftwareResultUninstallFailed  = 59
+)
+
+// Operations on a software package that are tracked through a snapd change
+const (
+	softwareInstall    = "install"
+	softwareUninstall  = "uninstall"
+	softwareActivate   = "activate"
+	softwareDeactivate = "deactivate"
+)
+
+// SoftwarePackage is a snap, as seen by the Software Management object
+type SoftwarePackage struct {
+	Name         string
+	Version      string
+	Active       bool
+	UpdateState  int
+	UpdateResult int
+
+	change    string
+	operation string
+}
+
+// SoftwareList defines the Software Management object, with an instance per
+// snap. The instance IDs are the persisted instance IDs of the snap objects.
+type SoftwareList struct {
+	mu       sync.Mutex
+	packages map[int]*SoftwarePackage
+}
+
+// Using a singleton to define the software packages
+var softwareInstance *SoftwareList
+var softwareOnce sync.Once
+
+// GetSoftwareInstance returns an instance of the Software Management object
+func GetSoftwareInstance() *SoftwareList {
+	l := CachedSoftwareInstance()
+	l.refresh(GetSnapsInstance())
+	return l
+}
+
+// CachedSoftwareInstance returns the software packages as of the last refresh.
+// Only the first call lists the packages from the installed snaps.
+func CachedSoftwareInstance() *SoftwareList {
+	softwareOnce.Do(func() {
+		softwareInstance = &SoftwareList{
+			packages: map[int]*SoftwarePackage{},
+		}
+		softwareInstance.refresh(CachedSnapsInstance())
+	})
+
+	return softwareInstance
+}
+
+// IDs returns the instance IDs of the software packages in ascending order
+func (l *SoftwareList) IDs() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ids := []int{}
+	for id := range l.packages {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Package returns a copy of the software package with the instance ID
+func (l *SoftwareList) Package(id int) (SoftwarePackage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, ok := l.packages[id]
+	if !ok {
+		return SoftwarePackage{}, false
+	}
+	return *p, true
+}
+
+// Create adds a software package for a snap that will be installed from the store
+func (l *SoftwareList) Create(id int, name string) error {
+	s := GetSnapsInstance()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(name) == 0 {
+		return errors.New("the package name is required")
+	}
+	if _, ok := l.packages[id]; ok {
+		return fmt.Errorf("software package %d already exists", id)
+	}
+	if err := s.Reserve(name, id); err != nil {
+		return err
+	}
+
+	// Snaps are delivered by the store, so the package can be installed straight away
+	l.packages[id] = &SoftwarePackage{
+		Name:         name,
+		UpdateState:  SoftwareStateDelivered,
+		UpdateResult: SoftwareResultDelivered,
+	}
+	return nil
+}
+
+// Install installs the snap of a delivered software package
+func (l *SoftwareList) Install(id int) error {
+	return l.operate(id, softwareInstall, func(p SoftwarePackage) error {
+		if p.UpdateState != SoftwareStateDelivered {
+			return fmt.Errorf("software package %s is not delivered", p.Name)
+		}
+		return nil
+	}, func(s *SnapList, name string) (string, error) {
+		return s.Install(name, nil)
+	})
+}
+
+// Uninstall removes the snap of a software package
+func (l *SoftwareList) Uninstall(id int) error {
+	s := GetSnapsInstance()
+
+	l.mu.Lock()
+	p, ok := l.packages[id]
+	if ok && p.UpdateState != SoftwareStateInstalled && len(p.operation) == 0 {
+		// The snap was never installed, so just forget the package
+		delete(l.packages, id)
+		s.Release(p.Name)
+		l.mu.Unlock()
+		return nil
+	}
+	l.mu.Unlock()
+
+	return l.operate(id, softwareUninstall, nil, func(s *SnapList, name string) (string, error) {
+		return s.Remove(name)
+	})
+}
+
+// Activate enables the snap of an installed software package
+func (l *SoftwareList) Activate(id int) error {
+	return l.operate(id, softwareActivate, nil, func(s *SnapList, name string) (string, error) {
+		return s.Enable(name)
+	})
+}
+
+// Deactivate disables the snap of an installed software package
+func (l *SoftwareList) Deactivate(id int) error {
+	return l.operate(id, softwareDeactivate, nil, func(s *SnapList, name string) (string, error) {
+		return s.Disable(name)
+	})
+}
+
+// operate starts a snapd change for the software package and tracks it. The
+// package is checked under the lock, but the lock is not held while snapd starts
+// the change, so the package can be read in the meantime.
+func (l *SoftwareList) operate(id int, operation string, check func(SoftwarePackage) error, action func(*SnapList, string) (string, error)) error {
+	l.mu.Lock()
+	p, ok := l.packages[id]
+	if !ok {
+		l.mu.Unlock()
+		return fmt.Errorf("unknown software package %d", id)
+	}
+	if len(p.operation) > 0 {
+		l.mu.Unlock()
+		return fmt.Errorf("software package %s has a %s in progress", p.Name, p.operation)
+	}
+	if check != nil {
+		if err := check(*p); err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+
+	// Mark the operation, so it is not started twice
+	name := p.Name
+	p.operation = operation
+	l.mu.Unlock()
+
+	change, err := action(GetSnapsInstance(), name)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, ok = l.packages[id]
+	if !ok {
+		return err
+	}
+	if err != nil {
+		p.operation = ""
+		l.failed(p, operation)
+		return err
+	}
+
+	p.change = change
+	p.UpdateResult = SoftwareResultInitial
+	return nil
+}
+
+// pendingChange is a snapd change of a software package that has not completed
+type pendingChange struct {
+	id        int
+	change    string
+	operation string
+	name      string
+}
+
+// refresh keeps the software packages in step with the installed snaps and
+// snapd changes. The changes are checked without holding the lock.
+func (l *SoftwareList) refresh(s *SnapList) {
+	l.mu.Lock()
+	pending := []pendingChange{}
+	for id, p := range l.packages {
+		if len(p.change) > 0 {
+			pending = append(pending, pendingChange{id, p.change, p.operation, p.Name})
+		}
+	}
+	l.mu.Unlock()
+
+	ready := map[int]*client.Change{}
+	for _, c := range pending {
+		chg, err := s.Change(c.change)
+		if err != nil {
+			log.Printf("Error checking the %s of %s: %v", c.operation, c.name, err)
+			continue
+		}
+		if chg.Ready {
+			ready[c.id] = chg
+		}
+	}
+
+	// Refresh the snap list if a change has completed
+	if len(ready) > 0 {
+		s.Invalidate()
+	}
+	s = GetSnapsInstance()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, c := range pending {
+		chg, ok := ready[c.id]
+		if !ok {
+			continue
+		}
+		p, ok := l.packages[c.id]
+		if !ok || p.change != c.change {
+			continue
+		}
+
+		p.change = ""
+		p.operation = ""
+
+		if chg.Status != "Done" {
+			log.Printf("Error during the %s of %s: %s", c.operation, p.Name, chg.Err)
+			l.failed(p, c.operation)
+			continue
+		}
+
+		switch c.operation {
+		case softwareInstall:
+			p.UpdateState = SoftwareStateInstalled
+			p.UpdateResult = SoftwareResultInstalled
+		case softwareUninstall:
+			delete(l.packages, c.id)
+		}
+	}
+
+	installed := map[string]bool{}
+	for _, snap := range s.Snaps {
+		id, ok := s.ID(snap.Name)
+		if !ok {
+			continue
+		}
+		installed[snap.Name] = true
+
+		p, ok := l.packages[id]
+		if !ok {
+			p = &SoftwarePackage{Name: snap.Name}
+			l.packages[id] = p
+		}
+		p.Version = snap.Version
+		p.Active = snap.Status == "active"
+		p.UpdateState = SoftwareStateInstalled
+	}
+
+	// Forget the snaps that were removed outside of the object
+	for id, p := range l.packages {
+		if p.UpdateState == SoftwareStateInstalled && len(p.operation) == 0 && !installed[p.Name] {
+			delete(l.packages, id)
+		}
+	}
+}
+
+// failed records the failure of an operation on the software package
+func (l *SoftwareList) failed(p *SoftwarePackage, operation string) {
+	switch operation {
+	case softwareInstall:
+		p.UpdateResult = SoftwareResultInstallFailed
+	case softwareUninstall:
+		p.UpdateResult = SoftwareResultUninstallFailed
+	default:
+		p.UpdateResult = SoftwareResultDeviceError
+	}
+}
@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snapcore/snapd/client"
+)
+
+// useSnaps makes the snaps the installed snaps of the fake snapd
+func useSnaps(names ...string) {
+	snaps := []*client.Snap{}
+	for _, name := range names {
+		snaps = append(snaps, &client.Snap{Name: name, Version: "1.0", Status: "active"})
+	}
+	fakeSnapd.Snaps = snaps
+	GetSnapsInstance().Invalidate()
+}
+
+// lastAction returns the last request made to the fake snapd
+func lastAction() string {
+	if len(fakeSnapd.Actions) == 0 {
+		return ""
+	}
+	return fakeSnapd.Actions[len(fakeSnapd.Actions)-1]
+}
+
+func TestSoftwareSharesTheSnapInstanceIDs(t *testing.T) {
+	useSnaps("core18", "hello", "pc")
+
+	s := GetSnapsInstance()
+	l := GetSoftwareInstance()
+	if !reflect.DeepEqual(l.IDs(), s.IDs()) {
+		t.Fatalf("software IDs = %v, want the snap IDs %v", l.IDs(), s.IDs())
+	}
+	for _, id := range l.IDs() {
+		p, _ := l.Package(id)
+		snap, ok := s.Snap(id)
+		if !ok || snap.Name != p.Name {
+			t.Errorf("software package %d is %s, want snap %s", id, p.Name, snap.Name)
+		}
+		if p.UpdateState != SoftwareStateInstalled || !p.Active {
+			t.Errorf("software package %s = %+v, want an active installed package", p.Name, p)
+		}
+	}
+}
+
+func TestSoftwareCreateAndInstall(t *testing.T) {
+	useSnaps("core18", "pc")
+	l := GetSoftwareInstance()
+
+	if err := l.Create(40, "hello-world"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, id := range GetSnapsInstance().IDs() {
+		if id == 40 {
+			t.Fatal("the snap objects list a snap that is not installed")
+		}
+	}
+
+	if err := l.Install(40); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if action := lastAction(); action != "install hello-world" {
+		t.Fatalf("action = %q, want the install of the snap", action)
+	}
+	if err := l.Install(40); err == nil {
+		t.Error("the install was started twice")
+	}
+
+	// The snap is installed with the instance ID of the package
+	useSnaps("core18", "hello-world", "pc")
+	p, ok := GetSoftwareInstance().Package(40)
+	if !ok || p.UpdateState != SoftwareStateInstalled || p.UpdateResult != SoftwareResultInstalled {
+		t.Fatalf("software package = %+v, want an installed package", p)
+	}
+	if snap, ok := GetSnapsInstance().Snap(40); !ok || snap.Name != "hello-world" {
+		t.Errorf("snap 40 = %s, want hello-world", snap.Name)
+	}
+
+	if err := l.Uninstall(40); err != nil {
+		t.Fatalf("Uninstall: %v", err)
+	}
+	if action := lastAction(); action != "remove hello-world" {
+		t.Fatalf("action = %q, want the removal of the snap", action)
+	}
+	useSnaps("core18", "pc")
+	if _, ok := GetSoftwareInstance().Package(40); ok {
+		t.Error("the removed snap still has a software package")
+	}
+}
+
+func TestSoftwareCreateInvalid(t *testing.T) {
+	useSnaps("core18", "pc")
+	l := GetSoftwareInstance()
+	id, _ := GetSnapsInstance().ID("pc")
+
+	tests := []struct {
+		id   int
+		name string
+	}{
+		{41, ""},
+		{id, "hello-world"},
+		{id + 1, "pc"},
+		{maxInstanceID + 1, "hello-world"},
+	}
+	for _, tt := range tests {
+		if err := l.Create(tt.id, tt.name); err == nil {
+			t.Errorf("Create(%d, %q) succeeded, want an error", tt.id, tt.name)
+		}
+	}
+}
+
+func TestSoftwareDeleteReleasesTheInstanceID(t *testing.T) {
+	useSnaps("core18", "pc")
+	l := GetSoftwareInstance()
+
+	if err := l.Create(42, "hello-world"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := l.Uninstall(42); err != nil {
+		t.Fatalf("Uninstall: %v", err)
+	}
+	if _, ok := GetSnapsInstance().ID("hello-world"); ok {
+		t.Error("the instance ID of the deleted package was not released")
+	}
+	if err := l.Create(42, "other"); err != nil {
+		t.Errorf("Create with the released instance ID: %v", err)
+	}
+	l.Uninstall(42)
+}
+
+func TestSoftwareInstallFailed(t *testing.T) {
+	useSnaps("core18", "pc")
+	l := GetSoftwareInstance()
+
+	if err := l.Create(43, "broken"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := l.Install(43); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	chg, _ := fakeSnapd.Change(l.packages[43].change)
+	chg.Status = "Error"
+	chg.Err = "cannot install"
+
+	p, _ := GetSoftwareInstance().Package(43)
+	if p.UpdateState != SoftwareStateDelivered || p.UpdateResult != SoftwareResultInstallFailed {
+		t.Errorf("software package = %+v, want a failed install", p)
+	}
+	l.Uninstall(43)
+}
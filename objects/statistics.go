@@ -0,0 +1,291 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const statisticsStatusFile = "statistics.json"
+
+// InterfaceCounters are the traffic counters of a network interface from /sys/class/net
+type InterfaceCounters struct {
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxPackets uint64 `json:"rx_packets"`
+}
+
+// add accumulates the traffic since the last reading. The kernel counters
+// restart from zero when the interface is recreated or the device reboots.
+func (t *InterfaceCounters) add(last, current InterfaceCounters) {
+	delta := func(l, c uint64) uint64 {
+		if c < l {
+			return c
+		}
+		return c - l
+	}
+
+	t.TxBytes += delta(last.TxBytes, current.TxBytes)
+	t.RxBytes += delta(last.RxBytes, current.RxBytes)
+	t.TxPackets += delta(last.TxPackets, current.TxPackets)
+	t.RxPackets += delta(last.RxPackets, current.RxPackets)
+}
+
+func (t InterfaceCounters) averageSize() uint64 {
+	packets := t.TxPackets + t.RxPackets
+	if packets == 0 {
+		return 0
+	}
+	return (t.TxBytes + t.RxBytes) / packets
+}
+
+// StatisticsStatus is the state of the statistics collection, persisted across restarts
+type StatisticsStatus struct {
+	Collecting bool                         `json:"collecting"`
+	Started    int64                        `json:"started"`
+	Period     int                          `json:"period"`
+	Total      InterfaceCounters            `json:"total"`
+	Interfaces map[string]InterfaceCounters `json:"interfaces"`
+	Last       map[string]InterfaceCounters `json:"last"`
+	MaxSize    uint64                       `json:"max_size"`
+}
+
+// Statistics defines the Connectivity Statistics object. The traffic of the
+// physical interfaces is collected between the Start and Stop executes.
+type Statistics struct {
+	mu          sync.Mutex
+	status      StatisticsStatus
+	root        string
+	dir         string
+	lastRefresh int64
+}
+
+// Using a singleton to define the connectivity statistics
+var statisticsInstance *Statistics
+var statisticsOnce sync.Once
+
+// GetStatisticsInstance returns an instance of the Connectivity Statistics object
+func GetStatisticsInstance() *Statistics {
+	statisticsOnce.Do(func() {
+		statisticsInstance = NewStatistics(networkRoot, dataDir())
+	})
+	statisticsInstance.refreshStale()
+
+	return statisticsInstance
+}
+
+// NewStatistics creates a Connectivity Statistics object that reads the counters
+// from the /sys tree under the root and keeps its state in the directory.
+// A collection that was running when the agent stopped carries on.
+func NewStatistics(root, dir string) *Statistics {
+	s := &Statistics{root: root, dir: dir}
+	s.load()
+	return s
+}
+
+// Status returns the current state of the statistics collection
+func (s *Statistics) Status() StatisticsStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// TxKB returns the data transmitted during the collection period in kilobytes
+func (s *Statistics) TxKB() uint64 {
+	return s.Status().Total.TxBytes / 1024
+}
+
+// RxKB returns the data received during the collection period in kilobytes
+func (s *Statistics) RxKB() uint64 {
+	return s.Status().Total.RxBytes / 1024
+}
+
+// AverageSize returns the average size of the packets in bytes
+func (s *Statistics) AverageSize() uint64 {
+	return s.Status().Total.averageSize()
+}
+
+// MaxSize returns the largest average packet size seen between two readings
+// of the counters, as the kernel does not record the size of each packet
+func (s *Statistics) MaxSize() uint64 {
+	return s.Status().MaxSize
+}
+
+// Start resets the counters and starts the collection of the statistics
+func (s *Statistics) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Collecting = true
+	s.status.Started = time.Now().Unix()
+	s.status.Total = InterfaceCounters{}
+	s.status.Interfaces = map[string]InterfaceCounters{}
+	s.status.MaxSize = 0
+	s.status.Last = s.readCounters()
+	s.save()
+}
+
+// Stop ends the collection of the statistics, keeping the counters
+func (s *Statistics) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.status.Collecting {
+		return
+	}
+	s.collect()
+	s.status.Collecting = false
+	s.save()
+}
+
+// SetPeriod sets the collection period in seconds, after which the collection
+// stops. Zero collects until the Stop execute.
+func (s *Statistics) SetPeriod(period int) error {
+	if period < 0 {
+		return errors.New("the collection period must not be negative")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Period = period
+	s.save()
+	return nil
+}
+
+// Refresh adds the traffic since the last reading and ends a collection period that has expired
+func (s *Statistics) Refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refresh()
+}
+
+// refreshStale refreshes the statistics when the last reading is out of date
+func (s *Statistics) refreshStale() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dataIsStale(s.lastRefresh) {
+		s.refresh()
+		s.lastRefresh = time.Now().Unix()
+	}
+}
+
+// refresh adds the traffic since the last reading, and only stores the state
+// when it has changed
+func (s *Statistics) refresh() {
+	if !s.status.Collecting {
+		return
+	}
+	changed := s.collect()
+
+	if s.status.Period > 0 && time.Now().Unix() >= s.status.Started+int64(s.status.Period) {
+		log.Println("Connectivity statistics collection period has ended")
+		s.status.Collecting = false
+		changed = true
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// collect adds the traffic of each interface since the last reading, and
+// returns true when the counters have changed
+func (s *Statistics) collect() bool {
+	current := s.readCounters()
+	if reflect.DeepEqual(current, s.status.Last) {
+		return false
+	}
+	if s.status.Interfaces == nil {
+		s.status.Interfaces = map[string]InterfaceCounters{}
+	}
+
+	interval := InterfaceCounters{}
+	for name, c := range current {
+		t := s.status.Interfaces[name]
+		t.add(s.status.Last[name], c)
+		s.status.Interfaces[name] = t
+		interval.add(s.status.Last[name], c)
+	}
+
+	s.status.Total.TxBytes += interval.TxBytes
+	s.status.Total.RxBytes += interval.RxBytes
+	s.status.Total.TxPackets += interval.TxPackets
+	s.status.Total.RxPackets += interval.RxPackets
+	if size := interval.averageSize(); size > s.status.MaxSize {
+		s.status.MaxSize = size
+	}
+	s.status.Last = current
+	return true
+}
+
+// readCounters reads the statistics of the physical interfaces from /sys/class/net
+func (s *Statistics) readCounters() map[string]InterfaceCounters {
+	counters := map[string]InterfaceCounters{}
+
+	files, err := ioutil.ReadDir(filepath.Join(s.root, "sys/class/net"))
+	if err != nil {
+		log.Printf("Error reading the network interfaces: %v", err)
+		return counters
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if name == "lo" || exists(filepath.Join(s.root, "sys/devices/virtual/net", name)) {
+			continue
+		}
+
+		dir := filepath.Join(s.root, "sys/class/net", name, "statistics")
+		counters[name] = InterfaceCounters{
+			TxBytes:   readCounter(filepath.Join(dir, "tx_bytes")),
+			RxBytes:   readCounter(filepath.Join(dir, "rx_bytes")),
+			TxPackets: readCounter(filepath.Join(dir, "tx_packets")),
+			RxPackets: readCounter(filepath.Join(dir, "rx_packets")),
+		}
+	}
+	return counters
+}
+
+// load reads the persisted statistics collection state
+func (s *Statistics) load() {
+	dat, err := ioutil.ReadFile(filepath.Join(s.dir, statisticsStatusFile))
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(dat, &s.status); err != nil {
+		log.Printf("Error parsing the connectivity statistics: %v", err)
+	}
+}
+
+// save persists the statistics collection state
+func (s *Statistics) save() {
+	b, err := json.Marshal(s.status)
+	if err != nil {
+		log.Printf("Error marshalling the connectivity statistics: %v", err)
+		return
+	}
+
+	if err = WriteFileAtomic(filepath.Join(s.dir, statisticsStatusFile), b, 0600); err != nil {
+		log.Printf("Error storing the connectivity statistics: %v", err)
+	}
+}
+
+func readCounter(path string) uint64 {
+	i := readInt(path)
+	if i < 0 {
+		return 0
+	}
+	return uint64(i)
+}
@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeCounters sets the traffic counters of an interface in the fake /sys tree
+func writeCounters(t *testing.T, root, name string, c InterfaceCounters) {
+	dir := filepath.Join("sys/class/net", name, "statistics")
+	writeFiles(t, root, map[string]string{
+		filepath.Join(dir, "tx_bytes"):   fmt.Sprint(c.TxBytes),
+		filepath.Join(dir, "rx_bytes"):   fmt.Sprint(c.RxBytes),
+		filepath.Join(dir, "tx_packets"): fmt.Sprint(c.TxPackets),
+		filepath.Join(dir, "rx_packets"): fmt.Sprint(c.RxPackets),
+	})
+}
+
+func TestStatisticsCollect(t *testing.T) {
+	root := newNetworkTree(t)
+	writeCounters(t, root, "eth0", InterfaceCounters{TxBytes: 1000, RxBytes: 2000, TxPackets: 10, RxPackets: 20})
+	writeCounters(t, root, "br0", InterfaceCounters{TxBytes: 5000, RxBytes: 5000, TxPackets: 5, RxPackets: 5})
+
+	s := NewStatistics(root, t.TempDir())
+	s.Start()
+
+	writeCounters(t, root, "eth0", InterfaceCounters{TxBytes: 3048, RxBytes: 6096, TxPackets: 20, RxPackets: 40})
+	writeCounters(t, root, "br0", InterfaceCounters{TxBytes: 9000, RxBytes: 9000, TxPackets: 9, RxPackets: 9})
+	s.Refresh()
+
+	// The bridge is virtual, so only the traffic of eth0 is counted
+	if s.TxKB() != 2 || s.RxKB() != 4 {
+		t.Errorf("tx = %d KB, rx = %d KB, want 2 and 4", s.TxKB(), s.RxKB())
+	}
+	if s.AverageSize() != 204 || s.MaxSize() != 204 {
+		t.Errorf("average size = %d, max size = %d, want 204", s.AverageSize(), s.MaxSize())
+	}
+
+	// The counters restart from zero when the interface is recreated
+	writeCounters(t, root, "eth0", InterfaceCounters{TxBytes: 1024, TxPackets: 1})
+	s.Stop()
+	if s.TxKB() != 3 || s.Status().Collecting {
+		t.Errorf("tx = %d KB, collecting = %v, want 3 KB and stopped", s.TxKB(), s.Status().Collecting)
+	}
+
+	// The statistics are kept after the collection stops
+	writeCounters(t, root, "eth0", InterfaceCounters{TxBytes: 8192, TxPackets: 2})
+	s.Refresh()
+	if s.TxKB() != 3 {
+		t.Errorf("tx = %d KB, want the 3 KB collected before the stop", s.TxKB())
+	}
+}
+
+func TestStatisticsSavedWhenChanged(t *testing.T) {
+	root := newNetworkTree(t)
+	dir := t.TempDir()
+	writeCounters(t, root, "eth0", InterfaceCounters{TxBytes: 1000, TxPackets: 10})
+
+	s := NewStatistics(root, dir)
+	s.Start()
+
+	path := filepath.Join(dir, statisticsStatusFile)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Refresh()
+	if exists(path) {
+		t.Error("the statistics were saved without a change of the counters")
+	}
+
+	writeCounters(t, root, "eth0", InterfaceCounters{TxBytes: 2000, TxPackets: 20})
+	s.Refresh()
+	if !exists(path) {
+		t.Fatal("the changed statistics were not saved")
+	}
+
+	// The collection carries on after a restart
+	restarted := NewStatistics(root, dir)
+	if !restarted.Status().Collecting || restarted.Status().Total.TxBytes != 1000 {
+		t.Errorf("restored status = %+v, want the running collection", restarted.Status())
+	}
+}
+
+func TestStatisticsPeriodEnds(t *testing.T) {
+	root := newNetworkTree(t)
+	writeCounters(t, root, "eth0", InterfaceCounters{})
+
+	s := NewStatistics(root, t.TempDir())
+	if err := s.SetPeriod(-1); err == nil {
+		t.Error("a negative collection period was accepted")
+	}
+	if err := s.SetPeriod(1); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+
+	s.mu.Lock()
+	s.status.Started -= 2
+	s.mu.Unlock()
+	s.Refresh()
+	if s.Status().Collecting {
+		t.Error("the collection did not stop at the end of the period")
+	}
+}
+
+func TestStatisticsConcurrentRefresh(t *testing.T) {
+	root := newNetworkTree(t)
+	writeCounters(t, root, "eth0", InterfaceCounters{})
+
+	s := NewStatistics(root, t.TempDir())
+	s.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s.refreshStale()
+				s.TxKB()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package objects
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+// System modes supported by snapd on Ubuntu Core 20+
+const (
+	ModeRun          = "run"
+	ModeRecover      = "recover"
+	ModeInstall      = "install"
+	ModeFactoryReset = "factory-reset"
+)
+
+// SystemList defines the recovery systems object
+type SystemList struct {
+	Systems     []snapdapi.System
+	lastRefresh int64
+	client      snapdapi.SnapdClient
+}
+
+// Using a singleton to define the recovery systems
+var systemsInstance *SystemList
+var systemsOnce sync.Once
+
+// GetSystemsInstance returns an instance of the recovery systems object
+func GetSystemsInstance() *SystemList {
+	systemsOnce.Do(func() {
+		systemsInstance = &SystemList{client: newClient()}
+	})
+	if dataIsStale(systemsInstance.lastRefresh) {
+		systemsInstance.refresh()
+		systemsInstance.lastRefresh = time.Now().Unix()
+	}
+
+	return systemsInstance
+}
+
+// refresh the recovery systems from the snapd API
+func (s *SystemList) refresh() {
+	systems, err := s.client.Systems()
+	if err != nil {
+		// Systems are only available on Ubuntu Core 20+
+		log.Printf("Error refreshing the list of recovery systems: %v", err)
+		return
+	}
+
+	s.Systems = systems
+}
+
+// Current returns the recovery system that the device is running
+func (s *SystemList) Current() (snapdapi.System, error) {
+	for _, sys := range s.Systems {
+		if sys.Current {
+			return sys, nil
+		}
+	}
+	return snapdapi.System{}, errors.New("no current recovery system found")
+}
+
+// Supports checks whether the recovery system allows the mode
+func (s *SystemList) Supports(instanceID int, mode string) error {
+	if instanceID < 0 || instanceID >= len(s.Systems) {
+		return fmt.Errorf("unknown recovery system %d", instanceID)
+	}
+
+	for _, a := range s.Systems[instanceID].Actions {
+		if a.Mode == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("recovery system %s does not support the %s mode", s.Systems[instanceID].Label, mode)
+}
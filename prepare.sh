@@ -5,11 +5,22 @@ git submodule update --init --recursive
 
 cd lwm2m
 
+# tinydtls is a submodule of the vendored wakaama, so it is fetched here when
+# the connections are secured with DTLS. TINYDTLS_REF selects its version.
+DTLS=${DTLS:-OFF}
+TINYDTLS_DIR=wakaama/examples/shared/tinydtls
+if [ "$DTLS" = "ON" ] && [ ! -f "$TINYDTLS_DIR/dtls.c" ]; then
+    git clone https://github.com/eclipse/tinydtls.git "$TINYDTLS_DIR"
+    if [ -n "$TINYDTLS_REF" ]; then
+        git -C "$TINYDTLS_DIR" checkout "$TINYDTLS_REF"
+    fi
+fi
+
 # Build the C headers from the Go files
-go tool cgo -exportheader ./src/gocallbacks.h m2m.go callbacks_device.go callbacks_snap.go
+go tool cgo -exportheader ./src/gocallbacks.h m2m.go callbacks_device.go callbacks_snap.go callbacks_system.go callbacks_firmware.go callbacks_software.go callbacks_connectivity.go callbacks_statistics.go callbacks_location.go callbacks_server.go callbacks_bootstrap.go callbacks_transport.go callbacks_send.go callbacks_operations.go callbacks_services.go
 
 # Build the C code as a static library liblwm2mclient.a
-cmake . && make
+cmake -DDTLS="$DTLS" . && make
 
 # Clean up the build files
 rm -rf CMakeFiles/ CMakeCache.txt cmake_install.cmake Makefile _obj/
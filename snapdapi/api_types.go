@@ -17,3 +17,32 @@ type DeviceInfo struct {
 	UTCOffset       string
 	Timezone        string
 }
+
+// System holds the details of a recovery system known to snapd (Ubuntu Core 20+)
+type System struct {
+	Label   string         `json:"label"`
+	Current bool           `json:"current"`
+	Model   SystemModel    `json:"model"`
+	Brand   SystemBrand    `json:"brand"`
+	Actions []SystemAction `json:"actions"`
+}
+
+// SystemModel is the model of a recovery system
+type SystemModel struct {
+	Model       string `json:"model"`
+	BrandID     string `json:"brand-id"`
+	DisplayName string `json:"display-name"`
+}
+
+// SystemBrand is the brand of a recovery system
+type SystemBrand struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display-name"`
+}
+
+// SystemAction is an action that can be performed on a recovery system
+type SystemAction struct {
+	Title string `json:"title"`
+	Mode  string `json:"mode"`
+}
@@ -0,0 +1,30 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package snapdapi
+
+import (
+	"github.com/snapcore/snapd/client"
+)
+
+// SnapOptions are the options of a snap install or refresh, with the cohort
+// key that the vendored snapd client does not support
+type SnapOptions struct {
+	client.SnapOptions
+	CohortKey string `json:"cohort-key,omitempty"`
+}
+
+// snapActionData is the body of a snap action request
+type snapActionData struct {
+	Action string `json:"action"`
+	*SnapOptions
+}
+
+// SnapAction requests the action e.g. install or refresh on the snap, with
+// options that the vendored snapd client cannot send
+func (a *ClientAdapter) SnapAction(action, name string, options *SnapOptions) (string, error) {
+	body := snapActionData{Action: action, SnapOptions: options}
+
+	return a.doRaw("POST", "/v2/snaps/"+name, body, nil)
+}
@@ -7,6 +7,7 @@ package snapdapi
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"strconv"
 	"syscall"
@@ -21,6 +22,7 @@ type SnapdClient interface {
 	Snap(name string) (*client.Snap, *client.ResultInfo, error)
 	List(names []string, opts *client.ListOptions) ([]*client.Snap, error)
 	Install(name string, options *client.SnapOptions) (string, error)
+	InstallPath(path string, options *client.SnapOptions) (string, error)
 	Refresh(name string, options *client.SnapOptions) (string, error)
 	Revert(name string, options *client.SnapOptions) (string, error)
 	Remove(name string, options *client.SnapOptions) (string, error)
@@ -34,17 +36,28 @@ type SnapdClient interface {
 	Find(opts *client.FindOptions) ([]*client.Snap, *client.ResultInfo, error)
 	FindOne(name string) (*client.Snap, *client.ResultInfo, error)
 	FindSnaps(query, section string, private bool) ([]*client.Snap, *client.ResultInfo, error)
+	Change(id string) (*client.Change, error)
+	Reboot(mode string) error
+	Systems() ([]System, error)
+	SystemAction(label, mode string) error
+	SnapAction(action, name string, options *SnapOptions) (string, error)
+	Apps(names []string, opts client.AppOptions) ([]*client.AppInfo, error)
+	Start(names []string, opts client.StartOptions) (string, error)
+	Stop(names []string, opts client.StopOptions) (string, error)
+	Restart(names []string, opts client.RestartOptions) (string, error)
 }
 
 // ClientAdapter adapts our expectations to the snapd client API.
 type ClientAdapter struct {
 	snapdClient *client.Client
+	rawClient   *http.Client
 }
 
 // NewClientAdapter creates a new ClientAdapter for use in snapweb.
 func NewClientAdapter() *ClientAdapter {
 	return &ClientAdapter{
 		snapdClient: client.New(nil),
+		rawClient:   newRawClient(),
 	}
 }
 
@@ -66,6 +79,11 @@ func (a *ClientAdapter) Install(name string, options *client.SnapOptions) (strin
 	return a.snapdClient.Install(name, options)
 }
 
+// InstallPath sideloads the snap with the local path given.
+func (a *ClientAdapter) InstallPath(path string, options *client.SnapOptions) (string, error) {
+	return a.snapdClient.InstallPath(path, options)
+}
+
 // Refresh updates the snap with the given name from the given channel (or
 // the system default channel if not).
 func (a *ClientAdapter) Refresh(name string, options *client.SnapOptions) (string, error) {
@@ -92,6 +110,11 @@ func (a *ClientAdapter) Disable(name string, options *client.SnapOptions) (strin
 	return a.snapdClient.Disable(name, options)
 }
 
+// Change fetches information about a change given its ID.
+func (a *ClientAdapter) Change(id string) (*client.Change, error) {
+	return a.snapdClient.Change(id)
+}
+
 // ServerVersion returns information about the snapd server.
 func (a *ClientAdapter) ServerVersion() (*client.ServerVersion, error) {
 	return a.snapdClient.ServerVersion()
@@ -117,6 +140,26 @@ func (a *ClientAdapter) SetConf(name string, patch map[string]interface{}) (stri
 	return a.snapdClient.SetConf(name, patch)
 }
 
+// Apps returns the apps of the snaps, where a name is a snap or a snap.app
+func (a *ClientAdapter) Apps(names []string, opts client.AppOptions) ([]*client.AppInfo, error) {
+	return a.snapdClient.Apps(names, opts)
+}
+
+// Start starts the services, and enables them with the option
+func (a *ClientAdapter) Start(names []string, opts client.StartOptions) (string, error) {
+	return a.snapdClient.Start(names, opts)
+}
+
+// Stop stops the services, and disables them with the option
+func (a *ClientAdapter) Stop(names []string, opts client.StopOptions) (string, error) {
+	return a.snapdClient.Stop(names, opts)
+}
+
+// Restart restarts the services, or starts them when they are not running
+func (a *ClientAdapter) Restart(names []string, opts client.RestartOptions) (string, error) {
+	return a.snapdClient.Restart(names, opts)
+}
+
 // GetModelInfo returns information about the device.
 func GetModelInfo(c SnapdClient) (DeviceInfo, error) {
 
@@ -0,0 +1,331 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+// Package snapdtest provides a fake snapd client for the tests and the
+// callback harness
+package snapdtest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/client"
+
+	"launchpad.net/ce-web/alpaca/snapdapi"
+)
+
+// FakeSnapdClient is an in-memory stand-in for snapd, so the agent can be
+// exercised without a real snapd or restarting the device
+type FakeSnapdClient struct {
+	mu sync.Mutex
+
+	Snaps   []*client.Snap
+	Config  map[string]map[string]interface{}
+	Version client.ServerVersion
+	Err     error
+
+	// AckErr is returned by Ack e.g. for an assertion that is not signed
+	AckErr error
+
+	SystemList []snapdapi.System
+
+	// AppList holds the apps of the snaps, whose services are started and stopped
+	AppList []*client.AppInfo
+
+	// Actions records the requests that would change the device
+	Actions []string
+
+	// Changes holds the snapd changes created by the actions, keyed by the change ID
+	Changes map[string]*client.Change
+}
+
+var _ snapdapi.SnapdClient = (*FakeSnapdClient)(nil)
+
+// NewFakeSnapdClient creates a fake snapd client with no snaps installed
+func NewFakeSnapdClient() *FakeSnapdClient {
+	return &FakeSnapdClient{
+		Config:  map[string]map[string]interface{}{},
+		Changes: map[string]*client.Change{},
+	}
+}
+
+func (f *FakeSnapdClient) record(format string, a ...interface{}) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return "", f.Err
+	}
+	f.Actions = append(f.Actions, fmt.Sprintf(format, a...))
+
+	// The change completes straight away, unless the caller amends it
+	id := fmt.Sprintf("%d", len(f.Actions))
+	if f.Changes == nil {
+		f.Changes = map[string]*client.Change{}
+	}
+	f.Changes[id] = &client.Change{ID: id, Summary: f.Actions[len(f.Actions)-1], Status: "Done", Ready: true}
+	return id, nil
+}
+
+// Snap returns the installed snap with the provided name
+func (f *FakeSnapdClient) Snap(name string) (*client.Snap, *client.ResultInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.Snaps {
+		if s.Name == name {
+			return s, &client.ResultInfo{}, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("snap %q not found", name)
+}
+
+// List returns the installed snaps
+func (f *FakeSnapdClient) List(names []string, opts *client.ListOptions) ([]*client.Snap, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Snaps, nil
+}
+
+// Install records the install of the snap
+func (f *FakeSnapdClient) Install(name string, options *client.SnapOptions) (string, error) {
+	return f.record("install %s", name)
+}
+
+// InstallPath records the install of the local snap file, and whether it is
+// installed without verifying its signature
+func (f *FakeSnapdClient) InstallPath(path string, options *client.SnapOptions) (string, error) {
+	return f.record("install-path %s dangerous=%v", path, options != nil && options.Dangerous)
+}
+
+// Refresh records the refresh of the snap
+func (f *FakeSnapdClient) Refresh(name string, options *client.SnapOptions) (string, error) {
+	return f.record("refresh %s", name)
+}
+
+// SnapAction records the action on the snap
+func (f *FakeSnapdClient) SnapAction(action, name string, options *snapdapi.SnapOptions) (string, error) {
+	return f.record("%s %s", action, name)
+}
+
+// Revert records the revert of the snap
+func (f *FakeSnapdClient) Revert(name string, options *client.SnapOptions) (string, error) {
+	return f.record("revert %s", name)
+}
+
+// Remove records the removal of the snap
+func (f *FakeSnapdClient) Remove(name string, options *client.SnapOptions) (string, error) {
+	return f.record("remove %s", name)
+}
+
+// Enable records the enabling of the snap
+func (f *FakeSnapdClient) Enable(name string, options *client.SnapOptions) (string, error) {
+	return f.record("enable %s", name)
+}
+
+// Disable records the disabling of the snap
+func (f *FakeSnapdClient) Disable(name string, options *client.SnapOptions) (string, error) {
+	return f.record("disable %s", name)
+}
+
+// ServerVersion returns the configured server version
+func (f *FakeSnapdClient) ServerVersion() (*client.ServerVersion, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	v := f.Version
+	return &v, nil
+}
+
+// Ack records the assertion, or returns AckErr
+func (f *FakeSnapdClient) Ack(b []byte) error {
+	f.mu.Lock()
+	err := f.AckErr
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.record("ack")
+	return err
+}
+
+// Known returns no assertions
+func (f *FakeSnapdClient) Known(assertTypeName string, headers map[string]string) ([]asserts.Assertion, error) {
+	return nil, f.Err
+}
+
+// Conf returns the stored configuration of the snap
+func (f *FakeSnapdClient) Conf(name string) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	conf := map[string]interface{}{}
+	for k, v := range f.Config[name] {
+		conf[k] = v
+	}
+	return conf, nil
+}
+
+// SetConf applies the patch to the stored configuration of the snap
+func (f *FakeSnapdClient) SetConf(name string, patch map[string]interface{}) (string, error) {
+	id, err := f.record("set %s", name)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Config[name] == nil {
+		f.Config[name] = map[string]interface{}{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(f.Config[name], k)
+			continue
+		}
+		f.Config[name][k] = v
+	}
+	return id, nil
+}
+
+// Find returns no store results
+func (f *FakeSnapdClient) Find(opts *client.FindOptions) ([]*client.Snap, *client.ResultInfo, error) {
+	return nil, &client.ResultInfo{}, f.Err
+}
+
+// FindOne returns no store result
+func (f *FakeSnapdClient) FindOne(name string) (*client.Snap, *client.ResultInfo, error) {
+	return nil, nil, fmt.Errorf("snap %q not found", name)
+}
+
+// FindSnaps returns no store results
+func (f *FakeSnapdClient) FindSnaps(query, section string, private bool) ([]*client.Snap, *client.ResultInfo, error) {
+	return nil, &client.ResultInfo{}, f.Err
+}
+
+// Change returns the change created by an action
+func (f *FakeSnapdClient) Change(id string) (*client.Change, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.Changes[id]
+	if !ok {
+		return nil, fmt.Errorf("cannot find change with id %q", id)
+	}
+	return c, nil
+}
+
+// Reboot records the reboot request
+func (f *FakeSnapdClient) Reboot(mode string) error {
+	_, err := f.record("reboot %s", mode)
+	return err
+}
+
+// Systems returns the configured recovery systems
+func (f *FakeSnapdClient) Systems() ([]snapdapi.System, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.SystemList, nil
+}
+
+// SystemAction records the system action request
+func (f *FakeSnapdClient) SystemAction(label, mode string) error {
+	_, err := f.record("system %s %s", label, mode)
+	return err
+}
+
+// Apps returns the configured apps of the snaps, where a name is a snap or a snap.app
+func (f *FakeSnapdClient) Apps(names []string, opts client.AppOptions) ([]*client.AppInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	apps := []*client.AppInfo{}
+	for _, a := range f.AppList {
+		if opts.Service && !a.IsService() {
+			continue
+		}
+		if len(names) > 0 && !appMatches(a, names) {
+			continue
+		}
+		app := *a
+		apps = append(apps, &app)
+	}
+	return apps, nil
+}
+
+// Start records the start of the services and marks them active
+func (f *FakeSnapdClient) Start(names []string, opts client.StartOptions) (string, error) {
+	id, err := f.record("start %v enable=%v", names, opts.Enable)
+	if err != nil {
+		return "", err
+	}
+	f.setServices(names, true, opts.Enable, false)
+	return id, nil
+}
+
+// Stop records the stop of the services and marks them inactive
+func (f *FakeSnapdClient) Stop(names []string, opts client.StopOptions) (string, error) {
+	id, err := f.record("stop %v disable=%v", names, opts.Disable)
+	if err != nil {
+		return "", err
+	}
+	f.setServices(names, false, false, opts.Disable)
+	return id, nil
+}
+
+// Restart records the restart of the services and marks them active
+func (f *FakeSnapdClient) Restart(names []string, opts client.RestartOptions) (string, error) {
+	id, err := f.record("restart %v", names)
+	if err != nil {
+		return "", err
+	}
+	f.setServices(names, true, false, false)
+	return id, nil
+}
+
+// setServices updates the state of the services with the names
+func (f *FakeSnapdClient) setServices(names []string, active, enable, disable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, a := range f.AppList {
+		if !a.IsService() || !appMatches(a, names) {
+			continue
+		}
+		a.Active = active
+		if enable {
+			a.Enabled = true
+		}
+		if disable {
+			a.Enabled = false
+		}
+	}
+}
+
+// appMatches returns true when a name is the snap or the snap.app of the app
+func appMatches(a *client.AppInfo, names []string) bool {
+	for _, n := range names {
+		if n == a.Snap || n == a.Snap+"."+a.Name {
+			return true
+		}
+	}
+	return false
+}
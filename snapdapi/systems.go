@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Ubuntu Management Agent
+// Copyright 2017 Canonical Ltd.  All rights reserved.
+
+package snapdapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+)
+
+// rawResponse is the envelope of every response of the snapd REST API
+type rawResponse struct {
+	Type       string          `json:"type"`
+	StatusCode int             `json:"status-code"`
+	Result     json.RawMessage `json:"result"`
+	Change     string          `json:"change"`
+}
+
+// newRawClient creates an HTTP client that talks to snapd over its socket.
+// It is used for the parts of the API that the vendored snapd client does not cover.
+func newRawClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", dirs.SnapdSocket)
+			},
+		},
+	}
+}
+
+// doRaw sends a request to the snapd API and decodes the sync result into v.
+// The change ID is returned for async requests.
+func (a *ClientAdapter) doRaw(method, urlpath string, body interface{}, v interface{}) (string, error) {
+	var b bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			return "", err
+		}
+	}
+
+	req, err := http.NewRequest(method, "http://localhost"+path.Join("/", urlpath), &b)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.rawClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var rsp rawResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rsp); err != nil {
+		return "", fmt.Errorf("cannot decode the snapd response: %v", err)
+	}
+
+	if rsp.Type == "error" {
+		e := &client.Error{}
+		if err := json.Unmarshal(rsp.Result, e); err != nil {
+			return "", fmt.Errorf("cannot decode the snapd error: %v", err)
+		}
+		e.StatusCode = rsp.StatusCode
+		return "", e
+	}
+
+	if v != nil && len(rsp.Result) > 0 {
+		if err := json.Unmarshal(rsp.Result, v); err != nil {
+			return "", fmt.Errorf("cannot unmarshal: %v", err)
+		}
+	}
+
+	return rsp.Change, nil
+}
+
+// Reboot asks snapd to restart the device. The mode is optional and only
+// applies to Ubuntu Core 20+ systems e.g. run, recover or install.
+func (a *ClientAdapter) Reboot(mode string) error {
+	body := map[string]string{"action": "reboot"}
+	if len(mode) > 0 {
+		body["mode"] = mode
+	}
+
+	_, err := a.doRaw("POST", "/v2/systems", body, nil)
+	return err
+}
+
+// Systems lists the recovery systems that snapd knows about
+func (a *ClientAdapter) Systems() ([]System, error) {
+	var result struct {
+		Systems []System `json:"systems"`
+	}
+
+	_, err := a.doRaw("GET", "/v2/systems", nil, &result)
+	return result.Systems, err
+}
+
+// SystemAction reboots the device into the recovery system with the given label
+// using a system mode e.g. run, recover, install or factory-reset
+func (a *ClientAdapter) SystemAction(label, mode string) error {
+	body := map[string]string{"action": "do", "mode": mode}
+
+	_, err := a.doRaw("POST", "/v2/systems/"+label, body, nil)
+	return err
+}